@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"net/http"
+)
+
+// Alerts are only created and resolved by the alerts cron, so they are read-only here
+func alertRouter(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	_, alertSpecified := req.PathParameters["id"]
+	switch req.HTTPMethod {
+	case "GET":
+		if alertSpecified {
+			return viewAlert(req)
+		}
+		return listAlerts(req)
+	default:
+		return domain.ClientError(http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	}
+}
+
+func viewAlert(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	var alert domain.Alert
+	err := db.GetItem(&alert, id)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.Alert{}, err)
+	}
+
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionTagBased, alert.Node.Tags)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	return domain.ReturnJsonOrError(alert, err)
+}
+
+// listAlerts returns the alerts for the nodes the user can use, optionally filtered by "state"
+func listAlerts(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	state := req.QueryStringParameters["state"]
+	if state != "" && state != domain.AlertStateFiring && state != domain.AlertStateResolved {
+		return domain.ClientError(http.StatusBadRequest, "Invalid state: "+state)
+	}
+
+	user, err := db.GetUserFromRequest(req)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	allAlerts, err := db.ListAlerts(state)
+	if err != nil {
+		return domain.ReturnJsonOrError([]domain.Alert{}, err)
+	}
+
+	visibleAlerts := []domain.Alert{}
+	for _, alert := range allAlerts {
+		if domain.CanUserUseNode(user, alert.Node) {
+			visibleAlerts = append(visibleAlerts, alert)
+		}
+	}
+
+	return domain.ReturnJsonOrError(visibleAlerts, nil)
+}
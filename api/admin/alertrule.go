@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"net/http"
+	"strings"
+)
+
+const UniqueAlertRuleNameErrorMessage = "Cannot update an Alert Rule with a Name that is already in use."
+
+func alertruleRouter(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	_, ruleSpecified := req.PathParameters["id"]
+	switch req.HTTPMethod {
+	case "GET":
+		if ruleSpecified {
+			return viewAlertRule(req)
+		}
+		return listAlertRules(req)
+	case "POST":
+		return updateAlertRule(req)
+	case "PUT":
+		return updateAlertRule(req)
+	case "DELETE":
+		return deleteAlertRule(req)
+	default:
+		return domain.ClientError(http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	}
+}
+
+// getAuthStatusForAlertRule only lets superAdmins deal with rules that apply to all nodes.
+// Otherwise, the user needs a tag that matches the rule's tag or the rule's node's tags.
+func getAuthStatusForAlertRule(req events.APIGatewayProxyRequest, rule domain.AlertRule) (int, string) {
+	if rule.NodeID != 0 {
+		var node domain.Node
+		err := db.GetItem(&node, rule.NodeID)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Sprintf("error getting node with ID: %d. %s", rule.NodeID, err.Error())
+		}
+		return db.GetAuthorizationStatus(req, domain.PermissionTagBased, node.Tags)
+	}
+
+	if rule.TagID != 0 {
+		var tag domain.Tag
+		err := db.GetItem(&tag, rule.TagID)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Sprintf("error getting tag with ID: %d. %s", rule.TagID, err.Error())
+		}
+		return db.GetAuthorizationStatus(req, domain.PermissionTagBased, []domain.Tag{tag})
+	}
+
+	return db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+}
+
+func canUserSeeAlertRule(user domain.User, rule domain.AlertRule) bool {
+	if user.Role == domain.UserRoleSuperAdmin {
+		return true
+	}
+
+	if rule.NodeID != 0 {
+		return domain.CanUserUseNode(user, rule.Node)
+	}
+
+	if rule.TagID != 0 {
+		return domain.DoTagsOverlap(user.Tags, []domain.Tag{rule.Tag})
+	}
+
+	return false
+}
+
+func viewAlertRule(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	var rule domain.AlertRule
+	err := db.GetItem(&rule, id)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.AlertRule{}, err)
+	}
+
+	statusCode, errMsg := getAuthStatusForAlertRule(req, rule)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	return domain.ReturnJsonOrError(rule, err)
+}
+
+func listAlertRules(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, err := db.GetUserFromRequest(req)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	var allRules []domain.AlertRule
+	err = db.ListItems(&allRules, "name asc")
+	if err != nil {
+		return domain.ReturnJsonOrError([]domain.AlertRule{}, err)
+	}
+
+	visibleRules := []domain.AlertRule{}
+	for _, rule := range allRules {
+		if canUserSeeAlertRule(user, rule) {
+			visibleRules = append(visibleRules, rule)
+		}
+	}
+
+	return domain.ReturnJsonOrError(visibleRules, nil)
+}
+
+func updateAlertRule(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var rule domain.AlertRule
+
+	// If ID is provided, load existing rule for updating, otherwise we'll create a new one
+	if req.PathParameters["id"] != "" {
+		id := domain.GetResourceIDFromRequest(req)
+		if id == 0 {
+			return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+		}
+
+		err := db.GetItem(&rule, id)
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return events.APIGatewayProxyResponse{
+					StatusCode: http.StatusNotFound,
+					Body:       "",
+				}, nil
+			}
+			return domain.ServerError(err)
+		}
+
+		// Enforce user authorization for the old version of the rule
+		statusCode, errMsg := getAuthStatusForAlertRule(req, rule)
+		if statusCode > 0 {
+			return domain.ClientError(statusCode, errMsg)
+		}
+	}
+
+	// Parse request body for updated attributes
+	var updatedRule domain.AlertRule
+	err := json.Unmarshal([]byte(req.Body), &updatedRule)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	if updatedRule.Name == "" {
+		return domain.ClientError(http.StatusUnprocessableEntity, "Name is required")
+	}
+
+	if !domain.IsValidAlertRuleMetric(updatedRule.Metric) {
+		return domain.ClientError(http.StatusUnprocessableEntity, "Invalid Metric: "+updatedRule.Metric)
+	}
+
+	if !domain.IsValidAlertRuleComparison(updatedRule.Comparison) {
+		return domain.ClientError(http.StatusUnprocessableEntity, "Invalid Comparison: "+updatedRule.Comparison)
+	}
+
+	if updatedRule.NodeID != 0 && updatedRule.TagID != 0 {
+		return domain.ClientError(http.StatusUnprocessableEntity, "An Alert Rule can have a NodeID or a TagID, but not both")
+	}
+
+	if updatedRule.WindowHours < 0 {
+		return domain.ClientError(http.StatusUnprocessableEntity, "WindowHours cannot be negative")
+	} else if updatedRule.WindowHours == 0 {
+		updatedRule.WindowHours = domain.DefaultAlertRuleWindowHours
+	}
+
+	// Enforce user authorization for the new version of the rule
+	statusCode, errMsg := getAuthStatusForAlertRule(req, updatedRule)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	rule.Name = updatedRule.Name
+	rule.Description = updatedRule.Description
+	rule.Metric = updatedRule.Metric
+	rule.Comparison = updatedRule.Comparison
+	rule.Threshold = updatedRule.Threshold
+	rule.WindowHours = updatedRule.WindowHours
+	rule.NodeID = updatedRule.NodeID
+	rule.TagID = updatedRule.TagID
+
+	// Don't save the previously loaded associations over the new IDs
+	rule.Node = domain.Node{}
+	rule.Tag = domain.Tag{}
+
+	err = db.PutItem(&rule)
+	if err != nil && strings.Contains(err.Error(), db.UniqueFieldErrorCode) {
+		return domain.ClientError(http.StatusConflict, UniqueAlertRuleNameErrorMessage)
+	}
+	return domain.ReturnJsonOrError(rule, err)
+}
+
+func deleteAlertRule(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	var rule domain.AlertRule
+	err := db.GetItem(&rule, id)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.AlertRule{}, err)
+	}
+
+	statusCode, errMsg := getAuthStatusForAlertRule(req, rule)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	err = db.DeleteItem(&rule, id)
+	return domain.ReturnJsonOrError(rule, err)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
+	"net/http"
+	"testing"
+)
+
+func TestUpdateAlertRule(t *testing.T) {
+	testutils.ResetDb(t)
+	testutils.CreateAdminUser(t)
+
+	rule := domain.AlertRule{
+		Name:       "Slow Download",
+		Metric:     domain.AlertRuleMetricDownloadAvg,
+		Comparison: domain.AlertRuleComparisonBelow,
+		Threshold:  5,
+	}
+
+	js, err := json.Marshal(&rule)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A normal admin cannot create a rule that applies to all nodes
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/alertrule",
+		Headers:    testutils.GetAdminUserReqHeader(),
+		Body:       string(js),
+	}
+	response, err := updateAlertRule(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("Wrong status code creating alert rule as admin. Expected %d, but got %d", http.StatusForbidden, response.StatusCode)
+		return
+	}
+
+	// A superAdmin can, and the window defaults to 24 hours
+	req.Headers = testutils.GetSuperAdminReqHeader()
+	response, err = updateAlertRule(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code creating alert rule. Expected %d, but got %d. %s", http.StatusOK, response.StatusCode, response.Body)
+		return
+	}
+
+	var created domain.AlertRule
+	err = json.Unmarshal([]byte(response.Body), &created)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if created.ID == 0 || created.WindowHours != domain.DefaultAlertRuleWindowHours {
+		t.Errorf("Alert rule not created as expected. Got %+v", created)
+		return
+	}
+
+	// The name must be unique
+	response, err = updateAlertRule(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("Wrong status code creating duplicate alert rule. Expected %d, but got %d", http.StatusConflict, response.StatusCode)
+		return
+	}
+
+	// An invalid metric is rejected
+	created.Metric = "Bogus"
+	js, _ = json.Marshal(&created)
+	req = events.APIGatewayProxyRequest{
+		HTTPMethod:     "PUT",
+		Path:           fmt.Sprintf("/alertrule/%v", created.ID),
+		PathParameters: map[string]string{"id": fmt.Sprintf("%v", created.ID)},
+		Headers:        testutils.GetSuperAdminReqHeader(),
+		Body:           string(js),
+	}
+	response, err = updateAlertRule(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Wrong status code with invalid metric. Expected %d, but got %d", http.StatusUnprocessableEntity, response.StatusCode)
+		return
+	}
+
+	// Update the threshold
+	created.Metric = domain.AlertRuleMetricDownloadAvg
+	created.Threshold = 10
+	js, _ = json.Marshal(&created)
+	req.Body = string(js)
+	response, err = updateAlertRule(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code updating alert rule. Expected %d, but got %d. %s", http.StatusOK, response.StatusCode, response.Body)
+		return
+	}
+
+	var dbRule domain.AlertRule
+	err = db.GetItem(&dbRule, created.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if dbRule.Threshold != 10 {
+		t.Errorf("Alert rule threshold not updated. Expected 10, but got %v", dbRule.Threshold)
+	}
+}
+
+func TestListAlertRules(t *testing.T) {
+	testutils.ResetDb(t)
+
+	tag1 := domain.Tag{Name: "tag1"}
+	tag2 := domain.Tag{Name: "tag2"}
+	for _, fix := range []*domain.Tag{&tag1, &tag2} {
+		err := db.PutItem(fix)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	adminUser := testutils.AdminUser
+	err := db.PutItemWithAssociations(
+		&adminUser,
+		[]domain.AssociationReplacements{
+			{AssociationName: "Tags", Replacements: []domain.Tag{tag1}},
+		},
+	)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rules := []domain.AlertRule{
+		{Name: "Global", Metric: domain.AlertRuleMetricOutageCount, Comparison: domain.AlertRuleComparisonAbove, Threshold: 3},
+		{Name: "Tag1", Metric: domain.AlertRuleMetricLatencyAvg, Comparison: domain.AlertRuleComparisonAbove, Threshold: 300, TagID: tag1.ID},
+		{Name: "Tag2", Metric: domain.AlertRuleMetricUploadAvg, Comparison: domain.AlertRuleComparisonBelow, Threshold: 1, TagID: tag2.ID},
+	}
+	for i := range rules {
+		err := db.PutItem(&rules[i])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	testCases := []struct {
+		headers  map[string]string
+		expected []string
+	}{
+		{testutils.GetSuperAdminReqHeader(), []string{"Global", "Tag1", "Tag2"}},
+		{testutils.GetAdminUserReqHeader(), []string{"Tag1"}},
+	}
+
+	for _, tc := range testCases {
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod: "GET",
+			Path:       "/alertrule",
+			Headers:    tc.headers,
+		}
+		response, err := listAlertRules(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		var results []domain.AlertRule
+		err = json.Unmarshal([]byte(response.Body), &results)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(results) != len(tc.expected) {
+			t.Errorf("Wrong number of alert rules listed. Expected %v, but got %+v", tc.expected, results)
+			continue
+		}
+
+		for i, name := range tc.expected {
+			if results[i].Name != name {
+				t.Errorf("Wrong alert rule listed. Expected %s, but got %s", name, results[i].Name)
+			}
+		}
+	}
+}
@@ -15,14 +15,24 @@ func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse,
 	subPath := pathParts[1]
 
 	switch subPath {
+	case "alert":
+		return alertRouter(req)
+	case "alertrule":
+		return alertruleRouter(req)
 	case "namedserver":
 		return namedserverRouter(req)
 	case "node":
 		return nodeRouter(req)
+	case "notificationchannel":
+		return notificationchannelRouter(req)
 	case "report":
 		return reportRouter(req)
 	case "reportingevent":
 		return reportingeventRouter(req)
+	case "rollout":
+		return rolloutRouter(req)
+	case "sla":
+		return slaRouter(req)
 	case "speedtestnetserver":
 		return speedtestnetserverRouter(req)
 	case "tag":
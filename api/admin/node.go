@@ -2,12 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/jinzhu/gorm"
 	"github.com/silinternational/speed-snitch-admin-api"
 	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/schedule"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const DefaultSpeedTestTimeoutInSeconds = 60 // 1 minute
@@ -21,6 +26,11 @@ const UploadSizesKey = "uploadSizes"
 const MaxSecondsKey = "maxSeconds"
 const TestTypeKey = "testType"
 
+const DefaultSchedulePreviewRuns = 5
+const MaxSchedulePreviewRuns = 100
+const DefaultDailyBandwidthBudgetMB = 500
+const BytesPerMB = 1000 * 1000
+
 func GetDefaultSpeedTestDownloadSizes() []int {
 	return []int{245388, 505544, 1118012, 1986284}
 }
@@ -33,16 +43,34 @@ func nodeRouter(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespon
 	_, nodeSpecified := req.PathParameters["id"]
 	switch req.HTTPMethod {
 	case "DELETE":
+		if strings.HasSuffix(req.Path, "/token") {
+			return revokeNodeToken(req)
+		}
 		return deleteNode(req)
 	case "GET":
 		if nodeSpecified {
 			if strings.HasSuffix(req.Path, "/tag") {
 				return listNodeTags(req)
 			}
+			if strings.HasSuffix(req.Path, "/timeline") {
+				return viewNodeTimeline(req)
+			}
+			if strings.HasSuffix(req.Path, "/schedule") {
+				return viewNodeSchedule(req)
+			}
 			return viewNode(req)
 		}
 		return listNodes(req)
 	case "PUT":
+		if strings.HasSuffix(req.Path, "/token") {
+			return rotateNodeToken(req)
+		}
+		if strings.HasSuffix(req.Path, "/approve") {
+			return approveNode(req)
+		}
+		if strings.HasSuffix(req.Path, "/reject") {
+			return rejectNode(req)
+		}
 		return updateNode(req)
 	default:
 		return domain.ClientError(http.StatusMethodNotAllowed, "Bad request method: "+req.HTTPMethod)
@@ -113,11 +141,228 @@ func listNodeTags(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResp
 	return domain.ReturnJsonOrError(node.Tags, err)
 }
 
-func listNodes(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	var allNodes []domain.Node
-	err := db.ListItems(&allNodes, "nickname asc")
+// viewNodeTimeline lists the node's hellos between the "start" and "end" dates, along with what changed in each.
+// If "changes" is "true", only the hellos in which the IP address, version or uptime changed are included.
+func viewNodeTimeline(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	periodStartTimestamp, err := getTimestampFromString(req.QueryStringParameters["start"], "start")
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	periodEndTimestamp, err := getTimestampFromString(req.QueryStringParameters["end"], "end")
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	periodEndTimestamp = periodEndTimestamp + domain.SecondsPerDay - 1
+
+	var node domain.Node
+	err = db.GetItem(&node, id)
+	if err != nil {
+		return domain.ReturnJsonOrError([]domain.NodeHeartbeat{}, err)
+	}
+
+	// Ensure user is authorized ...
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionTagBased, node.Tags)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	changesOnly := req.QueryStringParameters["changes"] == "true"
+	heartbeats, err := db.ListNodeHeartbeats(node.ID, periodStartTimestamp, periodEndTimestamp, changesOnly)
+	return domain.ReturnJsonOrError(heartbeats, err)
+}
+
+// viewNodeSchedule previews the next "count" runs of each of the node's tasks, in the node's timezone. It warns
+// about speed tests that would still be running when a ping runs and about speed tests that would use more than
+// the daily bandwidth budget ("budget_mb").
+func viewNodeSchedule(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	count, err := getIntFromQueryParam(req, "count", DefaultSchedulePreviewRuns)
+	if err != nil || count < 1 || count > MaxSchedulePreviewRuns {
+		return domain.ClientError(http.StatusBadRequest,
+			fmt.Sprintf("count must be from 1 to %d", MaxSchedulePreviewRuns))
+	}
+
+	budgetMB, err := getIntFromQueryParam(req, "budget_mb", DefaultDailyBandwidthBudgetMB)
+	if err != nil || budgetMB < 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid budget_mb: "+req.QueryStringParameters["budget_mb"])
+	}
+
+	var node domain.Node
+	err = db.GetItem(&node, id)
 	if err != nil {
-		return domain.ReturnJsonOrError([]domain.Node{}, err)
+		return domain.ReturnJsonOrError(domain.SchedulePreview{}, err)
+	}
+
+	// Ensure user is authorized ...
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionTagBased, node.Tags)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	preview := buildSchedulePreview(node, time.Now().UTC(), count, int64(budgetMB)*BytesPerMB)
+	return domain.ReturnJsonOrError(preview, nil)
+}
+
+// buildSchedulePreview works out the next runs of each of the node's tasks after now and how many times they run
+// in the following day. Tasks with invalid schedules get a warning instead of any runs.
+func buildSchedulePreview(node domain.Node, now time.Time, count int, budgetBytes int64) domain.SchedulePreview {
+	start := now.In(node.GetLocation())
+	dayEnd := start.Add(time.Duration(domain.SecondsPerDay) * time.Second)
+
+	preview := domain.SchedulePreview{
+		NodeID:            node.ID,
+		Timezone:          start.Location().String(),
+		Tasks:             []domain.TaskSchedulePreview{},
+		BytesPerDayBudget: budgetBytes,
+		Warnings:          []string{},
+	}
+
+	dayRuns := map[int][]time.Time{}
+	for i, task := range node.Tasks {
+		taskPreview := domain.TaskSchedulePreview{
+			TaskID:     task.ID,
+			Type:       task.Type,
+			Schedule:   task.Schedule,
+			ServerHost: task.ServerHost,
+			NextRuns:   []string{},
+		}
+
+		taskSchedule, err := schedule.Parse(task.Schedule)
+		if err != nil {
+			preview.Warnings = append(preview.Warnings, fmt.Sprintf("%s task %d: %s", task.Type, task.ID, err.Error()))
+			preview.Tasks = append(preview.Tasks, taskPreview)
+			continue
+		}
+
+		for _, run := range taskSchedule.NextRuns(start, count) {
+			taskPreview.NextRuns = append(taskPreview.NextRuns, run.Format(time.RFC3339))
+		}
+
+		dayRuns[i] = taskSchedule.RunsBetween(start, dayEnd)
+		taskPreview.RunsPerDay = len(dayRuns[i])
+
+		if task.Type == domain.TaskTypeSpeedTest {
+			taskPreview.BytesPerDay = int64(taskPreview.RunsPerDay) * getSpeedTestBytes(task)
+			preview.BytesPerDay += taskPreview.BytesPerDay
+		}
+
+		preview.Tasks = append(preview.Tasks, taskPreview)
+	}
+
+	for i, speedTask := range node.Tasks {
+		if speedTask.Type != domain.TaskTypeSpeedTest {
+			continue
+		}
+
+		duration := time.Duration(getSpeedTestMaxSeconds(speedTask) * float64(time.Second))
+
+		for j, pingTask := range node.Tasks {
+			if pingTask.Type != domain.TaskTypePing {
+				continue
+			}
+
+			overlaps := []time.Time{}
+			for _, speedRun := range dayRuns[i] {
+				for _, pingRun := range dayRuns[j] {
+					if !pingRun.Before(speedRun) && pingRun.Before(speedRun.Add(duration)) {
+						overlaps = append(overlaps, pingRun)
+					}
+				}
+			}
+
+			if len(overlaps) > 0 {
+				preview.Warnings = append(preview.Warnings, fmt.Sprintf(
+					"%s task %d and %s task %d overlap %d times a day, starting at %s",
+					speedTask.Type, speedTask.ID, pingTask.Type, pingTask.ID, len(overlaps), overlaps[0].Format(time.RFC3339)))
+			}
+		}
+	}
+
+	if preview.BytesPerDay > budgetBytes {
+		preview.Warnings = append(preview.Warnings, fmt.Sprintf(
+			"The speed tests would use about %.1f MB a day, which is more than the budget of %.1f MB",
+			float64(preview.BytesPerDay)/BytesPerMB, float64(budgetBytes)/BytesPerMB))
+	}
+
+	return preview
+}
+
+// getSpeedTestBytes returns about how much data one run of a speed test downloads and uploads
+func getSpeedTestBytes(task domain.Task) int64 {
+	downloadSizes, ok := task.TaskData.IntSlices[DownloadSizesKey]
+	if !ok {
+		downloadSizes = GetDefaultSpeedTestDownloadSizes()
+	}
+
+	uploadSizes, ok := task.TaskData.IntSlices[UploadSizesKey]
+	if !ok {
+		uploadSizes = GetDefaultSpeedTestUploadSizes()
+	}
+
+	var total int64
+	for _, size := range downloadSizes {
+		total += int64(size)
+	}
+	for _, size := range uploadSizes {
+		total += int64(size)
+	}
+
+	return total
+}
+
+// getSpeedTestMaxSeconds returns how long a speed test can take
+func getSpeedTestMaxSeconds(task domain.Task) float64 {
+	maxSeconds, ok := task.TaskData.FloatValues[MaxSecondsKey]
+	if !ok || maxSeconds <= 0 {
+		return DefaultSpeedTestMaxSeconds
+	}
+
+	return maxSeconds
+}
+
+func getIntFromQueryParam(req events.APIGatewayProxyRequest, name string, defaultValue int) (int, error) {
+	value := req.QueryStringParameters[name]
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+// listNodes returns the approved nodes the user can see, unless a different "status" is requested.
+// Only superAdmins can list pending or rejected nodes. A "version_status" (e.g. "deprecated") limits
+// the nodes to the ones running a version with that status.
+func listNodes(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	status := req.QueryStringParameters["status"]
+	if status == "" {
+		status = domain.NodeStatusApproved
+	}
+
+	if !isValidNodeStatus(status) {
+		return domain.ClientError(http.StatusBadRequest, "Invalid status: "+status)
+	}
+
+	versionStatus := req.QueryStringParameters["version_status"]
+	if versionStatus != "" && !domain.IsValidVersionStatus(versionStatus) {
+		return domain.ClientError(http.StatusBadRequest, "Invalid version_status: "+versionStatus)
+	}
+
+	if status != domain.NodeStatusApproved {
+		statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+		if statusCode > 0 {
+			return domain.ClientError(statusCode, errMsg)
+		}
 	}
 
 	user, err := db.GetUserFromRequest(req)
@@ -125,14 +370,49 @@ func listNodes(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespons
 		return domain.ClientError(http.StatusBadRequest, err.Error())
 	}
 
-	visibleNodes := []domain.Node{}
-	for _, node := range allNodes {
-		if domain.CanUserUseNode(user, node) {
-			visibleNodes = append(visibleNodes, node)
+	sortFields := map[string]string{
+		"nickname":   "nickname",
+		"last_seen":  "last_seen",
+		"first_seen": "first_seen",
+		"os":         "os",
+		"arch":       "arch",
+		"mac_addr":   "mac_addr",
+		"id":         "id",
+	}
+	params, err := domain.GetListParamsFromRequest(req, sortFields, "nickname")
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	filter := db.NodeFilter{
+		Status:         status,
+		TagID:          domain.GetUintFromString(req.QueryStringParameters["tag"]),
+		OS:             req.QueryStringParameters["os"],
+		Arch:           req.QueryStringParameters["arch"],
+		Version:        req.QueryStringParameters["version"],
+		VersionStatus:  versionStatus,
+		LastSeenAfter:  req.QueryStringParameters["last_seen_after"],
+		LastSeenBefore: req.QueryStringParameters["last_seen_before"],
+		Search:         req.QueryStringParameters["search"],
+	}
+
+	// Only let users see the nodes that share one of their tags
+	if user.Role != domain.UserRoleSuperAdmin {
+		filter.UserTagIDs = []uint{}
+		for _, tag := range user.Tags {
+			filter.UserTagIDs = append(filter.UserTagIDs, tag.ID)
+		}
+
+		if len(filter.UserTagIDs) == 0 {
+			pageInfo := domain.PageInfo{Limit: params.Limit, Offset: params.Offset}
+			return domain.ReturnPageJsonOrError([]domain.Node{}, pageInfo, nil)
 		}
 	}
 
-	return domain.ReturnJsonOrError(visibleNodes, err)
+	nodes := []domain.Node{}
+	total, err := db.ListNodesPage(&nodes, filter, params)
+	pageInfo := domain.PageInfo{Total: total, Limit: params.Limit, Offset: params.Offset}
+	return domain.ReturnPageJsonOrError(nodes, pageInfo, err)
 }
 
 // Nodes are only created through the agent /hello API and updated via admin /node APIs
@@ -193,14 +473,39 @@ func updateNode(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespon
 		return domain.ReturnJsonOrError(domain.Node{}, err)
 	}
 
+	timezone, err := domain.CleanTimezone(updatedNode.Timezone)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	workingDays, err := domain.CleanWorkingDays(updatedNode.WorkingDays)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
 	// Apply updates to node
 	node.BusinessStartTime = businessStartTime
 	node.BusinessCloseTime = businessCloseTime
+	node.Timezone = timezone
+	node.WorkingDays = workingDays
 	node.Nickname = updatedNode.Nickname
 	node.Notes = updatedNode.Notes
+	node.BetaOptIn = updatedNode.BetaOptIn
+
+	// The agent's credentials are issued the first time an admin updates an approved node
+	// that doesn't have any yet
+	if node.IsApproved() && !node.HasAuthToken() {
+		err = node.IssueAuthToken()
+		if err != nil {
+			return domain.ServerError(err)
+		}
+	}
 
 	updatedNode, err = updateNodeTasks(updatedNode)
-	if err != nil {
+	var scheduleErr *schedule.ParseError
+	if errors.As(err, &scheduleErr) {
+		return domain.ClientError(http.StatusUnprocessableEntity, err.Error())
+	} else if err != nil {
 		return domain.ReturnJsonOrError(domain.Node{}, err)
 	}
 
@@ -218,6 +523,7 @@ func updateNode(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespon
 		}
 
 		updatedNode.ConfiguredVersion = newVersion
+		node.ConfiguredVersionAt = domain.GetTimeNow()
 	}
 
 	replaceAssoc := []domain.AssociationReplacements{
@@ -254,9 +560,126 @@ func updateNode(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespon
 	return domain.ReturnJsonOrError(node, err)
 }
 
+// approveNode lets a pending node get its tasks and submit logs, and issues its agent token.
+// The new token is only ever included in this response.
+func approveNode(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	node, statusCode, errMsg := getNodeForStatusChange(req)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	node.Status = domain.NodeStatusApproved
+	err := node.IssueAuthToken()
+	if err != nil {
+		return domain.ServerError(err)
+	}
+
+	err = db.PutItem(&node)
+	return domain.ReturnJsonOrError(node, err)
+}
+
+// rejectNode keeps the node's record, so that the agent is refused rather than being enrolled again
+func rejectNode(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	node, statusCode, errMsg := getNodeForStatusChange(req)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	node.Status = domain.NodeStatusRejected
+	node.RevokeAuthToken()
+
+	err := db.PutItem(&node)
+	return domain.ReturnJsonOrError(node, err)
+}
+
+func getNodeForStatusChange(req events.APIGatewayProxyRequest) (domain.Node, int, string) {
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+	if statusCode > 0 {
+		return domain.Node{}, statusCode, errMsg
+	}
+
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.Node{}, http.StatusBadRequest, "Invalid ID"
+	}
+
+	var node domain.Node
+	err := db.GetItem(&node, id)
+	if gorm.IsRecordNotFoundError(err) {
+		return domain.Node{}, http.StatusNotFound, http.StatusText(http.StatusNotFound)
+	} else if err != nil {
+		return domain.Node{}, http.StatusInternalServerError, err.Error()
+	}
+
+	return node, 0, ""
+}
+
+func isValidNodeStatus(status string) bool {
+	validStatuses := []string{domain.NodeStatusPending, domain.NodeStatusApproved, domain.NodeStatusRejected}
+	isValid, _ := domain.InArray(status, validStatuses)
+	return isValid
+}
+
+// rotateNodeToken issues a new agent token for the node, replacing any existing one.
+// The new token is only ever included in this response.
+func rotateNodeToken(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	node, statusCode, errMsg := getNodeForTokenChange(req)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	err := node.IssueAuthToken()
+	if err != nil {
+		return domain.ServerError(err)
+	}
+
+	err = db.PutItem(&node)
+	return domain.ReturnJsonOrError(node, err)
+}
+
+// revokeNodeToken removes the node's agent token, so that the agent is refused until a new one is issued
+func revokeNodeToken(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	node, statusCode, errMsg := getNodeForTokenChange(req)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	node.RevokeAuthToken()
+
+	err := db.PutItem(&node)
+	return domain.ReturnJsonOrError(node, err)
+}
+
+func getNodeForTokenChange(req events.APIGatewayProxyRequest) (domain.Node, int, string) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.Node{}, http.StatusBadRequest, "Invalid ID"
+	}
+
+	var node domain.Node
+	err := db.GetItem(&node, id)
+	if gorm.IsRecordNotFoundError(err) {
+		return domain.Node{}, http.StatusNotFound, http.StatusText(http.StatusNotFound)
+	} else if err != nil {
+		return domain.Node{}, http.StatusInternalServerError, err.Error()
+	}
+
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionTagBased, node.Tags)
+	if statusCode > 0 {
+		return domain.Node{}, statusCode, errMsg
+	}
+
+	return node, 0, ""
+}
+
 func updateNodeTasks(node domain.Node) (domain.Node, error) {
 	newTasks := []domain.Task{}
 	for index, task := range node.Tasks {
+		_, err := schedule.Parse(task.Schedule)
+		if err != nil {
+			return node, fmt.Errorf("error updating task. Index: %d. Type: %s ... %w", index, task.Type, err)
+		}
+
 		if task.Type == domain.TaskTypeSpeedTest || task.Type == domain.TaskTypePing {
 			if task.NamedServerID == 0 {
 				err := fmt.Errorf("task of type %s must have a NamedServerID.", task.Type)
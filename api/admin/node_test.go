@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestDeleteNode(t *testing.T) {
@@ -298,6 +299,91 @@ func TestViewNodeWithoutTags(t *testing.T) {
 	}
 }
 
+func TestViewNodeTimeline(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node := domain.Node{MacAddr: "aa:aa:aa:aa:aa:aa"}
+	err := db.PutItem(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 1528145185 is 2018-06-04 20:46:25 UTC
+	heartbeats := []domain.NodeHeartbeat{
+		{NodeID: node.ID, Timestamp: 1528145185, IPAddress: "10.0.0.1", Changes: domain.NodeChangeFirstHello},
+		{NodeID: node.ID, Timestamp: 1528145185 + 60, IPAddress: "10.0.0.1"},
+		{NodeID: node.ID, Timestamp: 1528145185 + 120, IPAddress: "10.0.0.2", Changes: domain.NodeChangeIPAddress},
+		{NodeID: node.ID, Timestamp: 1528145185 + domain.SecondsPerDay, IPAddress: "10.0.0.2"},
+	}
+	for i := range heartbeats {
+		err = db.PutItem(&heartbeats[i])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	strNodeID := fmt.Sprintf("%v", node.ID)
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/node/" + strNodeID + "/timeline",
+		PathParameters:        map[string]string{"id": strNodeID},
+		QueryStringParameters: map[string]string{"start": "2018-06-04", "end": "2018-06-04"},
+		Headers:               testutils.GetSuperAdminReqHeader(),
+	}
+
+	resp, err := nodeRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code viewing node timeline. Expected %d, but got %d. %s", http.StatusOK, resp.StatusCode, resp.Body)
+		return
+	}
+
+	var timeline []domain.NodeHeartbeat
+	err = json.Unmarshal([]byte(resp.Body), &timeline)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(timeline) != 3 || timeline[0].Timestamp != 1528145185 || timeline[2].IPAddress != "10.0.0.2" {
+		t.Errorf("Node timeline not as expected. Got %+v", timeline)
+	}
+
+	// Only the changes
+	req.QueryStringParameters["changes"] = "true"
+	resp, err = nodeRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = json.Unmarshal([]byte(resp.Body), &timeline)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(timeline) != 2 || timeline[1].Changes != domain.NodeChangeIPAddress {
+		t.Errorf("Node timeline changes not as expected. Got %+v", timeline)
+	}
+
+	// The dates are required
+	req.QueryStringParameters = map[string]string{}
+	resp, err = nodeRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong status code viewing node timeline without dates. Expected %d, but got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
 func TestListNodes(t *testing.T) {
 	testutils.ResetDb(t)
 
@@ -436,6 +522,152 @@ func TestListNodes(t *testing.T) {
 
 }
 
+func TestListNodesPaged(t *testing.T) {
+	testutils.ResetDb(t)
+
+	deprecatedVersion := domain.Version{Number: "1.0.0", Description: "Deprecated", Status: domain.VersionStatusDeprecated}
+	err := db.PutItem(&deprecatedVersion)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	nodes := []domain.Node{
+		{MacAddr: "aa:aa:aa:aa:aa:aa", Nickname: "Alpha", OS: "linux", Arch: "amd64", LastSeen: "2018-06-01T00:00:00Z"},
+		{MacAddr: "bb:bb:bb:bb:bb:bb", Nickname: "Bravo", OS: "linux", Arch: "arm", LastSeen: "2018-06-02T00:00:00Z",
+			RunningVersion: deprecatedVersion},
+		{MacAddr: "cc:cc:cc:cc:cc:cc", Nickname: "Charlie", OS: "windows", Arch: "amd64", LastSeen: "2018-06-03T00:00:00Z"},
+		{MacAddr: "dd:dd:dd:dd:dd:dd", Nickname: "Delta", OS: "linux", Arch: "amd64", LastSeen: "2018-06-04T00:00:00Z"},
+	}
+
+	for i := range nodes {
+		err := db.PutItem(&nodes[i])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		params     map[string]string
+		expected   []string
+		total      string
+		nextOffset string
+	}{
+		{
+			name:     "not paged",
+			params:   map[string]string{},
+			expected: []string{"Alpha", "Bravo", "Charlie", "Delta"},
+			total:    "4",
+		},
+		{
+			name:       "first page",
+			params:     map[string]string{"limit": "2"},
+			expected:   []string{"Alpha", "Bravo"},
+			total:      "4",
+			nextOffset: "2",
+		},
+		{
+			name:       "last page",
+			params:     map[string]string{"limit": "2", "offset": "2"},
+			expected:   []string{"Charlie", "Delta"},
+			total:      "4",
+			nextOffset: "",
+		},
+		{
+			name:       "sorted descending by last seen",
+			params:     map[string]string{"sort": "-last_seen", "limit": "3"},
+			expected:   []string{"Delta", "Charlie", "Bravo"},
+			total:      "4",
+			nextOffset: "3",
+		},
+		{
+			name:     "filtered by os and arch",
+			params:   map[string]string{"os": "linux", "arch": "amd64"},
+			expected: []string{"Alpha", "Delta"},
+			total:    "2",
+		},
+		{
+			name:     "filtered by last seen range",
+			params:   map[string]string{"last_seen_after": "2018-06-02", "last_seen_before": "2018-06-03T23:59:59Z"},
+			expected: []string{"Bravo", "Charlie"},
+			total:    "2",
+		},
+		{
+			name:     "nickname search",
+			params:   map[string]string{"search": "ar"},
+			expected: []string{"Charlie"},
+			total:    "1",
+		},
+		{
+			name:     "filtered by version status",
+			params:   map[string]string{"version_status": domain.VersionStatusDeprecated},
+			expected: []string{"Bravo"},
+			total:    "1",
+		},
+	}
+
+	for _, tc := range testCases {
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod:            "GET",
+			Path:                  "/node",
+			Headers:               testutils.GetSuperAdminReqHeader(),
+			QueryStringParameters: tc.params,
+		}
+
+		resp, err := listNodes(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: did not get 200 listing nodes, got: %d. %s", tc.name, resp.StatusCode, resp.Body)
+			continue
+		}
+
+		var found []domain.Node
+		err = json.Unmarshal([]byte(resp.Body), &found)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		nicknames := []string{}
+		for _, n := range found {
+			nicknames = append(nicknames, n.Nickname)
+		}
+
+		if fmt.Sprintf("%v", nicknames) != fmt.Sprintf("%v", tc.expected) {
+			t.Errorf("%s: wrong nodes listed. Expected %v, but got %v", tc.name, tc.expected, nicknames)
+		}
+
+		if resp.Headers[domain.ListRespHeaderTotalCount] != tc.total {
+			t.Errorf("%s: wrong total. Expected %s, but got %s", tc.name, tc.total, resp.Headers[domain.ListRespHeaderTotalCount])
+		}
+
+		if resp.Headers[domain.ListRespHeaderNextOffset] != tc.nextOffset {
+			t.Errorf("%s: wrong next offset. Expected %q, but got %q", tc.name, tc.nextOffset, resp.Headers[domain.ListRespHeaderNextOffset])
+		}
+	}
+
+	// Sorting by a field that isn't allowed is rejected
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/node",
+		Headers:               testutils.GetSuperAdminReqHeader(),
+		QueryStringParameters: map[string]string{"sort": "auth_token_hash"},
+	}
+	resp, err := listNodes(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 with an invalid sort field, but got %d", resp.StatusCode)
+	}
+}
+
 func TestListNodeTags(t *testing.T) {
 	testutils.ResetDb(t)
 
@@ -659,8 +891,12 @@ func TestUpdateNode(t *testing.T) {
 			tag1,
 			tag2,
 		},
-		Nickname: "updated-test",
-		Notes:    "created this node via testing",
+		Nickname:          "updated-test",
+		Notes:             "created this node via testing",
+		BusinessStartTime: "22:00",
+		BusinessCloseTime: "06:00",
+		Timezone:          "America/New_York",
+		WorkingDays:       "fri,Mon",
 		Tasks: []domain.Task{
 			{
 				Type:          domain.TaskTypeSpeedTest,
@@ -723,6 +959,257 @@ func TestUpdateNode(t *testing.T) {
 	if node.ConfiguredVersion.Number != version2.Number {
 		t.Errorf("Configured Version not updated as expected.")
 	}
+
+	if node.BusinessStartTime != "22:00" || node.BusinessCloseTime != "06:00" {
+		t.Errorf("Business times not updated as expected. Got %s to %s", node.BusinessStartTime, node.BusinessCloseTime)
+	}
+
+	if node.Timezone != update1.Timezone {
+		t.Errorf("Timezone not updated as expected. Expected %s, got %s", update1.Timezone, node.Timezone)
+	}
+
+	if node.WorkingDays != "Mon,Fri" {
+		t.Errorf("Working days not updated as expected. Expected Mon,Fri, got %s", node.WorkingDays)
+	}
+
+	var respNode domain.Node
+	err = json.Unmarshal([]byte(resp.Body), &respNode)
+	if err != nil {
+		t.Error("Unable to unmarshal body into node, err: ", err.Error(), " body: ", resp.Body)
+		return
+	}
+
+	if respNode.AuthToken == "" || !node.IsValidAuthToken(respNode.AuthToken) {
+		t.Errorf("Expected a valid agent token to be issued when updating the node, got: %q", respNode.AuthToken)
+	}
+
+	// an invalid timezone should be rejected
+	update1.Timezone = "Nowhere/Special"
+	js, err = json.Marshal(update1)
+	if err != nil {
+		t.Error("Unable to marshal update into json for api call, err: ", err.Error())
+	}
+	req.Body = string(js)
+
+	resp, err = updateNode(req)
+	if err != nil {
+		t.Error("Unable to update node, err: ", err.Error())
+	}
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Error("Expected a 400 response for an invalid timezone, got: ", resp.StatusCode, " body: ", resp.Body)
+	}
+
+	// an invalid task schedule should be rejected
+	update1.Timezone = "America/New_York"
+	update1.Tasks[0].Schedule = "*/5 * * *"
+	js, err = json.Marshal(update1)
+	if err != nil {
+		t.Error("Unable to marshal update into json for api call, err: ", err.Error())
+	}
+	req.Body = string(js)
+
+	resp, err = updateNode(req)
+	if err != nil {
+		t.Error("Unable to update node, err: ", err.Error())
+	}
+
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Error("Expected a 422 response for an invalid schedule, got: ", resp.StatusCode, " body: ", resp.Body)
+	}
+}
+
+func TestBuildSchedulePreview(t *testing.T) {
+	node := domain.Node{
+		Timezone: "America/New_York",
+		Tasks: []domain.Task{
+			{
+				Model:    gorm.Model{ID: 1},
+				Type:     domain.TaskTypeSpeedTest,
+				Schedule: "0 * * * *",
+				TaskData: domain.TaskData{
+					IntSlices: map[string][]int{
+						DownloadSizesKey: {10 * BytesPerMB},
+						UploadSizesKey:   {5 * BytesPerMB},
+					},
+					FloatValues: map[string]float64{MaxSecondsKey: 120},
+				},
+			},
+			{
+				Model:    gorm.Model{ID: 2},
+				Type:     domain.TaskTypePing,
+				Schedule: "*/30 * * * *",
+			},
+			{
+				Model:    gorm.Model{ID: 3},
+				Type:     domain.TaskTypePing,
+				Schedule: "not a schedule",
+			},
+		},
+	}
+
+	now := time.Date(2018, 6, 13, 15, 50, 0, 0, time.UTC)
+	preview := buildSchedulePreview(node, now, 2, 300*BytesPerMB)
+
+	if len(preview.Tasks) != 3 {
+		t.Errorf("Expected 3 tasks, got %+v", preview.Tasks)
+		return
+	}
+
+	expectedRuns := []string{"2018-06-13T12:00:00-04:00", "2018-06-13T13:00:00-04:00"}
+	if fmt.Sprintf("%v", preview.Tasks[0].NextRuns) != fmt.Sprintf("%v", expectedRuns) {
+		t.Errorf("Wrong next runs. Expected %v, got %v", expectedRuns, preview.Tasks[0].NextRuns)
+	}
+
+	if preview.Tasks[0].RunsPerDay != 24 || preview.Tasks[1].RunsPerDay != 48 || preview.Tasks[2].RunsPerDay != 0 {
+		t.Errorf("Wrong runs per day. Got %+v", preview.Tasks)
+	}
+
+	if preview.BytesPerDay != 24*15*BytesPerMB {
+		t.Errorf("Expected %d bytes a day, got %d", 24*15*BytesPerMB, preview.BytesPerDay)
+	}
+
+	// The bad schedule, the overlapping speed test and ping, and the budget
+	if len(preview.Warnings) != 3 {
+		t.Errorf("Expected 3 warnings, got %v", preview.Warnings)
+	}
+}
+
+func TestViewNodeSchedule(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node := domain.Node{
+		MacAddr: "aa:aa:aa:aa:aa:aa",
+		Tasks: []domain.Task{
+			{Type: domain.TaskTypePing, Schedule: "*/10 * * * *"},
+		},
+	}
+	err := db.PutItem(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	nodeID := fmt.Sprintf("%v", node.ID)
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/node/" + nodeID + "/schedule",
+		PathParameters:        map[string]string{"id": nodeID},
+		QueryStringParameters: map[string]string{"count": "3"},
+		Headers:               testutils.GetSuperAdminReqHeader(),
+	}
+
+	resp, err := nodeRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Did not get 200 response previewing schedule, got: ", resp.StatusCode, " body: ", resp.Body)
+		return
+	}
+
+	var preview domain.SchedulePreview
+	err = json.Unmarshal([]byte(resp.Body), &preview)
+	if err != nil {
+		t.Error("Unable to unmarshal body into schedule preview, err: ", err.Error(), " body: ", resp.Body)
+		return
+	}
+
+	if len(preview.Tasks) != 1 || len(preview.Tasks[0].NextRuns) != 3 || len(preview.Warnings) != 0 {
+		t.Errorf("Schedule preview not as expected. Got %+v", preview)
+	}
+
+	// The number of runs is limited
+	req.QueryStringParameters["count"] = "1000"
+	resp, err = nodeRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Error("Expected a 400 response for too many runs, got: ", resp.StatusCode, " body: ", resp.Body)
+	}
+}
+
+func TestRotateAndRevokeNodeToken(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node1 := domain.Node{
+		MacAddr:       "aa:aa:aa:aa:aa:aa",
+		AuthTokenHash: testutils.NodeAuthTokenHash,
+	}
+	err := db.PutItem(&node1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	node1Id := fmt.Sprintf("%v", node1.ID)
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "PUT",
+		Path:       "/node/" + node1Id + "/token",
+		PathParameters: map[string]string{
+			"id": node1Id,
+		},
+		Headers: testutils.GetSuperAdminReqHeader(),
+	}
+
+	resp, err := nodeRouter(req)
+	if err != nil {
+		t.Error("Unable to rotate node token, err: ", err.Error())
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Did not get 200 response rotating node token, got: ", resp.StatusCode, " body: ", resp.Body)
+		return
+	}
+
+	var respNode domain.Node
+	err = json.Unmarshal([]byte(resp.Body), &respNode)
+	if err != nil {
+		t.Error("Unable to unmarshal body into node, err: ", err.Error(), " body: ", resp.Body)
+		return
+	}
+
+	var node domain.Node
+	err = db.GetItem(&node, node1.ID)
+	if err != nil {
+		t.Error("Unable to get node, err: ", err.Error())
+		return
+	}
+
+	if node.IsValidAuthToken(testutils.NodeAuthToken) {
+		t.Error("Old agent token is still valid after rotating it")
+	}
+
+	if !node.IsValidAuthToken(respNode.AuthToken) {
+		t.Errorf("New agent token is not valid after rotating it. Got: %q", respNode.AuthToken)
+	}
+
+	req.HTTPMethod = "DELETE"
+	resp, err = nodeRouter(req)
+	if err != nil {
+		t.Error("Unable to revoke node token, err: ", err.Error())
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Did not get 200 response revoking node token, got: ", resp.StatusCode, " body: ", resp.Body)
+		return
+	}
+
+	node = domain.Node{}
+	err = db.GetItem(&node, node1.ID)
+	if err != nil {
+		t.Error("Unable to get node after revoking its token, err: ", err.Error())
+		return
+	}
+
+	if node.HasAuthToken() {
+		t.Error("Node still has an agent token after revoking it")
+	}
 }
 
 func TestRemoveAssociations(t *testing.T) {
@@ -852,3 +1339,137 @@ func TestRemoveAssociations(t *testing.T) {
 	}
 
 }
+
+func TestApproveAndRejectNode(t *testing.T) {
+	testutils.ResetDb(t)
+	testutils.CreateAdminUser(t)
+
+	pendingNode := domain.Node{
+		MacAddr: "aa:aa:aa:aa:aa:aa",
+		Status:  domain.NodeStatusPending,
+	}
+	approvedNode := domain.Node{
+		MacAddr: "bb:bb:bb:bb:bb:bb",
+		Status:  domain.NodeStatusApproved,
+	}
+
+	for _, fix := range []*domain.Node{&pendingNode, &approvedNode} {
+		err := db.PutItem(fix)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Pending nodes are left out of the default list, but superAdmins can ask for them
+	listReq := events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/node",
+		Headers:               testutils.GetSuperAdminReqHeader(),
+		QueryStringParameters: map[string]string{},
+	}
+
+	for status, expectedID := range map[string]uint{"": approvedNode.ID, domain.NodeStatusPending: pendingNode.ID} {
+		listReq.QueryStringParameters["status"] = status
+		resp, err := listNodes(listReq)
+		if err != nil {
+			t.Error("Received error trying to list nodes: ", err.Error())
+			return
+		}
+
+		var found []domain.Node
+		err = json.Unmarshal([]byte(resp.Body), &found)
+		if err != nil {
+			t.Error("Unable to unmarshal list of nodes, err: ", err.Error(), " body: ", resp.Body)
+			return
+		}
+
+		if len(found) != 1 || found[0].ID != expectedID {
+			t.Errorf("Wrong nodes listed for status %q. Expected only ID %v, got: %+v", status, expectedID, found)
+		}
+	}
+
+	listReq.Headers = testutils.GetAdminUserReqHeader()
+	resp, err := listNodes(listReq)
+	if err != nil {
+		t.Error("Received error trying to list nodes: ", err.Error())
+		return
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Error("Expected 403 for an admin listing pending nodes, got: ", resp.StatusCode, " body: ", resp.Body)
+	}
+
+	nodeID := fmt.Sprintf("%v", pendingNode.ID)
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "PUT",
+		Path:       "/node/" + nodeID + "/approve",
+		PathParameters: map[string]string{
+			"id": nodeID,
+		},
+		Headers: testutils.GetAdminUserReqHeader(),
+	}
+
+	resp, err = nodeRouter(req)
+	if err != nil {
+		t.Error("Received error trying to approve node: ", err.Error())
+		return
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Error("Expected 403 for an admin approving a node, got: ", resp.StatusCode, " body: ", resp.Body)
+	}
+
+	req.Headers = testutils.GetSuperAdminReqHeader()
+	resp, err = nodeRouter(req)
+	if err != nil {
+		t.Error("Received error trying to approve node: ", err.Error())
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Did not get 200 approving node, got: ", resp.StatusCode, " body: ", resp.Body)
+		return
+	}
+
+	var respNode domain.Node
+	err = json.Unmarshal([]byte(resp.Body), &respNode)
+	if err != nil {
+		t.Error("Unable to unmarshal body into node, err: ", err.Error(), " body: ", resp.Body)
+		return
+	}
+
+	var node domain.Node
+	err = db.GetItem(&node, pendingNode.ID)
+	if err != nil {
+		t.Error("Unable to get node, err: ", err.Error())
+		return
+	}
+
+	if !node.IsApproved() {
+		t.Errorf("Node was not approved. Status: %s", node.Status)
+	}
+
+	if !node.IsValidAuthToken(respNode.AuthToken) {
+		t.Errorf("Approving the node did not issue a valid agent token. Got: %q", respNode.AuthToken)
+	}
+
+	req.Path = "/node/" + nodeID + "/reject"
+	resp, err = nodeRouter(req)
+	if err != nil {
+		t.Error("Received error trying to reject node: ", err.Error())
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Did not get 200 rejecting node, got: ", resp.StatusCode, " body: ", resp.Body)
+		return
+	}
+
+	node = domain.Node{}
+	err = db.GetItem(&node, pendingNode.ID)
+	if err != nil {
+		t.Error("Unable to get node, err: ", err.Error())
+		return
+	}
+
+	if node.Status != domain.NodeStatusRejected || node.HasAuthToken() {
+		t.Errorf("Node was not rejected as expected. Status: %s. Has token: %v", node.Status, node.HasAuthToken())
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const UniqueNotificationChannelNameErrorMessage = "Cannot update a Notification Channel with a Name that is already in use."
+
+func notificationchannelRouter(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	_, channelSpecified := req.PathParameters["id"]
+	switch req.HTTPMethod {
+	case "GET":
+		if channelSpecified {
+			return viewNotificationChannel(req)
+		}
+		return listNotificationChannels(req)
+	case "POST":
+		return updateNotificationChannel(req)
+	case "PUT":
+		return updateNotificationChannel(req)
+	case "DELETE":
+		return deleteNotificationChannel(req)
+	default:
+		return domain.ClientError(http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	}
+}
+
+// getAuthStatusForNotificationChannel only lets superAdmins deal with channels that get the alerts for all nodes.
+// Otherwise, the user needs the channel's tag.
+func getAuthStatusForNotificationChannel(req events.APIGatewayProxyRequest, channel domain.NotificationChannel) (int, string) {
+	if channel.TagID == 0 {
+		return db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+	}
+
+	var tag domain.Tag
+	err := db.GetItem(&tag, channel.TagID)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Sprintf("error getting tag with ID: %d. %s", channel.TagID, err.Error())
+	}
+	return db.GetAuthorizationStatus(req, domain.PermissionTagBased, []domain.Tag{tag})
+}
+
+func viewNotificationChannel(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	var channel domain.NotificationChannel
+	err := db.GetItem(&channel, id)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.NotificationChannel{}, err)
+	}
+
+	statusCode, errMsg := getAuthStatusForNotificationChannel(req, channel)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	return domain.ReturnJsonOrError(channel, err)
+}
+
+func listNotificationChannels(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, err := db.GetUserFromRequest(req)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	var allChannels []domain.NotificationChannel
+	err = db.ListItems(&allChannels, "name asc")
+	if err != nil {
+		return domain.ReturnJsonOrError([]domain.NotificationChannel{}, err)
+	}
+
+	visibleChannels := []domain.NotificationChannel{}
+	for _, channel := range allChannels {
+		if user.Role == domain.UserRoleSuperAdmin ||
+			(channel.TagID != 0 && domain.DoTagsOverlap(user.Tags, []domain.Tag{channel.Tag})) {
+			visibleChannels = append(visibleChannels, channel)
+		}
+	}
+
+	return domain.ReturnJsonOrError(visibleChannels, nil)
+}
+
+func updateNotificationChannel(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var channel domain.NotificationChannel
+
+	// If ID is provided, load existing channel for updating, otherwise we'll create a new one
+	if req.PathParameters["id"] != "" {
+		id := domain.GetResourceIDFromRequest(req)
+		if id == 0 {
+			return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+		}
+
+		err := db.GetItem(&channel, id)
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return events.APIGatewayProxyResponse{
+					StatusCode: http.StatusNotFound,
+					Body:       "",
+				}, nil
+			}
+			return domain.ServerError(err)
+		}
+
+		// Enforce user authorization for the old version of the channel
+		statusCode, errMsg := getAuthStatusForNotificationChannel(req, channel)
+		if statusCode > 0 {
+			return domain.ClientError(statusCode, errMsg)
+		}
+	}
+
+	// Parse request body for updated attributes
+	var updatedChannel domain.NotificationChannel
+	err := json.Unmarshal([]byte(req.Body), &updatedChannel)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	if updatedChannel.Name == "" {
+		return domain.ClientError(http.StatusUnprocessableEntity, "Name is required")
+	}
+
+	if !domain.IsValidNotificationChannelType(updatedChannel.Type) {
+		return domain.ClientError(http.StatusUnprocessableEntity, "Invalid Type: "+updatedChannel.Type)
+	}
+
+	if !isValidNotificationChannelTarget(updatedChannel.Type, updatedChannel.Target) {
+		return domain.ClientError(http.StatusUnprocessableEntity, "Invalid Target: "+updatedChannel.Target)
+	}
+
+	if updatedChannel.NotificationMode == "" {
+		updatedChannel.NotificationMode = domain.UserNotificationsImmediate
+	} else if !domain.IsValidNotificationMode(updatedChannel.NotificationMode) {
+		return domain.ClientError(http.StatusUnprocessableEntity, "Invalid NotificationMode: "+updatedChannel.NotificationMode)
+	}
+
+	// Enforce user authorization for the new version of the channel
+	statusCode, errMsg := getAuthStatusForNotificationChannel(req, updatedChannel)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	channel.Name = updatedChannel.Name
+	channel.Type = updatedChannel.Type
+	channel.Target = updatedChannel.Target
+	channel.NotificationMode = updatedChannel.NotificationMode
+	channel.TagID = updatedChannel.TagID
+
+	// Don't save the previously loaded tag over the new ID
+	channel.Tag = domain.Tag{}
+
+	err = db.PutItem(&channel)
+	if err != nil && strings.Contains(err.Error(), db.UniqueFieldErrorCode) {
+		return domain.ClientError(http.StatusConflict, UniqueNotificationChannelNameErrorMessage)
+	}
+	return domain.ReturnJsonOrError(channel, err)
+}
+
+// slackTargetHosts and teamsTargetHostSuffixes are where Slack and Teams incoming webhooks are hosted
+var slackTargetHosts = []string{"hooks.slack.com"}
+var teamsTargetHostSuffixes = []string{".webhook.office.com", ".logic.azure.com"}
+
+// isValidNotificationChannelTarget checks that email channels have an email address, that Slack and Teams channels
+// have an https URL on one of their hosts and that webhook channels have an http(s) URL. Hosts that are IP addresses
+// must be public ones, so that alerts can't be posted to internal services.
+func isValidNotificationChannelTarget(channelType, target string) bool {
+	if channelType == domain.NotificationChannelTypeEmail {
+		return strings.Contains(target, "@") && !strings.ContainsAny(target, " ,;")
+	}
+
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(targetURL.Hostname())
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+
+	if ip := net.ParseIP(host); ip != nil && !domain.IsPublicIP(ip) {
+		return false
+	}
+
+	switch channelType {
+	case domain.NotificationChannelTypeSlack:
+		isSlackHost, _ := domain.InArray(host, slackTargetHosts)
+		return targetURL.Scheme == "https" && isSlackHost
+	case domain.NotificationChannelTypeTeams:
+		for _, suffix := range teamsTargetHostSuffixes {
+			if strings.HasSuffix(host, suffix) {
+				return targetURL.Scheme == "https"
+			}
+		}
+		return false
+	}
+
+	return targetURL.Scheme == "https" || targetURL.Scheme == "http"
+}
+
+func deleteNotificationChannel(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	var channel domain.NotificationChannel
+	err := db.GetItem(&channel, id)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.NotificationChannel{}, err)
+	}
+
+	statusCode, errMsg := getAuthStatusForNotificationChannel(req, channel)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	err = db.DeleteItem(&channel, id)
+	return domain.ReturnJsonOrError(channel, err)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
+	"net/http"
+	"testing"
+)
+
+func TestUpdateNotificationChannel(t *testing.T) {
+	testutils.ResetDb(t)
+	testutils.CreateAdminUser(t)
+
+	testCases := []struct {
+		name       string
+		headers    map[string]string
+		channel    domain.NotificationChannel
+		statusCode int
+	}{
+		{
+			name:       "admin creating global channel",
+			headers:    testutils.GetAdminUserReqHeader(),
+			channel:    domain.NotificationChannel{Name: "Slack", Type: domain.NotificationChannelTypeSlack, Target: "https://hooks.slack.com/abc"},
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "invalid type",
+			headers:    testutils.GetSuperAdminReqHeader(),
+			channel:    domain.NotificationChannel{Name: "Pager", Type: "pager", Target: "https://pager.example.com"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "webhook without URL",
+			headers:    testutils.GetSuperAdminReqHeader(),
+			channel:    domain.NotificationChannel{Name: "Hook", Type: domain.NotificationChannelTypeWebhook, Target: "ops@example.com"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "email without address",
+			headers:    testutils.GetSuperAdminReqHeader(),
+			channel:    domain.NotificationChannel{Name: "Ops", Type: domain.NotificationChannelTypeEmail, Target: "https://example.com"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "slack channel on another host",
+			headers:    testutils.GetSuperAdminReqHeader(),
+			channel:    domain.NotificationChannel{Name: "Slack", Type: domain.NotificationChannelTypeSlack, Target: "https://slack.example.com/abc"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "webhook to the metadata service",
+			headers:    testutils.GetSuperAdminReqHeader(),
+			channel:    domain.NotificationChannel{Name: "Hook", Type: domain.NotificationChannelTypeWebhook, Target: "http://169.254.169.254/latest"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "webhook to a private address",
+			headers:    testutils.GetSuperAdminReqHeader(),
+			channel:    domain.NotificationChannel{Name: "Hook", Type: domain.NotificationChannelTypeWebhook, Target: "https://10.0.0.5/hook"},
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "valid slack channel",
+			headers:    testutils.GetSuperAdminReqHeader(),
+			channel:    domain.NotificationChannel{Name: "Slack", Type: domain.NotificationChannelTypeSlack, Target: "https://hooks.slack.com/abc"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "duplicate name",
+			headers:    testutils.GetSuperAdminReqHeader(),
+			channel:    domain.NotificationChannel{Name: "Slack", Type: domain.NotificationChannelTypeTeams, Target: "https://example.webhook.office.com/webhookb2/abc"},
+			statusCode: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range testCases {
+		js, err := json.Marshal(&tc.channel)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod: "POST",
+			Path:       "/notificationchannel",
+			Headers:    tc.headers,
+			Body:       string(js),
+		}
+		response, err := notificationchannelRouter(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if response.StatusCode != tc.statusCode {
+			t.Errorf("%s: wrong status code. Expected %d, but got %d. %s", tc.name, tc.statusCode, response.StatusCode, response.Body)
+		}
+	}
+
+	var channels []domain.NotificationChannel
+	err := db.ListItems(&channels, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(channels) != 1 || channels[0].NotificationMode != domain.UserNotificationsImmediate {
+		t.Errorf("Expected one immediate notification channel, but got %+v", channels)
+	}
+}
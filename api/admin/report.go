@@ -17,6 +17,12 @@ const PeriodTimeFormat = "2006-01-02"
 func reportRouter(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	id := req.PathParameters["id"]
 	if id != "" {
+		if strings.HasSuffix(req.Path, "/heatmap/csv") {
+			return viewNodeHeatmap(req, true)
+		}
+		if strings.HasSuffix(req.Path, "/heatmap") {
+			return viewNodeHeatmap(req, false)
+		}
 		if strings.HasSuffix(req.Path, "/raw") {
 			return getNodeRawData(req)
 		}
@@ -26,9 +32,78 @@ func reportRouter(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResp
 		return viewNodeReport(req)
 	}
 
+	if req.Path == "/report" {
+		return viewFleetReport(req)
+	}
+
 	return domain.ClientError(http.StatusBadRequest, "id is required in url")
 }
 
+// viewFleetReport summarizes the daily snapshots between "start" and "end" for all of the approved nodes
+// that the user can see or, if "tag" is provided, for the approved nodes with that tag
+func viewFleetReport(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	periodStartTimestamp, err := getTimestampFromString(req.QueryStringParameters["start"], "start")
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	periodEndTimestamp, err := getTimestampFromString(req.QueryStringParameters["end"], "end")
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	if periodEndTimestamp < periodStartTimestamp {
+		return domain.ClientError(http.StatusBadRequest, "end must not be before start")
+	}
+
+	var nodes []domain.Node
+	tagID := domain.GetUintFromString(req.QueryStringParameters["tag"])
+
+	if tagID > 0 {
+		var tag domain.Tag
+		err := db.GetItem(&tag, tagID)
+		if err != nil {
+			return domain.ReturnJsonOrError(domain.FleetReport{}, err)
+		}
+
+		// Ensure user is authorized ...
+		statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionTagBased, []domain.Tag{tag})
+		if statusCode > 0 {
+			return domain.ClientError(statusCode, errMsg)
+		}
+
+		nodes = tag.Nodes
+	} else {
+		user, err := db.GetUserFromRequest(req)
+		if err != nil {
+			return domain.ClientError(http.StatusBadRequest, err.Error())
+		}
+
+		var allNodes []domain.Node
+		err = db.ListNodesByStatus(&allNodes, domain.NodeStatusApproved, "nickname asc")
+		if err != nil {
+			return domain.ReturnJsonOrError(domain.FleetReport{}, err)
+		}
+
+		for _, node := range allNodes {
+			if domain.CanUserUseNode(user, node) {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+
+	approvedNodes := []domain.Node{}
+	for _, node := range nodes {
+		if node.IsApproved() {
+			approvedNodes = append(approvedNodes, node)
+		}
+	}
+
+	report, err := reporting.GetFleetReport(approvedNodes, periodStartTimestamp, periodEndTimestamp)
+	report.TagID = tagID
+	return domain.ReturnJsonOrError(report, err)
+}
+
 func viewNodeReport(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	id := domain.GetResourceIDFromRequest(req)
 	if id == 0 {
@@ -69,6 +144,65 @@ func viewNodeReport(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRe
 	return domain.ReturnJsonOrError(snapshots, err)
 }
 
+// viewNodeHeatmap buckets the node's raw speed test and ping logs between "start" and "end"
+// by day of the week and hour of the day
+func viewNodeHeatmap(req events.APIGatewayProxyRequest, asCSV bool) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid Node ID")
+	}
+
+	// Validate Inputs
+	periodStartTimestamp, err := getTimestampFromString(req.QueryStringParameters["start"], "start")
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	periodEndTimestamp, err := getTimestampFromString(req.QueryStringParameters["end"], "end")
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	if periodEndTimestamp < periodStartTimestamp {
+		return domain.ClientError(http.StatusBadRequest, "end must not be before start")
+	}
+
+	periodEndTimestamp = periodEndTimestamp + domain.SecondsPerDay - 1
+
+	// Fetch node to ensure exists and get tags for authorization
+	var node domain.Node
+	err = db.GetItem(&node, id)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.Node{}, err)
+	}
+
+	// Ensure user is authorized ...
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionTagBased, node.Tags)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	cells, err := reporting.GetHeatmap(node, periodStartTimestamp, periodEndTimestamp)
+	if !asCSV || err != nil {
+		heatmap := domain.Heatmap{
+			NodeID: node.ID,
+			Start:  req.QueryStringParameters["start"],
+			End:    req.QueryStringParameters["end"],
+			Cells:  cells,
+		}
+		return domain.ReturnJsonOrError(heatmap, err)
+	}
+
+	// You can't use a slice of structs as a slice of interfaces
+	cellMappers := make([]domain.TaskLogMapper, len(cells))
+	for i := range cells {
+		cellMappers[i] = cells[i]
+	}
+
+	filename := getCSVFilename(node.Nickname, "heatmap", periodStartTimestamp, periodEndTimestamp)
+	return domain.ReturnCSVOrError(cellMappers, filename, nil)
+}
+
 func getNodeReportingEvents(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	nodeID := domain.GetResourceIDFromRequest(req)
 	if nodeID == 0 {
@@ -124,7 +258,7 @@ func getTaskLogPingTestCSV(node domain.Node, startTimestamp, endTimestamp int64)
 		logMappers[i] = logItems[i]
 	}
 
-	filename := getCSVFilename(node, "ping", startTimestamp, endTimestamp)
+	filename := getCSVFilename(node.Nickname, "ping", startTimestamp, endTimestamp)
 	return domain.ReturnCSVOrError(logMappers, filename, nil)
 
 }
@@ -150,7 +284,7 @@ func getTaskLogSpeedTestCSV(node domain.Node, startTimestamp, endTimestamp int64
 		logMappers[i] = logItems[i]
 	}
 
-	filename := getCSVFilename(node, "speed", startTimestamp, endTimestamp)
+	filename := getCSVFilename(node.Nickname, "speed", startTimestamp, endTimestamp)
 	return domain.ReturnCSVOrError(logMappers, filename, nil)
 }
 
@@ -172,7 +306,7 @@ func getTaskLogDowntimeCSV(node domain.Node, startTimestamp, endTimestamp int64)
 		logMappers[i] = logItems[i]
 	}
 
-	filename := getCSVFilename(node, "downtime", startTimestamp, endTimestamp)
+	filename := getCSVFilename(node.Nickname, "downtime", startTimestamp, endTimestamp)
 	return domain.ReturnCSVOrError(logMappers, filename, nil)
 }
 
@@ -194,7 +328,7 @@ func getTaskLogRestartCSV(node domain.Node, startTimestamp, endTimestamp int64)
 		logMappers[i] = logItems[i]
 	}
 
-	filename := getCSVFilename(node, "restart", startTimestamp, endTimestamp)
+	filename := getCSVFilename(node.Nickname, "restart", startTimestamp, endTimestamp)
 	return domain.ReturnCSVOrError(logMappers, filename, nil)
 }
 
@@ -281,7 +415,7 @@ func getTimestampFromString(date, paramName string) (int64, error) {
 	return timestamp, nil
 }
 
-func getCSVFilename(node domain.Node, dataType string, startTimestamp, endTimestamp int64) string {
+func getCSVFilename(name, dataType string, startTimestamp, endTimestamp int64) string {
 
 	// Borrowed this regex stuff from https://github.com/kennygrant/sanitize/blob/master/sanitize.go
 	var (
@@ -290,15 +424,14 @@ func getCSVFilename(node domain.Node, dataType string, startTimestamp, endTimest
 		dashes      = regexp.MustCompile(`[\-]+`)
 	)
 
-	nodeName := node.Nickname
-	nodeName = separators.ReplaceAllString(nodeName, "-")
-	nodeName = illegalPath.ReplaceAllString(nodeName, " ")
-	nodeName = dashes.ReplaceAllString(nodeName, "-")
-	nodeName = strings.Trim(nodeName, " ")
+	cleanName := separators.ReplaceAllString(name, "-")
+	cleanName = illegalPath.ReplaceAllString(cleanName, " ")
+	cleanName = dashes.ReplaceAllString(cleanName, "-")
+	cleanName = strings.Trim(cleanName, " ")
 
 	startDate := time.Unix(startTimestamp, 0).UTC().Format(domain.DateLayout)
 	endDate := time.Unix(endTimestamp, 0).UTC().Format(domain.DateLayout)
 
-	filename := fmt.Sprintf(`"%s %s from %s to %s.csv"`, dataType, nodeName, startDate, endDate)
+	filename := fmt.Sprintf(`"%s %s from %s to %s.csv"`, dataType, cleanName, startDate, endDate)
 	return filename
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/silinternational/speed-snitch-admin-api"
 	"github.com/silinternational/speed-snitch-admin-api/db"
 	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
+	"net/http"
 	"strings"
 	"testing"
 )
@@ -582,3 +583,226 @@ func TestGetReportingEventsForRange(t *testing.T) {
 		}
 	}
 }
+
+func TestViewFleetReport(t *testing.T) {
+	testutils.ResetDb(t)
+
+	tag1 := domain.Tag{Name: "tag1"}
+	tag2 := domain.Tag{Name: "tag2"}
+	for _, i := range []*domain.Tag{&tag1, &tag2} {
+		db.PutItem(i)
+	}
+
+	node1 := domain.Node{MacAddr: "aa:aa:aa:aa:aa:aa", Nickname: "node1"}
+	node2 := domain.Node{MacAddr: "bb:bb:bb:bb:bb:bb", Nickname: "node2"}
+	pendingNode := domain.Node{MacAddr: "cc:cc:cc:cc:cc:cc", Nickname: "pending", Status: domain.NodeStatusPending}
+
+	nodeFixtures := []struct {
+		node *domain.Node
+		tag  domain.Tag
+	}{
+		{&node1, tag1},
+		{&node2, tag2},
+		{&pendingNode, tag1},
+	}
+	for _, fix := range nodeFixtures {
+		err := db.PutItemWithAssociations(
+			fix.node,
+			[]domain.AssociationReplacements{{Replacements: []domain.Tag{fix.tag}, AssociationName: "tags"}},
+		)
+		if err != nil {
+			t.Errorf("Error creating Node fixture.\n%s", err.Error())
+			return
+		}
+	}
+
+	adminUser := testutils.AdminUser
+	err := db.PutItemWithAssociations(
+		&adminUser,
+		[]domain.AssociationReplacements{{Replacements: []domain.Tag{tag1}, AssociationName: "tags"}},
+	)
+	if err != nil {
+		t.Error("Got Error loading user fixture.\n", err.Error())
+		return
+	}
+
+	snapshots := []domain.ReportingSnapshot{
+		{Interval: domain.ReportingIntervalDaily, Timestamp: 1527811200, NodeID: node1.ID, DownloadTotal: 20, DownloadAvg: 10, SpeedTestDataPoints: 2},
+		{Interval: domain.ReportingIntervalDaily, Timestamp: 1527897600, NodeID: node1.ID, DownloadTotal: 30, DownloadAvg: 30, SpeedTestDataPoints: 1},
+		{Interval: domain.ReportingIntervalDaily, Timestamp: 1527811200, NodeID: node2.ID, DownloadTotal: 50, DownloadAvg: 50, SpeedTestDataPoints: 1},
+		{Interval: domain.ReportingIntervalDaily, Timestamp: 1528588800, NodeID: node2.ID, DownloadTotal: 90, DownloadAvg: 90, SpeedTestDataPoints: 1},
+	}
+	for i := range snapshots {
+		err := db.PutItem(&snapshots[i])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		headers       map[string]string
+		tag           string
+		statusCode    int
+		nodes         []string
+		totalDownload float64
+	}{
+		{
+			name:          "superAdmin sees all approved nodes",
+			headers:       testutils.GetSuperAdminReqHeader(),
+			statusCode:    http.StatusOK,
+			nodes:         []string{"node1", "node2"},
+			totalDownload: 25,
+		},
+		{
+			name:          "admin only sees nodes with their tag",
+			headers:       testutils.GetAdminUserReqHeader(),
+			statusCode:    http.StatusOK,
+			nodes:         []string{"node1"},
+			totalDownload: 50.0 / 3,
+		},
+		{
+			name:          "superAdmin filtering by tag",
+			headers:       testutils.GetSuperAdminReqHeader(),
+			tag:           fmt.Sprintf("%v", tag2.ID),
+			statusCode:    http.StatusOK,
+			nodes:         []string{"node2"},
+			totalDownload: 50,
+		},
+		{
+			name:       "admin asking for another tag",
+			headers:    testutils.GetAdminUserReqHeader(),
+			tag:        fmt.Sprintf("%v", tag2.ID),
+			statusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod: "GET",
+			Path:       "/report",
+			Headers:    tc.headers,
+			QueryStringParameters: map[string]string{
+				"start": "2018-06-01",
+				"end":   "2018-06-07",
+				"tag":   tc.tag,
+			},
+		}
+
+		resp, err := reportRouter(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if resp.StatusCode != tc.statusCode {
+			t.Errorf("%s: wrong status code. Expected %v, but got %v. %s", tc.name, tc.statusCode, resp.StatusCode, resp.Body)
+			continue
+		}
+
+		if tc.statusCode != http.StatusOK {
+			continue
+		}
+
+		var report domain.FleetReport
+		err = json.Unmarshal([]byte(resp.Body), &report)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		nicknames := []string{}
+		for _, row := range report.Nodes {
+			nicknames = append(nicknames, row.Nickname)
+		}
+
+		if fmt.Sprintf("%v", nicknames) != fmt.Sprintf("%v", tc.nodes) {
+			t.Errorf("%s: wrong nodes in report. Expected %v, but got %v", tc.name, tc.nodes, nicknames)
+		}
+
+		if report.Totals.DownloadAvg != tc.totalDownload {
+			t.Errorf("%s: wrong fleet download average. Expected %v, but got %v", tc.name, tc.totalDownload, report.Totals.DownloadAvg)
+		}
+	}
+}
+
+func TestViewNodeHeatmap(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node := domain.Node{MacAddr: "aa:aa:aa:aa:aa:aa", Nickname: "node1"}
+	db.PutItem(&node)
+
+	// 1528145185 is Monday 2018-06-04 20:46:25 UTC
+	speedLogs := []domain.TaskLogSpeedTest{
+		{NodeID: node.ID, Timestamp: 1528145185, Download: 10, Upload: 5},
+		{NodeID: node.ID, Timestamp: 1528145285, Download: 20, Upload: 15},
+	}
+	for i := range speedLogs {
+		db.PutItem(&speedLogs[i])
+	}
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "GET",
+		Path:       fmt.Sprintf("/report/node/%v/heatmap", node.ID),
+		Headers:    testutils.GetSuperAdminReqHeader(),
+		PathParameters: map[string]string{
+			"id": fmt.Sprintf("%v", node.ID),
+		},
+		QueryStringParameters: map[string]string{
+			"start": "2018-06-01",
+			"end":   "2018-06-07",
+		},
+	}
+
+	resp, err := router(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code returned, expected %v, got %v. Body: %s", http.StatusOK, resp.StatusCode, resp.Body)
+		return
+	}
+
+	var heatmap domain.Heatmap
+	err = json.Unmarshal([]byte(resp.Body), &heatmap)
+	if err != nil {
+		t.Error("Unable to unmarshal heatmap, err: ", err.Error())
+		return
+	}
+
+	if len(heatmap.Cells) != 7*24 {
+		t.Errorf("Expected %v cells, got %v", 7*24, len(heatmap.Cells))
+		return
+	}
+
+	cell := heatmap.Cells[1*24+20]
+	if cell.SpeedTestCount != 2 || cell.DownloadAvg != 15 {
+		t.Errorf("Cell for Monday 20:00 not as expected. Got %+v", cell)
+	}
+
+	req.Path = req.Path + "/csv"
+	resp, err = router(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	records, err := csv.NewReader(strings.NewReader(resp.Body)).ReadAll()
+	if err != nil {
+		t.Error("Unable to read heatmap CSV, err: ", err.Error())
+		return
+	}
+
+	if len(records) != 7*24+1 || records[0][0] != "Day" {
+		t.Errorf("Heatmap CSV not as expected, got %v rows with header %v", len(records), records[0])
+	}
+
+	req.QueryStringParameters["end"] = "2018-05-01"
+	resp, _ = router(req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected %v when end is before start, got %v", http.StatusBadRequest, resp.StatusCode)
+	}
+}
@@ -61,6 +61,7 @@ func viewEvent(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespons
 	return domain.ReturnJsonOrError(reportingEvent, err)
 }
 
+// listEvents returns the global events, or the events for the node given by "node_id"
 func listEvents(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
 	nodeID := uint(0)
@@ -74,13 +75,7 @@ func listEvents(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespon
 	}
 
 	// Just return global events
-	globalEvents, err := db.GetReportingEvents(0)
-	if err != nil {
-		err := fmt.Errorf("Error getting global reporting events. %s", err.Error())
-		return domain.ReturnJsonOrError([]domain.ReportingEvent{}, err)
-	}
-
-	return domain.ReturnJsonOrError(globalEvents, err)
+	return listEventsPage(req, 0)
 }
 
 func listEventsForNode(req events.APIGatewayProxyRequest, nodeID uint) (events.APIGatewayProxyResponse, error) {
@@ -101,12 +96,34 @@ func listEventsForNode(req events.APIGatewayProxyRequest, nodeID uint) (events.A
 		return domain.ClientError(http.StatusForbidden, http.StatusText(http.StatusForbidden))
 	}
 
-	eventsForNode, err := db.GetReportingEvents(nodeID)
+	return listEventsPage(req, nodeID)
+}
+
+// listEventsPage returns a page of the events for the node, or of the global events if nodeID is 0,
+// using the paging, sorting and filtering query parameters. Only holidays are listed if "holiday" is "true".
+func listEventsPage(req events.APIGatewayProxyRequest, nodeID uint) (events.APIGatewayProxyResponse, error) {
+	sortFields := map[string]string{"date": "timestamp", "name": "name", "id": "id"}
+	params, err := domain.GetListParamsFromRequest(req, sortFields, "date")
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	filter := db.ReportingEventFilter{
+		NodeID:       nodeID,
+		StartDate:    req.QueryStringParameters["start"],
+		EndDate:      req.QueryStringParameters["end"],
+		Search:       req.QueryStringParameters["search"],
+		HolidaysOnly: req.QueryStringParameters["holiday"] == "true",
+	}
+
+	reportingEvents := []domain.ReportingEvent{}
+	total, err := db.ListReportingEventsPage(&reportingEvents, filter, params)
 	if err != nil {
-		return domain.ReturnJsonOrError([]domain.ReportingEvent{}, err)
+		err = fmt.Errorf("Error getting reporting events. %s", err.Error())
 	}
 
-	return domain.ReturnJsonOrError(eventsForNode, err)
+	pageInfo := domain.PageInfo{Total: total, Limit: params.Limit, Offset: params.Offset}
+	return domain.ReturnPageJsonOrError(reportingEvents, pageInfo, err)
 }
 
 func updateEvent(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
@@ -187,6 +204,7 @@ func updateEvent(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 
 	reportingEvent.Name = updatedEvent.Name
 	reportingEvent.Description = updatedEvent.Description
+	reportingEvent.IsHoliday = updatedEvent.IsHoliday
 	reportingEvent.NodeID = updatedEvent.NodeID
 
 	// Update the ReportingEvent (with its Node) in the database
@@ -110,7 +110,14 @@ func TestListEvents(t *testing.T) {
 		NodeID:      node.ID,
 	}
 
-	eventFixtures := []domain.ReportingEvent{event1, event2}
+	event3 := domain.ReportingEvent{
+		Date:        "2018-12-25",
+		Name:        "E3",
+		Description: "This is event 3 (a nodeless holiday)",
+		IsHoliday:   true,
+	}
+
+	eventFixtures := []domain.ReportingEvent{event1, event2, event3}
 
 	for _, fix := range eventFixtures {
 		err := db.PutItem(&fix)
@@ -148,7 +155,24 @@ func TestListEvents(t *testing.T) {
 
 	results := response.Body
 	if !strings.Contains(results, event1.Name) || strings.Contains(results, event2.Name) {
-		t.Errorf("listEvents should have returned event1 only. Got:\n%s\n", results)
+		t.Errorf("listEvents should have returned event1 and not event2. Got:\n%s\n", results)
+	}
+
+	// list only the holidays
+	req.QueryStringParameters = map[string]string{"holiday": "true"}
+	response, err = listEvents(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != 200 {
+		t.Error("Wrong status code returned, expected 200, got", response.StatusCode, response.Body)
+		return
+	}
+
+	results = response.Body
+	if !strings.Contains(results, event3.Name) || strings.Contains(results, event1.Name) {
+		t.Errorf("listEvents should have returned event3 only. Got:\n%s\n", results)
 	}
 }
 
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/rollouts"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const UniqueRolloutVersionErrorMessage = "There is already a rollout for that Version."
+
+func rolloutRouter(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	_, rolloutSpecified := req.PathParameters["id"]
+	switch req.HTTPMethod {
+	case "DELETE":
+		return deleteRollout(req)
+	case "GET":
+		if rolloutSpecified {
+			if strings.HasSuffix(req.Path, "/progress") {
+				return viewRolloutProgress(req)
+			}
+			return viewRollout(req)
+		}
+		return listRollouts(req)
+	case "POST":
+		return updateRollout(req)
+	case "PUT":
+		return updateRollout(req)
+	default:
+		return domain.ClientError(http.StatusMethodNotAllowed, "Bad request method: "+req.HTTPMethod)
+	}
+}
+
+func deleteRollout(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	var rollout domain.Rollout
+	err := db.DeleteItem(&rollout, id)
+	return domain.ReturnJsonOrError(rollout, err)
+}
+
+func viewRollout(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	var rollout domain.Rollout
+	err := db.GetItem(&rollout, id)
+	return domain.ReturnJsonOrError(rollout, err)
+}
+
+func listRollouts(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	rollouts := []domain.Rollout{}
+	err := db.ListItems(&rollouts, "id desc")
+	return domain.ReturnJsonOrError(rollouts, err)
+}
+
+// viewRolloutProgress compares the versions that the nodes set to the latest version are running
+// with the rollout's version
+func viewRolloutProgress(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	var rollout domain.Rollout
+	err := db.GetItem(&rollout, id)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.RolloutProgress{}, err)
+	}
+
+	nodes, err := rollouts.GetRolloutNodes()
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.RolloutProgress{}, err)
+	}
+
+	progress, err := rollouts.GetRolloutProgress(rollout, nodes, time.Now().UTC().Unix())
+	return domain.ReturnJsonOrError(progress, err)
+}
+
+func updateRollout(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	var rollout domain.Rollout
+	now := time.Now().UTC().Unix()
+
+	// If ID is provided, load existing rollout for updating, otherwise we'll create a new one
+	if req.PathParameters["id"] != "" {
+		id := domain.GetResourceIDFromRequest(req)
+		if id == 0 {
+			return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+		}
+
+		err := db.GetItem(&rollout, id)
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return events.APIGatewayProxyResponse{
+					StatusCode: http.StatusNotFound,
+					Body:       "",
+				}, nil
+			}
+			return domain.ServerError(err)
+		}
+	}
+
+	// Parse request body for updated attributes
+	var updatedRollout domain.Rollout
+	err := json.Unmarshal([]byte(req.Body), &updatedRollout)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	if updatedRollout.VersionID == 0 {
+		return domain.ClientError(http.StatusUnprocessableEntity, "VersionID is required")
+	}
+
+	var version domain.Version
+	err = db.GetItem(&version, updatedRollout.VersionID)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, fmt.Sprintf("error getting version with ID: %d", updatedRollout.VersionID))
+	}
+
+	if !db.AreTagsValid(updatedRollout.CanaryTags) {
+		return domain.ClientError(http.StatusBadRequest, "One or more submitted canary tags are invalid")
+	}
+
+	waves, err := domain.CleanRolloutWaves(updatedRollout.Waves)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	if updatedRollout.WaveHours < 0 || updatedRollout.MaxErrorRateIncrease < 0 || updatedRollout.MaxRestartRateIncrease < 0 {
+		return domain.ClientError(http.StatusUnprocessableEntity, "WaveHours and the rate increases cannot be negative")
+	}
+
+	if updatedRollout.WaveHours == 0 {
+		updatedRollout.WaveHours = domain.DefaultRolloutWaveHours
+	}
+
+	status := updatedRollout.Status
+	if status == "" {
+		status = rollout.Status
+	}
+
+	switch {
+	case rollout.ID == 0:
+		// A new rollout starts with the canaries, or with the first wave if there aren't any
+		status = domain.RolloutStatusActive
+		rollout.WaveStartedAt = now
+		if len(updatedRollout.CanaryTags) == 0 {
+			rollout.CurrentWave = 1
+		}
+	case status == domain.RolloutStatusActive && rollout.Status == domain.RolloutStatusPaused:
+		// Resuming gives the current wave a fresh start, so the rates that paused it don't count again
+		rollout.WaveStartedAt = now
+		rollout.PausedReason = ""
+	case status != domain.RolloutStatusActive && status != domain.RolloutStatusPaused && status != domain.RolloutStatusCompleted:
+		return domain.ClientError(http.StatusUnprocessableEntity, "Invalid Status: "+status)
+	}
+
+	rollout.VersionID = updatedRollout.VersionID
+	rollout.Version = version
+	rollout.Waves = waves
+	rollout.WaveHours = updatedRollout.WaveHours
+	rollout.MaxErrorRateIncrease = updatedRollout.MaxErrorRateIncrease
+	rollout.MaxRestartRateIncrease = updatedRollout.MaxRestartRateIncrease
+	rollout.Status = status
+
+	replaceAssoc := []domain.AssociationReplacements{
+		{
+			Replacements:    updatedRollout.CanaryTags,
+			AssociationName: "CanaryTags",
+		},
+	}
+
+	err = db.PutItemWithAssociations(&rollout, replaceAssoc)
+	if err != nil && strings.Contains(err.Error(), db.UniqueFieldErrorCode) {
+		return domain.ClientError(http.StatusConflict, UniqueRolloutVersionErrorMessage)
+	} else if err != nil {
+		return domain.ServerError(err)
+	}
+
+	err = db.GetItem(&rollout, rollout.ID)
+	return domain.ReturnJsonOrError(rollout, err)
+}
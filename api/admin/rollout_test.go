@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpdateRollout(t *testing.T) {
+	testutils.ResetDb(t)
+
+	version := domain.Version{Number: "1.1.0", Description: "Version 1.1"}
+	err := db.PutItem(&version)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tag := domain.Tag{Name: "canary", Description: "Canary nodes"}
+	err = db.PutItem(&tag)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rollout := domain.Rollout{
+		VersionID:  version.ID,
+		CanaryTags: []domain.Tag{tag},
+		Waves:      "10, 50,100",
+	}
+
+	js, err := json.Marshal(&rollout)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/rollout",
+		Headers:    testutils.GetSuperAdminReqHeader(),
+		Body:       string(js),
+	}
+	response, err := router(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code creating rollout. Expected %d, but got %d. %s", http.StatusOK, response.StatusCode, response.Body)
+		return
+	}
+
+	var created domain.Rollout
+	err = json.Unmarshal([]byte(response.Body), &created)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if created.ID == 0 || created.Status != domain.RolloutStatusActive || created.Waves != "10,50,100" ||
+		created.WaveHours != domain.DefaultRolloutWaveHours || created.CurrentWave != 0 || len(created.CanaryTags) != 1 {
+		t.Errorf("Rollout not created as expected. Got %+v", created)
+		return
+	}
+
+	// Only one rollout per version
+	response, err = router(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("Wrong status code creating a second rollout for a version. Expected %d, but got %d", http.StatusConflict, response.StatusCode)
+	}
+
+	// The waves must end with 100
+	rollout.Waves = "10,50"
+	rollout.Status = domain.RolloutStatusPaused
+	js, err = json.Marshal(&rollout)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	idStr := fmt.Sprintf("%v", created.ID)
+	req = events.APIGatewayProxyRequest{
+		HTTPMethod:     "PUT",
+		Path:           "/rollout/" + idStr,
+		PathParameters: map[string]string{"id": idStr},
+		Headers:        testutils.GetSuperAdminReqHeader(),
+		Body:           string(js),
+	}
+	response, err = router(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong status code updating rollout with bad waves. Expected %d, but got %d", http.StatusBadRequest, response.StatusCode)
+	}
+
+	// Pause it
+	rollout.Waves = "10,50,100"
+	js, err = json.Marshal(&rollout)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	req.Body = string(js)
+
+	response, err = router(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code pausing rollout. Expected %d, but got %d. %s", http.StatusOK, response.StatusCode, response.Body)
+		return
+	}
+
+	var updated domain.Rollout
+	err = json.Unmarshal([]byte(response.Body), &updated)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if updated.Status != domain.RolloutStatusPaused {
+		t.Errorf("Rollout not paused. Got status %s", updated.Status)
+	}
+
+	// Only superAdmins can manage rollouts
+	testutils.CreateAdminUser(t)
+	req.Headers = testutils.GetAdminUserReqHeader()
+	response, err = router(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("Wrong status code updating rollout as an admin. Expected %d, but got %d", http.StatusForbidden, response.StatusCode)
+	}
+}
+
+func TestViewRolloutProgress(t *testing.T) {
+	testutils.ResetDb(t)
+
+	latestVersion := domain.Version{Number: domain.VersionNumberLatest, Description: "Latest"}
+	oldVersion := domain.Version{Number: "1.0.0", Description: "Version 1.0"}
+	newVersion := domain.Version{Number: "1.1.0", Description: "Version 1.1"}
+	for _, version := range []*domain.Version{&latestVersion, &oldVersion, &newVersion} {
+		err := db.PutItem(version)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	tag := domain.Tag{Name: "canary", Description: "Canary nodes"}
+	err := db.PutItem(&tag)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	nodes := []domain.Node{
+		{
+			MacAddr:           "aa:aa:aa:aa:aa:01",
+			Tags:              []domain.Tag{tag},
+			RunningVersion:    newVersion,
+			ConfiguredVersion: latestVersion,
+		},
+		{
+			MacAddr:           "aa:aa:aa:aa:aa:02",
+			RunningVersion:    oldVersion,
+			ConfiguredVersion: latestVersion,
+		},
+		{
+			// Not set to the latest version, so not part of the rollout
+			MacAddr:           "aa:aa:aa:aa:aa:03",
+			RunningVersion:    oldVersion,
+			ConfiguredVersion: oldVersion,
+		},
+	}
+	for i := range nodes {
+		err = db.PutItem(&nodes[i])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	rollout := domain.Rollout{
+		VersionID:     newVersion.ID,
+		CanaryTags:    []domain.Tag{tag},
+		Waves:         "50,100",
+		WaveHours:     24,
+		WaveStartedAt: time.Now().UTC().Unix() - 3600,
+		Status:        domain.RolloutStatusActive,
+	}
+	err = db.PutItem(&rollout)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	idStr := fmt.Sprintf("%v", rollout.ID)
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod:     "GET",
+		Path:           "/rollout/" + idStr + "/progress",
+		PathParameters: map[string]string{"id": idStr},
+		Headers:        testutils.GetSuperAdminReqHeader(),
+	}
+
+	response, err := router(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code getting rollout progress. Expected %d, but got %d. %s", http.StatusOK, response.StatusCode, response.Body)
+		return
+	}
+
+	var progress domain.RolloutProgress
+	err = json.Unmarshal([]byte(response.Body), &progress)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if progress.Version != newVersion.Number || progress.TotalNodes != 2 || progress.TargetedNodes != 1 ||
+		progress.UpgradedNodes != 1 || progress.PendingNodes != 0 {
+		t.Errorf("Rollout progress not as expected. Got %+v", progress)
+	}
+}
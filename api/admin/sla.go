@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/reporting"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const UniqueSLANameErrorMessage = "Cannot update an SLA with a Name that is already in use."
+
+const SLAReportTypeCompliance = "compliance"
+const SLAReportTypeBreaches = "breaches"
+
+func slaRouter(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	_, slaSpecified := req.PathParameters["id"]
+	switch req.HTTPMethod {
+	case "GET":
+		if slaSpecified {
+			if strings.HasSuffix(req.Path, "/report/breaches/csv") {
+				return viewSLAReport(req, SLAReportTypeBreaches)
+			}
+			if strings.HasSuffix(req.Path, "/report/csv") {
+				return viewSLAReport(req, SLAReportTypeCompliance)
+			}
+			if strings.HasSuffix(req.Path, "/report") {
+				return viewSLAReport(req, "")
+			}
+			return viewSLA(req)
+		}
+		return listSLAs(req)
+	case "POST":
+		return updateSLA(req)
+	case "PUT":
+		return updateSLA(req)
+	case "DELETE":
+		return deleteSLA(req)
+	default:
+		return domain.ClientError(http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	}
+}
+
+// getAuthStatusForSLA requires the user to have a tag that matches the SLA's tag or the SLA's node's tags
+func getAuthStatusForSLA(req events.APIGatewayProxyRequest, sla domain.SLA) (int, string) {
+	if sla.NodeID != 0 {
+		var node domain.Node
+		err := db.GetItem(&node, sla.NodeID)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Sprintf("error getting node with ID: %d. %s", sla.NodeID, err.Error())
+		}
+		return db.GetAuthorizationStatus(req, domain.PermissionTagBased, node.Tags)
+	}
+
+	if sla.TagID != 0 {
+		var tag domain.Tag
+		err := db.GetItem(&tag, sla.TagID)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Sprintf("error getting tag with ID: %d. %s", sla.TagID, err.Error())
+		}
+		return db.GetAuthorizationStatus(req, domain.PermissionTagBased, []domain.Tag{tag})
+	}
+
+	return db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+}
+
+func canUserSeeSLA(user domain.User, sla domain.SLA) bool {
+	if user.Role == domain.UserRoleSuperAdmin {
+		return true
+	}
+
+	if sla.NodeID != 0 {
+		return domain.CanUserUseNode(user, sla.Node)
+	}
+
+	if sla.TagID != 0 {
+		return domain.DoTagsOverlap(user.Tags, []domain.Tag{sla.Tag})
+	}
+
+	return false
+}
+
+func viewSLA(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	var sla domain.SLA
+	err := db.GetItem(&sla, id)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.SLA{}, err)
+	}
+
+	statusCode, errMsg := getAuthStatusForSLA(req, sla)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	return domain.ReturnJsonOrError(sla, err)
+}
+
+func listSLAs(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, err := db.GetUserFromRequest(req)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	var allSLAs []domain.SLA
+	err = db.ListItems(&allSLAs, "name asc")
+	if err != nil {
+		return domain.ReturnJsonOrError([]domain.SLA{}, err)
+	}
+
+	visibleSLAs := []domain.SLA{}
+	for _, sla := range allSLAs {
+		if canUserSeeSLA(user, sla) {
+			visibleSLAs = append(visibleSLAs, sla)
+		}
+	}
+
+	return domain.ReturnJsonOrError(visibleSLAs, nil)
+}
+
+func updateSLA(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var sla domain.SLA
+
+	// If ID is provided, load existing SLA for updating, otherwise we'll create a new one
+	if req.PathParameters["id"] != "" {
+		id := domain.GetResourceIDFromRequest(req)
+		if id == 0 {
+			return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+		}
+
+		err := db.GetItem(&sla, id)
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return events.APIGatewayProxyResponse{
+					StatusCode: http.StatusNotFound,
+					Body:       "",
+				}, nil
+			}
+			return domain.ServerError(err)
+		}
+
+		// Enforce user authorization for the old version of the SLA
+		statusCode, errMsg := getAuthStatusForSLA(req, sla)
+		if statusCode > 0 {
+			return domain.ClientError(statusCode, errMsg)
+		}
+	}
+
+	// Parse request body for updated attributes
+	var updatedSLA domain.SLA
+	err := json.Unmarshal([]byte(req.Body), &updatedSLA)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	if updatedSLA.Name == "" {
+		return domain.ClientError(http.StatusUnprocessableEntity, "Name is required")
+	}
+
+	if (updatedSLA.NodeID == 0) == (updatedSLA.TagID == 0) {
+		return domain.ClientError(http.StatusUnprocessableEntity, "An SLA must have either a NodeID or a TagID")
+	}
+
+	if len(updatedSLA.GetTargets()) == 0 {
+		return domain.ClientError(http.StatusUnprocessableEntity, "An SLA must have at least one target")
+	}
+
+	if updatedSLA.MinDownload < 0 || updatedSLA.MinUpload < 0 || updatedSLA.MaxLatency < 0 ||
+		updatedSLA.MaxPacketLoss < 0 || updatedSLA.MinAvailability < 0 {
+		return domain.ClientError(http.StatusUnprocessableEntity, "SLA targets cannot be negative")
+	}
+
+	if updatedSLA.MaxPacketLoss > 100 || updatedSLA.MinAvailability > 100 {
+		return domain.ClientError(http.StatusUnprocessableEntity, "SLA percentages cannot be more than 100")
+	}
+
+	// Enforce user authorization for the new version of the SLA
+	statusCode, errMsg := getAuthStatusForSLA(req, updatedSLA)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	sla.Name = updatedSLA.Name
+	sla.Provider = updatedSLA.Provider
+	sla.Description = updatedSLA.Description
+	sla.MinDownload = updatedSLA.MinDownload
+	sla.MinUpload = updatedSLA.MinUpload
+	sla.MaxLatency = updatedSLA.MaxLatency
+	sla.MaxPacketLoss = updatedSLA.MaxPacketLoss
+	sla.MinAvailability = updatedSLA.MinAvailability
+	sla.NodeID = updatedSLA.NodeID
+	sla.TagID = updatedSLA.TagID
+
+	// Don't save the previously loaded associations over the new IDs
+	sla.Node = domain.Node{}
+	sla.Tag = domain.Tag{}
+
+	err = db.PutItem(&sla)
+	if err != nil && strings.Contains(err.Error(), db.UniqueFieldErrorCode) {
+		return domain.ClientError(http.StatusConflict, UniqueSLANameErrorMessage)
+	}
+	return domain.ReturnJsonOrError(sla, err)
+}
+
+func deleteSLA(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	var sla domain.SLA
+	err := db.GetItem(&sla, id)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.SLA{}, err)
+	}
+
+	statusCode, errMsg := getAuthStatusForSLA(req, sla)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	err = db.DeleteItem(&sla, id)
+	return domain.ReturnJsonOrError(sla, err)
+}
+
+// getSLANodes returns the SLA's node, or the approved nodes with the SLA's tag
+func getSLANodes(sla domain.SLA) ([]domain.Node, error) {
+	if sla.NodeID != 0 {
+		var node domain.Node
+		err := db.GetItem(&node, sla.NodeID)
+		return []domain.Node{node}, err
+	}
+
+	var tag domain.Tag
+	err := db.GetItem(&tag, sla.TagID)
+	if err != nil {
+		return []domain.Node{}, err
+	}
+
+	nodes := []domain.Node{}
+	for _, node := range tag.Nodes {
+		if node.IsApproved() {
+			nodes = append(nodes, node)
+		}
+	}
+
+	return nodes, nil
+}
+
+// viewSLAReport checks the SLA's nodes against its targets for the "month" (YYYY-MM).
+// If reportType is given, the compliance rows or the breach periods are returned as a CSV file.
+func viewSLAReport(req events.APIGatewayProxyRequest, reportType string) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	month, err := time.Parse(domain.SLAMonthLayout, req.QueryStringParameters["month"])
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, "month parameter is required and should be format YYYY-MM")
+	}
+
+	var sla domain.SLA
+	err = db.GetItem(&sla, id)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.SLA{}, err)
+	}
+
+	statusCode, errMsg := getAuthStatusForSLA(req, sla)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	nodes, err := getSLANodes(sla)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.SLAComplianceReport{}, err)
+	}
+
+	report, err := reporting.GetSLAComplianceReport(sla, nodes, month)
+	if reportType == "" || err != nil {
+		return domain.ReturnJsonOrError(report, err)
+	}
+
+	// You can't use a slice of structs as a slice of interfaces
+	reportMappers := []domain.TaskLogMapper{}
+	if reportType == SLAReportTypeBreaches {
+		for i := range report.Breaches {
+			reportMappers = append(reportMappers, report.Breaches[i])
+		}
+	} else {
+		for i := range report.Rows {
+			reportMappers = append(reportMappers, report.Rows[i])
+		}
+	}
+
+	startTimestamp, endTimestamp, err := reporting.GetStartEndTimestampsForInterval(month, domain.ReportingIntervalMonthly)
+	if err != nil {
+		return domain.ServerError(err)
+	}
+
+	filename := getCSVFilename(sla.Name, "SLA "+reportType, startTimestamp, endTimestamp)
+	return domain.ReturnCSVOrError(reportMappers, filename, nil)
+}
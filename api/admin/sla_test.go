@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUpdateSLA(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node := domain.Node{MacAddr: "aa:aa:aa:aa:aa:aa"}
+	err := db.PutItem(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sla := domain.SLA{
+		Name:        "Fiber Contract",
+		Provider:    "Example ISP",
+		MinDownload: 100,
+		MaxLatency:  50,
+	}
+
+	js, err := json.Marshal(&sla)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// An SLA must be attached to a node or a tag
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/sla",
+		Headers:    testutils.GetSuperAdminReqHeader(),
+		Body:       string(js),
+	}
+	response, err := updateSLA(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Wrong status code creating SLA without a node. Expected %d, but got %d", http.StatusUnprocessableEntity, response.StatusCode)
+		return
+	}
+
+	sla.NodeID = node.ID
+	js, err = json.Marshal(&sla)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	req.Body = string(js)
+
+	response, err = updateSLA(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code creating SLA. Expected %d, but got %d. %s", http.StatusOK, response.StatusCode, response.Body)
+		return
+	}
+
+	var created domain.SLA
+	err = json.Unmarshal([]byte(response.Body), &created)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if created.ID == 0 || created.NodeID != node.ID || created.MinDownload != 100 {
+		t.Errorf("SLA not created as expected. Got %+v", created)
+		return
+	}
+
+	// The name must be unique
+	response, err = updateSLA(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("Wrong status code creating duplicate SLA. Expected %d, but got %d", http.StatusConflict, response.StatusCode)
+		return
+	}
+
+	// An SLA needs at least one target
+	sla.MinDownload = 0
+	sla.MaxLatency = 0
+	js, err = json.Marshal(&sla)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	idStr := fmt.Sprintf("%v", created.ID)
+	req = events.APIGatewayProxyRequest{
+		HTTPMethod:     "PUT",
+		Path:           "/sla/" + idStr,
+		PathParameters: map[string]string{"id": idStr},
+		Headers:        testutils.GetSuperAdminReqHeader(),
+		Body:           string(js),
+	}
+	response, err = updateSLA(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Wrong status code updating SLA without targets. Expected %d, but got %d", http.StatusUnprocessableEntity, response.StatusCode)
+	}
+}
+
+func TestViewSLAReport(t *testing.T) {
+	testutils.ResetDb(t)
+
+	tag := domain.Tag{Name: "isp", Description: "Nodes on the ISP"}
+	err := db.PutItem(&tag)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	node := domain.Node{MacAddr: "aa:aa:aa:aa:aa:aa", Nickname: "office", Tags: []domain.Tag{tag}}
+	err = db.PutItem(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 1528145185 is 2018-06-04 20:46:25 UTC
+	speedLogs := []domain.TaskLogSpeedTest{
+		{NodeID: node.ID, Timestamp: 1528145185, Download: 120, Upload: 20},
+		{NodeID: node.ID, Timestamp: 1528145185 + 3600, Download: 40, Upload: 20},
+		{NodeID: node.ID, Timestamp: 1528145185 + 7200, Download: 110, Upload: 20},
+		{NodeID: node.ID, Timestamp: 1528145185 + 10800, Download: 130, Upload: 20},
+	}
+	for i := range speedLogs {
+		err = db.PutItem(&speedLogs[i])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	sla := domain.SLA{Name: "Fiber Contract", MinDownload: 100, TagID: tag.ID}
+	err = db.PutItem(&sla)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	idStr := fmt.Sprintf("%v", sla.ID)
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/sla/" + idStr + "/report",
+		PathParameters:        map[string]string{"id": idStr},
+		QueryStringParameters: map[string]string{"month": "2018-06"},
+		Headers:               testutils.GetSuperAdminReqHeader(),
+	}
+
+	response, err := router(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code getting SLA report. Expected %d, but got %d. %s", http.StatusOK, response.StatusCode, response.Body)
+		return
+	}
+
+	var report domain.SLAComplianceReport
+	err = json.Unmarshal([]byte(response.Body), &report)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if report.Month != "2018-06" || report.Start != "2018-06-01" || report.End != "2018-06-30" {
+		t.Errorf("Report period not as expected. Got %s from %s to %s", report.Month, report.Start, report.End)
+	}
+
+	if len(report.Rows) != 1 || report.Rows[0].CompliancePercent != 75 || report.Rows[0].Measurements != 4 {
+		t.Errorf("Report rows not as expected. Got %+v", report.Rows)
+	}
+
+	if len(report.Breaches) != 1 || report.Breaches[0].Start != 1528145185+3600 || report.Breaches[0].WorstValue != 40 {
+		t.Errorf("Report breaches not as expected. Got %+v", report.Breaches)
+	}
+
+	// The breaches as CSV
+	req.Path = "/sla/" + idStr + "/report/breaches/csv"
+	response, err = router(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code getting SLA breaches CSV. Expected %d, but got %d. %s", http.StatusOK, response.StatusCode, response.Body)
+		return
+	}
+
+	lines := strings.Split(strings.TrimSpace(response.Body), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "NodeID,Nickname,MacAddr,Metric") {
+		t.Errorf("SLA breaches CSV not as expected. Got:\n%s", response.Body)
+	}
+
+	if !strings.Contains(lines[1], "2018-06-04T21:46:25Z") {
+		t.Errorf("SLA breaches CSV is missing the breach start time. Got:\n%s", response.Body)
+	}
+
+	// A month is required
+	req.QueryStringParameters = map[string]string{}
+	response, err = router(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong status code getting SLA report without a month. Expected %d, but got %d", http.StatusBadRequest, response.StatusCode)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/silinternational/speed-snitch-admin-api"
 	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/reporting"
 	"net/http"
 	"strings"
 )
@@ -17,6 +18,12 @@ func tagRouter(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespons
 	switch req.HTTPMethod {
 	case "GET":
 		if tagSpecified {
+			if strings.HasSuffix(req.Path, "/report/csv") {
+				return viewTagReport(req, true)
+			}
+			if strings.HasSuffix(req.Path, "/report") {
+				return viewTagReport(req, false)
+			}
 			return viewTag(req)
 		}
 		return listTags(req)
@@ -47,6 +54,57 @@ func viewTag(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse,
 	return domain.ReturnJsonOrError(tag, err)
 }
 
+// viewTagReport combines the snapshots of all of the tag's nodes into one entry per period,
+// returned as JSON or, if asCSV is true, as a CSV file
+func viewTagReport(req events.APIGatewayProxyRequest, asCSV bool) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	// Validate Inputs
+	interval := req.QueryStringParameters["interval"]
+	if !reporting.IsValidReportingInterval(interval) {
+		return domain.ClientError(http.StatusBadRequest, "Invalid interval specified")
+	}
+
+	periodStartTimestamp, err := getTimestampFromString(req.QueryStringParameters["start"], "start")
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	periodEndTimestamp, err := getTimestampFromString(req.QueryStringParameters["end"], "end")
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	var tag domain.Tag
+	err = db.GetItem(&tag, id)
+	if err != nil {
+		return domain.ReturnJsonOrError(domain.Tag{}, err)
+	}
+
+	// Ensure user is authorized ...
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionTagBased, []domain.Tag{tag})
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	report, err := reporting.GetTagReport(tag, interval, periodStartTimestamp, periodEndTimestamp)
+	if !asCSV || err != nil {
+		return domain.ReturnJsonOrError(report, err)
+	}
+
+	// You can't use a slice of structs as a slice of interfaces
+	reportMappers := make([]domain.TaskLogMapper, len(report))
+	for i := range report {
+		reportMappers[i] = report[i]
+	}
+
+	filename := getCSVFilename(tag.Name, interval+" report", periodStartTimestamp, periodEndTimestamp)
+	return domain.ReturnCSVOrError(reportMappers, filename, nil)
+}
+
 func listTags(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
 	if statusCode > 0 {
@@ -96,9 +154,15 @@ func updateTag(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespons
 		return domain.ClientError(http.StatusUnprocessableEntity, "Name and Description are required")
 	}
 
+	workingDays, err := domain.CleanWorkingDays(updatedTag.WorkingDays)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
 	// Update tag record attributes for persistence
 	tag.Name = updatedTag.Name
 	tag.Description = updatedTag.Description
+	tag.WorkingDays = workingDays
 
 	err = db.PutItem(&tag)
 	if err != nil && strings.Contains(err.Error(), db.UniqueFieldErrorCode) {
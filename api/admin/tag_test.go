@@ -8,6 +8,7 @@ import (
 	"github.com/silinternational/speed-snitch-admin-api/db"
 	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -224,6 +225,7 @@ func TestUpdateTag(t *testing.T) {
 	}
 
 	tag1.Name = "Tag1B"
+	tag1.WorkingDays = "Sun,Thu,Mon,Tue,Wed"
 	strID1 := fmt.Sprintf("%d", tag1.ID)
 
 	js, err := json.Marshal(&tag1)
@@ -250,6 +252,17 @@ func TestUpdateTag(t *testing.T) {
 		t.Errorf("Wrong status code returned, expected %v, got %v", http.StatusOK, response.StatusCode)
 	}
 
+	var updatedTag domain.Tag
+	err = db.GetItem(&updatedTag, tag1.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if updatedTag.WorkingDays != "Mon,Tue,Wed,Thu,Sun" {
+		t.Errorf("Working days not updated as expected. Expected Mon,Tue,Wed,Thu,Sun, got %s", updatedTag.WorkingDays)
+	}
+
 	// Check that updating one tag with the Name of the other receives a 409
 	tag1.Name = tag2.Name
 
@@ -276,3 +289,95 @@ func TestUpdateTag(t *testing.T) {
 	}
 
 }
+
+func TestViewTagReport(t *testing.T) {
+	testutils.ResetDb(t)
+	testutils.CreateAdminUser(t)
+
+	tag := domain.Tag{Name: "Site A"}
+	db.PutItem(&tag)
+
+	node1 := domain.Node{MacAddr: "aa:aa:aa:aa:aa:aa"}
+	node2 := domain.Node{MacAddr: "bb:bb:bb:bb:bb:bb"}
+	for _, node := range []*domain.Node{&node1, &node2} {
+		err := db.PutItemWithAssociations(
+			node,
+			[]domain.AssociationReplacements{{Replacements: []domain.Tag{tag}, AssociationName: "tags"}},
+		)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	snapshots := []domain.ReportingSnapshot{
+		{Interval: domain.ReportingIntervalDaily, Timestamp: 1527811200, NodeID: node1.ID, DownloadTotal: 30, SpeedTestDataPoints: 3},
+		{Interval: domain.ReportingIntervalDaily, Timestamp: 1527811200, NodeID: node2.ID, DownloadTotal: 50, SpeedTestDataPoints: 1},
+		{Interval: domain.ReportingIntervalDaily, Timestamp: 1527897600, NodeID: node1.ID, DownloadTotal: 40, SpeedTestDataPoints: 2},
+	}
+	for i := range snapshots {
+		err := db.PutItem(&snapshots[i])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod:     "GET",
+		Path:           fmt.Sprintf("/tag/%v/report", tag.ID),
+		PathParameters: map[string]string{"id": fmt.Sprintf("%v", tag.ID)},
+		Headers:        testutils.GetAdminUserReqHeader(),
+		QueryStringParameters: map[string]string{
+			"interval": domain.ReportingIntervalDaily,
+			"start":    "2018-06-01",
+			"end":      "2018-06-02",
+		},
+	}
+
+	// The admin user doesn't have the tag
+	resp, err := tagRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status %v, but got %v", http.StatusForbidden, resp.StatusCode)
+		return
+	}
+
+	req.Headers = testutils.GetSuperAdminReqHeader()
+	resp, err = tagRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %v, but got %v. %s", http.StatusOK, resp.StatusCode, resp.Body)
+		return
+	}
+
+	var results []domain.TagReportPeriod
+	err = json.Unmarshal([]byte(resp.Body), &results)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(results) != 2 || results[0].NodeCount != 2 || results[0].DownloadAvg != 20 || results[1].DownloadAvg != 20 {
+		t.Errorf("Bad tag report. Got %+v", results)
+	}
+
+	// And as CSV
+	req.Path = req.Path + "/csv"
+	resp, err = tagRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	lines := strings.Split(strings.TrimSpace(resp.Body), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "Date,Interval,NodeCount") || !strings.HasPrefix(lines[1], "2018-06-01,daily,2") {
+		t.Errorf("Bad tag report CSV. Got:\n%s", resp.Body)
+	}
+}
@@ -28,6 +28,9 @@ func userRouter(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespon
 	case "POST":
 		return updateUser(req)
 	case "PUT":
+		if strings.HasSuffix(req.Path, "/me/notifications") {
+			return updateMyNotifications(req)
+		}
 		return updateUser(req)
 	default:
 		return domain.ClientError(http.StatusMethodNotAllowed, "Bad request method: "+req.HTTPMethod)
@@ -58,6 +61,29 @@ func viewMe(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse,
 	return domain.ReturnJsonOrError(user, err)
 }
 
+// updateMyNotifications lets any user choose how they get notified about alerts for their nodes
+func updateMyNotifications(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	user, err := db.GetUserFromRequest(req)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	var updatedUser domain.User
+	err = json.Unmarshal([]byte(req.Body), &updatedUser)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	if !domain.IsValidNotificationMode(updatedUser.NotificationMode) {
+		return domain.ClientError(http.StatusBadRequest, "Invalid NotificationMode provided")
+	}
+
+	user.NotificationMode = updatedUser.NotificationMode
+
+	err = db.PutItem(&user)
+	return domain.ReturnJsonOrError(user, err)
+}
+
 func viewUser(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
 	if statusCode > 0 {
@@ -80,9 +106,22 @@ func listUsers(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespons
 		return domain.ClientError(statusCode, errMsg)
 	}
 
-	var users []domain.User
-	err := db.ListItems(&users, "name asc")
-	return domain.ReturnJsonOrError(users, err)
+	sortFields := map[string]string{"name": "name", "email": "email", "role": "role", "id": "id"}
+	params, err := domain.GetListParamsFromRequest(req, sortFields, "name")
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	filter := db.UserFilter{
+		Role:   req.QueryStringParameters["role"],
+		TagID:  domain.GetUintFromString(req.QueryStringParameters["tag"]),
+		Search: req.QueryStringParameters["search"],
+	}
+
+	users := []domain.User{}
+	total, err := db.ListUsersPage(&users, filter, params)
+	pageInfo := domain.PageInfo{Total: total, Limit: params.Limit, Offset: params.Offset}
+	return domain.ReturnPageJsonOrError(users, pageInfo, err)
 }
 
 func updateUser(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
@@ -127,6 +166,10 @@ func updateUser(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespon
 		return domain.ClientError(http.StatusBadRequest, "Invalid Role provided")
 	}
 
+	if updatedUser.NotificationMode != "" && !domain.IsValidNotificationMode(updatedUser.NotificationMode) {
+		return domain.ClientError(http.StatusBadRequest, "Invalid NotificationMode provided")
+	}
+
 	// Make sure tags are valid and user calling api is allowed to use them
 	if !db.AreTagsValid(updatedUser.Tags) {
 		return domain.ClientError(http.StatusBadRequest, "One or more submitted tags are invalid")
@@ -137,6 +180,11 @@ func updateUser(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespon
 	user.Name = updatedUser.Name
 	user.Role = updatedUser.Role
 
+	// Keep the user's own notification preference unless a new one is provided
+	if updatedUser.NotificationMode != "" {
+		user.NotificationMode = updatedUser.NotificationMode
+	}
+
 	replacements := []domain.AssociationReplacements{
 		{
 			Replacements:    updatedUser.Tags,
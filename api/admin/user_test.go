@@ -488,3 +488,47 @@ func TestIsRoleValid(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateMyNotifications(t *testing.T) {
+	testutils.ResetDb(t)
+	testutils.CreateAdminUser(t)
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "PUT",
+		Path:       "/user/me/notifications",
+		Headers:    testutils.GetAdminUserReqHeader(),
+		Body:       `{"NotificationMode": "sometimes"}`,
+	}
+
+	resp, err := userRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong status code with invalid NotificationMode. Expected %d, but got %d", http.StatusBadRequest, resp.StatusCode)
+		return
+	}
+
+	req.Body = fmt.Sprintf(`{"NotificationMode": "%s"}`, domain.UserNotificationsDigest)
+	resp, err = userRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code updating notifications. Expected %d, but got %d. %s", http.StatusOK, resp.StatusCode, resp.Body)
+		return
+	}
+
+	var dbUser domain.User
+	err = db.GetItem(&dbUser, testutils.AdminUser.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if dbUser.NotificationMode != domain.UserNotificationsDigest {
+		t.Errorf("NotificationMode not updated. Expected %s, but got %s", domain.UserNotificationsDigest, dbUser.NotificationMode)
+	}
+}
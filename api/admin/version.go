@@ -2,12 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/jinzhu/gorm"
 	"github.com/silinternational/speed-snitch-admin-api"
 	"github.com/silinternational/speed-snitch-admin-api/db"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const UniqueNumberErrorMessage = "Cannot update a Version with a Number that is already in use."
@@ -19,8 +22,14 @@ func versionRouter(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRes
 		return deleteVersion(req)
 	case "GET":
 		if versionSpecified {
+			if strings.HasSuffix(req.Path, "/nodes") {
+				return listVersionNodes(req)
+			}
 			return viewVersion(req)
 		}
+		if strings.HasSuffix(req.Path, "/summary") {
+			return viewVersionSummary(req)
+		}
 		return listVersions(req)
 	case "POST":
 		return updateVersion(req)
@@ -64,6 +73,117 @@ func listVersions(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResp
 	return domain.ReturnJsonOrError(versions, err)
 }
 
+// listVersionNodes returns the approved nodes that should be running or are running the version. Nodes that are set to
+// the latest version should be running the version that they get for it. With "failed=true" it only returns the ones
+// that still aren't running the version after the convergence "hours"
+func listVersionNodes(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := domain.GetResourceIDFromRequest(req)
+	if id == 0 {
+		return domain.ClientError(http.StatusBadRequest, "Invalid ID")
+	}
+
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	convergenceHours, err := getConvergenceHours(req)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	var version domain.Version
+	err = db.GetItem(&version, id)
+	if err != nil {
+		return domain.ReturnJsonOrError([]domain.VersionNode{}, err)
+	}
+
+	versions, nodes, rollouts, err := listVersionsNodesAndRollouts()
+	if err != nil {
+		return domain.ServerError(err)
+	}
+
+	failedOnly := req.QueryStringParameters["failed"] == "true"
+	now := time.Now().UTC()
+
+	versionNodes := []domain.VersionNode{}
+	for _, node := range nodes {
+		versionNode, err := domain.BuildVersionNode(node, versions, rollouts, now, convergenceHours)
+		if err != nil {
+			return domain.ServerError(err)
+		}
+
+		if versionNode.TargetVersionID != version.ID && versionNode.RunningVersionID != version.ID {
+			continue
+		}
+
+		if failedOnly && (versionNode.TargetVersionID != version.ID || !versionNode.ConvergenceFailed) {
+			continue
+		}
+
+		versionNodes = append(versionNodes, versionNode)
+	}
+
+	return domain.ReturnJsonOrError(versionNodes, nil)
+}
+
+// viewVersionSummary returns how many of the approved nodes are configured for and running each version
+func viewVersionSummary(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
+
+	convergenceHours, err := getConvergenceHours(req)
+	if err != nil {
+		return domain.ClientError(http.StatusBadRequest, err.Error())
+	}
+
+	versions, nodes, rollouts, err := listVersionsNodesAndRollouts()
+	if err != nil {
+		return domain.ServerError(err)
+	}
+
+	summaries, err := domain.BuildVersionSummaries(versions, nodes, rollouts, time.Now().UTC(), convergenceHours)
+	return domain.ReturnJsonOrError(summaries, err)
+}
+
+// listVersionsNodesAndRollouts returns what's needed to work out which version each of the approved nodes
+// should be running
+func listVersionsNodesAndRollouts() ([]domain.Version, []domain.Node, []domain.Rollout, error) {
+	var versions []domain.Version
+	err := db.ListItems(&versions, "number asc")
+	if err != nil {
+		return versions, []domain.Node{}, []domain.Rollout{}, err
+	}
+
+	var nodes []domain.Node
+	err = db.ListNodesByStatus(&nodes, domain.NodeStatusApproved, "id asc")
+	if err != nil {
+		return versions, nodes, []domain.Rollout{}, err
+	}
+
+	var rollouts []domain.Rollout
+	err = db.ListItems(&rollouts, "id asc")
+
+	return versions, nodes, rollouts, err
+}
+
+// getConvergenceHours returns the "hours" that nodes are given to start running their configured version
+func getConvergenceHours(req events.APIGatewayProxyRequest) (int64, error) {
+	hoursParam := req.QueryStringParameters["hours"]
+	if hoursParam == "" {
+		return domain.DefaultConvergenceHours, nil
+	}
+
+	hours, err := strconv.ParseInt(hoursParam, 10, 64)
+	if err != nil || hours < 0 {
+		return 0, fmt.Errorf("Invalid hours: %s", hoursParam)
+	}
+
+	return hours, nil
+}
+
 func updateVersion(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// Verify authorization
 	statusCode, errMsg := db.GetAuthorizationStatus(req, domain.PermissionSuperAdmin, []domain.Tag{})
@@ -103,14 +223,55 @@ func updateVersion(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRes
 		return domain.ClientError(http.StatusUnprocessableEntity, "Number and Description are required")
 	}
 
+	// Keep the existing builds if they aren't given
+	replaceBuilds := updatedVersion.Builds != nil
+	builds, err := domain.CleanVersionBuilds(updatedVersion.Builds)
+	if err != nil {
+		return domain.ClientError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	// Keep the existing status if one isn't given. New versions are stable by default.
+	status := updatedVersion.Status
+	if status == "" {
+		status = version.Status
+	}
+	if status == "" {
+		status = domain.VersionStatusStable
+	}
+
+	if !domain.IsValidVersionStatus(status) {
+		return domain.ClientError(http.StatusUnprocessableEntity, "Invalid Status: "+status)
+	}
+
 	// Update tag record attributes for persistence
 	version.Number = updatedVersion.Number
 	version.Description = updatedVersion.Description
+	version.Status = status
 
-	err = db.PutItem(&version)
+	replaceAssoc := []domain.AssociationReplacements{}
+	if replaceBuilds {
+		replaceAssoc = append(replaceAssoc, domain.AssociationReplacements{
+			Replacements:    builds,
+			AssociationName: "Builds",
+		})
+	}
+
+	err = db.PutItemWithAssociations(&version, replaceAssoc)
 
 	if err != nil && strings.Contains(err.Error(), db.UniqueFieldErrorCode) {
 		return domain.ClientError(http.StatusConflict, UniqueNumberErrorMessage)
+	} else if err != nil {
+		return domain.ReturnJsonOrError(version, err)
+	}
+
+	if replaceBuilds {
+		build := domain.VersionBuild{}
+		err = db.DeleteOrphanedItems(&build, "version_id")
+		if err != nil {
+			return domain.ServerError(err)
+		}
 	}
+
+	err = db.GetItem(&version, version.ID)
 	return domain.ReturnJsonOrError(version, err)
 }
@@ -9,8 +9,10 @@ import (
 	"github.com/silinternational/speed-snitch-admin-api/db"
 	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
 	"net/http"
+	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func updateVersionWithSuperAdmin(version domain.Version, versionID uint) (events.APIGatewayProxyResponse, string) {
@@ -429,6 +431,173 @@ func TestUpdateVersionFailUniqueNumber(t *testing.T) {
 
 }
 
+func TestUpdateVersionBuilds(t *testing.T) {
+	testutils.ResetDb(t)
+
+	checksum := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	version := domain.Version{
+		Number:      "1.2.0",
+		Description: "Version with builds",
+		Builds: []domain.VersionBuild{
+			{OS: "linux", Arch: "amd64", URL: "https://example.com/1.2.0/linux/amd64/speedsnitch", SHA256: checksum, Size: 2048},
+			{OS: "linux", Arch: "arm", URL: "https://example.com/1.2.0/linux/arm/speedsnitch", SHA256: checksum, Size: 1024},
+		},
+	}
+
+	resp, errMsg := updateVersionWithSuperAdmin(version, 0)
+	if errMsg != "" {
+		t.Error(errMsg)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Wrong status code returned creating version with builds, expected 200, got", resp.StatusCode, resp.Body)
+		return
+	}
+
+	var created domain.Version
+	err := json.Unmarshal([]byte(resp.Body), &created)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(created.Builds) != 2 {
+		t.Errorf("Expected 2 builds, got %+v", created.Builds)
+		return
+	}
+
+	// Replace the builds with a single one
+	version.Builds = version.Builds[1:]
+	resp, errMsg = updateVersionWithSuperAdmin(version, created.ID)
+	if errMsg != "" {
+		t.Error(errMsg)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Wrong status code returned updating version builds, expected 200, got", resp.StatusCode, resp.Body)
+		return
+	}
+
+	var dbVersion domain.Version
+	err = db.GetItem(&dbVersion, created.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, ok := dbVersion.GetBuild("linux", "amd64"); ok || len(dbVersion.Builds) != 1 {
+		t.Errorf("Expected only the linux/arm build, got %+v", dbVersion.Builds)
+	}
+
+	var allBuilds []domain.VersionBuild
+	err = db.ListItems(&allBuilds, "id asc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(allBuilds) != 1 {
+		t.Errorf("Expected the replaced build to be deleted, but there are %v builds", len(allBuilds))
+	}
+
+	// Leaving out the builds keeps the existing ones
+	withoutBuilds := version
+	withoutBuilds.Builds = nil
+	withoutBuilds.Description = "Version with its builds kept"
+	resp, errMsg = updateVersionWithSuperAdmin(withoutBuilds, created.ID)
+	if errMsg != "" {
+		t.Error(errMsg)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Wrong status code returned updating version without builds, expected 200, got", resp.StatusCode, resp.Body)
+		return
+	}
+
+	dbVersion = domain.Version{}
+	err = db.GetItem(&dbVersion, created.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, ok := dbVersion.GetBuild("linux", "arm"); !ok || len(dbVersion.Builds) != 1 {
+		t.Errorf("Expected the linux/arm build to be kept, got %+v", dbVersion.Builds)
+	}
+
+	// The checksum must be a SHA-256 hash
+	version.Builds[0].SHA256 = "not-a-checksum"
+	resp, errMsg = updateVersionWithSuperAdmin(version, created.ID)
+	if errMsg != "" {
+		t.Error(errMsg)
+		return
+	}
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Error("Wrong status code returned for a bad checksum, expected 422, got", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestUpdateVersionStatus(t *testing.T) {
+	testutils.ResetDb(t)
+
+	version := domain.Version{
+		Number:      "1.3.0",
+		Description: "Version with a status",
+	}
+
+	resp, errMsg := updateVersionWithSuperAdmin(version, 0)
+	if errMsg != "" {
+		t.Error(errMsg)
+		return
+	}
+
+	var created domain.Version
+	err := json.Unmarshal([]byte(resp.Body), &created)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if created.Status != domain.VersionStatusStable {
+		t.Errorf("Expected a new version to be %s, got %q", domain.VersionStatusStable, created.Status)
+	}
+
+	version.Status = domain.VersionStatusDeprecated
+	resp, errMsg = updateVersionWithSuperAdmin(version, created.ID)
+	if errMsg != "" {
+		t.Error(errMsg)
+		return
+	}
+
+	// Leaving the status out keeps the one it has
+	version.Status = ""
+	resp, errMsg = updateVersionWithSuperAdmin(version, created.ID)
+	if errMsg != "" {
+		t.Error(errMsg)
+		return
+	}
+
+	var dbVersion domain.Version
+	err = db.GetItem(&dbVersion, created.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if dbVersion.Status != domain.VersionStatusDeprecated {
+		t.Errorf("Expected the version to be %s, got %q", domain.VersionStatusDeprecated, dbVersion.Status)
+	}
+
+	version.Status = "retired"
+	resp, errMsg = updateVersionWithSuperAdmin(version, created.ID)
+	if errMsg != "" {
+		t.Error(errMsg)
+		return
+	}
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Error("Wrong status code returned for an invalid status, expected 422, got", resp.StatusCode, resp.Body)
+	}
+}
+
 func TestViewVersion(t *testing.T) {
 	testutils.ResetDb(t)
 
@@ -526,3 +695,123 @@ func TestViewVersion(t *testing.T) {
 		t.Errorf("viewVersion did not include the version1 fixture. Got:\n%s\n", results)
 	}
 }
+
+func TestListVersionNodes(t *testing.T) {
+	testutils.ResetDb(t)
+
+	// So that the versions without builds can be downloaded and nodes set to the latest version get the newest one
+	os.Setenv("downloadBaseUrl", "https://download.example.com")
+	defer os.Unsetenv("downloadBaseUrl")
+
+	oldVersion := domain.Version{Number: "1.0.0", Description: "Version 1.0"}
+	newVersion := domain.Version{Number: "1.1.0", Description: "Version 1.1"}
+	for _, version := range []*domain.Version{&oldVersion, &newVersion} {
+		err := db.PutItem(version)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	longAgo := time.Now().UTC().Add(-48 * time.Hour).Format(time.RFC3339)
+	nodes := []domain.Node{
+		{MacAddr: "aa:aa:aa:aa:aa:01", Nickname: "Upgraded", RunningVersion: newVersion, ConfiguredVersion: newVersion},
+		{MacAddr: "aa:aa:aa:aa:aa:02", Nickname: "Upgrading", RunningVersion: oldVersion, ConfiguredVersion: newVersion,
+			ConfiguredVersionAt: domain.GetTimeNow()},
+		{MacAddr: "aa:aa:aa:aa:aa:03", Nickname: "Stuck", RunningVersion: oldVersion, ConfiguredVersion: newVersion,
+			ConfiguredVersionAt: longAgo},
+		{MacAddr: "aa:aa:aa:aa:aa:04", Nickname: "Old", RunningVersion: oldVersion, ConfiguredVersion: oldVersion},
+		{MacAddr: "aa:aa:aa:aa:aa:05", Nickname: "Latest", RunningVersion: oldVersion, OS: "linux", Arch: "amd64"},
+	}
+	for i := range nodes {
+		err := db.PutItem(&nodes[i])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		params   map[string]string
+		expected []string
+	}{
+		{name: "all nodes", params: map[string]string{}, expected: []string{"Upgraded", "Upgrading", "Stuck", "Latest"}},
+		{name: "failed nodes", params: map[string]string{"failed": "true"}, expected: []string{"Stuck"}},
+		{name: "failed nodes after three days", params: map[string]string{"failed": "true", "hours": "72"},
+			expected: []string{}},
+	}
+
+	for _, tc := range testCases {
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod:            "GET",
+			Path:                  fmt.Sprintf("/version/%v/nodes", newVersion.ID),
+			PathParameters:        map[string]string{"id": fmt.Sprintf("%v", newVersion.ID)},
+			QueryStringParameters: tc.params,
+			Headers:               testutils.GetSuperAdminReqHeader(),
+		}
+
+		resp, err := versionRouter(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: wrong status code, expected 200, got %v. %s", tc.name, resp.StatusCode, resp.Body)
+			continue
+		}
+
+		var versionNodes []domain.VersionNode
+		err = json.Unmarshal([]byte(resp.Body), &versionNodes)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		nicknames := []string{}
+		for _, n := range versionNodes {
+			nicknames = append(nicknames, n.Nickname)
+		}
+
+		if fmt.Sprintf("%v", nicknames) != fmt.Sprintf("%v", tc.expected) {
+			t.Errorf("%s: wrong nodes listed. Expected %v, but got %v", tc.name, tc.expected, nicknames)
+		}
+	}
+
+	// The summary counts the same nodes
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "GET",
+		Path:       "/version/summary",
+		Headers:    testutils.GetSuperAdminReqHeader(),
+	}
+
+	resp, err := versionRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var summaries []domain.VersionSummary
+	err = json.Unmarshal([]byte(resp.Body), &summaries)
+	if err != nil {
+		t.Error("Unable to unmarshal summary, err: ", err.Error(), " body: ", resp.Body)
+		return
+	}
+
+	if len(summaries) != 2 || summaries[1].ConfiguredNodes != 4 || summaries[1].RunningNodes != 1 ||
+		summaries[1].ConvergenceFailedNodes != 1 || summaries[0].RunningNodes != 4 {
+		t.Errorf("Wrong version summary. Got %+v", summaries)
+	}
+
+	// Only superAdmins can see the summary
+	testutils.CreateAdminUser(t)
+	req.Headers = testutils.GetAdminUserReqHeader()
+	resp, err = versionRouter(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Error("Wrong status code returned, expected 403, got", resp.StatusCode, resp.Body)
+	}
+}
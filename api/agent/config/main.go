@@ -28,26 +28,61 @@ func getConfig(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRespons
 		return domain.ServerError(err)
 	}
 
-	if node.ConfiguredVersion.Number == "" || node.ConfiguredVersion.Number == "latest" {
-		latestVersion, err := db.GetLatestVersion()
+	// Nodes that are waiting for approval have not been issued a token and don't get any tasks
+	if node.Status == domain.NodeStatusPending {
+		node.Tasks = []domain.Task{}
+	} else {
+		statusCode, errMsg := domain.GetNodeAuthStatus(req, node)
+		if statusCode > 0 {
+			return domain.ClientError(statusCode, errMsg)
+		}
+	}
+
+	if node.IsOnLatestVersion() {
+		latestVersion, err := db.GetLatestVersionForNode(node)
 		if err != nil {
 			return domain.ServerError(err)
 		}
 		node.ConfiguredVersion = latestVersion
 	}
 
-	downloadUrl := domain.GetUrlForAgentVersion(node.ConfiguredVersion.Number, node.OS, node.Arch)
+	// Nodes on a revoked version, or one older than the minimum supported version, are moved to the newest stable one
+	minimumVersion := domain.GetEnv(domain.MinSupportedVersionEnvKey, "")
+	if node.ConfiguredVersion.Number != "" && !node.ConfiguredVersion.IsSupported(minimumVersion) {
+		stableVersion, err := db.GetLatestVersionForPlatform(node.OS, node.Arch, false)
+		if err != nil {
+			return domain.ServerError(err)
+		}
+
+		if stableVersion.ID == 0 {
+			domain.ErrorLogger.Printf("Version %s is not supported, but there is no stable version for %s/%s (for node %s)\n",
+				node.ConfiguredVersion.Number, node.OS, node.Arch, node.MacAddr)
+		} else {
+			node.ConfiguredVersion = stableVersion
+		}
+	}
+
+	// A version is only assigned if it can be downloaded for the node's platform, otherwise the node stays on
+	// the version it is running. If that can't be downloaded either, the node still gets its tasks.
+	download, canDownload := node.ConfiguredVersion.GetDownload(node.OS, node.Arch)
+	if node.ConfiguredVersion.Number != "" && !canDownload {
+		domain.ErrorLogger.Printf("Version %s has no download for %s/%s (for node %s)\n",
+			node.ConfiguredVersion.Number, node.OS, node.Arch, node.MacAddr)
+
+		node.ConfiguredVersion = domain.Version{}
+		if runningDownload, ok := node.RunningVersion.GetDownload(node.OS, node.Arch); ok {
+			node.ConfiguredVersion = node.RunningVersion
+			download = runningDownload
+		}
+	}
 
 	config := domain.NodeConfig{
-		Version: struct {
-			Number string
-			URL    string
-		}{
-			Number: node.ConfiguredVersion.Number,
-			URL:    downloadUrl,
-		},
 		Tasks: node.Tasks,
 	}
+	config.Version.Number = node.ConfiguredVersion.Number
+	config.Version.URL = download.URL
+	config.Version.SHA256 = download.SHA256
+	config.Version.Size = download.Size
 
 	js, err := json.Marshal(config)
 	if err != nil {
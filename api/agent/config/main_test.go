@@ -1,16 +1,21 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/jinzhu/gorm"
 	"github.com/silinternational/speed-snitch-admin-api"
 	"github.com/silinternational/speed-snitch-admin-api/db"
 	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
+	"net/http"
+	"os"
 	"strings"
 	"testing"
 )
 
+const testBuildChecksum = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
 func listVersionsCheckLength(expectedLength int) ([]domain.Version, error) {
 	versions := []domain.Version{}
 	err := db.ListItems(&versions, "id asc")
@@ -44,8 +49,18 @@ func TestGetConfig(t *testing.T) {
 		},
 		Number:      "1.1.1",
 		Description: "Version 1",
+		Builds: []domain.VersionBuild{
+			{
+				OS:     "linux",
+				Arch:   "arn",
+				URL:    "https://example.com/1.1.1/linux/arn/speedsnitch",
+				SHA256: testBuildChecksum,
+				Size:   1024,
+			},
+		},
 	}
 
+	// version2 has no build for node1's platform
 	version2 := domain.Version{
 		Model: gorm.Model{
 			ID: 2,
@@ -64,6 +79,9 @@ func TestGetConfig(t *testing.T) {
 		}
 	}
 
+	// The builds have been saved, so the nodes don't need to save them again
+	version1.Builds = nil
+
 	task1 := domain.Task{
 		Type:       domain.TaskTypePing,
 		Schedule:   "*/5 * * * *",
@@ -72,6 +90,7 @@ func TestGetConfig(t *testing.T) {
 
 	node1 := domain.Node{
 		MacAddr:           "11:12:13:14:15:16",
+		AuthTokenHash:     testutils.NodeAuthTokenHash,
 		OS:                "linux",
 		Arch:              "arn",
 		RunningVersion:    version1,
@@ -119,6 +138,7 @@ func TestGetConfig(t *testing.T) {
 		PathParameters: map[string]string{
 			"macAddr": node1.MacAddr,
 		},
+		Headers: testutils.GetNodeReqHeader(),
 	}
 
 	response, err := getConfig(req)
@@ -131,7 +151,391 @@ func TestGetConfig(t *testing.T) {
 		return
 	}
 	results := response.Body
-	if !strings.Contains(results, node1.ConfiguredVersion.Number) || !strings.Contains(results, task1.ServerHost) {
+	if !strings.Contains(results, node1.ConfiguredVersion.Number) || !strings.Contains(results, task1.ServerHost) ||
+		!strings.Contains(results, testBuildChecksum) {
 		t.Errorf("getConfig did not include the right data. Got:\n%s\n", results)
 	}
+
+	// A version without a build for the node's platform isn't assigned
+	node := domain.Node{MacAddr: node1.MacAddr}
+	err = db.FindOne(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	node.ConfiguredVersion = version2
+	node.ConfiguredVersionID = version2.ID
+	err = db.PutItem(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, err = getConfig(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var config domain.NodeConfig
+	err = json.Unmarshal([]byte(response.Body), &config)
+	if err != nil {
+		t.Error("Unable to unmarshal config, err: ", err.Error(), " body: ", response.Body)
+		return
+	}
+
+	if config.Version.Number != version1.Number || config.Version.SHA256 != testBuildChecksum {
+		t.Errorf("Expected the node to stay on the version it is running. Got %+v", config.Version)
+	}
+
+	// If neither version can be downloaded, the node still gets its tasks
+	node.RunningVersion = version2
+	node.RunningVersionID = version2.ID
+	err = db.PutItem(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, err = getConfig(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusOK {
+		t.Error("Wrong status code returned, expected 200, got", response.StatusCode, response.Body)
+		return
+	}
+
+	config = domain.NodeConfig{}
+	err = json.Unmarshal([]byte(response.Body), &config)
+	if err != nil {
+		t.Error("Unable to unmarshal config, err: ", err.Error(), " body: ", response.Body)
+		return
+	}
+
+	if config.Version.Number != "" || config.Version.URL != "" || len(config.Tasks) != 1 {
+		t.Errorf("Expected the tasks without a version. Got %+v", config)
+	}
+
+	// A version without any builds is downloaded from under the download base URL
+	os.Setenv("downloadBaseUrl", "https://download.example.com")
+	defer os.Unsetenv("downloadBaseUrl")
+
+	response, err = getConfig(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	config = domain.NodeConfig{}
+	err = json.Unmarshal([]byte(response.Body), &config)
+	if err != nil {
+		t.Error("Unable to unmarshal config, err: ", err.Error(), " body: ", response.Body)
+		return
+	}
+
+	expectedURL := "https://download.example.com/2.2.2/linux/arn/speedsnitch"
+	if config.Version.Number != version2.Number || config.Version.URL != expectedURL || config.Version.SHA256 != "" {
+		t.Errorf("Expected version %s from %s. Got %+v", version2.Number, expectedURL, config.Version)
+	}
+}
+
+func TestGetConfigUnauthenticated(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node1 := domain.Node{
+		MacAddr:       "11:12:13:14:15:16",
+		OS:            "linux",
+		Arch:          "arm",
+		AuthTokenHash: testutils.NodeAuthTokenHash,
+	}
+
+	node2 := domain.Node{
+		MacAddr: "21:22:23:24:25:26",
+		OS:      "linux",
+		Arch:    "arm",
+	}
+
+	nodeFixtures := []domain.Node{node1, node2}
+
+	for _, fix := range nodeFixtures {
+		err := db.PutItem(&fix)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	tests := []struct {
+		macAddr string
+		token   string
+	}{
+		{macAddr: node1.MacAddr, token: ""},
+		{macAddr: node1.MacAddr, token: "wrong-token"},
+		{macAddr: node2.MacAddr, token: testutils.NodeAuthToken}, // node2 has not been issued a token
+	}
+
+	for _, test := range tests {
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod: "GET",
+			Path:       "/config",
+			PathParameters: map[string]string{
+				"macAddr": test.macAddr,
+			},
+			Headers: map[string]string{
+				domain.NodeReqHeaderToken: test.token,
+			},
+		}
+
+		response, err := getConfig(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Wrong status code for %s with token %q, expected 401, got %v", test.macAddr, test.token, response.StatusCode)
+		}
+	}
+}
+
+func TestGetConfigPendingNode(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node := domain.Node{
+		MacAddr: "11:12:13:14:15:16",
+		OS:      "linux",
+		Arch:    "arm",
+		Status:  domain.NodeStatusPending,
+		Tasks: []domain.Task{
+			{
+				Type:       domain.TaskTypePing,
+				Schedule:   "*/5 * * * *",
+				ServerHost: "www.google.com",
+			},
+		},
+	}
+
+	err := db.PutItem(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "GET",
+		Path:       "/config",
+		PathParameters: map[string]string{
+			"macAddr": node.MacAddr,
+		},
+	}
+
+	response, err := getConfig(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Error("Wrong status code returned, expected 200, got", response.StatusCode, response.Body)
+		return
+	}
+
+	var config domain.NodeConfig
+	err = json.Unmarshal([]byte(response.Body), &config)
+	if err != nil {
+		t.Error("Unable to unmarshal config, err: ", err.Error(), " body: ", response.Body)
+		return
+	}
+
+	if len(config.Tasks) != 0 {
+		t.Errorf("Pending node should not get any tasks. Got: %+v", config.Tasks)
+	}
+}
+
+func TestGetConfigRollout(t *testing.T) {
+	testutils.ResetDb(t)
+
+	version1 := domain.Version{Number: "1.1.1", Description: "Version 1"}
+	version2 := domain.Version{Number: "2.2.2", Description: "Version 2"}
+	for _, version := range []*domain.Version{&version1, &version2} {
+		version.Builds = []domain.VersionBuild{
+			{
+				OS:     "linux",
+				Arch:   "arm",
+				URL:    "https://example.com/" + version.Number + "/linux/arm/speedsnitch",
+				SHA256: testBuildChecksum,
+				Size:   1024,
+			},
+		}
+
+		err := db.PutItem(version)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Without a configured version, the node gets the latest one
+	node := domain.Node{
+		MacAddr:        "11:12:13:14:15:16",
+		AuthTokenHash:  testutils.NodeAuthTokenHash,
+		OS:             "linux",
+		Arch:           "arm",
+		RunningVersion: version1,
+	}
+	err := db.PutItem(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The rollout hasn't got past the canaries, so the node stays on the version it's running
+	rollout := domain.Rollout{
+		VersionID: version2.ID,
+		Waves:     "100",
+		Status:    domain.RolloutStatusActive,
+	}
+	err = db.PutItem(&rollout)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "GET",
+		Path:       "/config",
+		PathParameters: map[string]string{
+			"macAddr": node.MacAddr,
+		},
+		Headers: testutils.GetNodeReqHeader(),
+	}
+
+	for _, status := range []string{domain.RolloutStatusActive, domain.RolloutStatusCompleted} {
+		rollout.Status = status
+		err = db.PutItem(&rollout)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		response, err := getConfig(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		var config domain.NodeConfig
+		err = json.Unmarshal([]byte(response.Body), &config)
+		if err != nil {
+			t.Error("Unable to unmarshal config, err: ", err.Error(), " body: ", response.Body)
+			return
+		}
+
+		expected := version1.Number
+		if status == domain.RolloutStatusCompleted {
+			expected = version2.Number
+		}
+
+		if config.Version.Number != expected {
+			t.Errorf("Wrong version for a %s rollout. Expected %s, got %s", status, expected, config.Version.Number)
+		}
+	}
+}
+
+func TestGetConfigVersionStatus(t *testing.T) {
+	testutils.ResetDb(t)
+
+	oldVersion := domain.Version{Number: "1.0.0", Description: "Old", Status: domain.VersionStatusDeprecated}
+	stableVersion := domain.Version{Number: "1.1.0", Description: "Stable", Status: domain.VersionStatusStable}
+	revokedVersion := domain.Version{Number: "1.5.0", Description: "Revoked", Status: domain.VersionStatusRevoked}
+	betaVersion := domain.Version{Number: "2.0.0", Description: "Beta", Status: domain.VersionStatusBeta}
+
+	for _, version := range []*domain.Version{&oldVersion, &stableVersion, &revokedVersion, &betaVersion} {
+		version.Builds = []domain.VersionBuild{
+			{
+				OS:     "linux",
+				Arch:   "arm",
+				URL:    "https://example.com/" + version.Number + "/linux/arm/speedsnitch",
+				SHA256: testBuildChecksum,
+				Size:   1024,
+			},
+		}
+
+		err := db.PutItem(version)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		version.Builds = nil
+	}
+
+	t.Setenv(domain.MinSupportedVersionEnvKey, "1.0.5")
+
+	fixtures := []struct {
+		name     string
+		node     domain.Node
+		expected string
+	}{
+		{
+			name:     "latest without betas",
+			node:     domain.Node{MacAddr: "11:12:13:14:15:16"},
+			expected: stableVersion.Number,
+		},
+		{
+			name:     "latest with betas",
+			node:     domain.Node{MacAddr: "21:22:23:24:25:26", BetaOptIn: true},
+			expected: betaVersion.Number,
+		},
+		{
+			name:     "revoked",
+			node:     domain.Node{MacAddr: "31:32:33:34:35:36", ConfiguredVersion: revokedVersion},
+			expected: stableVersion.Number,
+		},
+		{
+			name:     "older than the minimum",
+			node:     domain.Node{MacAddr: "41:42:43:44:45:46", ConfiguredVersion: oldVersion},
+			expected: stableVersion.Number,
+		},
+	}
+
+	for _, fix := range fixtures {
+		node := fix.node
+		node.AuthTokenHash = testutils.NodeAuthTokenHash
+		node.OS = "linux"
+		node.Arch = "arm"
+
+		err := db.PutItem(&node)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod: "GET",
+			Path:       "/config",
+			PathParameters: map[string]string{
+				"macAddr": node.MacAddr,
+			},
+			Headers: testutils.GetNodeReqHeader(),
+		}
+
+		response, err := getConfig(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		var config domain.NodeConfig
+		err = json.Unmarshal([]byte(response.Body), &config)
+		if err != nil {
+			t.Error("Unable to unmarshal config, err: ", err.Error(), " body: ", response.Body)
+			return
+		}
+
+		if config.Version.Number != fix.expected {
+			t.Errorf("%s: expected version %s, got %s", fix.name, fix.expected, config.Version.Number)
+		}
+	}
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/silinternational/speed-snitch-admin-api/db"
 	"github.com/silinternational/speed-snitch-admin-api/lib/ipinfo"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -19,7 +20,7 @@ type Response struct {
 
 func Handler(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
-	// Check for existing agent or create new
+	// Check for existing agent or create new (pending approval)
 	// Update attributes for agent
 	// Save changes
 	// Return 204
@@ -37,11 +38,21 @@ func Handler(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse,
 
 	err = db.FindOne(&node)
 	if err == gorm.ErrRecordNotFound {
+		// New nodes must be approved by a superAdmin before they are given any tasks
 		node.OS = helloReq.OS
 		node.Arch = helloReq.Arch
 		node.FirstSeen = domain.GetTimeNow()
+		node.Status = domain.NodeStatusPending
 	} else if err != nil {
 		return domain.ServerError(err)
+	} else if node.Status == domain.NodeStatusRejected {
+		return domain.ClientError(http.StatusForbidden, "Node has been rejected: "+node.MacAddr)
+	} else if node.HasAuthToken() {
+		// Once a node has been issued a token, it must be used to check in
+		statusCode, errMsg := domain.GetNodeAuthStatus(req, node)
+		if statusCode > 0 {
+			return domain.ClientError(statusCode, errMsg)
+		}
 	}
 
 	// If node is new or IP address has changed, update ip address, location, and coordinates
@@ -53,6 +64,9 @@ func Handler(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse,
 		reqSourceIP = req.RequestContext.Identity.SourceIP
 	}
 
+	// Compare with the previous hello before the node gets updated
+	changes := domain.GetHelloChanges(node, helloReq, reqSourceIP)
+
 	if node.IPAddress != reqSourceIP {
 		node.IPAddress = reqSourceIP
 		ipDetails, err := ipinfo.GetIPInfo(reqSourceIP)
@@ -77,6 +91,7 @@ func Handler(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse,
 		node.RunningVersion = version
 		if node.ConfiguredVersionID == 0 {
 			node.ConfiguredVersion = version
+			node.ConfiguredVersionAt = domain.GetTimeNow()
 		}
 	}
 
@@ -90,6 +105,21 @@ func Handler(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse,
 		return domain.ServerError(err)
 	}
 
+	// Keep a record of the heartbeat, so gaps in them show when the node was unreachable
+	// and changes in them show when it moved networks, was upgraded or silently rebooted
+	heartbeat := domain.NodeHeartbeat{
+		NodeID:    node.ID,
+		Timestamp: getTimeNow(),
+		IPAddress: reqSourceIP,
+		Version:   helloReq.Version,
+		Uptime:    helloReq.Uptime,
+		Changes:   strings.Join(changes, domain.NodeChangesSeparator),
+	}
+	err = db.PutItem(&heartbeat)
+	if err != nil {
+		return domain.ServerError(err)
+	}
+
 	// Return a response with a 204 status
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusNoContent,
@@ -7,6 +7,7 @@ import (
 	"github.com/silinternational/speed-snitch-admin-api"
 	"github.com/silinternational/speed-snitch-admin-api/db"
 	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
+	"net/http"
 	"testing"
 )
 
@@ -44,6 +45,7 @@ func TestHandler(t *testing.T) {
 			ID: 1,
 		},
 		MacAddr:           "11.22.33.44.55.66",
+		AuthTokenHash:     testutils.NodeAuthTokenHash,
 		OS:                "linux",
 		Arch:              "arn",
 		RunningVersion:    version1,
@@ -73,6 +75,7 @@ func TestHandler(t *testing.T) {
 		t.Error("Unable to marshal hello request to JSON, err: ", err.Error())
 	}
 
+	// An existing node that has been issued a token must use it
 	req := events.APIGatewayProxyRequest{
 		HTTPMethod:     method,
 		Path:           "/hello",
@@ -85,6 +88,18 @@ func TestHandler(t *testing.T) {
 		t.Error(err)
 		return
 	}
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Error("Wrong status code returned, expected 401, got", response.StatusCode, response.Body)
+		return
+	}
+
+	req.Headers = testutils.GetNodeReqHeader()
+
+	response, err = Handler(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
 	if response.StatusCode != 204 {
 		t.Error("Wrong status code returned, expected 204, got", response.StatusCode, response.Body)
 		return
@@ -103,6 +118,16 @@ func TestHandler(t *testing.T) {
 		return
 	}
 
+	heartbeats, err := db.ListNodeHeartbeats(node1.ID, 0, getTimeNow()+60, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(heartbeats) != 1 || heartbeats[0].Version != version2.Number || heartbeats[0].Changes != domain.NodeChangeVersion {
+		t.Errorf("Expected a heartbeat recording the version change, got %+v", heartbeats)
+	}
+
 	// Test using a missing version for a new node
 	// NOTE: This should produce an error log message even though the test doesn't fail.
 	helloReq = domain.HelloRequest{
@@ -201,4 +226,35 @@ func TestHandler(t *testing.T) {
 	if node.BusinessCloseTime != "00:00" {
 		t.Errorf("BusinessCloseTime not the default (00:00). Got: %s", node.BusinessCloseTime)
 	}
+
+	if node.Status != domain.NodeStatusPending {
+		t.Errorf("New node should be pending approval. Got status: %s", node.Status)
+	}
+
+	heartbeats, err = db.ListNodeHeartbeats(node.ID, 0, getTimeNow()+60, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(heartbeats) != 1 || heartbeats[0].Changes != domain.NodeChangeFirstHello || heartbeats[0].Uptime != 111 {
+		t.Errorf("Expected a first hello heartbeat to be recorded for the node, got %+v", heartbeats)
+	}
+
+	// Test a node that has been rejected
+	node.Status = domain.NodeStatusRejected
+	err = db.PutItem(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, err = Handler(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusForbidden {
+		t.Error("Wrong status code returned for rejected node, expected 403, got", response.StatusCode, response.Body)
+	}
 }
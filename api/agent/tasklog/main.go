@@ -10,13 +10,44 @@ import (
 	"github.com/silinternational/speed-snitch-admin-api/db"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
-func putSpeedTest(req events.APIGatewayProxyRequest, node domain.Node, macAddr string) (events.APIGatewayProxyResponse, error) {
+// getNamedServer returns the named server with the given id, looking it up only once per request
+func getNamedServer(id uint, namedServers map[uint]domain.NamedServer) (domain.NamedServer, error) {
+	if namedServer, ok := namedServers[id]; ok {
+		return namedServer, nil
+	}
+
+	var namedServer domain.NamedServer
+	err := db.GetItem(&namedServer, id)
+	if err != nil {
+		return namedServer, err
+	}
+
+	namedServers[id] = namedServer
+	return namedServer, nil
+}
+
+func logEnrichmentError(macAddr, serverCountry string, namedServerID uint, err error) {
+	// Just log it and not error out for now
+	fmt.Fprintf(
+		os.Stdout,
+		"\nUnable to enrich task log entry for node %s. Country: %s, NamedServerID: %v. Err: %s",
+		macAddr, serverCountry, namedServerID, err.Error())
+}
+
+func getSpeedTest(body []byte, node domain.Node, macAddr string, namedServers map[uint]domain.NamedServer) (*domain.TaskLogSpeedTest, error) {
 	var taskLogEntry domain.TaskLogSpeedTest
-	err := json.Unmarshal([]byte(req.Body), &taskLogEntry)
+	err := json.Unmarshal(body, &taskLogEntry)
 	if err != nil {
-		return domain.ClientError(http.StatusUnprocessableEntity, err.Error())
+		return nil, err
+	}
+
+	err = taskLogEntry.Validate(time.Now().UTC().Unix())
+	if err != nil {
+		return nil, err
 	}
 	taskLogEntry.NodeID = node.ID
 	taskLogEntry.NodeLocation = node.Location
@@ -24,16 +55,12 @@ func putSpeedTest(req events.APIGatewayProxyRequest, node domain.Node, macAddr s
 	taskLogEntry.NodeNetwork = node.Network
 	taskLogEntry.NodeIPAddress = node.IPAddress
 	taskLogEntry.NodeRunningVersion = node.RunningVersion
+	taskLogEntry.DedupeKey = taskLogEntry.GetDedupeKey().GetTimestampKey()
 
 	if taskLogEntry.NamedServerID != 0 {
-		var namedServer domain.NamedServer
-		err = db.GetItem(&namedServer, taskLogEntry.NamedServerID)
+		namedServer, err := getNamedServer(taskLogEntry.NamedServerID, namedServers)
 		if err != nil {
-			// Just log it and not error out for now
-			fmt.Fprintf(
-				os.Stdout,
-				"\nUnable to enrich task log entry for node %s. Country: %s, NamedServerID: %v. Err: %s",
-				macAddr, taskLogEntry.ServerCountry, taskLogEntry.NamedServerID, err.Error())
+			logEnrichmentError(macAddr, taskLogEntry.ServerCountry, taskLogEntry.NamedServerID, err)
 		} else {
 			taskLogEntry.ServerCountry = namedServer.ServerCountry
 			taskLogEntry.ServerCoordinates = fmt.Sprintf("%s,%s", namedServer.SpeedTestNetServer.Lat, namedServer.SpeedTestNetServer.Lon)
@@ -42,22 +69,14 @@ func putSpeedTest(req events.APIGatewayProxyRequest, node domain.Node, macAddr s
 		}
 	}
 
-	err = db.PutItem(&taskLogEntry)
-	if err != nil {
-		return domain.ServerError(err)
-	}
-
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusNoContent,
-		Body:       "",
-	}, nil
+	return &taskLogEntry, nil
 }
 
-func putPingTest(req events.APIGatewayProxyRequest, node domain.Node, macAddr string) (events.APIGatewayProxyResponse, error) {
+func getPingTest(body []byte, node domain.Node, macAddr string, namedServers map[uint]domain.NamedServer) (*domain.TaskLogPingTest, error) {
 	var taskLogEntry domain.TaskLogPingTest
-	err := json.Unmarshal([]byte(req.Body), &taskLogEntry)
+	err := json.Unmarshal(body, &taskLogEntry)
 	if err != nil {
-		return domain.ClientError(http.StatusUnprocessableEntity, err.Error())
+		return nil, err
 	}
 	taskLogEntry.NodeID = node.ID
 	taskLogEntry.NodeLocation = node.Location
@@ -65,20 +84,21 @@ func putPingTest(req events.APIGatewayProxyRequest, node domain.Node, macAddr st
 	taskLogEntry.NodeNetwork = node.Network
 	taskLogEntry.NodeIPAddress = node.IPAddress
 	taskLogEntry.NodeRunningVersionID = node.RunningVersionID
+	taskLogEntry.DedupeKey = taskLogEntry.GetDedupeKey().GetTimestampKey()
 
 	if taskLogEntry.PacketLossPercent < 0 {
 		taskLogEntry.PacketLossPercent = 0
 	}
 
+	err = taskLogEntry.Validate(time.Now().UTC().Unix())
+	if err != nil {
+		return nil, err
+	}
+
 	if taskLogEntry.NamedServerID != 0 {
-		var namedServer domain.NamedServer
-		err = db.GetItem(&namedServer, taskLogEntry.NamedServerID)
+		namedServer, err := getNamedServer(taskLogEntry.NamedServerID, namedServers)
 		if err != nil {
-			// Just log it and not error out for now
-			fmt.Fprintf(
-				os.Stdout,
-				"\nUnable to enrich task log entry for node %s. Country: %s, NamedServerID: %v. Err: %s",
-				macAddr, taskLogEntry.ServerCountry, taskLogEntry.NamedServerID, err.Error())
+			logEnrichmentError(macAddr, taskLogEntry.ServerCountry, taskLogEntry.NamedServerID, err)
 		} else {
 			taskLogEntry.ServerCountry = namedServer.ServerCountry
 			taskLogEntry.ServerName = namedServer.Name
@@ -86,63 +106,53 @@ func putPingTest(req events.APIGatewayProxyRequest, node domain.Node, macAddr st
 		}
 	}
 
-	err = db.PutItem(&taskLogEntry)
-	if err != nil {
-		return domain.ServerError(err)
-	}
-
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusNoContent,
-		Body:       "",
-	}, nil
+	return &taskLogEntry, nil
 }
 
-func putDowntime(req events.APIGatewayProxyRequest, node domain.Node) (events.APIGatewayProxyResponse, error) {
-
+func getDowntime(body []byte, node domain.Node) (*domain.TaskLogNetworkDowntime, error) {
 	var taskLogEntry domain.TaskLogNetworkDowntime
-	err := json.Unmarshal([]byte(req.Body), &taskLogEntry)
+	err := json.Unmarshal(body, &taskLogEntry)
 	if err != nil {
-		return domain.ClientError(http.StatusUnprocessableEntity, err.Error())
+		return nil, err
 	}
-	taskLogEntry.NodeID = node.ID
-	taskLogEntry.NodeNetwork = node.Network
-	taskLogEntry.NodeIPAddress = node.IPAddress
 
-	err = db.PutItem(&taskLogEntry)
+	err = taskLogEntry.Validate(time.Now().UTC().Unix())
 	if err != nil {
-		return domain.ServerError(err)
+		return nil, err
 	}
+	taskLogEntry.NodeID = node.ID
+	taskLogEntry.NodeNetwork = node.Network
+	taskLogEntry.NodeIPAddress = node.IPAddress
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusNoContent,
-		Body:       "",
-	}, nil
+	return &taskLogEntry, nil
 }
 
-func putRestart(req events.APIGatewayProxyRequest, node domain.Node) (events.APIGatewayProxyResponse, error) {
+func getRestart(body []byte, node domain.Node) (*domain.TaskLogRestart, error) {
 	var taskLogEntry domain.TaskLogRestart
-	err := json.Unmarshal([]byte(req.Body), &taskLogEntry)
+	err := json.Unmarshal(body, &taskLogEntry)
 	if err != nil {
-		return domain.ClientError(http.StatusUnprocessableEntity, err.Error())
+		return nil, err
 	}
-	taskLogEntry.NodeID = node.ID
 
-	err = db.PutItem(&taskLogEntry)
+	err = taskLogEntry.Validate(time.Now().UTC().Unix())
 	if err != nil {
-		return domain.ServerError(err)
+		return nil, err
 	}
+	taskLogEntry.NodeID = node.ID
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusNoContent,
-		Body:       "",
-	}, nil
+	return &taskLogEntry, nil
 }
 
-func putError(req events.APIGatewayProxyRequest, node domain.Node, macAddr string) (events.APIGatewayProxyResponse, error) {
+func getError(body []byte, node domain.Node, macAddr string, namedServers map[uint]domain.NamedServer) (*domain.TaskLogError, error) {
 	var taskLogEntry domain.TaskLogError
-	err := json.Unmarshal([]byte(req.Body), &taskLogEntry)
+	err := json.Unmarshal(body, &taskLogEntry)
 	if err != nil {
-		return domain.ClientError(http.StatusUnprocessableEntity, err.Error())
+		return nil, err
+	}
+
+	err = taskLogEntry.Validate(time.Now().UTC().Unix())
+	if err != nil {
+		return nil, err
 	}
 	taskLogEntry.NodeID = node.ID
 	taskLogEntry.NodeLocation = node.Location
@@ -152,14 +162,9 @@ func putError(req events.APIGatewayProxyRequest, node domain.Node, macAddr strin
 	taskLogEntry.NodeRunningVersionID = node.RunningVersionID
 
 	if taskLogEntry.NamedServerID != 0 {
-		var namedServer domain.NamedServer
-		err = db.GetItem(&namedServer, taskLogEntry.NamedServerID)
+		namedServer, err := getNamedServer(taskLogEntry.NamedServerID, namedServers)
 		if err != nil {
-			// Just log it and not error out for now
-			fmt.Fprintf(
-				os.Stdout,
-				"\nUnable to enrich task log entry for node %s. Country: %s, NamedServerID: %v. Err: %s",
-				macAddr, taskLogEntry.ServerCountry, taskLogEntry.NamedServerID, err.Error())
+			logEnrichmentError(macAddr, taskLogEntry.ServerCountry, taskLogEntry.NamedServerID, err)
 		} else {
 			taskLogEntry.ServerCountry = namedServer.ServerCountry
 			taskLogEntry.ServerName = namedServer.Name
@@ -167,17 +172,219 @@ func putError(req events.APIGatewayProxyRequest, node domain.Node, macAddr strin
 		}
 	}
 
-	err = db.PutItem(&taskLogEntry)
+	return &taskLogEntry, nil
+}
+
+// getTaskLogEntry unmarshals the body into the task log entry for the entry type and adds the node's
+// and the named server's details to it
+func getTaskLogEntry(
+	entryType string,
+	body []byte,
+	node domain.Node,
+	macAddr string,
+	namedServers map[uint]domain.NamedServer,
+) (interface{}, error) {
+	switch entryType {
+	case domain.TaskTypeSpeedTest:
+		return getSpeedTest(body, node, macAddr, namedServers)
+
+	case domain.TaskTypePing:
+		return getPingTest(body, node, macAddr, namedServers)
+
+	case domain.LogTypeDowntime:
+		return getDowntime(body, node)
+
+	case domain.LogTypeRestart:
+		return getRestart(body, node)
+
+	case domain.LogTypeError:
+		return getError(body, node, macAddr, namedServers)
+	}
+
+	return nil, fmt.Errorf("Invalid entry type: %s", entryType)
+}
+
+// setIdempotencyKey uses the idempotency key from the request header as the entry's EntryID,
+// unless the agent included one in the entry itself
+func setIdempotencyKey(taskLogEntry interface{}, key string) {
+	switch entry := taskLogEntry.(type) {
+	case *domain.TaskLogSpeedTest:
+		if entry.EntryID == "" {
+			entry.EntryID = key
+		}
+	case *domain.TaskLogPingTest:
+		if entry.EntryID == "" {
+			entry.EntryID = key
+		}
+	}
+}
+
+// isDuplicateKeyError returns true if the error is from the database rejecting a duplicate entry.
+// The unique indexes catch a duplicate that is saved at the same time as the original, which
+// isDuplicateTaskLog can't see yet.
+func isDuplicateKeyError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), db.UniqueFieldErrorCode)
+}
+
+// isDuplicateTaskLog returns true if the entry is of a type that is de-duplicated and it has already been stored
+func isDuplicateTaskLog(taskLogEntry interface{}) (bool, error) {
+	dedupable, ok := taskLogEntry.(domain.DedupableTaskLog)
+	if !ok {
+		return false, nil
+	}
+
+	return db.IsDuplicateTaskLog(dedupable)
+}
+
+// getBatchDedupeKeys returns the keys for finding duplicates of the entry within a batch
+func getBatchDedupeKeys(entryType string, taskLogEntry interface{}) []string {
+	dedupable, ok := taskLogEntry.(domain.DedupableTaskLog)
+	if !ok {
+		return []string{}
+	}
+
+	key := dedupable.GetDedupeKey()
+	keys := []string{fmt.Sprintf("%s|%v|%v", entryType, key.Timestamp, key.NamedServerID)}
+	if key.EntryID != "" {
+		keys = append(keys, entryType+"|"+key.EntryID)
+	}
+
+	return keys
+}
+
+// putTaskLogEntry saves a single task log entry. A duplicate of an entry that is already stored
+// is treated as a success without being stored again.
+func putTaskLogEntry(req events.APIGatewayProxyRequest, node domain.Node, macAddr, entryType string) (events.APIGatewayProxyResponse, error) {
+	taskLogEntry, err := getTaskLogEntry(entryType, []byte(req.Body), node, macAddr, map[uint]domain.NamedServer{})
+	if err != nil {
+		return domain.ClientError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	setIdempotencyKey(taskLogEntry, req.Headers[domain.NodeReqHeaderIdempotencyKey])
+
+	isDuplicate, err := isDuplicateTaskLog(taskLogEntry)
 	if err != nil {
 		return domain.ServerError(err)
 	}
 
+	if isDuplicate {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusNoContent,
+			Body:       "",
+		}, nil
+	}
+
+	err = db.PutItem(taskLogEntry)
+	if err != nil && !isDuplicateKeyError(err) {
+		return domain.ServerError(err)
+	}
+
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusNoContent,
 		Body:       "",
 	}, nil
 }
 
+// putBatch saves a list of task log entries of mixed types within one transaction.
+// An entry that is invalid or can't be saved doesn't stop the others from being saved.
+// Duplicates, whether of stored entries or of earlier entries in the batch, are reported as successes but not saved.
+// Responds with the result for each entry, with a 207 status if any of them failed.
+func putBatch(req events.APIGatewayProxyRequest, node domain.Node, macAddr string) (events.APIGatewayProxyResponse, error) {
+	var batch []domain.TaskLogBatchEntry
+	err := json.Unmarshal([]byte(req.Body), &batch)
+	if err != nil {
+		return domain.ClientError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	if len(batch) == 0 {
+		return domain.ClientError(http.StatusUnprocessableEntity, "Batch must include at least one entry")
+	}
+
+	if len(batch) > domain.TaskLogBatchMaxEntries {
+		return domain.ClientError(
+			http.StatusUnprocessableEntity,
+			fmt.Sprintf("Batch must not include more than %v entries", domain.TaskLogBatchMaxEntries),
+		)
+	}
+
+	results := make([]domain.TaskLogBatchResult, len(batch))
+	namedServers := map[uint]domain.NamedServer{}
+	seenKeys := map[string]bool{}
+
+	// The entries to save along with their positions in the batch
+	taskLogEntries := []interface{}{}
+	batchIndexes := []int{}
+
+	for i, item := range batch {
+		results[i] = domain.TaskLogBatchResult{Index: i, Type: item.Type}
+
+		taskLogEntry, err := getTaskLogEntry(item.Type, item.Entry, node, macAddr, namedServers)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		isDuplicate, err := isDuplicateTaskLog(taskLogEntry)
+		if err != nil {
+			return domain.ServerError(err)
+		}
+
+		dedupeKeys := getBatchDedupeKeys(item.Type, taskLogEntry)
+		for _, key := range dedupeKeys {
+			isDuplicate = isDuplicate || seenKeys[key]
+			seenKeys[key] = true
+		}
+
+		if isDuplicate {
+			results[i].Success = true
+			results[i].Duplicate = true
+			continue
+		}
+
+		taskLogEntries = append(taskLogEntries, taskLogEntry)
+		batchIndexes = append(batchIndexes, i)
+	}
+
+	if len(taskLogEntries) > 0 {
+		itemErrors, err := db.PutItemsInTransaction(taskLogEntries)
+		if err != nil {
+			return domain.ServerError(err)
+		}
+
+		for j, itemErr := range itemErrors {
+			i := batchIndexes[j]
+			if isDuplicateKeyError(itemErr) {
+				results[i].Success = true
+				results[i].Duplicate = true
+				continue
+			}
+			if itemErr != nil {
+				results[i].Error = itemErr.Error()
+				continue
+			}
+			results[i].Success = true
+		}
+	}
+
+	statusCode := http.StatusOK
+	for _, result := range results {
+		if !result.Success {
+			statusCode = http.StatusMultiStatus
+			break
+		}
+	}
+
+	js, err := json.Marshal(results)
+	if err != nil {
+		return domain.ServerError(err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Body:       string(js),
+	}, nil
+}
+
 func Handler(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	macAddr := req.PathParameters["macAddr"]
 	entryType := req.PathParameters["entryType"]
@@ -194,21 +401,21 @@ func Handler(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse,
 		return domain.ClientError(http.StatusBadRequest, err.Error())
 	}
 
-	switch entryType {
-	case domain.TaskTypeSpeedTest:
-		return putSpeedTest(req, node, macAddr)
-
-	case domain.TaskTypePing:
-		return putPingTest(req, node, macAddr)
+	if !node.IsApproved() {
+		return domain.ClientError(http.StatusForbidden, "Node has not been approved: "+node.MacAddr)
+	}
 
-	case domain.LogTypeDowntime:
-		return putDowntime(req, node)
+	statusCode, errMsg := domain.GetNodeAuthStatus(req, node)
+	if statusCode > 0 {
+		return domain.ClientError(statusCode, errMsg)
+	}
 
-	case domain.LogTypeRestart:
-		return putRestart(req, node)
+	switch entryType {
+	case domain.TaskTypeSpeedTest, domain.TaskTypePing, domain.LogTypeDowntime, domain.LogTypeRestart, domain.LogTypeError:
+		return putTaskLogEntry(req, node, macAddr, entryType)
 
-	case domain.LogTypeError:
-		return putError(req, node, macAddr)
+	case domain.LogTypeBatch:
+		return putBatch(req, node, macAddr)
 
 	}
 
@@ -8,6 +8,7 @@ import (
 	"github.com/silinternational/speed-snitch-admin-api"
 	"github.com/silinternational/speed-snitch-admin-api/db"
 	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
+	"net/http"
 	"testing"
 	"time"
 )
@@ -16,10 +17,11 @@ func TestHandlerSpeedTest(t *testing.T) {
 	testutils.ResetDb(t)
 
 	node1 := domain.Node{
-		MacAddr:     "aa:aa:aa:aa:aa:aa",
-		IPAddress:   "123.123.123.123",
-		Location:    "Charlotte, NC, Unitied States",
-		Coordinates: "23,23",
+		MacAddr:       "aa:aa:aa:aa:aa:aa",
+		AuthTokenHash: testutils.NodeAuthTokenHash,
+		IPAddress:     "123.123.123.123",
+		Location:      "Charlotte, NC, Unitied States",
+		Coordinates:   "23,23",
 		RunningVersion: domain.Version{
 			Model: gorm.Model{
 				ID: 1,
@@ -89,7 +91,8 @@ func TestHandlerSpeedTest(t *testing.T) {
 				"macAddr":   node1.MacAddr,
 				"entryType": domain.TaskTypeSpeedTest,
 			},
-			Body: string(js),
+			Body:    string(js),
+			Headers: testutils.GetNodeReqHeader(),
 		}
 
 		resp, err := Handler(req)
@@ -120,10 +123,11 @@ func TestHandlerPing(t *testing.T) {
 	testutils.ResetDb(t)
 
 	node1 := domain.Node{
-		MacAddr:     "aa:aa:aa:aa:aa:aa",
-		IPAddress:   "123.123.123.123",
-		Location:    "Charlotte, NC, Unitied States",
-		Coordinates: "23,23",
+		MacAddr:       "aa:aa:aa:aa:aa:aa",
+		AuthTokenHash: testutils.NodeAuthTokenHash,
+		IPAddress:     "123.123.123.123",
+		Location:      "Charlotte, NC, Unitied States",
+		Coordinates:   "23,23",
 		RunningVersion: domain.Version{
 			Model: gorm.Model{
 				ID: 1,
@@ -178,7 +182,8 @@ func TestHandlerPing(t *testing.T) {
 				"macAddr":   node1.MacAddr,
 				"entryType": domain.TaskTypePing,
 			},
-			Body: string(js),
+			Body:    string(js),
+			Headers: testutils.GetNodeReqHeader(),
 		}
 
 		resp, err := Handler(req)
@@ -229,10 +234,11 @@ func TestHandlerDowntime(t *testing.T) {
 	testutils.ResetDb(t)
 
 	node1 := domain.Node{
-		MacAddr:     "aa:aa:aa:aa:aa:aa",
-		IPAddress:   "123.123.123.123",
-		Location:    "Charlotte, NC, Unitied States",
-		Coordinates: "23,23",
+		MacAddr:       "aa:aa:aa:aa:aa:aa",
+		AuthTokenHash: testutils.NodeAuthTokenHash,
+		IPAddress:     "123.123.123.123",
+		Location:      "Charlotte, NC, Unitied States",
+		Coordinates:   "23,23",
 		RunningVersion: domain.Version{
 			Model: gorm.Model{
 				ID: 1,
@@ -273,7 +279,8 @@ func TestHandlerDowntime(t *testing.T) {
 				"macAddr":   node1.MacAddr,
 				"entryType": domain.LogTypeDowntime,
 			},
-			Body: string(js),
+			Body:    string(js),
+			Headers: testutils.GetNodeReqHeader(),
 		}
 
 		resp, err := Handler(req)
@@ -304,10 +311,11 @@ func TestHandlerRestart(t *testing.T) {
 	testutils.ResetDb(t)
 
 	node1 := domain.Node{
-		MacAddr:     "aa:aa:aa:aa:aa:aa",
-		IPAddress:   "123.123.123.123",
-		Location:    "Charlotte, NC, Unitied States",
-		Coordinates: "23,23",
+		MacAddr:       "aa:aa:aa:aa:aa:aa",
+		AuthTokenHash: testutils.NodeAuthTokenHash,
+		IPAddress:     "123.123.123.123",
+		Location:      "Charlotte, NC, Unitied States",
+		Coordinates:   "23,23",
 		RunningVersion: domain.Version{
 			Model: gorm.Model{
 				ID: 1,
@@ -342,7 +350,8 @@ func TestHandlerRestart(t *testing.T) {
 				"macAddr":   node1.MacAddr,
 				"entryType": domain.LogTypeRestart,
 			},
-			Body: string(js),
+			Body:    string(js),
+			Headers: testutils.GetNodeReqHeader(),
 		}
 
 		resp, err := Handler(req)
@@ -370,10 +379,11 @@ func TestHandlerError(t *testing.T) {
 	testutils.ResetDb(t)
 
 	node1 := domain.Node{
-		MacAddr:     "aa:aa:aa:aa:aa:aa",
-		IPAddress:   "123.123.123.123",
-		Location:    "Charlotte, NC, Unitied States",
-		Coordinates: "23,23",
+		MacAddr:       "aa:aa:aa:aa:aa:aa",
+		AuthTokenHash: testutils.NodeAuthTokenHash,
+		IPAddress:     "123.123.123.123",
+		Location:      "Charlotte, NC, Unitied States",
+		Coordinates:   "23,23",
 		RunningVersion: domain.Version{
 			Model: gorm.Model{
 				ID: 1,
@@ -443,7 +453,8 @@ func TestHandlerError(t *testing.T) {
 				"macAddr":   node1.MacAddr,
 				"entryType": domain.LogTypeError,
 			},
-			Body: string(js),
+			Body:    string(js),
+			Headers: testutils.GetNodeReqHeader(),
 		}
 
 		resp, err := Handler(req)
@@ -469,3 +480,280 @@ func TestHandlerError(t *testing.T) {
 		t.Errorf("Task log entry does not have correct upload value, expected %v, got %v", logsToSend[0].ErrorCode, taskLogEntry.ErrorCode)
 	}
 }
+
+func TestHandlerPendingNode(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node1 := domain.Node{
+		MacAddr: "aa:aa:aa:aa:aa:aa",
+		Status:  domain.NodeStatusPending,
+	}
+	db.PutItem(&node1)
+
+	js, err := json.Marshal(domain.TaskLogSpeedTest{Timestamp: 1531246102, Upload: 10, Download: 10})
+	if err != nil {
+		t.Error("Unable to marshal log fixture to json, err: ", err.Error())
+		return
+	}
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       fmt.Sprintf("/log/%s/%s", node1.MacAddr, domain.TaskTypeSpeedTest),
+		PathParameters: map[string]string{
+			"macAddr":   node1.MacAddr,
+			"entryType": domain.TaskTypeSpeedTest,
+		},
+		Body: string(js),
+	}
+
+	resp, err := Handler(req)
+	if err != nil {
+		t.Error("Got error trying to submit log, err: ", err.Error())
+		return
+	}
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 submitting log for a pending node, got %v. body: %s", resp.StatusCode, resp.Body)
+	}
+
+	var taskLogs []domain.TaskLogSpeedTest
+	db.GetTaskLogForRange(&taskLogs, node1.ID, 1531246102, 1531246102)
+	if len(taskLogs) != 0 {
+		t.Errorf("Log for a pending node should not have been stored. Got: %+v", taskLogs)
+	}
+}
+
+func TestHandlerBatch(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node1 := domain.Node{
+		MacAddr:       "aa:aa:aa:aa:aa:aa",
+		AuthTokenHash: testutils.NodeAuthTokenHash,
+		IPAddress:     "123.123.123.123",
+		Location:      "Charlotte, NC, Unitied States",
+		Coordinates:   "23,23",
+	}
+	db.PutItem(&node1)
+
+	namedServer := domain.NamedServer{
+		Model: gorm.Model{
+			ID: 1,
+		},
+		ServerType:    domain.ServerTypePing,
+		ServerHost:    "google.com",
+		ServerCountry: "US",
+		Name:          "test example",
+	}
+	db.PutItem(&namedServer)
+
+	batch := []map[string]interface{}{
+		{
+			"Type":  domain.TaskTypePing,
+			"Entry": domain.TaskLogPingTest{Timestamp: 1531246102, NamedServerID: namedServer.ID, Latency: 2, PacketLossPercent: -1},
+		},
+		{
+			"Type":  domain.LogTypeRestart,
+			"Entry": domain.TaskLogRestart{Timestamp: 1531246103},
+		},
+		{
+			"Type":  "notAType",
+			"Entry": domain.TaskLogRestart{Timestamp: 1531246104},
+		},
+		{
+			"Type":  domain.LogTypeDowntime,
+			"Entry": domain.TaskLogNetworkDowntime{Timestamp: 1531246105, DowntimeSeconds: 100},
+		},
+	}
+
+	js, err := json.Marshal(batch)
+	if err != nil {
+		t.Error("Unable to marshal batch fixture to json, err: ", err.Error())
+		return
+	}
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       fmt.Sprintf("/log/%s/%s", node1.MacAddr, domain.LogTypeBatch),
+		PathParameters: map[string]string{
+			"macAddr":   node1.MacAddr,
+			"entryType": domain.LogTypeBatch,
+		},
+		Body:    string(js),
+		Headers: testutils.GetNodeReqHeader(),
+	}
+
+	resp, err := Handler(req)
+	if err != nil {
+		t.Error("Got error trying to submit batch, err: ", err.Error())
+		return
+	}
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Errorf("Expected status code %v submitting batch, got %v. body: %s", http.StatusMultiStatus, resp.StatusCode, resp.Body)
+		return
+	}
+
+	var results []domain.TaskLogBatchResult
+	err = json.Unmarshal([]byte(resp.Body), &results)
+	if err != nil {
+		t.Error("Unable to unmarshal batch results, err: ", err.Error())
+		return
+	}
+
+	if len(results) != len(batch) {
+		t.Errorf("Expected %v batch results, got %v", len(batch), len(results))
+		return
+	}
+
+	for i, result := range results {
+		expected := i != 2
+		if result.Success != expected {
+			t.Errorf("Batch entry %v has the wrong result, expected success to be %v, got %+v", i, expected, result)
+		}
+	}
+
+	var pingLog domain.TaskLogPingTest
+	err = db.GetTaskLogForRange(&pingLog, node1.ID, 1531246102, 1531246102)
+	if err != nil {
+		t.Error("Unable to retrieve ping log from batch, err: ", err.Error())
+	}
+
+	if pingLog.ServerCountry != namedServer.ServerCountry || pingLog.PacketLossPercent != 0 {
+		t.Errorf("Ping log from batch was not enriched properly. Got: %+v", pingLog)
+	}
+
+	var downtimeLogs []domain.TaskLogNetworkDowntime
+	db.GetTaskLogForRange(&downtimeLogs, node1.ID, 1531246105, 1531246105)
+	if len(downtimeLogs) != 1 || downtimeLogs[0].NodeIPAddress != node1.IPAddress {
+		t.Errorf("Downtime log from batch was not saved properly. Got: %+v", downtimeLogs)
+	}
+
+	req.Body = "[]"
+	resp, _ = Handler(req)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status code %v for an empty batch, got %v", http.StatusUnprocessableEntity, resp.StatusCode)
+	}
+}
+
+func TestHandlerDuplicate(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node1 := domain.Node{
+		MacAddr:       "aa:aa:aa:aa:aa:aa",
+		AuthTokenHash: testutils.NodeAuthTokenHash,
+		IPAddress:     "123.123.123.123",
+	}
+	db.PutItem(&node1)
+
+	js, err := json.Marshal(domain.TaskLogPingTest{Timestamp: 1531246102, Latency: 1})
+	if err != nil {
+		t.Error("Unable to marshal log fixture to json, err: ", err.Error())
+		return
+	}
+
+	headers := testutils.GetNodeReqHeader()
+	headers[domain.NodeReqHeaderIdempotencyKey] = "abc123"
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       fmt.Sprintf("/log/%s/%s", node1.MacAddr, domain.TaskTypePing),
+		PathParameters: map[string]string{
+			"macAddr":   node1.MacAddr,
+			"entryType": domain.TaskTypePing,
+		},
+		Body:    string(js),
+		Headers: headers,
+	}
+
+	// The retry has a different timestamp but the same idempotency key
+	retry, err := json.Marshal(domain.TaskLogPingTest{Timestamp: 1531246109, Latency: 1})
+	if err != nil {
+		t.Error("Unable to marshal log fixture to json, err: ", err.Error())
+		return
+	}
+
+	for _, body := range []string{string(js), string(js), string(retry)} {
+		req.Body = body
+		resp, err := Handler(req)
+		if err != nil {
+			t.Error("Got error trying to submit log, err: ", err.Error())
+			return
+		}
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("Expected 204 submitting log, got %v. body: %s", resp.StatusCode, resp.Body)
+		}
+	}
+
+	var taskLogs []domain.TaskLogPingTest
+	db.GetTaskLogForRange(&taskLogs, node1.ID, 1531246100, 1531246110)
+	if len(taskLogs) != 1 {
+		t.Errorf("Expected only one log to be stored, got %v", len(taskLogs))
+		return
+	}
+
+	if taskLogs[0].EntryID != "abc123" {
+		t.Errorf("Expected the idempotency key to be stored as the EntryID, got %s", taskLogs[0].EntryID)
+	}
+}
+
+func TestHandlerValidation(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node1 := domain.Node{
+		MacAddr:       "aa:aa:aa:aa:aa:aa",
+		AuthTokenHash: testutils.NodeAuthTokenHash,
+		IPAddress:     "123.123.123.123",
+	}
+	db.PutItem(&node1)
+
+	testCases := []struct {
+		entry          domain.TaskLogSpeedTest
+		expectedStatus int
+	}{
+		{domain.TaskLogSpeedTest{Timestamp: 1531246102, Download: -10, Upload: 10}, http.StatusUnprocessableEntity},
+		{domain.TaskLogSpeedTest{Timestamp: time.Now().Add(time.Hour).Unix(), Download: 10, Upload: 10}, http.StatusUnprocessableEntity},
+		{domain.TaskLogSpeedTest{Timestamp: 1531246103, Download: domain.TaskLogSuspiciousSpeed + 1, Upload: 10}, http.StatusNoContent},
+	}
+
+	for _, tc := range testCases {
+		js, err := json.Marshal(tc.entry)
+		if err != nil {
+			t.Error("Unable to marshal log fixture to json, err: ", err.Error())
+			return
+		}
+
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod: "POST",
+			Path:       fmt.Sprintf("/log/%s/%s", node1.MacAddr, domain.TaskTypeSpeedTest),
+			PathParameters: map[string]string{
+				"macAddr":   node1.MacAddr,
+				"entryType": domain.TaskTypeSpeedTest,
+			},
+			Body:    string(js),
+			Headers: testutils.GetNodeReqHeader(),
+		}
+
+		resp, err := Handler(req)
+		if err != nil {
+			t.Error("Got error trying to submit log, err: ", err.Error())
+			return
+		}
+
+		if resp.StatusCode != tc.expectedStatus {
+			t.Errorf("Expected status %v submitting log %+v, got %v. body: %s", tc.expectedStatus, tc.entry, resp.StatusCode, resp.Body)
+		}
+	}
+
+	var taskLogs []domain.TaskLogSpeedTest
+	db.GetTaskLogForRange(&taskLogs, node1.ID, 1531246100, 1531246110)
+	if len(taskLogs) != 1 || !taskLogs[0].Flagged {
+		t.Errorf("Expected only the suspicious log to be stored and flagged. Got: %+v", taskLogs)
+	}
+
+	var unflaggedLogs []domain.TaskLogSpeedTest
+	db.GetUnflaggedTaskLogForRange(&unflaggedLogs, node1.ID, 1531246100, 1531246110)
+	if len(unflaggedLogs) != 0 {
+		t.Errorf("Expected the flagged log to be left out. Got: %+v", unflaggedLogs)
+	}
+}
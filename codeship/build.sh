@@ -14,5 +14,8 @@ go build -buildvcs=false -ldflags="-s -w" -o bin/speedtestnetserverupdate   cron
 go build -buildvcs=false -ldflags="-s -w" -o bin/alerts                     cron/alerts/main.go
 go build -buildvcs=false -ldflags="-s -w" -o bin/dailysnapshot              cron/dailysnapshot/main.go
 go build -buildvcs=false -ldflags="-s -w" -o bin/migrations                 cron/migrations/main.go
+go build -buildvcs=false -ldflags="-s -w" -o bin/tasklogdedupe              cron/tasklogdedupe/main.go
+go build -buildvcs=false -ldflags="-s -w" -o bin/tasklogarchive             cron/tasklogarchive/main.go
+go build -buildvcs=false -ldflags="-s -w" -o bin/rollouts                   cron/rollouts/main.go
 go build -buildvcs=false -ldflags="-s -w" -o bin/tasklog                    api/agent/tasklog/main.go
 
@@ -21,8 +21,10 @@ export VPC_SG_ID="${DEV_VPC_SG_ID}"
 export VPC_SUBNET1="${DEV_VPC_SUBNET1}"
 export VPC_SUBNET2="${DEV_VPC_SUBNET2}"
 export VPC_SUBNET3="${DEV_VPC_SUBNET3}"
+export ARCHIVE_BUCKET="${DEV_ARCHIVE_BUCKET}"
 export SES_RETURN_TO_ADDR="${DEV_SES_RETURN_TO_ADDR}"
 export SES_AWS_REGION="${DEV_SES_AWS_REGION}"
+export MIN_SUPPORTED_VERSION="${DEV_MIN_SUPPORTED_VERSION}"
 
 # Echo commands to console
 set -x
@@ -4,19 +4,25 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/silinternational/speed-snitch-admin-api"
 	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/alerting"
+	"github.com/silinternational/speed-snitch-admin-api/lib/notify"
 
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ses"
 	"log"
 	"os"
 	"strings"
+	"time"
 )
 
 const DaysMissing = int(1)
 const SESCharSet = "UTF-8"
 const SESSubjectText = "MIA Speedsnitch Nodes"
+const RulesSubjectText = "Speedsnitch Alerts"
+const DigestSubjectText = "Speedsnitch Alerts Digest"
+
+const AlertTypeMIA = "mia"
+const AlertTypeRules = "rules"
+const AlertTypeDigest = "digest"
 
 func getSESReturnToAddr() string {
 	envKey := "SES_RETURN_TO_ADDR"
@@ -32,14 +38,21 @@ func getSESAWSRegion() string {
 }
 
 type AlertsConfig struct {
-	DaysMissing     int    `json:"DaysMissing"`
-	SESAWSRegion    string `json:"SESAWSRegion"`
-	SESCharSet      string `json:"SESCharSet"`
-	SESReturnToAddr string `json:"SESReturnToAddr"`
-	SESSubjectText  string `json:"SESSubjectText"`
+	AlertType         string `json:"AlertType"`
+	DaysMissing       int    `json:"DaysMissing"`
+	SESAWSRegion      string `json:"SESAWSRegion"`
+	SESCharSet        string `json:"SESCharSet"`
+	SESReturnToAddr   string `json:"SESReturnToAddr"`
+	SESSubjectText    string `json:"SESSubjectText"`
+	RulesSubjectText  string `json:"RulesSubjectText"`
+	DigestSubjectText string `json:"DigestSubjectText"`
 }
 
 func (a *AlertsConfig) setDefaults() {
+	if a.AlertType == "" {
+		a.AlertType = AlertTypeMIA
+	}
+
 	if a.DaysMissing == 0 {
 		a.DaysMissing = DaysMissing
 	}
@@ -59,120 +72,189 @@ func (a *AlertsConfig) setDefaults() {
 	if a.SESSubjectText == "" {
 		a.SESSubjectText = SESSubjectText
 	}
+
+	if a.RulesSubjectText == "" {
+		a.RulesSubjectText = RulesSubjectText
+	}
+
+	if a.DigestSubjectText == "" {
+		a.DigestSubjectText = DigestSubjectText
+	}
 }
 
-func sendAnEmail(emailMsg ses.Message, recipient *string, config AlertsConfig) error {
-	recipients := []*string{recipient}
+func getDispatcher(config AlertsConfig) alerting.Dispatcher {
+	return alerting.NewDispatcher(notify.SESConfig{
+		AWSRegion:    config.SESAWSRegion,
+		CharSet:      config.SESCharSet,
+		ReturnToAddr: config.SESReturnToAddr,
+	})
+}
 
-	input := &ses.SendEmailInput{
-		Destination: &ses.Destination{
-			ToAddresses: recipients,
-		},
-		Message: &emailMsg,
-		Source:  aws.String(config.SESReturnToAddr),
+// sendNotifications sends the notifications to the users and notification channels that want them in the given mode
+// Returns the number of messages sent and an error if one is present
+func sendNotifications(subject, intro, mode string, notifications []alerting.Notification, config AlertsConfig) (int, error) {
+	users, err := alerting.ListUsersByNotificationMode(mode)
+	if err != nil {
+		return 0, fmt.Errorf("Error getting list of users to notify: %s", err.Error())
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(config.SESAWSRegion)},
-	)
+	channels, err := alerting.ListChannelsByNotificationMode(mode)
+	if err != nil {
+		return 0, fmt.Errorf("Error getting list of notification channels: %s", err.Error())
+	}
 
-	// Create an SES session.
-	svc := ses.New(sess)
-	result, err := svc.SendEmail(input)
-	log.Println(result)
-	return err
+	return getDispatcher(config).Send(subject, intro, notifications, users, channels)
 }
 
 func handler(config AlertsConfig) ([]domain.Node, error) {
-	log.Println("Starting Alert for MIA Nodes")
-
 	config.setDefaults()
 
-	nodes, err := db.ListMIANodes(config.DaysMissing)
-	if err != nil {
-		err := fmt.Errorf("Error getting list of MIA Nodes: %s", err.Error())
-		log.Println(err.Error())
-		return []domain.Node{}, err
+	switch config.AlertType {
+	case AlertTypeRules:
+		return handleAlertRules(config)
+	case AlertTypeDigest:
+		return handleDigest(config)
 	}
 
-	superAdmins := []domain.User{}
-	users := []domain.User{}
-	err = db.ListItems(&users, "")
+	return handleMIANodes(config)
+}
+
+// getMIANotifications returns a notification for each scheduled node that has been MIA for more than daysMissing
+func getMIANotifications(daysMissing int, prefix string) ([]alerting.Notification, error) {
+	nodes, err := db.ListMIANodes(daysMissing)
 	if err != nil {
-		err := fmt.Errorf("Error getting list of SuperAdmin users: %s", err.Error())
-		log.Println(err.Error())
-		return []domain.Node{}, err
+		return []alerting.Notification{}, fmt.Errorf("Error getting list of MIA Nodes: %s", err.Error())
 	}
 
-	msg := fmt.Sprintf("The following nodes have been MIA for more than %d day(s).", config.DaysMissing)
-	scheduledNodes := []domain.Node{}
-
+	notifications := []alerting.Notification{}
 	for _, node := range nodes {
 		if node.IsScheduled() {
-			msg = fmt.Sprintf("%s\n%s", msg, node.Nickname)
-			scheduledNodes = append(scheduledNodes, node)
+			notifications = append(notifications, alerting.Notification{Node: node, Message: prefix + node.Nickname})
+		}
+	}
+
+	return notifications, nil
+}
+
+// getAlertNotifications returns a notification for each alert, labelled with its current state
+func getAlertNotifications(alerts []domain.Alert) []alerting.Notification {
+	notifications := []alerting.Notification{}
+	for _, alert := range alerts {
+		msg := fmt.Sprintf("[%s] %s", strings.ToUpper(alert.State), alert.Message)
+		notifications = append(notifications, alerting.Notification{Node: alert.Node, Message: msg})
+	}
+
+	return notifications
+}
+
+func getNotificationNodes(notifications []alerting.Notification) []domain.Node {
+	nodes := []domain.Node{}
+	seen := map[uint]bool{}
+	for _, n := range notifications {
+		if !seen[n.Node.ID] {
+			seen[n.Node.ID] = true
+			nodes = append(nodes, n.Node)
 		}
 	}
 
+	return nodes
+}
+
+func handleMIANodes(config AlertsConfig) ([]domain.Node, error) {
+	log.Println("Starting Alert for MIA Nodes")
+
+	notifications, err := getMIANotifications(config.DaysMissing, "")
+	if err != nil {
+		log.Println(err.Error())
+		return []domain.Node{}, err
+	}
+
+	scheduledNodes := getNotificationNodes(notifications)
 	if len(scheduledNodes) < 1 {
 		log.Print("No MIA nodes found")
 		return scheduledNodes, nil
 	}
 
-	charSet := config.SESCharSet
+	intro := fmt.Sprintf("The following nodes have been MIA for more than %d day(s).", config.DaysMissing)
+	sentCount, err := sendNotifications(config.SESSubjectText, intro, domain.UserNotificationsImmediate, notifications, config)
+
+	log.Printf("%v MIA nodes found\n", len(scheduledNodes))
+	log.Printf("MIA node notifications sent: %v\n", sentCount)
 
-	subject := config.SESSubjectText
-	subjContent := ses.Content{
-		Charset: &charSet,
-		Data:    &subject,
+	return scheduledNodes, err
+}
+
+// handleAlertRules evaluates the admin-defined alert rules and notifies the users of the affected nodes
+// about the alerts that were opened or resolved.
+// Returns the nodes whose alerts changed
+func handleAlertRules(config AlertsConfig) ([]domain.Node, error) {
+	log.Println("Starting evaluation of alert rules")
+
+	changedAlerts, err := alerting.EvaluateAlertRules(time.Now().UTC())
+	if err != nil {
+		err := fmt.Errorf("Error evaluating alert rules: %s", err.Error())
+		log.Println(err.Error())
+		return []domain.Node{}, err
 	}
 
-	msgContent := ses.Content{
-		Charset: &charSet,
-		Data:    &msg,
+	if len(changedAlerts) < 1 {
+		log.Print("No alerts opened or resolved")
+		return []domain.Node{}, nil
 	}
 
-	msgBody := ses.Body{
-		Text: &msgContent,
+	// The alerts that were just saved don't have their nodes loaded
+	for i := range changedAlerts {
+		err := db.GetItem(&changedAlerts[i].Node, changedAlerts[i].NodeID)
+		if err != nil {
+			err := fmt.Errorf("Error getting node %v for alert: %s", changedAlerts[i].NodeID, err.Error())
+			log.Println(err.Error())
+			return []domain.Node{}, err
+		}
 	}
 
-	emailMsg := ses.Message{}
-	emailMsg.SetSubject(&subjContent)
-	emailMsg.SetBody(&msgBody)
+	notifications := getAlertNotifications(changedAlerts)
+	intro := "The following alerts have changed state."
+	sentCount, err := sendNotifications(config.RulesSubjectText, intro, domain.UserNotificationsImmediate, notifications, config)
 
-	lastError := ""
-	badRecipients := []string{}
+	log.Printf("%v alerts opened or resolved\n", len(changedAlerts))
+	log.Printf("Alert notifications sent: %v\n", sentCount)
 
-	for _, user := range users {
-		if user.Role != domain.UserRoleSuperAdmin {
-			continue
-		}
-		superAdmins = append(superAdmins, user)
-		recipient := aws.String(user.Email)
-		err = sendAnEmail(emailMsg, recipient, config)
-		if err != nil {
-			lastError = err.Error()
-			badRecipients = append(badRecipients, *recipient)
-		}
+	return getNotificationNodes(notifications), err
+}
+
+// handleDigest sends the users who prefer a digest a single summary of the MIA nodes and of
+// the alerts that changed state over the last day.
+// Returns the nodes included in the digest
+func handleDigest(config AlertsConfig) ([]domain.Node, error) {
+	log.Println("Starting alerts digest")
+
+	notifications, err := getMIANotifications(config.DaysMissing, "[MIA] ")
+	if err != nil {
+		log.Println(err.Error())
+		return []domain.Node{}, err
 	}
 
-	err = nil
+	since := time.Now().UTC().AddDate(0, 0, -1).Unix()
+	changedAlerts, err := db.ListAlertsChangedSince(since)
+	if err != nil {
+		err := fmt.Errorf("Error getting list of alerts: %s", err.Error())
+		log.Println(err.Error())
+		return []domain.Node{}, err
+	}
 
-	if lastError != "" {
-		addresses := strings.Join(badRecipients, ", ")
-		msg := fmt.Sprintf(
-			"Error sending MIA nodes emails from %s to \n %s: \n %s",
-			*aws.String(config.SESReturnToAddr),
-			addresses,
-			lastError,
-		)
-		err = fmt.Errorf(msg)
+	notifications = append(notifications, getAlertNotifications(changedAlerts)...)
+	if len(notifications) < 1 {
+		log.Print("Nothing to include in the digest")
+		return []domain.Node{}, nil
 	}
 
-	log.Printf("%v MIA nodes found\n", len(scheduledNodes))
-	log.Printf("MIA node emails sent to %v superAdmins\n", len(superAdmins)-len(badRecipients))
+	intro := "Here is the summary of MIA nodes and alerts from the last day."
+	sentCount, err := sendNotifications(config.DigestSubjectText, intro, domain.UserNotificationsDigest, notifications, config)
 
-	return scheduledNodes, err
+	log.Printf("%v digest items found\n", len(notifications))
+	log.Printf("Digest notifications sent: %v\n", sentCount)
+
+	return getNotificationNodes(notifications), err
 }
 
 func main() {
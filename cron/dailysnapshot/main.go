@@ -43,6 +43,14 @@ func handler(config SnapshotConfig) error {
 
 	fmt.Fprintf(os.Stdout, "%v snapshots generated and stored", snapshotCount)
 
+	rollupCount, err := reporting.GenerateRollupSnapshots(reportDate, config.NumDaysToProcess, config.ForceOverwrite)
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "Error generating weekly and monthly snapshots: %s", err.Error())
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "%v weekly and monthly snapshots generated and stored", rollupCount)
+
 	return nil
 }
 
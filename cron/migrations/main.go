@@ -5,14 +5,49 @@ import (
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/silinternational/speed-snitch-admin-api"
 	"github.com/silinternational/speed-snitch-admin-api/db"
 	"os"
 )
 
+// checkTaskLogDuplicates returns an error if there are duplicate task logs, since they would stop the unique
+// indexes from being added. The tasklogdedupe job merges them and regenerates the snapshots they affected.
+func checkTaskLogDuplicates() error {
+	gdb, err := db.GetDb()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []interface{}{&domain.TaskLogSpeedTest{}, &domain.TaskLogPingTest{}} {
+		if !gdb.HasTable(table) {
+			continue
+		}
+
+		duplicates, err := db.ListTaskLogDuplicates(table)
+		if err != nil {
+			return err
+		}
+
+		if len(duplicates) > 0 {
+			return fmt.Errorf(
+				"%v duplicate entries found in %s, run the tasklogdedupe job before migrating",
+				len(duplicates), gdb.NewScope(table).TableName())
+		}
+	}
+
+	return nil
+}
+
 func handler(ctx context.Context, event events.CloudWatchEvent) error {
 	fmt.Fprintf(os.Stdout, "Starting database auto migrations")
 
-	err := db.AutoMigrateTables()
+	err := checkTaskLogDuplicates()
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "Error checking for duplicate task logs: %s", err.Error())
+		return err
+	}
+
+	err = db.AutoMigrateTables()
 	if err != nil {
 		fmt.Fprintf(os.Stdout, "Error migrating database: %s", err.Error())
 		return err
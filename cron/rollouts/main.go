@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/rollouts"
+	"os"
+	"time"
+)
+
+func handler() error {
+	fmt.Fprintf(os.Stdout, "Starting rollout checks")
+
+	changedRollouts, err := rollouts.CheckRollouts(time.Now().UTC())
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "Error checking rollouts: %s", err.Error())
+		return err
+	}
+
+	for _, rollout := range changedRollouts {
+		fmt.Fprintf(os.Stdout, "\nRollout %v is %s at wave %v. %s", rollout.ID, rollout.Status, rollout.CurrentWave, rollout.PausedReason)
+	}
+
+	fmt.Fprintf(os.Stdout, "\n%v rollouts changed", len(changedRollouts))
+
+	return nil
+}
+
+func main() {
+	defer db.Db.Close()
+	lambda.Start(handler)
+}
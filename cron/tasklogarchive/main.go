@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/archive"
+	"os"
+	"strconv"
+	"time"
+)
+
+const RetentionDays = 365
+const MaxDaysToProcess = 30
+
+func getRetentionDays() int {
+	envKey := "ARCHIVE_RETENTION_DAYS"
+	value, err := strconv.Atoi(os.Getenv(envKey))
+	if err != nil || value < 1 {
+		return RetentionDays
+	}
+	return value
+}
+
+type ArchiveConfig struct {
+	RetentionDays    int    `json:"RetentionDays"`
+	MaxDaysToProcess int    `json:"MaxDaysToProcess"`
+	DryRun           bool   `json:"DryRun"`
+	RestoreKey       string `json:"RestoreKey"`
+	Bucket           string `json:"Bucket"`
+	AWSRegion        string `json:"AWSRegion"`
+}
+
+func (a *ArchiveConfig) setDefaults() {
+	if a.RetentionDays == 0 {
+		a.RetentionDays = getRetentionDays()
+	}
+
+	if a.MaxDaysToProcess == 0 {
+		a.MaxDaysToProcess = MaxDaysToProcess
+	}
+
+	if a.Bucket == "" {
+		a.Bucket = os.Getenv("ARCHIVE_BUCKET")
+	}
+
+	if a.AWSRegion == "" {
+		a.AWSRegion = domain.GetEnv("ARCHIVE_AWS_REGION", "us-east-1")
+	}
+}
+
+// handler archives raw task logs that are older than the retention period and then deletes them.
+// If a RestoreKey is given, it puts the entries from that archive file back instead.
+func handler(config ArchiveConfig) error {
+	config.setDefaults()
+
+	if config.Bucket == "" {
+		err := fmt.Errorf("Error: required value missing for environment variable ARCHIVE_BUCKET")
+		fmt.Fprintln(os.Stdout, err.Error())
+		return err
+	}
+
+	store, err := archive.NewS3Store(config.AWSRegion, config.Bucket)
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "Error connecting to the archive: %s", err.Error())
+		return err
+	}
+
+	if config.RestoreKey != "" {
+		fmt.Fprintf(os.Stdout, "Starting restore of archived task logs from %s", config.RestoreKey)
+
+		restored, err := archive.RestoreTaskLogs(store, config.RestoreKey)
+		fmt.Fprintf(os.Stdout, "\n%v task logs restored", restored)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "\nError restoring task logs: %s", err.Error())
+		}
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "Starting archive of task logs older than %v days", config.RetentionDays)
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -config.RetentionDays)
+	result, err := archive.ArchiveTaskLogs(store, cutoff, config.MaxDaysToProcess, config.DryRun)
+
+	fmt.Fprintf(os.Stdout, "\n%v task logs archived, %v deleted", result.Archived, result.Deleted)
+	fmt.Fprintf(os.Stdout, "\n%v node days skipped for missing a daily snapshot", result.SkippedNoSnapshot)
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "\nError archiving task logs: %s", err.Error())
+	}
+
+	return err
+}
+
+func main() {
+	defer db.Db.Close()
+	lambda.Start(handler)
+}
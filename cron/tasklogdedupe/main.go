@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/reporting"
+	"os"
+	"time"
+)
+
+type DedupeConfig struct {
+	DryRun bool `json:"DryRun"`
+}
+
+// The task log tables that are de-duplicated
+func getDedupeTables() map[string]interface{} {
+	return map[string]interface{}{
+		domain.TaskTypeSpeedTest: &domain.TaskLogSpeedTest{},
+		domain.TaskTypePing:      &domain.TaskLogPingTest{},
+	}
+}
+
+// addAffectedDays records the days that had duplicates for each node, so their snapshots can be regenerated
+func addAffectedDays(affectedDays map[uint]map[string]time.Time, duplicates []domain.TaskLogDuplicate) {
+	for _, dupe := range duplicates {
+		day := time.Unix(dupe.Timestamp, 0).UTC().Truncate(24 * time.Hour)
+		if _, ok := affectedDays[dupe.NodeID]; !ok {
+			affectedDays[dupe.NodeID] = map[string]time.Time{}
+		}
+		affectedDays[dupe.NodeID][day.Format("2006-01-02")] = day
+	}
+}
+
+// regenerateSnapshots overwrites the daily, weekly and monthly snapshots for the days that had duplicates,
+// since their averages included the duplicates
+func regenerateSnapshots(affectedDays map[uint]map[string]time.Time) (int64, error) {
+	var snapshotCount int64 = 0
+
+	for nodeID, days := range affectedDays {
+		var node domain.Node
+		err := db.GetItem(&node, nodeID)
+		if err != nil {
+			return snapshotCount, fmt.Errorf("Error getting node %v: %s", nodeID, err.Error())
+		}
+
+		for _, day := range days {
+			created, err := reporting.GenerateDailySnapshotForNodeForDate(node, day, true)
+			if err != nil {
+				return snapshotCount, err
+			}
+			if created {
+				snapshotCount++
+			}
+
+			for _, interval := range []string{domain.ReportingIntervalWeekly, domain.ReportingIntervalMonthly} {
+				created, err := reporting.GenerateRollupSnapshotForNodeForDate(node, day, interval, true)
+				if err != nil {
+					return snapshotCount, err
+				}
+				if created {
+					snapshotCount++
+				}
+			}
+		}
+	}
+
+	return snapshotCount, nil
+}
+
+func handler(config DedupeConfig) error {
+	fmt.Fprintf(os.Stdout, "Starting task log de-duplication")
+
+	affectedDays := map[uint]map[string]time.Time{}
+
+	for logType, table := range getDedupeTables() {
+		duplicates, err := db.ListTaskLogDuplicates(table)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "Error finding duplicate %s logs: %s", logType, err.Error())
+			return err
+		}
+
+		fmt.Fprintf(os.Stdout, "\n%v duplicate %s logs found", len(duplicates), logType)
+
+		if config.DryRun || len(duplicates) == 0 {
+			continue
+		}
+
+		err = db.MergeTaskLogDuplicates(table, duplicates)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "Error merging duplicate %s logs: %s", logType, err.Error())
+			return err
+		}
+
+		addAffectedDays(affectedDays, duplicates)
+	}
+
+	snapshotCount, err := regenerateSnapshots(affectedDays)
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "Error regenerating snapshots: %s", err.Error())
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "\n%v snapshots regenerated", snapshotCount)
+
+	return nil
+}
+
+func main() {
+	defer db.Db.Close()
+	lambda.Start(handler)
+}
@@ -3,12 +3,12 @@ package db
 import (
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/fillup/semver"
 	"github.com/jinzhu/gorm"
 	"github.com/silinternational/speed-snitch-admin-api"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -28,7 +28,10 @@ var DatabaseTables = []interface{}{
 	&domain.Contact{}, &domain.Country{}, &domain.Tag{}, &domain.Task{}, &domain.SpeedTestNetServer{},
 	&domain.UserTags{}, &domain.User{}, &domain.Version{}, &domain.TaskLogSpeedTest{},
 	&domain.TaskLogPingTest{}, &domain.TaskLogError{}, &domain.TaskLogRestart{}, &domain.TaskLogNetworkDowntime{},
-	&domain.ReportingSnapshot{}, &domain.NamedServer{}, &domain.NodeTags{}, &domain.Node{}, &domain.ReportingEvent{}}
+	&domain.ReportingSnapshot{}, &domain.NamedServer{}, &domain.NodeTags{}, &domain.Node{}, &domain.ReportingEvent{},
+	&domain.AlertRule{}, &domain.Alert{}, &domain.NotificationChannel{}, &domain.SLA{},
+	&domain.NodeHeartbeat{}, &domain.RolloutTags{}, &domain.Rollout{},
+	&domain.VersionBuild{}}
 
 func GetDb() (*gorm.DB, error) {
 	if Db == nil {
@@ -237,6 +240,102 @@ func CreateForeignKeys() error {
 			OnDelete:    CASCADE,
 			OnUpdate:    NOACTION,
 		},
+		{
+			ChildModel:  &domain.AlertRule{},
+			ChildField:  "node_id",
+			ParentTable: "node",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
+		{
+			ChildModel:  &domain.AlertRule{},
+			ChildField:  "tag_id",
+			ParentTable: "tag",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
+		{
+			ChildModel:  &domain.NodeHeartbeat{},
+			ChildField:  "node_id",
+			ParentTable: "node",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
+		{
+			ChildModel:  &domain.VersionBuild{},
+			ChildField:  "version_id",
+			ParentTable: "version",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
+		{
+			ChildModel:  &domain.Rollout{},
+			ChildField:  "version_id",
+			ParentTable: "version",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
+		{
+			ChildModel:  &domain.RolloutTags{},
+			ChildField:  "rollout_id",
+			ParentTable: "rollout",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
+		{
+			ChildModel:  &domain.RolloutTags{},
+			ChildField:  "tag_id",
+			ParentTable: "tag",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
+		{
+			ChildModel:  &domain.SLA{},
+			ChildField:  "node_id",
+			ParentTable: "node",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
+		{
+			ChildModel:  &domain.SLA{},
+			ChildField:  "tag_id",
+			ParentTable: "tag",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
+		{
+			ChildModel:  &domain.Alert{},
+			ChildField:  "alert_rule_id",
+			ParentTable: "alert_rule",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
+		{
+			ChildModel:  &domain.Alert{},
+			ChildField:  "node_id",
+			ParentTable: "node",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
+		{
+			ChildModel:  &domain.NotificationChannel{},
+			ChildField:  "tag_id",
+			ParentTable: "tag",
+			ParentField: "id",
+			OnDelete:    CASCADE,
+			OnUpdate:    NOACTION,
+		},
 	}
 
 	for _, key := range keys {
@@ -269,6 +368,7 @@ func DropTables() error {
 	// Need to manually drop many2many tables since they don't have their own models
 	db.DropTable("node_tags")
 	db.DropTable("user_tags")
+	db.DropTable("rollout_tags")
 	db.Exec("SET FOREIGN_KEY_CHECKS=1")
 	return nil
 }
@@ -337,6 +437,17 @@ func ListItems(itemObj interface{}, order string) error {
 	return gdb.Error
 }
 
+func ListNodesByStatus(nodes *[]domain.Node, status, order string) error {
+	gdb, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	gdb.Set("gorm:auto_preload", true).Unscoped().Order(order).Where("status = ?", status).Find(nodes)
+
+	return gdb.Error
+}
+
 func PutItem(itemObj interface{}) error {
 	gdb, err := GetDb()
 	if err != nil {
@@ -357,6 +468,35 @@ func PutItem(itemObj interface{}) error {
 	return nil
 }
 
+// PutItemsInTransaction saves the items within a single transaction. An item that can't be saved
+// doesn't stop the others from being saved.
+// Returns the error for each item (nil if it was saved) and an error if the transaction failed as a whole
+func PutItemsInTransaction(items []interface{}) ([]error, error) {
+	itemErrors := make([]error, len(items))
+
+	gdb, err := GetDb()
+	if err != nil {
+		return itemErrors, err
+	}
+
+	tx := gdb.Begin()
+	if tx.Error != nil {
+		return itemErrors, tx.Error
+	}
+
+	for i, item := range items {
+		itemErrors[i] = tx.Save(item).Error
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		tx.Rollback()
+		return itemErrors, err
+	}
+
+	return itemErrors, nil
+}
+
 func PutItemWithAssociations(itemObj interface{}, replacements []domain.AssociationReplacements) error {
 	gdb, err := GetDb()
 	if err != nil {
@@ -416,20 +556,22 @@ func DeleteItem(itemObj interface{}, id uint) error {
 
 // itemObj needs to be a pointer
 // relationID should be something like "node_id"
-func DeleteOrphanedItems(itemObj interface{}, relationID string) {
+func DeleteOrphanedItems(itemObj interface{}, relationID string) error {
 	gdb, err := GetDb()
 	if err != nil {
 		fmt.Fprintf(os.Stdout, "\nError connecting to database to delete orphaned items.\n%+v", err.Error())
-		return
+		return err
 	}
 
 	where := fmt.Sprintf("%s is %s", relationID, MYSQL_NULL)
-	gdb.Unscoped().Where(where).Delete(itemObj)
+	result := gdb.Unscoped().Where(where).Delete(itemObj)
 
-	if gdb.Error != nil {
-		fmt.Fprintf(os.Stdout, "\nError deleting orphaned items from the database based on %s.\n%s", relationID, gdb.Error.Error())
-		return
+	if result.Error != nil {
+		fmt.Fprintf(os.Stdout, "\nError deleting orphaned items from the database based on %s.\n%s", relationID, result.Error.Error())
+		return result.Error
 	}
+
+	return nil
 }
 
 func FindOne(itemObj interface{}) error {
@@ -459,6 +601,157 @@ func GetTaskLogForRange(itemObj interface{}, nodeId uint, rangeStart, rangeEnd i
 	return gdb.Error
 }
 
+// GetUnflaggedTaskLogForRange is like GetTaskLogForRange, but leaves out the entries that were flagged
+// as suspicious when they were submitted. Only for the task log types that can be flagged.
+func GetUnflaggedTaskLogForRange(itemObj interface{}, nodeId uint, rangeStart, rangeEnd int64) error {
+	gdb, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	where := "node_id = ? AND timestamp between ? AND ? AND flagged = ?"
+	result := gdb.Set("gorm:auto_preload", true).Order("timestamp asc").Where(where, nodeId, rangeStart, rangeEnd, false).Find(itemObj)
+
+	return result.Error
+}
+
+// GetOldestTaskLogTimestamp returns the timestamp of the oldest entry in the task log table for itemObj,
+// including soft deleted entries, or 0 if the table is empty
+func GetOldestTaskLogTimestamp(itemObj interface{}) (int64, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return 0, err
+	}
+
+	var oldest struct {
+		Timestamp int64
+	}
+	result := gdb.Unscoped().Model(itemObj).Select("IFNULL(MIN(timestamp), 0) AS timestamp").Scan(&oldest)
+
+	return oldest.Timestamp, result.Error
+}
+
+// ListNodeIDsWithTaskLogsForRange returns the IDs of the nodes with entries in the task log table for itemObj
+// within the range, including soft deleted entries
+func ListNodeIDsWithTaskLogsForRange(itemObj interface{}, rangeStart, rangeEnd int64) ([]uint, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return []uint{}, err
+	}
+
+	nodeIDs := []uint{}
+	result := gdb.Unscoped().Model(itemObj).Where("timestamp between ? AND ?", rangeStart, rangeEnd).
+		Where("node_id IS NOT NULL").Order("node_id asc").Pluck("DISTINCT node_id", &nodeIDs)
+
+	return nodeIDs, result.Error
+}
+
+// GetTaskLogForRangeToArchive is like GetTaskLogForRange, but includes soft deleted entries and
+// doesn't load the associations, so the entries can be archived and restored as they are
+func GetTaskLogForRangeToArchive(itemObj interface{}, nodeId uint, rangeStart, rangeEnd int64) error {
+	gdb, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	where := "node_id = ? AND timestamp between ? AND ?"
+	result := gdb.Unscoped().Order("timestamp asc, id asc").Where(where, nodeId, rangeStart, rangeEnd).Find(itemObj)
+
+	return result.Error
+}
+
+// DeleteTaskLogForRange permanently deletes the node's entries in the task log table for itemObj within the range
+// Returns the number of entries deleted
+func DeleteTaskLogForRange(itemObj interface{}, nodeId uint, rangeStart, rangeEnd int64) (int64, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return 0, err
+	}
+
+	where := "node_id = ? AND timestamp between ? AND ?"
+	result := gdb.Unscoped().Where(where, nodeId, rangeStart, rangeEnd).Delete(itemObj)
+
+	return result.RowsAffected, result.Error
+}
+
+// IsDuplicateTaskLog returns true if a task log entry with the same dedupe key is already stored
+func IsDuplicateTaskLog(entry domain.DedupableTaskLog) (bool, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return false, err
+	}
+
+	key := entry.GetDedupeKey()
+	where := "node_id = ? AND ((timestamp = ? AND IFNULL(named_server_id, 0) = ?)"
+	args := []interface{}{key.NodeID, key.Timestamp, key.NamedServerID}
+	if key.EntryID != "" {
+		where += " OR entry_id = ?"
+		args = append(args, key.EntryID)
+	}
+	where += ")"
+
+	var count int
+	result := gdb.Model(entry).Where(where, args...).Count(&count)
+
+	return count > 0, result.Error
+}
+
+// ListTaskLogDuplicates returns the entries in the task log table for itemObj that have the same node, timestamp
+// and named server as an older entry, along with the ID of the oldest of those entries
+func ListTaskLogDuplicates(itemObj interface{}) ([]domain.TaskLogDuplicate, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return []domain.TaskLogDuplicate{}, err
+	}
+
+	table := gdb.NewScope(itemObj).TableName()
+	query := fmt.Sprintf(`SELECT dupe.id AS id, MIN(keep.id) AS keep_id, dupe.node_id AS node_id,
+		dupe.timestamp AS timestamp, IFNULL(dupe.entry_id, '') AS entry_id
+		FROM %[1]s dupe JOIN %[1]s keep ON keep.node_id = dupe.node_id AND keep.timestamp = dupe.timestamp
+			AND IFNULL(keep.named_server_id, 0) = IFNULL(dupe.named_server_id, 0) AND keep.id < dupe.id
+		WHERE dupe.deleted_at IS NULL AND keep.deleted_at IS NULL
+		GROUP BY dupe.id, dupe.node_id, dupe.timestamp, dupe.entry_id
+		ORDER BY dupe.id`, table)
+
+	var duplicates []domain.TaskLogDuplicate
+	result := gdb.Raw(query).Scan(&duplicates)
+
+	return duplicates, result.Error
+}
+
+// MergeTaskLogDuplicates deletes the duplicate entries from the task log table for itemObj in a single transaction.
+// If a duplicate has an EntryID and the entry that is kept doesn't, the kept entry takes on that EntryID,
+// so that a later retry of the duplicate is still recognized.
+func MergeTaskLogDuplicates(itemObj interface{}, duplicates []domain.TaskLogDuplicate) error {
+	gdb, err := GetDb()
+	if err != nil {
+		return err
+	}
+
+	tx := gdb.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	for _, dupe := range duplicates {
+		if dupe.EntryID != "" {
+			result := tx.Model(itemObj).Where("id = ? AND entry_id IS NULL", dupe.KeepID).UpdateColumn("entry_id", dupe.EntryID)
+			if result.Error != nil {
+				tx.Rollback()
+				return result.Error
+			}
+		}
+
+		result := tx.Unscoped().Where("id = ?", dupe.ID).Delete(itemObj)
+		if result.Error != nil {
+			tx.Rollback()
+			return result.Error
+		}
+	}
+
+	return tx.Commit().Error
+}
+
 func GetUserFromRequest(req events.APIGatewayProxyRequest) (domain.User, error) {
 	uuid, ok := req.Headers[domain.UserReqHeaderUUID]
 	if !ok {
@@ -562,32 +855,34 @@ func AreTagsValid(tags []domain.Tag) bool {
 	return len(tags) == len(foundTags)
 }
 
-// GetLatestVersion iterates through version in Db to return only the latest version
-func GetLatestVersion() (domain.Version, error) {
+// GetLatestVersionForPlatform returns the latest stable version that can be downloaded for the operating system and
+// architecture. If includeBetas is true, beta versions are considered as well.
+func GetLatestVersionForPlatform(operatingSystem, arch string, includeBetas bool) (domain.Version, error) {
 	var versions []domain.Version
 	err := ListItems(&versions, "number asc")
 	if err != nil {
 		return domain.Version{}, err
 	}
 
-	var latest domain.Version
+	return domain.GetLatestVersionForPlatform(versions, operatingSystem, arch, includeBetas)
+}
 
-	for _, version := range versions {
-		if latest.Number == "" {
-			latest = version
-			continue
-		}
+// GetLatestVersionForNode returns the version that a node that is set to the latest version should get,
+// taking its platform, whether it gets betas and any rollout of that version into account
+func GetLatestVersionForNode(node domain.Node) (domain.Version, error) {
+	var versions []domain.Version
+	err := ListItems(&versions, "number asc")
+	if err != nil {
+		return domain.Version{}, err
+	}
 
-		isNewer, err := semver.IsNewer(latest.Number, version.Number)
-		if err != nil {
-			return domain.Version{}, err
-		}
-		if isNewer {
-			latest = version
-		}
+	var rollouts []domain.Rollout
+	err = ListItems(&rollouts, "id asc")
+	if err != nil {
+		return domain.Version{}, err
 	}
 
-	return latest, nil
+	return domain.GetLatestVersionForNode(node, versions, rollouts)
 }
 
 // GetAuthorizationStatus returns 0, nil for users that are authorized to use the object
@@ -646,6 +941,24 @@ func GetSnapshotsForRange(interval string, nodeId uint, rangeStart, rangeEnd int
 	return snapshots, gdb.Error
 }
 
+// GetSnapshotsForNodesForRange returns the snapshots for all of the nodes at once, without loading their nodes
+func GetSnapshotsForNodesForRange(interval string, nodeIDs []uint, rangeStart, rangeEnd int64) ([]domain.ReportingSnapshot, error) {
+	if len(nodeIDs) == 0 {
+		return []domain.ReportingSnapshot{}, nil
+	}
+
+	gdb, err := GetDb()
+	if err != nil {
+		return []domain.ReportingSnapshot{}, err
+	}
+
+	var snapshots []domain.ReportingSnapshot
+	where := "`node_id` IN (?) AND `interval` = ? AND `timestamp` between ? AND ?"
+	result := gdb.Order("node_id asc, timestamp asc").Where(where, nodeIDs, interval, rangeStart, rangeEnd).Find(&snapshots)
+
+	return snapshots, result.Error
+}
+
 func GetReportingEventsForRange(nodeId uint, rangeStart, rangeEnd int64) ([]domain.ReportingEvent, error) {
 	gdb, err := GetDb()
 	if err != nil {
@@ -677,3 +990,269 @@ func GetReportingEvents(nodeID uint) ([]domain.ReportingEvent, error) {
 
 	return events, gdb.Error
 }
+
+// GetHolidaysForDate returns the holidays on the given date (YYYY-MM-DD) for the node, including the global ones
+func GetHolidaysForDate(nodeID uint, date string) ([]domain.ReportingEvent, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return []domain.ReportingEvent{}, err
+	}
+
+	var holidays []domain.ReportingEvent
+	where := "`is_holiday` = ? AND (`node_id` IS NULL OR `node_id` = ?) AND `date` = ?"
+	result := gdb.Order("name asc").Where(where, true, nodeID, date).Find(&holidays)
+
+	return holidays, result.Error
+}
+
+// GetHeartbeatTimestampsForRange returns the timestamps of the node's heartbeats in the range, oldest first.
+// The timestamp of its last heartbeat before the range comes first, if there is one.
+func GetHeartbeatTimestampsForRange(nodeID uint, rangeStart, rangeEnd int64) ([]int64, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return []int64{}, err
+	}
+
+	var previous []int64
+	result := gdb.Model(&domain.NodeHeartbeat{}).Where("node_id = ? AND timestamp < ?", nodeID, rangeStart).
+		Order("timestamp desc").Limit(1).Pluck("timestamp", &previous)
+	if result.Error != nil {
+		return []int64{}, result.Error
+	}
+
+	var timestamps []int64
+	result = gdb.Model(&domain.NodeHeartbeat{}).Where("node_id = ? AND timestamp between ? AND ?", nodeID, rangeStart, rangeEnd).
+		Order("timestamp asc").Pluck("timestamp", &timestamps)
+
+	return append(previous, timestamps...), result.Error
+}
+
+// ListNodeHeartbeats returns the node's heartbeats from rangeStart to rangeEnd in order.
+// If changesOnly is true, only the heartbeats that recorded a change are included.
+func ListNodeHeartbeats(nodeID uint, rangeStart, rangeEnd int64, changesOnly bool) ([]domain.NodeHeartbeat, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return []domain.NodeHeartbeat{}, err
+	}
+
+	query := gdb.Where("node_id = ? AND timestamp between ? AND ?", nodeID, rangeStart, rangeEnd)
+	if changesOnly {
+		query = query.Where("changes <> ''")
+	}
+
+	heartbeats := []domain.NodeHeartbeat{}
+	result := query.Order("timestamp asc").Find(&heartbeats)
+	return heartbeats, result.Error
+}
+
+// CountTaskLogsForNodes returns how many entries the task log table for itemObj has for the nodes
+// from rangeStart to rangeEnd
+func CountTaskLogsForNodes(itemObj interface{}, nodeIDs []uint, rangeStart, rangeEnd int64) (int, error) {
+	if len(nodeIDs) == 0 {
+		return 0, nil
+	}
+
+	gdb, err := GetDb()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	where := "node_id IN (?) AND timestamp between ? AND ?"
+	result := gdb.Model(itemObj).Where(where, nodeIDs, rangeStart, rangeEnd).Count(&count)
+	return count, result.Error
+}
+
+// GetFiringAlert returns the alert that is still open for the rule and node, if there is one
+func GetFiringAlert(alertRuleID, nodeID uint) (domain.Alert, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return domain.Alert{}, err
+	}
+
+	// The associations are not loaded, so that saving changes to the alert doesn't save them too
+	var alert domain.Alert
+	where := "alert_rule_id = ? AND node_id = ? AND state = ?"
+	result := gdb.Where(where, alertRuleID, nodeID, domain.AlertStateFiring).First(&alert)
+	if result.RecordNotFound() {
+		return domain.Alert{}, gorm.ErrRecordNotFound
+	}
+
+	return alert, result.Error
+}
+
+// ListAlerts returns the alerts with the given state, or all alerts if state is empty, newest first
+func ListAlerts(state string) ([]domain.Alert, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return []domain.Alert{}, err
+	}
+
+	var alerts []domain.Alert
+	query := gdb.Set("gorm:auto_preload", true).Order("fired_at desc")
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	query = query.Find(&alerts)
+
+	return alerts, query.Error
+}
+
+// ListAlertsChangedSince returns the alerts that fired or were resolved at or after the given timestamp
+func ListAlertsChangedSince(since int64) ([]domain.Alert, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return []domain.Alert{}, err
+	}
+
+	var alerts []domain.Alert
+	query := gdb.Set("gorm:auto_preload", true).Order("fired_at asc")
+	query = query.Where("fired_at >= ? OR resolved_at >= ?", since, since).Find(&alerts)
+
+	return alerts, query.Error
+}
+
+// NodeFilter holds the conditions for listing nodes. Empty values are ignored.
+type NodeFilter struct {
+	Status string
+	// UserTagIDs limits the nodes to the ones that a non-superAdmin user can use
+	UserTagIDs     []uint
+	TagID          uint
+	OS             string
+	Arch           string
+	Version        string
+	VersionStatus  string
+	LastSeenAfter  string
+	LastSeenBefore string
+	Search         string
+}
+
+type UserFilter struct {
+	Role   string
+	TagID  uint
+	Search string
+}
+
+type ReportingEventFilter struct {
+	// NodeID of 0 means the global events, which don't have a node
+	NodeID    uint
+	StartDate string
+	EndDate   string
+	Search    string
+	// HolidaysOnly limits the events to the ones that are holidays
+	HolidaysOnly bool
+}
+
+// ListItemsPage loads one page of the items that match the query, in the order given by the params.
+// Returns the total number of items that match the query and an error if one is present
+func ListItemsPage(itemObj interface{}, query *gorm.DB, params domain.ListParams) (int, error) {
+	var total int
+	result := query.Model(itemObj).Count(&total)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	query = query.Set("gorm:auto_preload", true).Order(params.Order)
+	if params.Limit > 0 {
+		query = query.Limit(params.Limit).Offset(params.Offset)
+	}
+
+	result = query.Find(itemObj)
+	return total, result.Error
+}
+
+func ListNodesPage(nodes *[]domain.Node, filter NodeFilter, params domain.ListParams) (int, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return 0, err
+	}
+
+	query := gdb.Unscoped().Where("status = ?", filter.Status)
+
+	if filter.UserTagIDs != nil {
+		query = query.Where("id IN (SELECT node_id FROM node_tags WHERE tag_id IN (?))", filter.UserTagIDs)
+	}
+	if filter.TagID != 0 {
+		query = query.Where("id IN (SELECT node_id FROM node_tags WHERE tag_id = ?)", filter.TagID)
+	}
+	if filter.OS != "" {
+		query = query.Where("os = ?", filter.OS)
+	}
+	if filter.Arch != "" {
+		query = query.Where("arch = ?", filter.Arch)
+	}
+	if filter.Version != "" {
+		query = query.Where("running_version_id IN (SELECT id FROM version WHERE number = ?)", filter.Version)
+	}
+	if filter.VersionStatus != "" {
+		query = query.Where("running_version_id IN (SELECT id FROM version WHERE status = ?)", filter.VersionStatus)
+	}
+	if filter.LastSeenAfter != "" {
+		query = query.Where("last_seen >= ?", filter.LastSeenAfter)
+	}
+	if filter.LastSeenBefore != "" {
+		query = query.Where("last_seen <= ?", filter.LastSeenBefore)
+	}
+	if filter.Search != "" {
+		query = query.Where("nickname LIKE ?", getLikePattern(filter.Search))
+	}
+
+	return ListItemsPage(nodes, query, params)
+}
+
+func ListUsersPage(users *[]domain.User, filter UserFilter, params domain.ListParams) (int, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return 0, err
+	}
+
+	query := gdb.Unscoped()
+
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if filter.TagID != 0 {
+		query = query.Where("id IN (SELECT user_id FROM user_tags WHERE tag_id = ?)", filter.TagID)
+	}
+	if filter.Search != "" {
+		pattern := getLikePattern(filter.Search)
+		query = query.Where("name LIKE ? OR email LIKE ?", pattern, pattern)
+	}
+
+	return ListItemsPage(users, query, params)
+}
+
+func ListReportingEventsPage(events *[]domain.ReportingEvent, filter ReportingEventFilter, params domain.ListParams) (int, error) {
+	gdb, err := GetDb()
+	if err != nil {
+		return 0, err
+	}
+
+	var query *gorm.DB
+	if filter.NodeID > 0 {
+		query = gdb.Where("node_id = ?", filter.NodeID)
+	} else {
+		query = gdb.Where("node_id IS NULL")
+	}
+
+	if filter.StartDate != "" {
+		query = query.Where("date >= ?", filter.StartDate)
+	}
+	if filter.EndDate != "" {
+		query = query.Where("date <= ?", filter.EndDate)
+	}
+	if filter.Search != "" {
+		query = query.Where("name LIKE ?", getLikePattern(filter.Search))
+	}
+	if filter.HolidaysOnly {
+		query = query.Where("is_holiday = ?", true)
+	}
+
+	return ListItemsPage(events, query, params)
+}
+
+// getLikePattern returns a pattern for a LIKE condition that matches values containing the search text
+func getLikePattern(search string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + escaper.Replace(search) + "%"
+}
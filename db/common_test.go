@@ -3,6 +3,7 @@ package db
 import (
 	"github.com/jinzhu/gorm"
 	"github.com/silinternational/speed-snitch-admin-api"
+	"strings"
 	"testing"
 	"time"
 )
@@ -159,3 +160,112 @@ func TestListMIANodes(t *testing.T) {
 		t.Errorf("Did not get expected results. \nExpected: %+v\n But got: %+v", expected, results)
 	}
 }
+
+func TestMergeTaskLogDuplicates(t *testing.T) {
+	DropTables()
+	AutoMigrateTables()
+
+	node := domain.Node{MacAddr: "11:22:33:44:55:aa"}
+	err := PutItem(&node)
+	if err != nil {
+		t.Errorf("Error saving fixture. %s", err.Error())
+		return
+	}
+
+	logs := []domain.TaskLogSpeedTest{
+		{NodeID: node.ID, Timestamp: 1531246102, Download: 10},
+		{NodeID: node.ID, Timestamp: 1531246102, Download: 10, EntryID: "retry"},
+		{NodeID: node.ID, Timestamp: 1531246102, Download: 10},
+		{NodeID: node.ID, Timestamp: 1531246103, Download: 20},
+	}
+
+	for i := range logs {
+		err := PutItem(&logs[i])
+		if err != nil {
+			t.Errorf("Error saving fixture. %s", err.Error())
+			return
+		}
+	}
+
+	duplicates, err := ListTaskLogDuplicates(&domain.TaskLogSpeedTest{})
+	if err != nil {
+		t.Errorf("Got an unexpected error listing duplicates.\n %s", err.Error())
+		return
+	}
+
+	if len(duplicates) != 2 || duplicates[0].ID != logs[1].ID || duplicates[0].KeepID != logs[0].ID || duplicates[1].ID != logs[2].ID {
+		t.Errorf("Did not get expected duplicates. Got: %+v", duplicates)
+		return
+	}
+
+	err = MergeTaskLogDuplicates(&domain.TaskLogSpeedTest{}, duplicates)
+	if err != nil {
+		t.Errorf("Got an unexpected error merging duplicates.\n %s", err.Error())
+		return
+	}
+
+	var remaining []domain.TaskLogSpeedTest
+	err = GetTaskLogForRange(&remaining, node.ID, 1531246102, 1531246103)
+	if err != nil {
+		t.Errorf("Got an unexpected error.\n %s", err.Error())
+		return
+	}
+
+	if len(remaining) != 2 || remaining[0].ID != logs[0].ID || remaining[0].EntryID != "retry" {
+		t.Errorf("Did not get expected logs after merging duplicates. Got: %+v", remaining)
+	}
+
+	isDuplicate, err := IsDuplicateTaskLog(&domain.TaskLogSpeedTest{NodeID: node.ID, Timestamp: 1531246999, EntryID: "retry"})
+	if err != nil {
+		t.Errorf("Got an unexpected error.\n %s", err.Error())
+		return
+	}
+
+	if !isDuplicate {
+		t.Error("Expected log with a stored EntryID to be a duplicate")
+	}
+}
+
+func TestTaskLogUniqueIndexes(t *testing.T) {
+	DropTables()
+	AutoMigrateTables()
+
+	node := domain.Node{MacAddr: "11:22:33:44:55:aa"}
+	err := PutItem(&node)
+	if err != nil {
+		t.Errorf("Error saving fixture. %s", err.Error())
+		return
+	}
+
+	original := domain.TaskLogPingTest{NodeID: node.ID, Timestamp: 1531246102, EntryID: "abc123", DedupeKey: "1531246102|0"}
+	err = PutItem(&original)
+	if err != nil {
+		t.Errorf("Error saving fixture. %s", err.Error())
+		return
+	}
+
+	duplicates := []domain.TaskLogPingTest{
+		{NodeID: node.ID, Timestamp: 1531246102, DedupeKey: "1531246102|0"},
+		{NodeID: node.ID, Timestamp: 1531246109, EntryID: "abc123", DedupeKey: "1531246109|0"},
+	}
+
+	for i := range duplicates {
+		err := PutItem(&duplicates[i])
+		if err == nil || !strings.Contains(err.Error(), UniqueFieldErrorCode) {
+			t.Errorf("Expected a duplicate key error saving duplicate %v, got: %v", i, err)
+		}
+	}
+
+	// Entries without an EntryID or DedupeKey aren't checked
+	others := []domain.TaskLogPingTest{
+		{NodeID: node.ID, Timestamp: 1531246200},
+		{NodeID: node.ID, Timestamp: 1531246200},
+	}
+
+	for i := range others {
+		err := PutItem(&others[i])
+		if err != nil {
+			t.Errorf("Got an unexpected error saving entry %v.\n %s", i, err.Error())
+		}
+	}
+}
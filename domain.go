@@ -2,29 +2,52 @@ package domain
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql/driver"
+	"encoding/base64"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/fillup/semver"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	"hash/fnv"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	_ "time/tzdata"
 )
 
 const DataTypeSpeedTestNetServer = "speedtestnetserver"
 const DataTypeSTNetServerList = "stnetserverlist"
 
 const LogTypeDowntime = "downtime"
+const LogTypeHeartbeat = "heartbeat"
 const LogTypeRestart = "restarted"
 const LogTypeError = "error"
+const LogTypeBatch = "batch"
+const TaskLogBatchMaxEntries = 1000
+
+// Limits for validating task log entries. Values beyond the Max limits are rejected and
+// values beyond the Suspicious limits are stored but flagged, so they are left out of reports.
+const TaskLogMaxClockSkewSeconds = 300
+const TaskLogMaxSpeed = 100000.0        // Mbps
+const TaskLogSuspiciousSpeed = 2000.0   // Mbps
+const TaskLogMaxLatency = 60000.0       // ms
+const TaskLogSuspiciousLatency = 3000.0 // ms
+const TaskLogMaxDowntimeSeconds = 30 * SecondsPerDay
+const TaskLogSuspiciousDowntimeSeconds = SecondsPerDay
 
 const ServerTypeSpeedTestNet = "speedTest"
 const ServerTypePing = "ping"
@@ -37,7 +60,19 @@ const TaskTypeSpeedTest = "speedTest"
 const TestConfigSpeedTest = "speedTest"
 
 const SecondsPerDay = 86400 // 60 * 60 * 24
+
+// HeartbeatMaxGapSeconds is how long a node can go without saying hello before it counts as unreachable
+const HeartbeatMaxGapSeconds = 15 * 60
+
+// Changes that a hello from a node can show since its previous one
+const NodeChangeFirstHello = "firstHello"
+const NodeChangeIPAddress = "ipAddress"
+const NodeChangeVersion = "version"
+const NodeChangeUptimeReset = "uptimeReset"
+const NodeChangesSeparator = ","
+
 const BusinessTimeFormat = "15:04"
+const WorkingDaysSeparator = ","
 
 const DefaultSpeedTestNetServerID = "5559"
 const DefaultSpeedTestNetServerHost = "paris1.speedtest.orange.fr:8080"
@@ -45,11 +80,23 @@ const DefaultSpeedTestNetServerHost = "paris1.speedtest.orange.fr:8080"
 // Log errors to stderr
 var ErrorLogger = log.New(os.Stderr, "ERROR ", log.Llongfile)
 
+const NodeStatusPending = "pending"
+const NodeStatusApproved = "approved"
+const NodeStatusRejected = "rejected"
+
+const NodeReqHeaderToken = "x-node-token"
+const NodeReqHeaderIdempotencyKey = "x-idempotency-key"
+const NodeAuthTokenBytes = 32
+
 const UserReqHeaderUUID = "x-user-uuid"
 const UserReqHeaderEmail = "x-user-mail"
 const UserRoleSuperAdmin = "superAdmin"
 const UserRoleAdmin = "admin"
 
+const UserNotificationsImmediate = "immediate"
+const UserNotificationsDigest = "digest"
+const UserNotificationsOff = "off"
+
 const PermissionSuperAdmin = "superAdmin"
 const PermissionTagBased = "tagBased"
 
@@ -59,6 +106,65 @@ const ReportingIntervalMonthly = "monthly"
 
 const DateLayout = "2006-01-02"
 
+// DefaultListLimit is only used when an offset is given without a limit. Lists are not paged if neither is given.
+const DefaultListLimit = 100
+const MaxListLimit = 1000
+const ListRespHeaderTotalCount = "X-Total-Count"
+const ListRespHeaderLimit = "X-Limit"
+const ListRespHeaderOffset = "X-Offset"
+const ListRespHeaderNextOffset = "X-Next-Offset"
+const CORSHeaderExposeHeaders = "Access-Control-Expose-Headers"
+
+const AlertRuleMetricDownloadAvg = "downloadAvg"
+const AlertRuleMetricUploadAvg = "uploadAvg"
+const AlertRuleMetricLatencyAvg = "latencyAvg"
+const AlertRuleMetricPacketLossAvg = "packetLossAvg"
+const AlertRuleMetricOutageCount = "outageCount"
+
+const AlertRuleComparisonBelow = "below"
+const AlertRuleComparisonAbove = "above"
+
+const DefaultAlertRuleWindowHours = 24
+
+const SLAMetricDownload = "download"
+const SLAMetricUpload = "upload"
+const SLAMetricLatency = "latency"
+const SLAMetricPacketLoss = "packetLoss"
+const SLAMetricAvailability = "availability"
+
+const SLAMonthLayout = "2006-01"
+
+const RolloutStatusActive = "active"
+const RolloutStatusPaused = "paused"
+const RolloutStatusCompleted = "completed"
+
+const VersionStatusBeta = "beta"
+const VersionStatusStable = "stable"
+const VersionStatusDeprecated = "deprecated"
+const VersionStatusRevoked = "revoked"
+
+// MinSupportedVersionEnvKey names the environment variable with the oldest version nodes may stay on (e.g. "1.2.0")
+const MinSupportedVersionEnvKey = "MIN_SUPPORTED_VERSION"
+
+// DefaultConvergenceHours is how long a node has to start running its configured version before it is
+// reported as not having converged
+const DefaultConvergenceHours = 24
+
+// VersionNumberLatest is the number of the version that nodes are set to if they should get each new version
+const VersionNumberLatest = "latest"
+
+const DefaultRolloutWaves = "10,50,100"
+const DefaultRolloutWaveHours = 24
+const RolloutWavesSeparator = ","
+
+const AlertStateFiring = "firing"
+const AlertStateResolved = "resolved"
+
+const NotificationChannelTypeEmail = "email"
+const NotificationChannelTypeWebhook = "webhook"
+const NotificationChannelTypeSlack = "slack"
+const NotificationChannelTypeTeams = "teams"
+
 /***************************************************************
 /*
 /* Define types that will be stored to database using GORM
@@ -82,6 +188,7 @@ type Tag struct {
 	gorm.Model
 	Name        string `gorm:"not null;unique_index"`
 	Description string `gorm:"not null"`
+	WorkingDays string `gorm:"type:varchar(32)"` // e.g. "Mon,Tue,Wed,Thu,Fri", empty means every day
 	Nodes       []Node `gorm:"many2many:node_tags"`
 	Users       []User `gorm:"many2many:user_tags"`
 }
@@ -95,6 +202,7 @@ type Node struct {
 	RunningVersionID    uint    `gorm:"default:null"`
 	ConfiguredVersion   Version `gorm:"foreignkey:ConfiguredVersionID" json:"-"`
 	ConfiguredVersionID uint    `gorm:"default:null"`
+	ConfiguredVersionAt string  `gorm:"type:varchar(64)"` // When the ConfiguredVersion was last changed
 	Uptime              int64   `gorm:"default:0"`
 	LastSeen            string  `gorm:"type:varchar(64)"`
 	FirstSeen           string  `gorm:"type:varchar(64)"`
@@ -110,6 +218,13 @@ type Node struct {
 	Notes               string
 	BusinessStartTime   string `gorm:"type:varchar(5);default:'00:00'"`
 	BusinessCloseTime   string `gorm:"type:varchar(5);default:'00:00'"`
+	Timezone            string `gorm:"type:varchar(64);not null;default:'UTC'"`
+	WorkingDays         string `gorm:"type:varchar(32)"`       // e.g. "Mon,Tue,Wed,Thu,Fri", empty means use the tags'
+	BetaOptIn           bool   `gorm:"not null;default:false"` // Gets beta versions when set to the latest version
+	Status              string `gorm:"type:varchar(16);not null;default:'approved'"`
+	AuthTokenHash       string `gorm:"type:varchar(64)" json:"-"`
+	AuthTokenIssuedAt   string `gorm:"type:varchar(64)"`
+	AuthToken           string `gorm:"-" json:",omitempty"` // Only set in the response that issues a new token
 }
 
 func (n *Node) IsScheduled() bool {
@@ -119,6 +234,117 @@ func (n *Node) IsScheduled() bool {
 	return false
 }
 
+// IssueAuthToken generates a new secret for the agent to use, replacing any previous one.
+// Only a hash of the secret is stored.
+func (n *Node) IssueAuthToken() error {
+	tokenBytes := make([]byte, NodeAuthTokenBytes)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return fmt.Errorf("Error generating node auth token. %s", err.Error())
+	}
+
+	n.AuthToken = base64.RawURLEncoding.EncodeToString(tokenBytes)
+	n.AuthTokenHash = HashNodeAuthToken(n.AuthToken)
+	n.AuthTokenIssuedAt = GetTimeNow()
+	return nil
+}
+
+// RevokeAuthToken removes the node's secret, so that the agent can no longer get its config or submit logs
+func (n *Node) RevokeAuthToken() {
+	n.AuthToken = ""
+	n.AuthTokenHash = ""
+	n.AuthTokenIssuedAt = ""
+}
+
+// GetLocation returns the location for the node's IANA timezone, falling back to UTC if it isn't set or valid
+func (n *Node) GetLocation() *time.Location {
+	if n.Timezone == "" {
+		return time.UTC
+	}
+
+	location, err := time.LoadLocation(n.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return location
+}
+
+// GetWorkingDays returns the node's own working days if it has them. Otherwise it returns the working days
+// of its tag with the lowest ID that has them, or an empty string (every day) if none of them do.
+func (n *Node) GetWorkingDays() string {
+	if n.WorkingDays != "" {
+		return n.WorkingDays
+	}
+
+	workingDays := ""
+	var tagID uint
+	for _, tag := range n.Tags {
+		if tag.WorkingDays != "" && (tagID == 0 || tag.ID < tagID) {
+			workingDays = tag.WorkingDays
+			tagID = tag.ID
+		}
+	}
+
+	return workingDays
+}
+
+// IsOnLatestVersion returns true if the node hasn't been set to a specific version
+func (n *Node) IsOnLatestVersion() bool {
+	return n.ConfiguredVersion.Number == "" || n.ConfiguredVersion.Number == VersionNumberLatest
+}
+
+// GetTargetVersion returns the version that the node should be running and when it was set to it. For a node that is
+// set to the latest version, that's the latest version it can get, which was set when that version, or its rollout,
+// was last updated. A zero time means the version was set before that was tracked.
+func (n *Node) GetTargetVersion(versions []Version, rollouts []Rollout) (Version, time.Time, error) {
+	// A time that doesn't parse is left as zero
+	configuredAt, _ := time.Parse(time.RFC3339, n.ConfiguredVersionAt)
+	if !n.IsOnLatestVersion() {
+		return n.ConfiguredVersion, configuredAt, nil
+	}
+
+	latestVersion, err := GetLatestVersionForNode(*n, versions, rollouts)
+	if err != nil {
+		return Version{}, time.Time{}, err
+	}
+
+	setAt := configuredAt
+	if latestVersion.UpdatedAt.After(setAt) {
+		setAt = latestVersion.UpdatedAt
+	}
+	for _, rollout := range rollouts {
+		if rollout.VersionID == latestVersion.ID && rollout.UpdatedAt.After(setAt) {
+			setAt = rollout.UpdatedAt
+		}
+	}
+
+	return latestVersion, setAt, nil
+}
+
+func (n *Node) IsApproved() bool {
+	return n.Status == NodeStatusApproved
+}
+
+func (n *Node) HasAuthToken() bool {
+	return n.AuthTokenHash != ""
+}
+
+// IsValidAuthToken returns true if the node has been issued a token and it matches the given one
+func (n *Node) IsValidAuthToken(token string) bool {
+	if !n.HasAuthToken() || token == "" {
+		return false
+	}
+
+	hash := HashNodeAuthToken(token)
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(n.AuthTokenHash)) == 1
+}
+
+func HashNodeAuthToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 type NodeTags struct {
 	gorm.Model
 	Tag    Node `gorm:"foreignkey:TagID"`
@@ -174,6 +400,20 @@ type User struct {
 	Email string `gorm:"not null;unique_index"`
 	Role  string `gorm:"not null"`
 	Tags  []Tag  `gorm:"many2many:user_tags"`
+	// NotificationMode is one of immediate, digest or off and decides how alerts get sent to the user
+	NotificationMode string `gorm:"type:varchar(16);not null;default:'immediate'"`
+}
+
+// GetNotificationMode treats a user without a NotificationMode as wanting immediate notifications
+func (u *User) GetNotificationMode() string {
+	if u.NotificationMode == "" {
+		return UserNotificationsImmediate
+	}
+	return u.NotificationMode
+}
+
+func IsValidNotificationMode(mode string) bool {
+	return mode == UserNotificationsImmediate || mode == UserNotificationsDigest || mode == UserNotificationsOff
 }
 
 type UserTags struct {
@@ -188,6 +428,221 @@ type Version struct {
 	gorm.Model
 	Number      string `gorm:"not null;unique_index"`
 	Description string `gorm:"not null"`
+	Status      string `gorm:"type:varchar(16);not null;default:'stable'"`
+	Builds      []VersionBuild
+}
+
+// IsSupported returns false if the version has been revoked or is older than the minimum supported version.
+// An empty minimum means there isn't one.
+func (v *Version) IsSupported(minimumVersion string) bool {
+	if v.Status == VersionStatusRevoked {
+		return false
+	}
+
+	if minimumVersion == "" {
+		return true
+	}
+
+	isOlder, _ := semver.IsNewer(v.Number, minimumVersion)
+	return !isOlder
+}
+
+func IsValidVersionStatus(status string) bool {
+	validStatuses := []string{VersionStatusBeta, VersionStatusStable, VersionStatusDeprecated, VersionStatusRevoked}
+	isValid, _ := InArray(status, validStatuses)
+	return isValid
+}
+
+// GetBuild returns the version's build for the operating system and architecture,
+// along with false if there isn't one
+func (v *Version) GetBuild(operatingSystem, arch string) (VersionBuild, bool) {
+	for _, build := range v.Builds {
+		if strings.EqualFold(build.OS, operatingSystem) && strings.EqualFold(build.Arch, arch) {
+			return build, true
+		}
+	}
+	return VersionBuild{}, false
+}
+
+// GetDownload returns the version's build for the operating system and architecture. A version that has no builds
+// registered, such as one added before builds were, falls back to its URL under the download base URL, without a
+// checksum or size. Returns false if the version can't be downloaded for the platform.
+func (v *Version) GetDownload(operatingSystem, arch string) (VersionBuild, bool) {
+	build, ok := v.GetBuild(operatingSystem, arch)
+	if ok {
+		return build, true
+	}
+
+	if len(v.Builds) > 0 || v.Number == "" || v.Number == VersionNumberLatest || os.Getenv("downloadBaseUrl") == "" {
+		return VersionBuild{}, false
+	}
+
+	return VersionBuild{
+		VersionID: v.ID,
+		OS:        operatingSystem,
+		Arch:      arch,
+		URL:       GetUrlForAgentVersion(v.Number, operatingSystem, arch),
+	}, true
+}
+
+// VersionBuild is the agent binary of a version for one platform. Agents can check the download against SHA256.
+type VersionBuild struct {
+	gorm.Model
+	VersionID uint
+	OS        string `gorm:"type:varchar(16);not null"`
+	Arch      string `gorm:"type:varchar(8);not null"`
+	URL       string `gorm:"type:varchar(2048);not null"`
+	SHA256    string `gorm:"type:varchar(64);not null"`
+	Size      int64  `gorm:"not null;default:0"` // bytes
+}
+
+// Rollout stages the upgrade of the nodes that are set to the latest version to the rollout's version.
+// Nodes with one of the canary tags get it first. Each wave after that gives it to a bigger percentage of
+// the other nodes, once the previous wave has run for WaveHours without the error or restart rates of the
+// upgraded nodes rising more than allowed above those of the nodes that are still waiting.
+type Rollout struct {
+	gorm.Model
+	Version                Version `gorm:"foreignkey:VersionID"`
+	VersionID              uint    `gorm:"not null;unique_index"`
+	CanaryTags             []Tag   `gorm:"many2many:rollout_tags"`
+	Waves                  string  `gorm:"type:varchar(255);not null"` // e.g. "10,50,100", the percentages of the nodes
+	WaveHours              int64   `gorm:"not null;default:24"`
+	CurrentWave            int64   `gorm:"not null;default:0"` // 0 is the canaries only, 1 is the first wave, etc.
+	WaveStartedAt          int64   `gorm:"type:int(11);not null;default:0"`
+	MaxErrorRateIncrease   float64 // Extra task log errors per node per day, zero means not checked
+	MaxRestartRateIncrease float64 // Extra restarts per node per day, zero means not checked
+	Status                 string  `gorm:"type:varchar(16);not null;default:'active'"`
+	PausedReason           string  `gorm:"type:varchar(255)"`
+}
+
+// GetWavePercentages returns the percentage of the nodes that each of the waves includes
+func (r *Rollout) GetWavePercentages() []int {
+	percentages := []int{}
+	for _, wave := range strings.Split(r.Waves, RolloutWavesSeparator) {
+		percent, err := strconv.Atoi(strings.TrimSpace(wave))
+		if err == nil {
+			percentages = append(percentages, percent)
+		}
+	}
+	return percentages
+}
+
+// GetCurrentPercent returns the percentage of the nodes that the rollout has reached so far,
+// not counting the canaries
+func (r *Rollout) GetCurrentPercent() int {
+	if r.Status == RolloutStatusCompleted {
+		return 100
+	}
+
+	percentages := r.GetWavePercentages()
+	if r.CurrentWave < 1 || len(percentages) == 0 {
+		return 0
+	}
+	if int(r.CurrentWave) > len(percentages) {
+		return percentages[len(percentages)-1]
+	}
+	return percentages[r.CurrentWave-1]
+}
+
+// IsNodeIncluded returns true if the rollout has reached the node, either because it has one of the canary tags
+// or because it falls within the current wave's percentage
+func (r *Rollout) IsNodeIncluded(node Node) bool {
+	if r.Status == RolloutStatusCompleted || DoTagsOverlap(r.CanaryTags, node.Tags) {
+		return true
+	}
+
+	return GetRolloutBucket(node.MacAddr, r.VersionID) < r.GetCurrentPercent()
+}
+
+// GetRolloutBucket places a node in one of 100 buckets for a rollout, so that each wave includes all the nodes
+// of the previous waves. The version is mixed in so that the same nodes aren't always upgraded first.
+func GetRolloutBucket(macAddr string, versionID uint) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(fmt.Sprintf("%s-%d", macAddr, versionID)))
+	return int(hash.Sum32() % 100)
+}
+
+// GetLatestVersionForPlatform returns the latest of the stable versions that can be downloaded for the operating
+// system and architecture. If includeBetas is true, beta versions are considered as well.
+func GetLatestVersionForPlatform(versions []Version, operatingSystem, arch string, includeBetas bool) (Version, error) {
+	var latest Version
+
+	for _, version := range versions {
+		isAvailable := version.Status == VersionStatusStable || (includeBetas && version.Status == VersionStatusBeta)
+		if !isAvailable {
+			continue
+		}
+
+		if _, ok := version.GetDownload(operatingSystem, arch); !ok {
+			continue
+		}
+
+		if latest.Number == "" {
+			latest = version
+			continue
+		}
+
+		isNewer, err := semver.IsNewer(latest.Number, version.Number)
+		if err != nil {
+			return Version{}, err
+		}
+		if isNewer {
+			latest = version
+		}
+	}
+
+	return latest, nil
+}
+
+// GetLatestVersionForNode returns the latest of the versions that can be downloaded for the node's platform,
+// including betas if the node has opted in to them, unless it is being rolled out and the rollout hasn't reached
+// the node yet. Then the node stays on the version it is running.
+func GetLatestVersionForNode(node Node, versions []Version, rollouts []Rollout) (Version, error) {
+	latestVersion, err := GetLatestVersionForPlatform(versions, node.OS, node.Arch, node.BetaOptIn)
+	if err != nil || latestVersion.ID == 0 || node.RunningVersion.Number == "" {
+		return latestVersion, err
+	}
+
+	for _, rollout := range rollouts {
+		if rollout.VersionID == latestVersion.ID && !rollout.IsNodeIncluded(node) {
+			return node.RunningVersion, nil
+		}
+	}
+
+	return latestVersion, nil
+}
+
+// CleanRolloutWaves takes a comma separated list of percentages (e.g. "10, 50,100"). They must increase and end
+// with 100. Returns them in the standard format (e.g. "10,50,100") or the default waves if none are given.
+func CleanRolloutWaves(waves string) (string, error) {
+	if strings.TrimSpace(waves) == "" {
+		return DefaultRolloutWaves, nil
+	}
+
+	cleanWaves := []string{}
+	previous := 0
+	for _, wave := range strings.Split(waves, RolloutWavesSeparator) {
+		percent, err := strconv.Atoi(strings.TrimSpace(wave))
+		if err != nil || percent <= previous || percent > 100 {
+			return waves, fmt.Errorf("Invalid rollout waves: %s. Expected increasing percentages, e.g. %s", waves, DefaultRolloutWaves)
+		}
+		cleanWaves = append(cleanWaves, strconv.Itoa(percent))
+		previous = percent
+	}
+
+	if previous != 100 {
+		return waves, fmt.Errorf("Invalid rollout waves: %s. The last wave must be 100", waves)
+	}
+
+	return strings.Join(cleanWaves, RolloutWavesSeparator), nil
+}
+
+type RolloutTags struct {
+	gorm.Model
+	Rollout   Rollout `gorm:"foreignkey:RolloutID"`
+	RolloutID uint
+	Tag       Tag `gorm:"foreignkey:TagID"`
+	TagID     uint
 }
 
 type SpeedTestNetServer struct {
@@ -201,11 +656,40 @@ type SpeedTestNetServer struct {
 	Host        string `xml:"host,attr" gorm:"not null"`
 }
 
+// TaskLogDedupeKey identifies a task log entry for de-duplication. Entries of the same type are duplicates
+// if they have the same node, timestamp and named server, or the same node and agent-supplied EntryID.
+type TaskLogDedupeKey struct {
+	NodeID        uint
+	Timestamp     int64
+	NamedServerID uint
+	EntryID       string
+}
+
+// GetTimestampKey returns the key for the entry's timestamp and named server, which is stored with the entry
+// so that the database rejects a duplicate that is submitted at the same time as the original
+func (k TaskLogDedupeKey) GetTimestampKey() string {
+	return fmt.Sprintf("%v|%v", k.Timestamp, k.NamedServerID)
+}
+
+// DedupableTaskLog is implemented by the task log entries that are de-duplicated when they are submitted
+type DedupableTaskLog interface {
+	GetDedupeKey() TaskLogDedupeKey
+}
+
+// TaskLogDuplicate is a task log entry that duplicates the older entry with the ID KeepID
+type TaskLogDuplicate struct {
+	ID        uint
+	KeepID    uint
+	NodeID    uint
+	Timestamp int64
+	EntryID   string
+}
+
 type TaskLogSpeedTest struct {
 	gorm.Model
 	Node                 Node
 	NamedServer          NamedServer
-	NodeID               uint    `gorm:"default:null"`
+	NodeID               uint    `gorm:"default:null;unique_index:idx_node_entry_id,idx_node_dedupe_key"`
 	Timestamp            int64   `gorm:"type:int(11); not null"`
 	Upload               float64 `gorm:"not null;default:0"`
 	Download             float64 `gorm:"not null;default:0"`
@@ -220,6 +704,19 @@ type TaskLogSpeedTest struct {
 	NodeIPAddress        string  `gorm:"not null"`
 	NodeRunningVersion   Version `gorm:"foreignkey:NodeRunningVersionID"`
 	NodeRunningVersionID uint    `gorm:"default:null"`
+	EntryID              string  `gorm:"type:varchar(64);default:null;unique_index:idx_node_entry_id"`
+	DedupeKey            string  `gorm:"type:varchar(64);default:null;unique_index:idx_node_dedupe_key"`
+	Flagged              bool    `gorm:"not null;default:false"`
+	FlagReason           string
+}
+
+func (t TaskLogSpeedTest) GetDedupeKey() TaskLogDedupeKey {
+	return TaskLogDedupeKey{
+		NodeID:        t.NodeID,
+		Timestamp:     t.Timestamp,
+		NamedServerID: t.NamedServerID,
+		EntryID:       t.EntryID,
+	}
 }
 
 func (t TaskLogSpeedTest) GetTaskLogMap() map[string]string {
@@ -258,7 +755,7 @@ type TaskLogPingTest struct {
 	gorm.Model
 	Node                 Node
 	NamedServer          NamedServer
-	NodeID               uint    `gorm:"default:null"`
+	NodeID               uint    `gorm:"default:null;unique_index:idx_node_entry_id,idx_node_dedupe_key"`
 	Timestamp            int64   `gorm:"type:int(11); not null"`
 	Latency              float64 `gorm:"not null;default:0"`
 	PacketLossPercent    float64 `gorm:"not null;default:0"`
@@ -272,6 +769,19 @@ type TaskLogPingTest struct {
 	NodeIPAddress        string
 	NodeRunningVersion   Version `gorm:"foreignkey:NodeRunningVersionID"`
 	NodeRunningVersionID uint    `gorm:"default:null"`
+	EntryID              string  `gorm:"type:varchar(64);default:null;unique_index:idx_node_entry_id"`
+	DedupeKey            string  `gorm:"type:varchar(64);default:null;unique_index:idx_node_dedupe_key"`
+	Flagged              bool    `gorm:"not null;default:false"`
+	FlagReason           string
+}
+
+func (t TaskLogPingTest) GetDedupeKey() TaskLogDedupeKey {
+	return TaskLogDedupeKey{
+		NodeID:        t.NodeID,
+		Timestamp:     t.Timestamp,
+		NamedServerID: t.NamedServerID,
+		EntryID:       t.EntryID,
+	}
 }
 
 func (t TaskLogPingTest) GetTaskLogMap() map[string]string {
@@ -358,6 +868,8 @@ type TaskLogNetworkDowntime struct {
 	DowntimeSeconds int64  `gorm:"not null;default:0"`
 	NodeNetwork     string
 	NodeIPAddress   string
+	Flagged         bool `gorm:"not null;default:false"`
+	FlagReason      string
 }
 
 func (t TaskLogNetworkDowntime) GetTaskLogMap() map[string]string {
@@ -385,6 +897,108 @@ func (t TaskLogNetworkDowntime) GetTaskLogKeys() []string {
 	return taskLogKeys
 }
 
+func validateTaskLogTimestamp(timestamp, now int64) error {
+	if timestamp <= 0 {
+		return fmt.Errorf("Timestamp is required")
+	}
+
+	if timestamp > now+TaskLogMaxClockSkewSeconds {
+		return fmt.Errorf("Timestamp %v is in the future", timestamp)
+	}
+
+	return nil
+}
+
+// Validate returns an error if the entry has impossible values, and flags it if it has suspicious ones
+func (t *TaskLogSpeedTest) Validate(now int64) error {
+	err := validateTaskLogTimestamp(t.Timestamp, now)
+	if err != nil {
+		return err
+	}
+
+	if t.Upload < 0 || t.Download < 0 {
+		return fmt.Errorf("Upload and Download must not be negative")
+	}
+
+	if t.Upload > TaskLogMaxSpeed || t.Download > TaskLogMaxSpeed {
+		return fmt.Errorf("Upload and Download must not be more than %v", TaskLogMaxSpeed)
+	}
+
+	t.Flagged = false
+	t.FlagReason = ""
+	if t.Upload > TaskLogSuspiciousSpeed || t.Download > TaskLogSuspiciousSpeed {
+		t.Flagged = true
+		t.FlagReason = fmt.Sprintf("Speed is more than %v", TaskLogSuspiciousSpeed)
+	}
+
+	return nil
+}
+
+// Validate returns an error if the entry has impossible values, and flags it if it has suspicious ones.
+// A latency of 0 is only possible when all the packets were lost.
+func (t *TaskLogPingTest) Validate(now int64) error {
+	err := validateTaskLogTimestamp(t.Timestamp, now)
+	if err != nil {
+		return err
+	}
+
+	if t.PacketLossPercent > 100 {
+		return fmt.Errorf("PacketLossPercent must not be more than 100")
+	}
+
+	if t.Latency < 0 || (t.Latency == 0 && t.PacketLossPercent < 100) {
+		return fmt.Errorf("Latency must be more than 0")
+	}
+
+	if t.Latency > TaskLogMaxLatency {
+		return fmt.Errorf("Latency must not be more than %v", TaskLogMaxLatency)
+	}
+
+	t.Flagged = false
+	t.FlagReason = ""
+	if t.Latency > TaskLogSuspiciousLatency {
+		t.Flagged = true
+		t.FlagReason = fmt.Sprintf("Latency is more than %v", TaskLogSuspiciousLatency)
+	}
+
+	return nil
+}
+
+// Validate returns an error if the entry has impossible values, and flags it if it has suspicious ones
+func (t *TaskLogNetworkDowntime) Validate(now int64) error {
+	err := validateTaskLogTimestamp(t.Timestamp, now)
+	if err != nil {
+		return err
+	}
+
+	if t.DowntimeSeconds < 0 {
+		return fmt.Errorf("DowntimeSeconds must not be negative")
+	}
+
+	if t.DowntimeSeconds > TaskLogMaxDowntimeSeconds {
+		return fmt.Errorf("DowntimeSeconds must not be more than %v", TaskLogMaxDowntimeSeconds)
+	}
+
+	t.Flagged = false
+	t.FlagReason = ""
+	if t.DowntimeSeconds > TaskLogSuspiciousDowntimeSeconds {
+		t.Flagged = true
+		t.FlagReason = fmt.Sprintf("DowntimeSeconds is more than %v", TaskLogSuspiciousDowntimeSeconds)
+	}
+
+	return nil
+}
+
+// Validate returns an error if the entry has impossible values
+func (t *TaskLogRestart) Validate(now int64) error {
+	return validateTaskLogTimestamp(t.Timestamp, now)
+}
+
+// Validate returns an error if the entry has impossible values
+func (t *TaskLogError) Validate(now int64) error {
+	return validateTaskLogTimestamp(t.Timestamp, now)
+}
+
 type ReportingSnapshot struct {
 	gorm.Model
 	Node                      Node
@@ -395,44 +1009,74 @@ type ReportingSnapshot struct {
 	UploadMax                 float64 `gorm:"not null;default:0"`
 	UploadMin                 float64 `gorm:"not null;default:0"`
 	UploadTotal               float64 `gorm:"not null;default:0"`
+	UploadP50                 float64 `gorm:"not null;default:0"`
+	UploadP90                 float64 `gorm:"not null;default:0"`
+	UploadP95                 float64 `gorm:"not null;default:0"`
 	DownloadAvg               float64 `gorm:"not null;default:0"`
 	DownloadMax               float64 `gorm:"not null;default:0"`
 	DownloadMin               float64 `gorm:"not null;default:0"`
 	DownloadTotal             float64 `gorm:"not null;default:0"`
+	DownloadP50               float64 `gorm:"not null;default:0"`
+	DownloadP90               float64 `gorm:"not null;default:0"`
+	DownloadP95               float64 `gorm:"not null;default:0"`
 	LatencyAvg                float64 `gorm:"not null;default:0"`
 	LatencyMax                float64 `gorm:"not null;default:0"`
 	LatencyMin                float64 `gorm:"not null;default:0"`
 	LatencyTotal              float64 `gorm:"not null;default:0"`
+	LatencyP50                float64 `gorm:"not null;default:0"`
+	LatencyP90                float64 `gorm:"not null;default:0"`
+	LatencyP95                float64 `gorm:"not null;default:0"`
 	PacketLossAvg             float64 `gorm:"not null;default:0"`
 	PacketLossMax             float64 `gorm:"not null;default:0"`
 	PacketLossMin             float64 `gorm:"not null;default:0"`
 	PacketLossTotal           float64 `gorm:"not null;default:0"`
+	PacketLossP50             float64 `gorm:"not null;default:0"`
+	PacketLossP90             float64 `gorm:"not null;default:0"`
+	PacketLossP95             float64 `gorm:"not null;default:0"`
 	SpeedTestDataPoints       int64   `gorm:"not null;default:0"`
 	LatencyDataPoints         int64   `gorm:"not null;default:0"`
 	NetworkDowntimeSeconds    int64   `gorm:"not null;default:0"`
 	NetworkOutagesCount       int64   `gorm:"not null;default:0"`
 	RestartsCount             int64   `gorm:"not null;default:0"`
+	MonitoredSeconds          int64   `gorm:"not null;default:0"` // The part of the period that has passed
+	HeartbeatGapSeconds       int64   `gorm:"not null;default:0"` // Time without heartbeats, when unreachable
+	UnavailableSeconds        int64   `gorm:"not null;default:0"` // Network downtime and heartbeat gaps combined
+	AvailabilityPercent       float64 `gorm:"not null;default:0"`
 	BizUploadAvg              float64 `gorm:"not null;default:0"`
 	BizUploadMax              float64 `gorm:"not null;default:0"`
 	BizUploadMin              float64 `gorm:"not null;default:0"`
 	BizUploadTotal            float64 `gorm:"not null;default:0"`
+	BizUploadP50              float64 `gorm:"not null;default:0"`
+	BizUploadP90              float64 `gorm:"not null;default:0"`
+	BizUploadP95              float64 `gorm:"not null;default:0"`
 	BizDownloadAvg            float64 `gorm:"not null;default:0"`
 	BizDownloadMax            float64 `gorm:"not null;default:0"`
 	BizDownloadMin            float64 `gorm:"not null;default:0"`
 	BizDownloadTotal          float64 `gorm:"not null;default:0"`
+	BizDownloadP50            float64 `gorm:"not null;default:0"`
+	BizDownloadP90            float64 `gorm:"not null;default:0"`
+	BizDownloadP95            float64 `gorm:"not null;default:0"`
 	BizLatencyAvg             float64 `gorm:"not null;default:0"`
 	BizLatencyMax             float64 `gorm:"not null;default:0"`
 	BizLatencyMin             float64 `gorm:"not null;default:0"`
 	BizLatencyTotal           float64 `gorm:"not null;default:0"`
+	BizLatencyP50             float64 `gorm:"not null;default:0"`
+	BizLatencyP90             float64 `gorm:"not null;default:0"`
+	BizLatencyP95             float64 `gorm:"not null;default:0"`
 	BizPacketLossAvg          float64 `gorm:"not null;default:0"`
 	BizPacketLossMax          float64 `gorm:"not null;default:0"`
 	BizPacketLossMin          float64 `gorm:"not null;default:0"`
 	BizPacketLossTotal        float64 `gorm:"not null;default:0"`
+	BizPacketLossP50          float64 `gorm:"not null;default:0"`
+	BizPacketLossP90          float64 `gorm:"not null;default:0"`
+	BizPacketLossP95          float64 `gorm:"not null;default:0"`
 	BizSpeedTestDataPoints    int64   `gorm:"not null;default:0"`
 	BizLatencyDataPoints      int64   `gorm:"not null;default:0"`
 	BizNetworkDowntimeSeconds int64   `gorm:"not null;default:0"`
 	BizNetworkOutagesCount    int64   `gorm:"not null;default:0"`
 	BizRestartsCount          int64   `gorm:"not null;default:0"`
+	BizExcludedDays           int64   `gorm:"not null;default:0"` // Days that were not business days
+	BizExcludedReason         string  `gorm:"type:varchar(255)"`  // Why a daily snapshot's day was not a business day
 }
 
 type ReportingEvent struct {
@@ -443,6 +1087,7 @@ type ReportingEvent struct {
 	Date        string `gorm:"not null;unique_index:idx_node_name_date"`
 	Name        string `gorm:"not null;unique_index:idx_node_name_date"`
 	Description string `gorm:"type:varchar(2048)"`
+	IsHoliday   bool   `gorm:"not null;default:false"` // Holidays are excluded from business hours metrics
 }
 
 func (r *ReportingEvent) SetTimestamp() error {
@@ -458,6 +1103,172 @@ func (r *ReportingEvent) SetTimestamp() error {
 	return nil
 }
 
+// AlertRule defines a threshold for a metric taken from the raw task logs over the last WindowHours.
+// The outage count is compared as outages per day. A rule applies to a single node, to all the nodes with a tag,
+// or to all approved nodes if neither is set.
+type AlertRule struct {
+	gorm.Model
+	Name        string  `gorm:"not null;unique_index"`
+	Description string  `gorm:"type:varchar(2048)"`
+	Metric      string  `gorm:"type:varchar(32);not null"`
+	Comparison  string  `gorm:"type:varchar(8);not null"`
+	Threshold   float64 `gorm:"not null;default:0"`
+	WindowHours int64   `gorm:"not null;default:24"`
+	Node        Node    `gorm:"foreignkey:NodeID" json:"-"`
+	NodeID      uint    `gorm:"default:null"`
+	Tag         Tag     `gorm:"foreignkey:TagID" json:"-"`
+	TagID       uint    `gorm:"default:null"`
+}
+
+// IsBreached returns true if the value is on the wrong side of the rule's threshold
+func (r *AlertRule) IsBreached(value float64) bool {
+	if r.Comparison == AlertRuleComparisonBelow {
+		return value < r.Threshold
+	}
+
+	return value > r.Threshold
+}
+
+func IsValidAlertRuleMetric(metric string) bool {
+	metrics := []string{
+		AlertRuleMetricDownloadAvg,
+		AlertRuleMetricUploadAvg,
+		AlertRuleMetricLatencyAvg,
+		AlertRuleMetricPacketLossAvg,
+		AlertRuleMetricOutageCount,
+	}
+	isValid, _ := InArray(metric, metrics)
+	return isValid
+}
+
+func IsValidAlertRuleComparison(comparison string) bool {
+	return comparison == AlertRuleComparisonBelow || comparison == AlertRuleComparisonAbove
+}
+
+// Alert is opened when an AlertRule is breached for a node and stays firing until the rule is no longer breached
+type Alert struct {
+	gorm.Model
+	AlertRule   AlertRule `gorm:"foreignkey:AlertRuleID" json:"-"`
+	AlertRuleID uint      `gorm:"not null"`
+	Node        Node      `gorm:"foreignkey:NodeID" json:"-"`
+	NodeID      uint      `gorm:"not null"`
+	State       string    `gorm:"type:varchar(16);not null"`
+	Value       float64   `gorm:"not null;default:0"`
+	Message     string    `gorm:"type:varchar(2048)"`
+	FiredAt     int64     `gorm:"type:int(11); not null;default:0"`
+	ResolvedAt  int64     `gorm:"type:int(11); not null;default:0"`
+}
+
+// NotificationChannel is somewhere other than the users' own email addresses that alerts get sent to.
+// Channels with a Tag only get the alerts for nodes with that Tag.
+type NotificationChannel struct {
+	gorm.Model
+	Name string `gorm:"not null;unique_index"`
+	Type string `gorm:"type:varchar(16);not null"`
+	// Target is the email address for email channels and the URL for the webhook channels
+	Target           string `gorm:"type:varchar(2048);not null"`
+	NotificationMode string `gorm:"type:varchar(16);not null;default:'immediate'"`
+	Tag              Tag    `gorm:"foreignkey:TagID" json:"-"`
+	TagID            uint   `gorm:"default:null"`
+}
+
+// IsPublicIP returns false for loopback, private, link-local (e.g. the cloud metadata service at 169.254.169.254),
+// shared (carrier-grade NAT), multicast and unspecified addresses, which notifications must never be sent to
+func IsPublicIP(ip net.IP) bool {
+	_, sharedRange, _ := net.ParseCIDR("100.64.0.0/10")
+
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedRange.Contains(ip))
+}
+
+func IsValidNotificationChannelType(channelType string) bool {
+	channelTypes := []string{
+		NotificationChannelTypeEmail,
+		NotificationChannelTypeWebhook,
+		NotificationChannelTypeSlack,
+		NotificationChannelTypeTeams,
+	}
+	isValid, _ := InArray(channelType, channelTypes)
+	return isValid
+}
+
+// NodeHeartbeat records each time a node says hello, so that gaps between them show when it was unreachable.
+// Changes lists what is different from the node's previous hello (e.g. "ipAddress,uptimeReset").
+type NodeHeartbeat struct {
+	gorm.Model
+	NodeID    uint   `gorm:"not null;index:idx_node_heartbeat_node_timestamp"`
+	Timestamp int64  `gorm:"type:int(11); not null;default:0;index:idx_node_heartbeat_node_timestamp"`
+	IPAddress string `gorm:"type:varchar(64)"`
+	Version   string `gorm:"type:varchar(32)"`
+	Uptime    int64  `gorm:"default:0"`
+	Changes   string `gorm:"type:varchar(255)"`
+}
+
+// GetChanges returns the list of changes that the heartbeat recorded
+func (h *NodeHeartbeat) GetChanges() []string {
+	if h.Changes == "" {
+		return []string{}
+	}
+	return strings.Split(h.Changes, NodeChangesSeparator)
+}
+
+// SLA holds the performance that a provider guarantees for a node, or for each of the nodes with a tag.
+// A target that is zero is not checked.
+type SLA struct {
+	gorm.Model
+	Name            string `gorm:"not null;unique_index"`
+	Provider        string
+	Description     string  `gorm:"type:varchar(2048)"`
+	MinDownload     float64 `gorm:"not null;default:0"` // Mbps
+	MinUpload       float64 `gorm:"not null;default:0"` // Mbps
+	MaxLatency      float64 `gorm:"not null;default:0"` // Milliseconds
+	MaxPacketLoss   float64 `gorm:"not null;default:0"` // Percent
+	MinAvailability float64 `gorm:"not null;default:0"` // Percent
+	Node            Node    `gorm:"foreignkey:NodeID" json:"-"`
+	NodeID          uint    `gorm:"default:null"`
+	Tag             Tag     `gorm:"foreignkey:TagID" json:"-"`
+	TagID           uint    `gorm:"default:null"`
+}
+
+// GetTargets returns the SLA's targets that are set, keyed by metric
+func (s *SLA) GetTargets() map[string]float64 {
+	allTargets := map[string]float64{
+		SLAMetricDownload:     s.MinDownload,
+		SLAMetricUpload:       s.MinUpload,
+		SLAMetricLatency:      s.MaxLatency,
+		SLAMetricPacketLoss:   s.MaxPacketLoss,
+		SLAMetricAvailability: s.MinAvailability,
+	}
+
+	targets := map[string]float64{}
+	for metric, target := range allTargets {
+		if target > 0 {
+			targets[metric] = target
+		}
+	}
+
+	return targets
+}
+
+// IsMet returns true if the value meets the SLA's target for the metric. Download, upload and availability
+// must be at least the target, while latency and packet loss must be no more than the target.
+func (s *SLA) IsMet(metric string, value float64) bool {
+	switch metric {
+	case SLAMetricDownload:
+		return value >= s.MinDownload
+	case SLAMetricUpload:
+		return value >= s.MinUpload
+	case SLAMetricLatency:
+		return value <= s.MaxLatency
+	case SLAMetricPacketLoss:
+		return value <= s.MaxPacketLoss
+	case SLAMetricAvailability:
+		return value >= s.MinAvailability
+	}
+
+	return false
+}
+
 /***************************************************************
 /*
 /* Define non-database types
@@ -472,10 +1283,506 @@ type HelloRequest struct {
 	Arch    string
 }
 
+// GetHelloChanges compares a hello with what the node reported in its previous one, before the node is updated.
+// An uptime that is lower than before means the node restarted in between, even if it didn't log a restart.
+func GetHelloChanges(node Node, hello HelloRequest, ipAddress string) []string {
+	if node.ID == 0 {
+		return []string{NodeChangeFirstHello}
+	}
+
+	changes := []string{}
+	if node.IPAddress != ipAddress {
+		changes = append(changes, NodeChangeIPAddress)
+	}
+	if node.RunningVersion.Number != hello.Version {
+		changes = append(changes, NodeChangeVersion)
+	}
+	if hello.Uptime < node.Uptime {
+		changes = append(changes, NodeChangeUptimeReset)
+	}
+
+	return changes
+}
+
+// TaskLogBatchEntry is one of the entries in a batch of task logs from an agent.
+// Type is one of the entry types for the single entry endpoint and Entry is the body that would be sent to it.
+type TaskLogBatchEntry struct {
+	Type  string
+	Entry json.RawMessage
+}
+
+// TaskLogBatchResult reports whether the entry at Index in a batch of task logs was saved
+type TaskLogBatchResult struct {
+	Index     int
+	Type      string
+	Success   bool
+	Duplicate bool   `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+// FleetReportRow summarizes the snapshots for one node, or for the whole fleet, over the period of a FleetReport
+type FleetReportRow struct {
+	NodeID                 uint
+	Nickname               string
+	MacAddr                string
+	DownloadAvg            float64
+	DownloadMax            float64
+	DownloadMin            float64
+	UploadAvg              float64
+	UploadMax              float64
+	UploadMin              float64
+	LatencyAvg             float64
+	LatencyMax             float64
+	LatencyMin             float64
+	PacketLossAvg          float64
+	PacketLossMax          float64
+	SpeedTestDataPoints    int64
+	LatencyDataPoints      int64
+	NetworkDowntimeSeconds int64
+	NetworkOutagesCount    int64
+	RestartsCount          int64
+	UnavailableSeconds     int64
+	AvailabilityPercent    float64
+}
+
+type FleetReport struct {
+	Start  string
+	End    string
+	TagID  uint
+	Nodes  []FleetReportRow
+	Totals FleetReportRow
+}
+
+// TagReportPeriod combines the snapshots of all the nodes with a tag for one reporting period.
+// The averages are weighted by the number of data points behind each snapshot.
+type TagReportPeriod struct {
+	Timestamp                 int64
+	Date                      string
+	Interval                  string
+	NodeCount                 int
+	DownloadAvg               float64
+	DownloadMax               float64
+	DownloadMin               float64
+	UploadAvg                 float64
+	UploadMax                 float64
+	UploadMin                 float64
+	LatencyAvg                float64
+	LatencyMax                float64
+	LatencyMin                float64
+	PacketLossAvg             float64
+	PacketLossMax             float64
+	SpeedTestDataPoints       int64
+	LatencyDataPoints         int64
+	NetworkDowntimeSeconds    int64
+	NetworkOutagesCount       int64
+	RestartsCount             int64
+	UnavailableSeconds        int64
+	AvailabilityPercent       float64
+	BizDownloadAvg            float64
+	BizUploadAvg              float64
+	BizLatencyAvg             float64
+	BizPacketLossAvg          float64
+	BizNetworkDowntimeSeconds int64
+	BizExcludedDays           int64 // Node days that were not business days
+}
+
+func (t TagReportPeriod) GetTaskLogMap() map[string]string {
+	return map[string]string{
+		"Date":                      t.Date,
+		"Interval":                  t.Interval,
+		"NodeCount":                 fmt.Sprintf("%v", t.NodeCount),
+		"DownloadAvg":               fmt.Sprintf("%.3f", t.DownloadAvg),
+		"DownloadMax":               fmt.Sprintf("%.3f", t.DownloadMax),
+		"DownloadMin":               fmt.Sprintf("%.3f", t.DownloadMin),
+		"UploadAvg":                 fmt.Sprintf("%.3f", t.UploadAvg),
+		"UploadMax":                 fmt.Sprintf("%.3f", t.UploadMax),
+		"UploadMin":                 fmt.Sprintf("%.3f", t.UploadMin),
+		"LatencyAvg":                fmt.Sprintf("%.3f", t.LatencyAvg),
+		"LatencyMax":                fmt.Sprintf("%.3f", t.LatencyMax),
+		"LatencyMin":                fmt.Sprintf("%.3f", t.LatencyMin),
+		"PacketLossAvg":             fmt.Sprintf("%.3f", t.PacketLossAvg),
+		"PacketLossMax":             fmt.Sprintf("%.3f", t.PacketLossMax),
+		"SpeedTestDataPoints":       fmt.Sprintf("%v", t.SpeedTestDataPoints),
+		"LatencyDataPoints":         fmt.Sprintf("%v", t.LatencyDataPoints),
+		"NetworkDowntimeSeconds":    fmt.Sprintf("%v", t.NetworkDowntimeSeconds),
+		"NetworkOutagesCount":       fmt.Sprintf("%v", t.NetworkOutagesCount),
+		"RestartsCount":             fmt.Sprintf("%v", t.RestartsCount),
+		"UnavailableSeconds":        fmt.Sprintf("%v", t.UnavailableSeconds),
+		"AvailabilityPercent":       fmt.Sprintf("%.3f", t.AvailabilityPercent),
+		"BizDownloadAvg":            fmt.Sprintf("%.3f", t.BizDownloadAvg),
+		"BizUploadAvg":              fmt.Sprintf("%.3f", t.BizUploadAvg),
+		"BizLatencyAvg":             fmt.Sprintf("%.3f", t.BizLatencyAvg),
+		"BizPacketLossAvg":          fmt.Sprintf("%.3f", t.BizPacketLossAvg),
+		"BizNetworkDowntimeSeconds": fmt.Sprintf("%v", t.BizNetworkDowntimeSeconds),
+		"BizExcludedDays":           fmt.Sprintf("%v", t.BizExcludedDays),
+	}
+}
+
+func (t TagReportPeriod) GetTaskLogKeys() []string {
+	return []string{
+		"Date",
+		"Interval",
+		"NodeCount",
+		"DownloadAvg",
+		"DownloadMax",
+		"DownloadMin",
+		"UploadAvg",
+		"UploadMax",
+		"UploadMin",
+		"LatencyAvg",
+		"LatencyMax",
+		"LatencyMin",
+		"PacketLossAvg",
+		"PacketLossMax",
+		"SpeedTestDataPoints",
+		"LatencyDataPoints",
+		"NetworkDowntimeSeconds",
+		"NetworkOutagesCount",
+		"RestartsCount",
+		"UnavailableSeconds",
+		"AvailabilityPercent",
+		"BizDownloadAvg",
+		"BizUploadAvg",
+		"BizLatencyAvg",
+		"BizPacketLossAvg",
+		"BizNetworkDowntimeSeconds",
+		"BizExcludedDays",
+	}
+}
+
+// HeatmapCell summarizes a node's raw logs for one hour of the day on one day of the week
+type HeatmapCell struct {
+	DayOfWeek      int // 0 is Sunday
+	Day            string
+	Hour           int
+	DownloadAvg    float64
+	UploadAvg      float64
+	SpeedTestCount int64
+	LatencyAvg     float64
+	PacketLossAvg  float64
+	PingCount      int64
+}
+
+func (h HeatmapCell) GetTaskLogMap() map[string]string {
+	return map[string]string{
+		"Day":            h.Day,
+		"Hour":           fmt.Sprintf("%02d:00", h.Hour),
+		"DownloadAvg":    fmt.Sprintf("%.3f", h.DownloadAvg),
+		"UploadAvg":      fmt.Sprintf("%.3f", h.UploadAvg),
+		"SpeedTestCount": fmt.Sprintf("%v", h.SpeedTestCount),
+		"LatencyAvg":     fmt.Sprintf("%.3f", h.LatencyAvg),
+		"PacketLossAvg":  fmt.Sprintf("%.3f", h.PacketLossAvg),
+		"PingCount":      fmt.Sprintf("%v", h.PingCount),
+	}
+}
+
+func (h HeatmapCell) GetTaskLogKeys() []string {
+	return []string{
+		"Day",
+		"Hour",
+		"DownloadAvg",
+		"UploadAvg",
+		"SpeedTestCount",
+		"LatencyAvg",
+		"PacketLossAvg",
+		"PingCount",
+	}
+}
+
+// Heatmap has a cell for each hour of each day of the week, ordered by day and then by hour
+type Heatmap struct {
+	NodeID uint
+	Start  string
+	End    string
+	Cells  []HeatmapCell
+}
+
+// SLAComplianceRow shows how well one of an SLA's targets was met by a node over the report's period.
+// For availability, each measurement is a day and the average is the availability over the whole period.
+type SLAComplianceRow struct {
+	NodeID            uint
+	Nickname          string
+	MacAddr           string
+	Metric            string
+	Target            float64
+	Average           float64
+	CompliancePercent float64 // The percentage of measurements that met the target
+	Measurements      int64
+	BreachPeriods     int64
+}
+
+func (r SLAComplianceRow) GetTaskLogMap() map[string]string {
+	return map[string]string{
+		"NodeID":            fmt.Sprintf("%v", r.NodeID),
+		"Nickname":          r.Nickname,
+		"MacAddr":           r.MacAddr,
+		"Metric":            r.Metric,
+		"Target":            fmt.Sprintf("%.3f", r.Target),
+		"Average":           fmt.Sprintf("%.3f", r.Average),
+		"CompliancePercent": fmt.Sprintf("%.3f", r.CompliancePercent),
+		"Measurements":      fmt.Sprintf("%v", r.Measurements),
+		"BreachPeriods":     fmt.Sprintf("%v", r.BreachPeriods),
+	}
+}
+
+func (r SLAComplianceRow) GetTaskLogKeys() []string {
+	return []string{
+		"NodeID",
+		"Nickname",
+		"MacAddr",
+		"Metric",
+		"Target",
+		"Average",
+		"CompliancePercent",
+		"Measurements",
+		"BreachPeriods",
+	}
+}
+
+// SLABreachPeriod is a run of consecutive measurements for a node that all missed one of an SLA's targets
+type SLABreachPeriod struct {
+	NodeID       uint
+	Nickname     string
+	MacAddr      string
+	Metric       string
+	Target       float64
+	Start        int64
+	End          int64
+	Measurements int64
+	WorstValue   float64
+}
+
+func (b SLABreachPeriod) GetTaskLogMap() map[string]string {
+	return map[string]string{
+		"NodeID":       fmt.Sprintf("%v", b.NodeID),
+		"Nickname":     b.Nickname,
+		"MacAddr":      b.MacAddr,
+		"Metric":       b.Metric,
+		"Target":       fmt.Sprintf("%.3f", b.Target),
+		"Start":        time.Unix(b.Start, 0).UTC().Format(time.RFC3339),
+		"End":          time.Unix(b.End, 0).UTC().Format(time.RFC3339),
+		"Measurements": fmt.Sprintf("%v", b.Measurements),
+		"WorstValue":   fmt.Sprintf("%.3f", b.WorstValue),
+	}
+}
+
+func (b SLABreachPeriod) GetTaskLogKeys() []string {
+	return []string{
+		"NodeID",
+		"Nickname",
+		"MacAddr",
+		"Metric",
+		"Target",
+		"Start",
+		"End",
+		"Measurements",
+		"WorstValue",
+	}
+}
+
+// SLAComplianceReport shows how well an SLA was met by each of its nodes for a month
+type SLAComplianceReport struct {
+	SLAID    uint
+	Name     string
+	Provider string
+	Month    string
+	Start    string
+	End      string
+	Rows     []SLAComplianceRow
+	Breaches []SLABreachPeriod
+}
+
+// RolloutNodeProgress shows whether a rollout has reached a node and whether the node is running its version
+type RolloutNodeProgress struct {
+	NodeID         uint
+	Nickname       string
+	MacAddr        string
+	RunningVersion string
+	Targeted       bool
+	Upgraded       bool
+}
+
+// RolloutProgress compares the versions that the nodes in a rollout are running with the rollout's version.
+// The rates are the task log errors and restarts per node per day since the current wave started, for the nodes
+// that are running the rollout's version and for the ones that are not (the baseline).
+type RolloutProgress struct {
+	RolloutID           uint
+	Version             string
+	Status              string
+	PausedReason        string
+	CurrentWave         int64
+	CurrentPercent      int
+	TotalNodes          int
+	TargetedNodes       int
+	UpgradedNodes       int
+	PendingNodes        int // Targeted, but not running the version yet
+	UpgradedErrorRate   float64
+	UpgradedRestartRate float64
+	BaselineErrorRate   float64
+	BaselineRestartRate float64
+	Nodes               []RolloutNodeProgress
+}
+
+// VersionNode shows the version that a node should be running and the one it is actually running
+type VersionNode struct {
+	NodeID              uint
+	Nickname            string
+	MacAddr             string
+	ConfiguredVersion   string
+	TargetVersionID     uint
+	TargetVersion       string // The configured version, or the one the node gets for the latest version
+	RunningVersionID    uint
+	RunningVersion      string
+	ConfiguredVersionAt string
+	Converged           bool
+	ConvergenceFailed   bool // Still not running its target version after the allowed time
+}
+
+// VersionSummary counts the nodes that should be running a version and the ones that are running it
+type VersionSummary struct {
+	VersionID              uint
+	Number                 string
+	Status                 string
+	ConfiguredNodes        int
+	RunningNodes           int
+	ConvergenceFailedNodes int
+}
+
+// BuildVersionNode compares the version the node is running with the one it should be running. Nodes whose version
+// was set before that was tracked count as having had long enough to start running it.
+func BuildVersionNode(node Node, versions []Version, rollouts []Rollout, now time.Time, convergenceHours int64) (VersionNode, error) {
+	targetVersion, setAt, err := node.GetTargetVersion(versions, rollouts)
+	if err != nil {
+		return VersionNode{}, err
+	}
+
+	converged := targetVersion.ID == 0 || node.RunningVersionID == targetVersion.ID
+
+	return VersionNode{
+		NodeID:              node.ID,
+		Nickname:            node.Nickname,
+		MacAddr:             node.MacAddr,
+		ConfiguredVersion:   node.ConfiguredVersion.Number,
+		TargetVersionID:     targetVersion.ID,
+		TargetVersion:       targetVersion.Number,
+		RunningVersionID:    node.RunningVersionID,
+		RunningVersion:      node.RunningVersion.Number,
+		ConfiguredVersionAt: node.ConfiguredVersionAt,
+		Converged:           converged,
+		ConvergenceFailed:   !converged && now.Sub(setAt) > time.Duration(convergenceHours)*time.Hour,
+	}, nil
+}
+
+// BuildVersionSummaries counts, for each of the versions, the nodes that should be running it, the ones that are
+// running it and the ones that haven't started running it within the convergence hours. Nodes that are set to the
+// latest version are counted for the version that they get.
+func BuildVersionSummaries(versions []Version, nodes []Node, rollouts []Rollout, now time.Time, convergenceHours int64) ([]VersionSummary, error) {
+	versionNodes := []VersionNode{}
+	for _, node := range nodes {
+		versionNode, err := BuildVersionNode(node, versions, rollouts, now, convergenceHours)
+		if err != nil {
+			return []VersionSummary{}, err
+		}
+		versionNodes = append(versionNodes, versionNode)
+	}
+
+	summaries := []VersionSummary{}
+	for _, version := range versions {
+		summary := VersionSummary{
+			VersionID: version.ID,
+			Number:    version.Number,
+			Status:    version.Status,
+		}
+
+		for _, versionNode := range versionNodes {
+			if versionNode.TargetVersionID == version.ID {
+				summary.ConfiguredNodes++
+				if versionNode.ConvergenceFailed {
+					summary.ConvergenceFailedNodes++
+				}
+			}
+			if versionNode.RunningVersionID == version.ID {
+				summary.RunningNodes++
+			}
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
+
+// TaskSchedulePreview shows when a task will next run and how much it will run in a day
+type TaskSchedulePreview struct {
+	TaskID      uint
+	Type        string
+	Schedule    string
+	ServerHost  string
+	NextRuns    []string
+	RunsPerDay  int
+	BytesPerDay int64
+}
+
+// SchedulePreview shows when a node's tasks will next run, in the node's timezone, along with any problems
+// with their schedules
+type SchedulePreview struct {
+	NodeID            uint
+	Timezone          string
+	Tasks             []TaskSchedulePreview
+	BytesPerDay       int64
+	BytesPerDayBudget int64
+	Warnings          []string
+}
+
+// ListParams holds the paging and sorting that were requested for a list endpoint
+type ListParams struct {
+	Limit  int
+	Offset int
+	// Order is an sql order clause, built from a sort field that has been checked against a whitelist
+	Order string
+}
+
+// PageInfo is the paging metadata that gets returned in the headers of a list response
+type PageInfo struct {
+	Total  int
+	Limit  int
+	Offset int
+}
+
+// GetHeaders returns the paging response headers, including the offset of the next page if there is one
+func (p PageInfo) GetHeaders() map[string]string {
+	headers := map[string]string{
+		ListRespHeaderTotalCount: strconv.Itoa(p.Total),
+		// Browsers only let the admin UI read the headers that are exposed
+		CORSHeaderExposeHeaders: strings.Join([]string{
+			ListRespHeaderTotalCount,
+			ListRespHeaderLimit,
+			ListRespHeaderOffset,
+			ListRespHeaderNextOffset,
+		}, ", "),
+	}
+
+	// A limit of 0 means the list wasn't paged
+	if p.Limit == 0 {
+		return headers
+	}
+
+	headers[ListRespHeaderLimit] = strconv.Itoa(p.Limit)
+	headers[ListRespHeaderOffset] = strconv.Itoa(p.Offset)
+
+	if p.Offset+p.Limit < p.Total {
+		headers[ListRespHeaderNextOffset] = strconv.Itoa(p.Offset + p.Limit)
+	}
+
+	return headers
+}
+
 type NodeConfig struct {
 	Version struct {
 		Number string
 		URL    string
+		SHA256 string
+		Size   int64
 	}
 	Tasks []Task
 }
@@ -531,6 +1838,23 @@ func ClientError(status int, body string) (events.APIGatewayProxyResponse, error
 	}, nil
 }
 
+// GetNodeAuthStatus returns 0, "" if the agent request includes the token that was issued to the node
+func GetNodeAuthStatus(req events.APIGatewayProxyRequest, node Node) (int, string) {
+	token := req.Headers[NodeReqHeaderToken]
+	if node.IsValidAuthToken(token) {
+		return 0, ""
+	}
+
+	fmt.Fprintf(
+		os.Stdout,
+		"Attempt at unauthenticated agent access at path: %s.\n  Node: %s.\n  Token provided: %v.\n",
+		req.Path,
+		node.MacAddr,
+		token != "",
+	)
+	return http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized)
+}
+
 // IsValidMacAddress checks whether the input is ...
 //   - 12 hexacedimal digits OR
 //   - 6 pairs of hexadecimal digits separated by colons and/or hyphens
@@ -557,7 +1881,8 @@ func CleanMACAddress(mAddr string) (string, error) {
 // CleanBusinessTimes takes to strings for 24-hour times (HH:MM).
 // If they are both empty strings, it returns empty strings.
 // Otherwise makes sure there is no error parsing them into time.Time values,
-// in which case it returns the original values
+// in which case it returns the original values.
+// A close time before the start time means the business window wraps past midnight.
 func CleanBusinessTimes(start, close string) (string, string, error) {
 	if (start == "" || start == "00:00") && (close == "" || close == "00:00") {
 		return start, close, nil
@@ -581,15 +1906,87 @@ func CleanBusinessTimes(start, close string) (string, string, error) {
 		return start, close, fmt.Errorf("Error parsing business close time.\n %s", err.Error())
 	}
 
-	if !closeTime.After(startTime) {
+	if closeTime.Equal(startTime) {
 		return start, close, fmt.Errorf(
-			"Error parsing business times. A 24-hour format must be used and close time must come after start time.",
+			"Error parsing business times. A 24-hour format must be used and close time must differ from start time.",
 		)
 	}
 
 	return start, close, nil
 }
 
+// CleanWorkingDays takes a comma separated list of day abbreviations (e.g. "mon, tue,Wed").
+// It returns them in the standard format and order (e.g. "Mon,Tue,Wed") without any duplicates,
+// or an empty string if no days are given.
+func CleanWorkingDays(workingDays string) (string, error) {
+	if strings.TrimSpace(workingDays) == "" {
+		return "", nil
+	}
+
+	isWorkingDay := map[time.Weekday]bool{}
+	for _, day := range strings.Split(workingDays, WorkingDaysSeparator) {
+		weekday, err := getWeekdayFromAbbreviation(strings.TrimSpace(day))
+		if err != nil {
+			return workingDays, err
+		}
+		isWorkingDay[weekday] = true
+	}
+
+	days := []string{}
+	// time.Weekday has Sunday as 0, so shift it to start the list on Monday
+	for i := 1; i <= 7; i++ {
+		weekday := time.Weekday(i % 7)
+		if isWorkingDay[weekday] {
+			days = append(days, weekday.String()[:3])
+		}
+	}
+
+	return strings.Join(days, WorkingDaysSeparator), nil
+}
+
+// IsWorkingDay returns true if the weekday is in the comma separated list of working days.
+// An empty list means every day is a working day.
+func IsWorkingDay(workingDays string, weekday time.Weekday) bool {
+	if workingDays == "" {
+		return true
+	}
+
+	for _, day := range strings.Split(workingDays, WorkingDaysSeparator) {
+		dayOfWeek, err := getWeekdayFromAbbreviation(strings.TrimSpace(day))
+		if err == nil && dayOfWeek == weekday {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getWeekdayFromAbbreviation(day string) (time.Weekday, error) {
+	for i := 0; i < 7; i++ {
+		weekday := time.Weekday(i)
+		if strings.EqualFold(day, weekday.String()[:3]) {
+			return weekday, nil
+		}
+	}
+
+	return time.Sunday, fmt.Errorf("Invalid working day: %s. Use Mon, Tue, Wed, Thu, Fri, Sat or Sun", day)
+}
+
+// CleanTimezone makes sure the given IANA timezone name (e.g. "America/New_York") can be loaded.
+// An empty string is treated as UTC.
+func CleanTimezone(timezone string) (string, error) {
+	if timezone == "" {
+		return "UTC", nil
+	}
+
+	_, err := time.LoadLocation(timezone)
+	if err != nil {
+		return timezone, fmt.Errorf("Invalid timezone: %s", timezone)
+	}
+
+	return timezone, nil
+}
+
 // GetUrlForAgentVersion creates url to agent binary for given version, os, and arch
 func GetUrlForAgentVersion(version, operatingsystem, arch string) string {
 	downloadBaseUrl := os.Getenv("downloadBaseUrl")
@@ -606,6 +2003,48 @@ func GetUrlForAgentVersion(version, operatingsystem, arch string) string {
 	return url
 }
 
+// CleanVersionBuilds checks that each build has a platform, an http(s) URL, a SHA-256 checksum in hex and a size,
+// and that no platform has more than one build. The platform and checksum are made lower case.
+func CleanVersionBuilds(builds []VersionBuild) ([]VersionBuild, error) {
+	cleanBuilds := []VersionBuild{}
+	platforms := map[string]bool{}
+
+	for _, build := range builds {
+		build.OS = strings.ToLower(strings.TrimSpace(build.OS))
+		build.Arch = strings.ToLower(strings.TrimSpace(build.Arch))
+		build.SHA256 = strings.ToLower(strings.TrimSpace(build.SHA256))
+		build.URL = strings.TrimSpace(build.URL)
+
+		if build.OS == "" || build.Arch == "" {
+			return builds, fmt.Errorf("Each build must have an OS and an Arch")
+		}
+
+		platform := build.OS + "/" + build.Arch
+		if platforms[platform] {
+			return builds, fmt.Errorf("There is more than one build for %s", platform)
+		}
+		platforms[platform] = true
+
+		buildURL, err := url.ParseRequestURI(build.URL)
+		if err != nil || (buildURL.Scheme != "http" && buildURL.Scheme != "https") || buildURL.Host == "" {
+			return builds, fmt.Errorf("Invalid URL for the %s build: %s", platform, build.URL)
+		}
+
+		checksum, err := hex.DecodeString(build.SHA256)
+		if err != nil || len(checksum) != sha256.Size {
+			return builds, fmt.Errorf("Invalid SHA256 checksum for the %s build: %s", platform, build.SHA256)
+		}
+
+		if build.Size <= 0 {
+			return builds, fmt.Errorf("Invalid Size for the %s build: %v", platform, build.Size)
+		}
+
+		cleanBuilds = append(cleanBuilds, build)
+	}
+
+	return cleanBuilds, nil
+}
+
 // DoTagsOverlap returns true if there is a tag with the same UID
 //  in both slices of tags.  Otherwise, returns false.
 func DoTagsOverlap(tags1, tags2 []Tag) bool {
@@ -709,6 +2148,63 @@ func GetUintFromString(param string) uint {
 	return uint(id)
 }
 
+// GetListParamsFromRequest reads the limit, offset and sort query parameters.
+// The sort parameter is a field name with an optional leading "-" for descending order. It must be one
+// of the keys of sortFields, which maps it to its database column.
+func GetListParamsFromRequest(req events.APIGatewayProxyRequest, sortFields map[string]string, defaultSort string) (ListParams, error) {
+	params := ListParams{}
+
+	if limitParam := req.QueryStringParameters["limit"]; limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > MaxListLimit {
+			return ListParams{}, fmt.Errorf("limit must be a number from 1 to %d", MaxListLimit)
+		}
+		params.Limit = limit
+	}
+
+	if offsetParam := req.QueryStringParameters["offset"]; offsetParam != "" {
+		offset, err := strconv.Atoi(offsetParam)
+		if err != nil || offset < 0 {
+			return ListParams{}, fmt.Errorf("offset must be a number that is not negative")
+		}
+		params.Offset = offset
+
+		if params.Limit == 0 {
+			params.Limit = DefaultListLimit
+		}
+	}
+
+	sort := req.QueryStringParameters["sort"]
+	if sort == "" {
+		sort = defaultSort
+	}
+
+	direction := "asc"
+	if strings.HasPrefix(sort, "-") {
+		direction = "desc"
+		sort = strings.TrimPrefix(sort, "-")
+	}
+
+	column, ok := sortFields[sort]
+	if !ok {
+		return ListParams{}, fmt.Errorf("invalid sort field: %s", sort)
+	}
+
+	// Include the id so that paging is stable when the sort column has duplicates
+	params.Order = fmt.Sprintf("%s %s, id %s", column, direction, direction)
+
+	return params, nil
+}
+
+// ReturnPageJsonOrError works like ReturnJsonOrError, but adds the paging headers
+func ReturnPageJsonOrError(response interface{}, pageInfo PageInfo, err error) (events.APIGatewayProxyResponse, error) {
+	resp, respErr := ReturnJsonOrError(response, err)
+	if resp.StatusCode == http.StatusOK {
+		resp.Headers = pageInfo.GetHeaders()
+	}
+	return resp, respErr
+}
+
 func ReturnJsonOrError(response interface{}, err error) (events.APIGatewayProxyResponse, error) {
 	if err != nil {
 		if gorm.IsRecordNotFoundError(err) {
@@ -2,8 +2,15 @@ package domain
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/jinzhu/gorm"
+	"net"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type testMACAddr struct {
@@ -406,9 +413,24 @@ func TestCleanBusinessTimes(t *testing.T) {
 		return
 	}
 
-	// Close time too early
+	// Good - wraps past midnight
+	start = "22:00"
+	close = "06:00"
+
+	resultStart, resultClose, err = CleanBusinessTimes(start, close)
+	if err != nil {
+		t.Errorf("Unexpected error.\n%s", err.Error())
+		return
+	}
+
+	if resultStart != start || resultClose != close {
+		t.Errorf("Bad results. Expected: %s and %s, but got %s and %s", start, close, resultStart, resultClose)
+		return
+	}
+
+	// Close time same as start time
 	start = "08:00"
-	close = "04:00"
+	close = "08:00"
 
 	resultStart, resultClose, err = CleanBusinessTimes(start, close)
 	if err == nil {
@@ -436,3 +458,643 @@ func TestCleanBusinessTimes(t *testing.T) {
 		return
 	}
 }
+
+func TestCleanTimezone(t *testing.T) {
+	goodZones := map[string]string{
+		"":                 "UTC",
+		"UTC":              "UTC",
+		"America/New_York": "America/New_York",
+		"Asia/Kolkata":     "Asia/Kolkata",
+	}
+
+	for timezone, expected := range goodZones {
+		result, err := CleanTimezone(timezone)
+		if err != nil {
+			t.Errorf("Unexpected error for %s.\n%s", timezone, err.Error())
+			continue
+		}
+
+		if result != expected {
+			t.Errorf("Bad result for %s. Expected %s, but got %s", timezone, expected, result)
+		}
+	}
+
+	badZones := []string{"Mars/Olympus_Mons", "EST+99", "../etc/passwd"}
+	for _, timezone := range badZones {
+		_, err := CleanTimezone(timezone)
+		if err == nil {
+			t.Errorf("Expected an error for %s, but didn't get one.", timezone)
+		}
+	}
+}
+
+func TestNode_GetLocation(t *testing.T) {
+	node := Node{}
+	if node.GetLocation() != time.UTC {
+		t.Errorf("Expected UTC for a node without a timezone, but got %s", node.GetLocation())
+	}
+
+	node.Timezone = "Not/A_Zone"
+	if node.GetLocation() != time.UTC {
+		t.Errorf("Expected UTC for a node with an invalid timezone, but got %s", node.GetLocation())
+	}
+
+	node.Timezone = "America/Chicago"
+	if node.GetLocation().String() != "America/Chicago" {
+		t.Errorf("Expected America/Chicago, but got %s", node.GetLocation())
+	}
+}
+
+func TestNode_IssueAuthToken(t *testing.T) {
+	node := Node{}
+
+	if node.IsValidAuthToken("") {
+		t.Error("A node without a token should not accept an empty token")
+	}
+
+	err := node.IssueAuthToken()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	token := node.AuthToken
+	if token == "" || node.AuthTokenHash == "" || node.AuthTokenIssuedAt == "" {
+		t.Errorf("Token was not issued as expected. Got: %+v", node)
+		return
+	}
+
+	if node.AuthTokenHash == token {
+		t.Error("The token should not be stored in plain text")
+	}
+
+	if !node.IsValidAuthToken(token) {
+		t.Error("The issued token was not accepted")
+	}
+
+	if node.IsValidAuthToken(token + "x") {
+		t.Error("A wrong token was accepted")
+	}
+
+	err = node.IssueAuthToken()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if node.IsValidAuthToken(token) {
+		t.Error("The replaced token is still accepted")
+	}
+
+	node.RevokeAuthToken()
+	if node.HasAuthToken() || node.IsValidAuthToken(node.AuthToken) {
+		t.Errorf("Token was not revoked as expected. Got: %+v", node)
+	}
+}
+
+func TestAlertRule_IsBreached(t *testing.T) {
+	testCases := []struct {
+		comparison string
+		value      float64
+		expected   bool
+	}{
+		{AlertRuleComparisonBelow, 4.9, true},
+		{AlertRuleComparisonBelow, 5, false},
+		{AlertRuleComparisonBelow, 5.1, false},
+		{AlertRuleComparisonAbove, 4.9, false},
+		{AlertRuleComparisonAbove, 5, false},
+		{AlertRuleComparisonAbove, 5.1, true},
+	}
+
+	for _, tc := range testCases {
+		rule := AlertRule{Comparison: tc.comparison, Threshold: 5}
+		results := rule.IsBreached(tc.value)
+		if results != tc.expected {
+			t.Errorf("Wrong result for %s %v. Expected %v, but got %v", tc.comparison, tc.value, tc.expected, results)
+		}
+	}
+}
+
+func TestGetListParamsFromRequest(t *testing.T) {
+	sortFields := map[string]string{"name": "name", "date": "timestamp"}
+
+	testCases := []struct {
+		name        string
+		params      map[string]string
+		expected    ListParams
+		expectError bool
+	}{
+		{
+			name:     "defaults",
+			params:   map[string]string{},
+			expected: ListParams{Limit: 0, Offset: 0, Order: "name asc, id asc"},
+		},
+		{
+			name:     "offset without a limit",
+			params:   map[string]string{"offset": "20"},
+			expected: ListParams{Limit: DefaultListLimit, Offset: 20, Order: "name asc, id asc"},
+		},
+		{
+			name:     "all given",
+			params:   map[string]string{"limit": "10", "offset": "20", "sort": "-date"},
+			expected: ListParams{Limit: 10, Offset: 20, Order: "timestamp desc, id desc"},
+		},
+		{
+			name:        "limit too big",
+			params:      map[string]string{"limit": "1001"},
+			expectError: true,
+		},
+		{
+			name:        "negative offset",
+			params:      map[string]string{"offset": "-1"},
+			expectError: true,
+		},
+		{
+			name:        "invalid sort",
+			params:      map[string]string{"sort": "name; drop table node"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		req := events.APIGatewayProxyRequest{QueryStringParameters: tc.params}
+		results, err := GetListParamsFromRequest(req, sortFields, "name")
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error, but got %+v", tc.name, results)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.name, err.Error())
+			continue
+		}
+
+		if results != tc.expected {
+			t.Errorf("%s: bad results. Expected %+v, but got %+v", tc.name, tc.expected, results)
+		}
+	}
+}
+
+func TestPageInfo_GetHeaders(t *testing.T) {
+	headers := PageInfo{Total: 25, Limit: 10, Offset: 10}.GetHeaders()
+	if headers[ListRespHeaderTotalCount] != "25" || headers[ListRespHeaderNextOffset] != "20" {
+		t.Errorf("Bad headers for a middle page. Got %+v", headers)
+	}
+
+	headers = PageInfo{Total: 25, Limit: 10, Offset: 20}.GetHeaders()
+	if _, ok := headers[ListRespHeaderNextOffset]; ok {
+		t.Errorf("Did not expect a next offset for the last page. Got %+v", headers)
+	}
+
+	headers = PageInfo{Total: 25}.GetHeaders()
+	if _, ok := headers[ListRespHeaderNextOffset]; ok || headers[ListRespHeaderTotalCount] != "25" {
+		t.Errorf("Did not expect a next offset for a list that wasn't paged. Got %+v", headers)
+	}
+
+	if !strings.Contains(headers[CORSHeaderExposeHeaders], ListRespHeaderNextOffset) {
+		t.Errorf("Expected the paging headers to be exposed to browsers. Got %+v", headers)
+	}
+}
+
+func TestTaskLogSpeedTest_Validate(t *testing.T) {
+	now := int64(1531246102)
+
+	testCases := []struct {
+		name          string
+		entry         TaskLogSpeedTest
+		expectError   bool
+		expectFlagged bool
+	}{
+		{"valid", TaskLogSpeedTest{Timestamp: now, Download: 50, Upload: 10}, false, false},
+		{"missing timestamp", TaskLogSpeedTest{Download: 50, Upload: 10}, true, false},
+		{"future timestamp", TaskLogSpeedTest{Timestamp: now + 3600, Download: 50, Upload: 10}, true, false},
+		{"slight clock skew", TaskLogSpeedTest{Timestamp: now + 60, Download: 50, Upload: 10}, false, false},
+		{"negative speed", TaskLogSpeedTest{Timestamp: now, Download: -1, Upload: 10}, true, false},
+		{"impossible speed", TaskLogSpeedTest{Timestamp: now, Download: TaskLogMaxSpeed + 1}, true, false},
+		{"suspicious speed", TaskLogSpeedTest{Timestamp: now, Download: TaskLogSuspiciousSpeed + 1}, false, true},
+	}
+
+	for _, tc := range testCases {
+		err := tc.entry.Validate(now)
+		if (err != nil) != tc.expectError {
+			t.Errorf("%s: expected error to be %v, but got %v", tc.name, tc.expectError, err)
+		}
+		if tc.entry.Flagged != tc.expectFlagged {
+			t.Errorf("%s: expected flagged to be %v, but got %v", tc.name, tc.expectFlagged, tc.entry.Flagged)
+		}
+	}
+}
+
+func TestTaskLogPingTest_Validate(t *testing.T) {
+	now := int64(1531246102)
+
+	testCases := []struct {
+		name          string
+		entry         TaskLogPingTest
+		expectError   bool
+		expectFlagged bool
+	}{
+		{"valid", TaskLogPingTest{Timestamp: now, Latency: 20, PacketLossPercent: 1}, false, false},
+		{"zero latency", TaskLogPingTest{Timestamp: now, Latency: 0}, true, false},
+		{"zero latency with all packets lost", TaskLogPingTest{Timestamp: now, Latency: 0, PacketLossPercent: 100}, false, false},
+		{"too much packet loss", TaskLogPingTest{Timestamp: now, Latency: 20, PacketLossPercent: 101}, true, false},
+		{"impossible latency", TaskLogPingTest{Timestamp: now, Latency: TaskLogMaxLatency + 1}, true, false},
+		{"suspicious latency", TaskLogPingTest{Timestamp: now, Latency: TaskLogSuspiciousLatency + 1}, false, true},
+	}
+
+	for _, tc := range testCases {
+		err := tc.entry.Validate(now)
+		if (err != nil) != tc.expectError {
+			t.Errorf("%s: expected error to be %v, but got %v", tc.name, tc.expectError, err)
+		}
+		if tc.entry.Flagged != tc.expectFlagged {
+			t.Errorf("%s: expected flagged to be %v, but got %v", tc.name, tc.expectFlagged, tc.entry.Flagged)
+		}
+	}
+}
+
+func TestTaskLogNetworkDowntime_Validate(t *testing.T) {
+	now := int64(1531246102)
+
+	testCases := []struct {
+		name          string
+		entry         TaskLogNetworkDowntime
+		expectError   bool
+		expectFlagged bool
+	}{
+		{"valid", TaskLogNetworkDowntime{Timestamp: now, DowntimeSeconds: 300}, false, false},
+		{"negative downtime", TaskLogNetworkDowntime{Timestamp: now, DowntimeSeconds: -1}, true, false},
+		{"impossible downtime", TaskLogNetworkDowntime{Timestamp: now, DowntimeSeconds: TaskLogMaxDowntimeSeconds + 1}, true, false},
+		{"suspicious downtime", TaskLogNetworkDowntime{Timestamp: now, DowntimeSeconds: TaskLogSuspiciousDowntimeSeconds + 1}, false, true},
+	}
+
+	for _, tc := range testCases {
+		err := tc.entry.Validate(now)
+		if (err != nil) != tc.expectError {
+			t.Errorf("%s: expected error to be %v, but got %v", tc.name, tc.expectError, err)
+		}
+		if tc.entry.Flagged != tc.expectFlagged {
+			t.Errorf("%s: expected flagged to be %v, but got %v", tc.name, tc.expectFlagged, tc.entry.Flagged)
+		}
+	}
+}
+
+func TestCleanWorkingDays(t *testing.T) {
+	goodDays := map[string]string{
+		"":                    "",
+		" ":                   "",
+		"Mon,Tue,Wed,Thu,Fri": "Mon,Tue,Wed,Thu,Fri",
+		"fri, mon,WED":        "Mon,Wed,Fri",
+		"Sun,Sat,Sun":         "Sat,Sun",
+	}
+
+	for workingDays, expected := range goodDays {
+		result, err := CleanWorkingDays(workingDays)
+		if err != nil {
+			t.Errorf("Unexpected error for %q.\n%s", workingDays, err.Error())
+			continue
+		}
+
+		if result != expected {
+			t.Errorf("Bad result for %q. Expected %q, but got %q", workingDays, expected, result)
+		}
+	}
+
+	badDays := []string{"Monday", "Mon,,Tue", "Mon;Tue", "Xyz"}
+	for _, workingDays := range badDays {
+		_, err := CleanWorkingDays(workingDays)
+		if err == nil {
+			t.Errorf("Expected an error for %q, but didn't get one.", workingDays)
+		}
+	}
+}
+
+func TestIsWorkingDay(t *testing.T) {
+	if !IsWorkingDay("", time.Sunday) {
+		t.Error("Expected every day to be a working day when none are set")
+	}
+
+	if !IsWorkingDay("Mon,Tue,Wed,Thu,Fri", time.Wednesday) {
+		t.Error("Expected Wednesday to be a working day")
+	}
+
+	if IsWorkingDay("Mon,Tue,Wed,Thu,Fri", time.Saturday) {
+		t.Error("Expected Saturday not to be a working day")
+	}
+}
+
+func TestNode_GetWorkingDays(t *testing.T) {
+	node := Node{
+		Tags: []Tag{
+			{Model: gorm.Model{ID: 3}, WorkingDays: "Sat,Sun"},
+			{Model: gorm.Model{ID: 2}},
+			{Model: gorm.Model{ID: 1}, WorkingDays: "Mon,Tue,Wed,Thu,Fri"},
+		},
+	}
+
+	if node.GetWorkingDays() != "Mon,Tue,Wed,Thu,Fri" {
+		t.Errorf("Expected the working days of the tag with the lowest ID, got %q", node.GetWorkingDays())
+	}
+
+	node.WorkingDays = "Mon,Tue"
+	if node.GetWorkingDays() != "Mon,Tue" {
+		t.Errorf("Expected the node's own working days, got %q", node.GetWorkingDays())
+	}
+
+	node = Node{Tags: []Tag{{Model: gorm.Model{ID: 1}}}}
+	if node.GetWorkingDays() != "" {
+		t.Errorf("Expected no working days, got %q", node.GetWorkingDays())
+	}
+}
+
+func TestGetHelloChanges(t *testing.T) {
+	hello := HelloRequest{ID: "aa:aa:aa:aa:aa:aa", Version: "1.0.0", Uptime: 500}
+
+	changes := GetHelloChanges(Node{}, hello, "10.0.0.1")
+	if !reflect.DeepEqual(changes, []string{NodeChangeFirstHello}) {
+		t.Errorf("Expected a first hello for a new node, got %v", changes)
+	}
+
+	node := Node{
+		Model:          gorm.Model{ID: 1},
+		IPAddress:      "10.0.0.1",
+		RunningVersion: Version{Number: "1.0.0"},
+		Uptime:         400,
+	}
+
+	changes = GetHelloChanges(node, hello, "10.0.0.1")
+	if len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+
+	hello.Version = "1.1.0"
+	hello.Uptime = 20
+	changes = GetHelloChanges(node, hello, "10.0.0.2")
+	expected := []string{NodeChangeIPAddress, NodeChangeVersion, NodeChangeUptimeReset}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %v, got %v", expected, changes)
+	}
+}
+
+func TestCleanRolloutWaves(t *testing.T) {
+	fixtures := []struct {
+		waves     string
+		want      string
+		wantError bool
+	}{
+		{waves: "", want: DefaultRolloutWaves},
+		{waves: " 5, 25,100", want: "5,25,100"},
+		{waves: "100", want: "100"},
+		{waves: "50,10,100", wantError: true},
+		{waves: "10,50", wantError: true},
+		{waves: "10,50,150", wantError: true},
+		{waves: "ten,100", wantError: true},
+	}
+
+	for _, fix := range fixtures {
+		got, err := CleanRolloutWaves(fix.waves)
+		if fix.wantError {
+			if err == nil {
+				t.Errorf("Expected an error for waves %q", fix.waves)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error for waves %q: %s", fix.waves, err.Error())
+		} else if got != fix.want {
+			t.Errorf("Expected %q for waves %q, got %q", fix.want, fix.waves, got)
+		}
+	}
+}
+
+func TestRollout_IsNodeIncluded(t *testing.T) {
+	canaryTag := Tag{Model: gorm.Model{ID: 5}}
+	rollout := Rollout{
+		VersionID:  2,
+		CanaryTags: []Tag{canaryTag},
+		Waves:      "10,50,100",
+		Status:     RolloutStatusActive,
+	}
+
+	canary := Node{MacAddr: "aa:aa:aa:aa:aa:aa", Tags: []Tag{canaryTag}}
+	if !rollout.IsNodeIncluded(canary) {
+		t.Error("Expected a canary node to be included before the first wave")
+	}
+
+	// Each wave must include the nodes of the previous waves
+	included := map[string]bool{}
+	for wave := int64(0); wave <= 3; wave++ {
+		rollout.CurrentWave = wave
+		count := 0
+		for i := 0; i < 200; i++ {
+			node := Node{MacAddr: fmt.Sprintf("aa:aa:aa:aa:%02x:%02x", i/256, i%256)}
+			if rollout.IsNodeIncluded(node) {
+				count++
+				included[node.MacAddr] = true
+			} else if included[node.MacAddr] {
+				t.Errorf("Node %s was included in an earlier wave, but not in wave %v", node.MacAddr, wave)
+			}
+		}
+
+		if wave == 0 && count != 0 {
+			t.Errorf("Expected only canaries before the first wave, got %v nodes", count)
+		}
+		if wave == 3 && count != 200 {
+			t.Errorf("Expected every node in the last wave, got %v nodes", count)
+		}
+	}
+}
+
+func TestCleanVersionBuilds(t *testing.T) {
+	checksum := "E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855"
+	build := VersionBuild{OS: " Linux", Arch: "AMD64", URL: "https://example.com/linux/amd64/speedsnitch", SHA256: checksum, Size: 1024}
+
+	builds, err := CleanVersionBuilds([]VersionBuild{build})
+	if err != nil {
+		t.Errorf("Unexpected error cleaning builds: %s", err.Error())
+		return
+	}
+
+	if builds[0].OS != "linux" || builds[0].Arch != "amd64" || builds[0].SHA256 != strings.ToLower(checksum) {
+		t.Errorf("Build not cleaned as expected. Got %+v", builds[0])
+	}
+
+	badBuilds := map[string][]VersionBuild{
+		"a duplicate platform": {build, build},
+		"a missing arch":       {{OS: "linux", URL: build.URL, SHA256: checksum, Size: 1024}},
+		"a bad URL":            {{OS: "linux", Arch: "amd64", URL: "speedsnitch", SHA256: checksum, Size: 1024}},
+		"a short checksum":     {{OS: "linux", Arch: "amd64", URL: build.URL, SHA256: "e3b0c442", Size: 1024}},
+		"a missing size":       {{OS: "linux", Arch: "amd64", URL: build.URL, SHA256: checksum}},
+	}
+
+	for name, bad := range badBuilds {
+		_, err := CleanVersionBuilds(bad)
+		if err == nil {
+			t.Errorf("Expected an error for builds with %s", name)
+		}
+	}
+}
+
+func TestVersion_GetBuild(t *testing.T) {
+	version := Version{
+		Builds: []VersionBuild{
+			{OS: "linux", Arch: "amd64", URL: "https://example.com/linux/amd64/speedsnitch"},
+			{OS: "linux", Arch: "arm", URL: "https://example.com/linux/arm/speedsnitch"},
+		},
+	}
+
+	build, ok := version.GetBuild("Linux", "arm")
+	if !ok || build.URL != "https://example.com/linux/arm/speedsnitch" {
+		t.Errorf("Expected the linux/arm build, got %+v", build)
+	}
+
+	_, ok = version.GetBuild("windows", "amd64")
+	if ok {
+		t.Error("Expected no build for windows/amd64")
+	}
+}
+
+func TestVersion_GetDownload(t *testing.T) {
+	os.Setenv("downloadBaseUrl", "https://download.example.com")
+	defer os.Unsetenv("downloadBaseUrl")
+
+	withBuilds := Version{
+		Number: "2.0.0",
+		Builds: []VersionBuild{{OS: "linux", Arch: "amd64", URL: "https://example.com/linux/amd64/speedsnitch"}},
+	}
+
+	download, ok := withBuilds.GetDownload("linux", "amd64")
+	if !ok || download.URL != "https://example.com/linux/amd64/speedsnitch" {
+		t.Errorf("Expected the linux/amd64 build, got %+v", download)
+	}
+
+	_, ok = withBuilds.GetDownload("windows", "amd64")
+	if ok {
+		t.Error("Expected no download for a platform that isn't among a version's builds")
+	}
+
+	withoutBuilds := Version{Number: "1.0.0"}
+	download, ok = withoutBuilds.GetDownload("Windows", "AMD64")
+	if !ok || download.URL != "https://download.example.com/1.0.0/windows/amd64/speedsnitch.exe" {
+		t.Errorf("Expected a download under the base URL, got %+v", download)
+	}
+
+	latest := Version{Number: VersionNumberLatest}
+	_, ok = latest.GetDownload("linux", "amd64")
+	if ok {
+		t.Error("Expected no download for the latest version placeholder")
+	}
+
+	os.Unsetenv("downloadBaseUrl")
+	_, ok = withoutBuilds.GetDownload("linux", "amd64")
+	if ok {
+		t.Error("Expected no download without a download base URL")
+	}
+}
+
+func TestVersion_IsSupported(t *testing.T) {
+	fixtures := []struct {
+		version Version
+		minimum string
+		want    bool
+	}{
+		{version: Version{Number: "1.0.0", Status: VersionStatusStable}, minimum: "", want: true},
+		{version: Version{Number: "1.0.0", Status: VersionStatusDeprecated}, minimum: "1.0.0", want: true},
+		{version: Version{Number: "1.2.0", Status: VersionStatusBeta}, minimum: "1.1.5", want: true},
+		{version: Version{Number: "1.1.0", Status: VersionStatusStable}, minimum: "1.1.5", want: false},
+		{version: Version{Number: "2.0.0", Status: VersionStatusRevoked}, minimum: "", want: false},
+	}
+
+	for _, fix := range fixtures {
+		got := fix.version.IsSupported(fix.minimum)
+		if got != fix.want {
+			t.Errorf("Expected %v for %s version %s with minimum %q, got %v",
+				fix.want, fix.version.Status, fix.version.Number, fix.minimum, got)
+		}
+	}
+}
+
+func TestBuildVersionSummaries(t *testing.T) {
+	now := time.Date(2018, 6, 10, 12, 0, 0, 0, time.UTC)
+	recently := now.Add(-time.Hour)
+	longAgo := now.Add(-48 * time.Hour)
+
+	linuxBuilds := []VersionBuild{{OS: "linux", Arch: "amd64", URL: "https://example.com/linux/amd64/speedsnitch"}}
+	version1 := Version{Model: gorm.Model{ID: 1, UpdatedAt: longAgo}, Number: "1.0.0", Status: VersionStatusDeprecated, Builds: linuxBuilds}
+	version2 := Version{Model: gorm.Model{ID: 2, UpdatedAt: longAgo}, Number: "2.0.0", Status: VersionStatusStable, Builds: linuxBuilds}
+	version3 := Version{Model: gorm.Model{ID: 3, UpdatedAt: recently}, Number: "3.0.0", Status: VersionStatusStable, Builds: linuxBuilds}
+	latest := Version{Model: gorm.Model{ID: 4}, Number: VersionNumberLatest}
+	versions := []Version{version1, version2, version3, latest}
+
+	// The rollout of version3 hasn't reached any nodes yet
+	rollouts := []Rollout{{Model: gorm.Model{UpdatedAt: recently}, VersionID: version3.ID, Waves: "100"}}
+
+	nodes := []Node{
+		// Set to the latest version, so should stay on the version they are running until the rollout reaches them
+		{MacAddr: "aa:aa:aa:aa:aa:01", OS: "linux", Arch: "amd64", RunningVersionID: 2, RunningVersion: version2},
+		{MacAddr: "aa:aa:aa:aa:aa:02", OS: "linux", Arch: "amd64", RunningVersionID: 1, RunningVersion: version1,
+			ConfiguredVersionID: 4, ConfiguredVersion: latest},
+		{RunningVersionID: 2, ConfiguredVersionID: 2, ConfiguredVersion: version2, ConfiguredVersionAt: longAgo.Format(time.RFC3339)},
+		{RunningVersionID: 1, ConfiguredVersionID: 2, ConfiguredVersion: version2, ConfiguredVersionAt: recently.Format(time.RFC3339)},
+		{RunningVersionID: 1, ConfiguredVersionID: 2, ConfiguredVersion: version2}, // Configured before that was tracked
+	}
+
+	want := []VersionSummary{
+		{VersionID: 1, Number: "1.0.0", Status: VersionStatusDeprecated, ConfiguredNodes: 1, RunningNodes: 3},
+		{VersionID: 2, Number: "2.0.0", Status: VersionStatusStable, ConfiguredNodes: 4, RunningNodes: 2, ConvergenceFailedNodes: 1},
+		{VersionID: 3, Number: "3.0.0", Status: VersionStatusStable},
+		{VersionID: 4, Number: VersionNumberLatest},
+	}
+
+	got, err := BuildVersionSummaries(versions, nodes, rollouts, now, DefaultConvergenceHours)
+	if err != nil {
+		t.Errorf("Unexpected error building version summaries: %s", err.Error())
+		return
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong version summaries.\nExpected %+v\nbut got  %+v", want, got)
+	}
+
+	// Once the rollout is completed, the nodes set to the latest version should be running version3,
+	// but haven't had long enough to start running it yet
+	rollouts[0].Status = RolloutStatusCompleted
+	want[0].ConfiguredNodes = 0
+	want[1].ConfiguredNodes = 3
+	want[2].ConfiguredNodes = 2
+
+	got, err = BuildVersionSummaries(versions, nodes, rollouts, now, DefaultConvergenceHours)
+	if err != nil {
+		t.Errorf("Unexpected error building version summaries: %s", err.Error())
+		return
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong version summaries after the rollout.\nExpected %+v\nbut got  %+v", want, got)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	fixtures := map[string]bool{
+		"8.8.8.8":         true,
+		"2001:4860::8888": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+	}
+
+	for address, want := range fixtures {
+		if got := IsPublicIP(net.ParseIP(address)); got != want {
+			t.Errorf("Expected %v for %s, got %v", want, address, got)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package alerting
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"os"
+	"time"
+)
+
+const SecondsPerHour = 3600
+const HoursPerDay = 24
+
+// EvaluateAlertRules checks every rule against each node it applies to, opening new alerts for breached
+// rules and resolving firing alerts that are no longer breached.
+// Returns the alerts that were opened or resolved
+func EvaluateAlertRules(now time.Time) ([]domain.Alert, error) {
+	var rules []domain.AlertRule
+	err := db.ListItems(&rules, "id asc")
+	if err != nil {
+		return []domain.Alert{}, err
+	}
+
+	changedAlerts := []domain.Alert{}
+
+	for _, rule := range rules {
+		nodes, err := GetNodesForAlertRule(rule)
+		if err != nil {
+			return changedAlerts, err
+		}
+
+		for _, node := range nodes {
+			alert, changed, err := EvaluateAlertRuleForNode(rule, node, now)
+			if err != nil {
+				fmt.Fprintf(os.Stdout, "error evaluating alert rule %v - %s for node %v. err: %s", rule.ID, rule.Name, node.ID, err.Error())
+				return changedAlerts, err
+			}
+
+			if changed {
+				changedAlerts = append(changedAlerts, alert)
+			}
+		}
+	}
+
+	return changedAlerts, nil
+}
+
+// EvaluateAlertRuleForNode opens or resolves the alert for the rule and node as needed.
+// Nodes without any data for the rule's window are left as they are.
+// Returns the alert along with true if its state changed and an error if one is present
+func EvaluateAlertRuleForNode(rule domain.AlertRule, node domain.Node, now time.Time) (domain.Alert, bool, error) {
+	windowHours := rule.WindowHours
+	if windowHours < 1 {
+		windowHours = domain.DefaultAlertRuleWindowHours
+	}
+
+	endTime := now.Unix()
+	startTime := endTime - windowHours*SecondsPerHour
+
+	value, hasData, err := GetMetricValue(rule.Metric, node.ID, startTime, endTime)
+	if err != nil || !hasData {
+		return domain.Alert{}, false, err
+	}
+
+	alert, err := db.GetFiringAlert(rule.ID, node.ID)
+	if !gorm.IsRecordNotFoundError(err) && err != nil {
+		return domain.Alert{}, false, err
+	}
+
+	isBreached := rule.IsBreached(value)
+
+	// Nothing has changed
+	if isBreached == (alert.ID != 0) {
+		return alert, false, nil
+	}
+
+	alert.Value = value
+
+	if isBreached {
+		alert.AlertRuleID = rule.ID
+		alert.NodeID = node.ID
+		alert.State = domain.AlertStateFiring
+		alert.FiredAt = endTime
+		alert.Message = GetAlertMessage(rule, node, value, windowHours)
+	} else {
+		alert.State = domain.AlertStateResolved
+		alert.ResolvedAt = endTime
+	}
+
+	err = db.PutItem(&alert)
+	if err != nil {
+		return domain.Alert{}, false, err
+	}
+
+	return alert, true, nil
+}
+
+// GetMetricValue computes the value of the metric from the node's task logs in the given range, leaving out the
+// ones that were flagged as suspicious. The outage count is per day, so the threshold means the same for any window.
+// Returns the value, whether there was any data to base it on, and an error if one is present
+func GetMetricValue(metric string, nodeID uint, startTime, endTime int64) (float64, bool, error) {
+	switch metric {
+	case domain.AlertRuleMetricDownloadAvg, domain.AlertRuleMetricUploadAvg:
+		var speedLogs []domain.TaskLogSpeedTest
+		err := db.GetUnflaggedTaskLogForRange(&speedLogs, nodeID, startTime, endTime)
+		if err != nil || len(speedLogs) == 0 {
+			return 0, false, err
+		}
+
+		total := 0.0
+		for _, s := range speedLogs {
+			if metric == domain.AlertRuleMetricDownloadAvg {
+				total += s.Download
+			} else {
+				total += s.Upload
+			}
+		}
+		return total / float64(len(speedLogs)), true, nil
+
+	case domain.AlertRuleMetricLatencyAvg, domain.AlertRuleMetricPacketLossAvg:
+		var pingLogs []domain.TaskLogPingTest
+		err := db.GetUnflaggedTaskLogForRange(&pingLogs, nodeID, startTime, endTime)
+		if err != nil || len(pingLogs) == 0 {
+			return 0, false, err
+		}
+
+		total := 0.0
+		for _, p := range pingLogs {
+			if metric == domain.AlertRuleMetricLatencyAvg {
+				total += p.Latency
+			} else {
+				total += p.PacketLossPercent
+			}
+		}
+		return total / float64(len(pingLogs)), true, nil
+
+	case domain.AlertRuleMetricOutageCount:
+		var outages []domain.TaskLogNetworkDowntime
+		err := db.GetUnflaggedTaskLogForRange(&outages, nodeID, startTime, endTime)
+		if err != nil {
+			return 0, false, err
+		}
+
+		rangeHours := float64(endTime-startTime) / SecondsPerHour
+		if rangeHours <= 0 {
+			return 0, false, nil
+		}
+		return float64(len(outages)) * HoursPerDay / rangeHours, true, nil
+	}
+
+	return 0, false, fmt.Errorf("Invalid alert rule metric: %s", metric)
+}
+
+// GetNodesForAlertRule returns the approved nodes that the rule applies to
+func GetNodesForAlertRule(rule domain.AlertRule) ([]domain.Node, error) {
+	var nodes []domain.Node
+
+	if rule.NodeID != 0 {
+		var node domain.Node
+		err := db.GetItem(&node, rule.NodeID)
+		if err != nil {
+			return []domain.Node{}, err
+		}
+		nodes = []domain.Node{node}
+	} else if rule.TagID != 0 {
+		var tag domain.Tag
+		err := db.GetItem(&tag, rule.TagID)
+		if err != nil {
+			return []domain.Node{}, err
+		}
+		nodes = tag.Nodes
+	} else {
+		err := db.ListNodesByStatus(&nodes, domain.NodeStatusApproved, "id asc")
+		return nodes, err
+	}
+
+	approvedNodes := []domain.Node{}
+	for _, node := range nodes {
+		if node.IsApproved() {
+			approvedNodes = append(approvedNodes, node)
+		}
+	}
+
+	return approvedNodes, nil
+}
+
+func GetAlertMessage(rule domain.AlertRule, node domain.Node, value float64, windowHours int64) string {
+	nodeName := node.Nickname
+	if nodeName == "" {
+		nodeName = node.MacAddr
+	}
+
+	return fmt.Sprintf(
+		"%s: %s was %.2f, which is %s the threshold of %.2f, over the last %d hour(s) (rule: %s)",
+		nodeName,
+		rule.Metric,
+		value,
+		rule.Comparison,
+		rule.Threshold,
+		windowHours,
+		rule.Name,
+	)
+}
@@ -0,0 +1,297 @@
+package alerting
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/notify"
+	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvaluateAlertRuleForNode(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node := domain.Node{MacAddr: "aa:aa:aa:aa:aa:aa", Nickname: "test node"}
+	err := db.PutItem(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rule := domain.AlertRule{
+		Name:        "Slow Download",
+		Metric:      domain.AlertRuleMetricDownloadAvg,
+		Comparison:  domain.AlertRuleComparisonBelow,
+		Threshold:   10,
+		WindowHours: 1,
+	}
+	err = db.PutItem(&rule)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	now := time.Date(2018, 6, 26, 12, 0, 0, 0, time.UTC)
+
+	// No data, so no alert
+	_, changed, err := EvaluateAlertRuleForNode(rule, node, now)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if changed {
+		t.Error("Expected no alert change without any data")
+		return
+	}
+
+	slowTest := domain.TaskLogSpeedTest{
+		NodeID:    node.ID,
+		Timestamp: now.Unix() - 600,
+		Download:  5,
+		Upload:    5,
+	}
+	err = db.PutItem(&slowTest)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Breached, so an alert fires
+	alert, changed, err := EvaluateAlertRuleForNode(rule, node, now)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !changed || alert.State != domain.AlertStateFiring || alert.Value != 5 {
+		t.Errorf("Expected a firing alert with value 5, but got changed: %v, %+v", changed, alert)
+		return
+	}
+
+	// Still breached, so nothing changes
+	_, changed, err = EvaluateAlertRuleForNode(rule, node, now)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if changed {
+		t.Error("Expected no alert change while the rule is still breached")
+		return
+	}
+
+	fastTest := domain.TaskLogSpeedTest{
+		NodeID:    node.ID,
+		Timestamp: now.Unix() - 300,
+		Download:  25,
+		Upload:    25,
+	}
+	err = db.PutItem(&fastTest)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The average is now 15, so the alert resolves
+	resolved, changed, err := EvaluateAlertRuleForNode(rule, node, now)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !changed || resolved.ID != alert.ID || resolved.State != domain.AlertStateResolved || resolved.ResolvedAt != now.Unix() {
+		t.Errorf("Expected alert %v to be resolved, but got changed: %v, %+v", alert.ID, changed, resolved)
+		return
+	}
+
+	firingAlerts, err := db.ListAlerts(domain.AlertStateFiring)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(firingAlerts) != 0 {
+		t.Errorf("Expected no firing alerts, but got %+v", firingAlerts)
+	}
+}
+
+func TestGetMetricValue(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node := domain.Node{MacAddr: "aa:aa:aa:aa:aa:aa"}
+	err := db.PutItem(&node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	endTime := time.Date(2018, 6, 26, 12, 0, 0, 0, time.UTC).Unix()
+	startTime := endTime - 48*SecondsPerHour
+
+	logs := []interface{}{
+		&domain.TaskLogNetworkDowntime{NodeID: node.ID, Timestamp: endTime - 40*SecondsPerHour, DowntimeStart: "a"},
+		&domain.TaskLogNetworkDowntime{NodeID: node.ID, Timestamp: endTime - 30*SecondsPerHour, DowntimeStart: "b"},
+		&domain.TaskLogNetworkDowntime{NodeID: node.ID, Timestamp: endTime - 20*SecondsPerHour, DowntimeStart: "c"},
+		&domain.TaskLogNetworkDowntime{NodeID: node.ID, Timestamp: endTime - 10*SecondsPerHour, DowntimeStart: "d"},
+		&domain.TaskLogNetworkDowntime{NodeID: node.ID, Timestamp: endTime - 5*SecondsPerHour, DowntimeStart: "e", Flagged: true},
+		&domain.TaskLogSpeedTest{NodeID: node.ID, Timestamp: endTime - 600, Download: 10},
+		&domain.TaskLogSpeedTest{NodeID: node.ID, Timestamp: endTime - 300, Download: 1000, Flagged: true},
+	}
+	for _, log := range logs {
+		err := db.PutItem(log)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Four outages in two days, not counting the flagged one
+	value, hasData, err := GetMetricValue(domain.AlertRuleMetricOutageCount, node.ID, startTime, endTime)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !hasData || value != 2 {
+		t.Errorf("Expected 2 outages per day, but got %v (has data: %v)", value, hasData)
+	}
+
+	value, hasData, err = GetMetricValue(domain.AlertRuleMetricDownloadAvg, node.ID, startTime, endTime)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !hasData || value != 10 {
+		t.Errorf("Expected the flagged speed test to be left out of the average, but got %v (has data: %v)", value, hasData)
+	}
+}
+
+func TestGetNotificationsForUser(t *testing.T) {
+	tag1 := domain.Tag{Model: gorm.Model{ID: 1}, Name: "tag1"}
+	tag2 := domain.Tag{Model: gorm.Model{ID: 2}, Name: "tag2"}
+
+	node1 := domain.Node{Model: gorm.Model{ID: 1}, Tags: []domain.Tag{tag1}}
+	node2 := domain.Node{Model: gorm.Model{ID: 2}, Tags: []domain.Tag{tag2}}
+	node3 := domain.Node{Model: gorm.Model{ID: 3}}
+
+	notifications := []Notification{
+		{Node: node1, Message: "node1"},
+		{Node: node2, Message: "node2"},
+		{Node: node3, Message: "node3"},
+	}
+
+	testCases := []struct {
+		name     string
+		user     domain.User
+		expected []string
+	}{
+		{
+			name:     "superAdmin",
+			user:     domain.User{Role: domain.UserRoleSuperAdmin},
+			expected: []string{"node1", "node2", "node3"},
+		},
+		{
+			name:     "admin with tag1",
+			user:     domain.User{Role: domain.UserRoleAdmin, Tags: []domain.Tag{tag1}},
+			expected: []string{"node1"},
+		},
+		{
+			name:     "admin with digest and both tags",
+			user:     domain.User{Role: domain.UserRoleAdmin, Tags: []domain.Tag{tag1, tag2}, NotificationMode: domain.UserNotificationsDigest},
+			expected: []string{"node1", "node2"},
+		},
+		{
+			name:     "admin without tags",
+			user:     domain.User{Role: domain.UserRoleAdmin},
+			expected: []string{},
+		},
+		{
+			name:     "superAdmin opted out",
+			user:     domain.User{Role: domain.UserRoleSuperAdmin, NotificationMode: domain.UserNotificationsOff},
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		results := GetNotificationsForUser(tc.user, notifications)
+		if len(results) != len(tc.expected) {
+			t.Errorf("%s: wrong number of notifications. Expected %v, but got %+v", tc.name, tc.expected, results)
+			continue
+		}
+
+		for i, msg := range tc.expected {
+			if results[i].Message != msg {
+				t.Errorf("%s: wrong notification. Expected %s, but got %s", tc.name, msg, results[i].Message)
+			}
+		}
+	}
+}
+
+type fakeNotifier struct {
+	channel  domain.NotificationChannel
+	messages *map[string]notify.Message
+}
+
+func (f *fakeNotifier) Notify(msg notify.Message) error {
+	if f.channel.Target == "fail@example.com" {
+		return fmt.Errorf("failed to send")
+	}
+	(*f.messages)[f.channel.Target] = msg
+	return nil
+}
+
+func TestDispatcher_Send(t *testing.T) {
+	tag1 := domain.Tag{Model: gorm.Model{ID: 1}, Name: "tag1"}
+	tag2 := domain.Tag{Model: gorm.Model{ID: 2}, Name: "tag2"}
+
+	notifications := []Notification{
+		{Node: domain.Node{Model: gorm.Model{ID: 1}, Tags: []domain.Tag{tag1}}, Message: "node1"},
+		{Node: domain.Node{Model: gorm.Model{ID: 2}, Tags: []domain.Tag{tag2}}, Message: "node2"},
+	}
+
+	users := []domain.User{
+		{Email: "super@example.com", Role: domain.UserRoleSuperAdmin},
+		{Email: "tag2@example.com", Role: domain.UserRoleAdmin, Tags: []domain.Tag{tag2}},
+		{Email: "none@example.com", Role: domain.UserRoleAdmin},
+		{Email: "fail@example.com", Role: domain.UserRoleSuperAdmin},
+	}
+
+	channels := []domain.NotificationChannel{
+		{Name: "all", Type: domain.NotificationChannelTypeSlack, Target: "https://slack.example.com"},
+		{Name: "tag1", Type: domain.NotificationChannelTypeWebhook, Target: "https://hook.example.com", TagID: tag1.ID},
+	}
+
+	messages := map[string]notify.Message{}
+	dispatcher := Dispatcher{
+		GetNotifier: func(channel domain.NotificationChannel) (notify.Notifier, error) {
+			return &fakeNotifier{channel: channel, messages: &messages}, nil
+		},
+	}
+
+	sentCount, err := dispatcher.Send("Subject", "Intro", notifications, users, channels)
+	if err == nil {
+		t.Error("Expected an error for the failed delivery")
+	}
+
+	if sentCount != 4 {
+		t.Errorf("Wrong number of messages sent. Expected 4, but got %v", sentCount)
+	}
+
+	expected := map[string][]string{
+		"super@example.com":         {"node1", "node2"},
+		"tag2@example.com":          {"node2"},
+		"https://slack.example.com": {"node1", "node2"},
+		"https://hook.example.com":  {"node1"},
+	}
+
+	if len(messages) != len(expected) {
+		t.Errorf("Wrong messages sent. Expected %v, but got %+v", expected, messages)
+		return
+	}
+
+	for target, lines := range expected {
+		msg := messages[target]
+		if msg.Subject != "Subject" || msg.Intro != "Intro" || strings.Join(msg.Lines, ",") != strings.Join(lines, ",") {
+			t.Errorf("Wrong message sent to %s. Expected lines %v, but got %+v", target, lines, msg)
+		}
+	}
+}
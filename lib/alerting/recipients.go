@@ -0,0 +1,153 @@
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/notify"
+	"strings"
+)
+
+// Notification is one line of an alert message, about a single node
+type Notification struct {
+	Node    domain.Node
+	Message string
+}
+
+// ListUsersByNotificationMode returns the users who want their notifications sent in the given mode
+func ListUsersByNotificationMode(mode string) ([]domain.User, error) {
+	var users []domain.User
+	err := db.ListItems(&users, "id asc")
+	if err != nil {
+		return []domain.User{}, err
+	}
+
+	modeUsers := []domain.User{}
+	for _, user := range users {
+		if user.GetNotificationMode() == mode {
+			modeUsers = append(modeUsers, user)
+		}
+	}
+
+	return modeUsers, nil
+}
+
+// GetNotificationsForUser returns the notifications about nodes that the user can use, which
+// means superAdmins get all of them and other users only get the ones for nodes that share one of their tags
+func GetNotificationsForUser(user domain.User, notifications []Notification) []Notification {
+	userNotifications := []Notification{}
+	if user.GetNotificationMode() == domain.UserNotificationsOff {
+		return userNotifications
+	}
+
+	for _, n := range notifications {
+		if domain.CanUserUseNode(user, n.Node) {
+			userNotifications = append(userNotifications, n)
+		}
+	}
+
+	return userNotifications
+}
+
+// ListChannelsByNotificationMode returns the notification channels that get their notifications sent in the given mode
+func ListChannelsByNotificationMode(mode string) ([]domain.NotificationChannel, error) {
+	var channels []domain.NotificationChannel
+	err := db.ListItems(&channels, "id asc")
+	if err != nil {
+		return []domain.NotificationChannel{}, err
+	}
+
+	modeChannels := []domain.NotificationChannel{}
+	for _, channel := range channels {
+		if channel.NotificationMode == mode {
+			modeChannels = append(modeChannels, channel)
+		}
+	}
+
+	return modeChannels, nil
+}
+
+// GetNotificationsForChannel returns the notifications about nodes that have the channel's tag,
+// or all of them if the channel doesn't have a tag
+func GetNotificationsForChannel(channel domain.NotificationChannel, notifications []Notification) []Notification {
+	if channel.TagID == 0 {
+		return notifications
+	}
+
+	channelNotifications := []Notification{}
+	for _, n := range notifications {
+		if domain.DoTagsOverlap([]domain.Tag{{Model: gorm.Model{ID: channel.TagID}}}, n.Node.Tags) {
+			channelNotifications = append(channelNotifications, n)
+		}
+	}
+
+	return channelNotifications
+}
+
+// Dispatcher fans notifications out to the users' email addresses and to the notification channels
+type Dispatcher struct {
+	// GetNotifier returns the notifier for a channel. Users are treated as email channels.
+	GetNotifier func(channel domain.NotificationChannel) (notify.Notifier, error)
+}
+
+// NewDispatcher returns a Dispatcher that delivers through SES and the webhook notifiers
+func NewDispatcher(sesConfig notify.SESConfig) Dispatcher {
+	return Dispatcher{
+		GetNotifier: func(channel domain.NotificationChannel) (notify.Notifier, error) {
+			return notify.NewNotifier(channel, sesConfig)
+		},
+	}
+}
+
+// Send delivers a message to each user and channel with the notifications that apply to them,
+// skipping the ones that none of the notifications apply to.
+// Returns the number of messages sent and an error listing the ones that failed
+func (d Dispatcher) Send(subject, intro string, notifications []Notification, users []domain.User, channels []domain.NotificationChannel) (int, error) {
+	type delivery struct {
+		channel       domain.NotificationChannel
+		notifications []Notification
+	}
+
+	deliveries := []delivery{}
+	for _, user := range users {
+		channel := domain.NotificationChannel{Name: user.Email, Type: domain.NotificationChannelTypeEmail, Target: user.Email}
+		deliveries = append(deliveries, delivery{channel, GetNotificationsForUser(user, notifications)})
+	}
+
+	for _, channel := range channels {
+		deliveries = append(deliveries, delivery{channel, GetNotificationsForChannel(channel, notifications)})
+	}
+
+	sentCount := 0
+	errMsgs := []string{}
+
+	for _, dl := range deliveries {
+		if len(dl.notifications) < 1 {
+			continue
+		}
+
+		msg := notify.Message{Subject: subject, Intro: intro}
+		for _, n := range dl.notifications {
+			msg.Lines = append(msg.Lines, n.Message)
+		}
+
+		notifier, err := d.GetNotifier(dl.channel)
+		if err == nil {
+			err = notifier.Notify(msg)
+		}
+
+		if err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("Error sending %s to %s: %s", subject, dl.channel.Name, err.Error()))
+			continue
+		}
+		sentCount++
+	}
+
+	if len(errMsgs) > 0 {
+		return sentCount, errors.New(strings.Join(errMsgs, "\n"))
+	}
+
+	return sentCount, nil
+}
@@ -0,0 +1,369 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/reporting"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const KeyPrefix = "tasklogs"
+const FileExtension = ".jsonl.gz"
+
+// Store keeps the archive files
+type Store interface {
+	Put(key string, body []byte) error
+	Get(key string) ([]byte, error)
+}
+
+// S3Store keeps the archive files in an S3 bucket
+type S3Store struct {
+	Bucket string
+	client *s3.S3
+}
+
+func NewS3Store(awsRegion, bucket string) (*S3Store, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(awsRegion)})
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Store{Bucket: bucket, client: s3.New(sess)}, nil
+}
+
+func (s *S3Store) Put(key string, body []byte) error {
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket:          aws.String(s.Bucket),
+		Key:             aws.String(key),
+		Body:            bytes.NewReader(body),
+		ContentType:     aws.String("application/x-ndjson"),
+		ContentEncoding: aws.String("gzip"),
+	})
+	return err
+}
+
+func (s *S3Store) Get(key string) ([]byte, error) {
+	output, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+	defer output.Body.Close()
+
+	return io.ReadAll(output.Body)
+}
+
+// TaskLogTable describes one of the task log tables, or the node heartbeats table, that gets archived
+type TaskLogTable struct {
+	LogType string
+	Model   func() interface{} // Returns a pointer to an empty entry
+	List    func() interface{} // Returns a pointer to an empty slice of entries
+}
+
+func GetTaskLogTables() []TaskLogTable {
+	return []TaskLogTable{
+		{
+			LogType: domain.TaskTypeSpeedTest,
+			Model:   func() interface{} { return &domain.TaskLogSpeedTest{} },
+			List:    func() interface{} { return &[]domain.TaskLogSpeedTest{} },
+		},
+		{
+			LogType: domain.TaskTypePing,
+			Model:   func() interface{} { return &domain.TaskLogPingTest{} },
+			List:    func() interface{} { return &[]domain.TaskLogPingTest{} },
+		},
+		{
+			LogType: domain.LogTypeError,
+			Model:   func() interface{} { return &domain.TaskLogError{} },
+			List:    func() interface{} { return &[]domain.TaskLogError{} },
+		},
+		{
+			LogType: domain.LogTypeRestart,
+			Model:   func() interface{} { return &domain.TaskLogRestart{} },
+			List:    func() interface{} { return &[]domain.TaskLogRestart{} },
+		},
+		{
+			LogType: domain.LogTypeDowntime,
+			Model:   func() interface{} { return &domain.TaskLogNetworkDowntime{} },
+			List:    func() interface{} { return &[]domain.TaskLogNetworkDowntime{} },
+		},
+		{
+			LogType: domain.LogTypeHeartbeat,
+			Model:   func() interface{} { return &domain.NodeHeartbeat{} },
+			List:    func() interface{} { return &[]domain.NodeHeartbeat{} },
+		},
+	}
+}
+
+func GetTaskLogTable(logType string) (TaskLogTable, error) {
+	for _, table := range GetTaskLogTables() {
+		if table.LogType == logType {
+			return table, nil
+		}
+	}
+
+	return TaskLogTable{}, fmt.Errorf("Invalid log type: %s", logType)
+}
+
+// GetArchiveKey returns the key of the archive file for a node's entries of one log type for one day,
+// for example "tasklogs/speedTest/2018-07-10/node-1.jsonl.gz"
+func GetArchiveKey(logType string, date time.Time, nodeID uint) string {
+	return fmt.Sprintf("%s/%s/%s/node-%v%s", KeyPrefix, logType, date.Format(domain.DateLayout), nodeID, FileExtension)
+}
+
+// GetLogTypeFromArchiveKey returns the log type that an archive file with the given key holds
+func GetLogTypeFromArchiveKey(key string) (string, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 || parts[0] != KeyPrefix || !strings.HasSuffix(key, FileExtension) {
+		return "", fmt.Errorf("Invalid archive key: %s", key)
+	}
+
+	return parts[1], nil
+}
+
+// EncodeJSONLines returns the entries in the slice that list points to as gzip compressed JSON lines,
+// along with the number of entries
+func EncodeJSONLines(list interface{}) ([]byte, int, error) {
+	items := reflect.Indirect(reflect.ValueOf(list))
+	if items.Kind() != reflect.Slice {
+		return []byte{}, 0, fmt.Errorf("Expected a slice of entries, got %s", items.Kind())
+	}
+
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	encoder := json.NewEncoder(gz)
+
+	for i := 0; i < items.Len(); i++ {
+		err := encoder.Encode(items.Index(i).Interface())
+		if err != nil {
+			return []byte{}, 0, err
+		}
+	}
+
+	err := gz.Close()
+	if err != nil {
+		return []byte{}, 0, err
+	}
+
+	return b.Bytes(), items.Len(), nil
+}
+
+// DecodeJSONLines reads gzip compressed JSON lines, unmarshalling each line into a new entry from newItem
+func DecodeJSONLines(data []byte, newItem func() interface{}) ([]interface{}, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return []interface{}{}, err
+	}
+	defer gz.Close()
+
+	items := []interface{}{}
+	decoder := json.NewDecoder(gz)
+	for decoder.More() {
+		item := newItem()
+		err := decoder.Decode(item)
+		if err != nil {
+			return []interface{}{}, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+type Result struct {
+	Archived          int64
+	Deleted           int64
+	SkippedNoSnapshot int64
+}
+
+// ArchiveTaskLogs archives and then deletes the task log entries from the days before the cutoff date,
+// starting with the oldest day of each table and processing no more than maxDays days per table.
+// A node's days run from midnight to midnight in its own timezone, matching its daily snapshots, and
+// a node's entries for a day are only archived once the daily snapshot for that node and day exists.
+// If dryRun is true, nothing is archived or deleted, but the results show what would have been.
+func ArchiveTaskLogs(store Store, cutoff time.Time, maxDays int, dryRun bool) (Result, error) {
+	result := Result{}
+	cutoffDay := cutoff.UTC().Truncate(24 * time.Hour)
+	locations := map[uint]*time.Location{}
+
+	for _, table := range GetTaskLogTables() {
+		oldest, err := db.GetOldestTaskLogTimestamp(table.Model())
+		if err != nil {
+			return result, err
+		}
+
+		if oldest == 0 {
+			continue
+		}
+
+		// Start a day early, since the oldest entry may belong to the previous day in its node's timezone
+		day := time.Unix(oldest, 0).UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+		for i := 0; i < maxDays && day.Before(cutoffDay); i++ {
+			err := archiveTaskLogsForDate(store, table, day, locations, dryRun, &result)
+			if err != nil {
+				return result, err
+			}
+			day = day.AddDate(0, 0, 1)
+		}
+	}
+
+	return result, nil
+}
+
+// getNodeLocation returns the location for the node's timezone, looking it up only once per run.
+// Falls back to UTC if the node no longer exists.
+func getNodeLocation(nodeID uint, locations map[uint]*time.Location) (*time.Location, error) {
+	if location, ok := locations[nodeID]; ok {
+		return location, nil
+	}
+
+	var node domain.Node
+	err := db.GetItem(&node, nodeID)
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+
+	location := node.GetLocation()
+	locations[nodeID] = location
+	return location, nil
+}
+
+func archiveTaskLogsForDate(
+	store Store,
+	table TaskLogTable,
+	date time.Time,
+	locations map[uint]*time.Location,
+	dryRun bool,
+	result *Result,
+) error {
+	snapshotTime, _, err := reporting.GetStartEndTimestampsForDate(date, "", "")
+	if err != nil {
+		return err
+	}
+
+	// Nodes' days are in their own timezones, so look for entries from a day either side of the UTC day
+	nodeIDs, err := db.ListNodeIDsWithTaskLogsForRange(
+		table.Model(),
+		snapshotTime-domain.SecondsPerDay,
+		snapshotTime+2*domain.SecondsPerDay-1,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, nodeID := range nodeIDs {
+		location, err := getNodeLocation(nodeID, locations)
+		if err != nil {
+			return err
+		}
+
+		startTime, endTime, err := reporting.GetStartEndTimestampsForDateInLocation(date, "", "", location)
+		if err != nil {
+			return err
+		}
+
+		list := table.List()
+		err = db.GetTaskLogForRangeToArchive(list, nodeID, startTime, endTime)
+		if err != nil {
+			return err
+		}
+
+		if reflect.Indirect(reflect.ValueOf(list)).Len() == 0 {
+			continue
+		}
+
+		snapshot := domain.ReportingSnapshot{
+			Timestamp: snapshotTime,
+			NodeID:    nodeID,
+			Interval:  domain.ReportingIntervalDaily,
+		}
+		err = db.FindOne(&snapshot)
+		if gorm.IsRecordNotFoundError(err) {
+			log.Printf("Not archiving %s logs for node %v on %s, since it has no daily snapshot\n",
+				table.LogType, nodeID, date.Format(domain.DateLayout))
+			result.SkippedNoSnapshot++
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		data, count, err := EncodeJSONLines(list)
+		if err != nil {
+			return err
+		}
+
+		result.Archived += int64(count)
+		if dryRun {
+			continue
+		}
+
+		err = store.Put(GetArchiveKey(table.LogType, date, nodeID), data)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := db.DeleteTaskLogForRange(table.Model(), nodeID, startTime, endTime)
+		result.Deleted += deleted
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RestoreTaskLogs puts the entries from the archive file with the given key back into their task log table.
+// Entries keep their original IDs, so restoring the same file more than once doesn't duplicate them.
+// Returns the number of entries restored and the error for the first entry that couldn't be, if any
+func RestoreTaskLogs(store Store, key string) (int, error) {
+	logType, err := GetLogTypeFromArchiveKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	table, err := GetTaskLogTable(logType)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	items, err := DecodeJSONLines(data, table.Model)
+	if err != nil {
+		return 0, err
+	}
+
+	itemErrors, err := db.PutItemsInTransaction(items)
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	var firstErr error
+	for i, itemErr := range itemErrors {
+		if itemErr == nil {
+			restored++
+		} else if firstErr == nil {
+			firstErr = fmt.Errorf("Error restoring entry %v of %s: %s", i, key, itemErr.Error())
+		}
+	}
+
+	return restored, firstErr
+}
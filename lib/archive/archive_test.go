@@ -0,0 +1,83 @@
+package archive
+
+import (
+	"github.com/silinternational/speed-snitch-admin-api"
+	"testing"
+	"time"
+)
+
+func TestGetArchiveKey(t *testing.T) {
+	date := time.Date(2018, 7, 10, 0, 0, 0, 0, time.UTC)
+	key := GetArchiveKey(domain.TaskTypeSpeedTest, date, 3)
+
+	expected := "tasklogs/speedTest/2018-07-10/node-3.jsonl.gz"
+	if key != expected {
+		t.Errorf("Wrong archive key. Expected %s, but got %s", expected, key)
+	}
+
+	logType, err := GetLogTypeFromArchiveKey(key)
+	if err != nil {
+		t.Errorf("Unexpected error getting log type from archive key: %s", err.Error())
+		return
+	}
+
+	if logType != domain.TaskTypeSpeedTest {
+		t.Errorf("Wrong log type from archive key. Expected %s, but got %s", domain.TaskTypeSpeedTest, logType)
+	}
+
+	for _, badKey := range []string{"", "tasklogs/speedTest/node-3.jsonl.gz", "other/speedTest/2018-07-10/node-3.jsonl.gz"} {
+		_, err := GetLogTypeFromArchiveKey(badKey)
+		if err == nil {
+			t.Errorf("Expected an error for archive key %q", badKey)
+		}
+	}
+}
+
+func TestEncodeDecodeJSONLines(t *testing.T) {
+	logs := []domain.TaskLogPingTest{
+		{NodeID: 1, Timestamp: 1531246102, Latency: 1.5, PacketLossPercent: 2},
+		{NodeID: 1, Timestamp: 1531246103, Latency: 2.5, EntryID: "abc"},
+	}
+	logs[0].ID = 10
+	logs[1].ID = 11
+
+	data, count, err := EncodeJSONLines(&logs)
+	if err != nil {
+		t.Errorf("Unexpected error encoding logs: %s", err.Error())
+		return
+	}
+
+	if count != len(logs) {
+		t.Errorf("Wrong count of encoded logs. Expected %v, but got %v", len(logs), count)
+	}
+
+	table, err := GetTaskLogTable(domain.TaskTypePing)
+	if err != nil {
+		t.Errorf("Unexpected error getting task log table: %s", err.Error())
+		return
+	}
+
+	items, err := DecodeJSONLines(data, table.Model)
+	if err != nil {
+		t.Errorf("Unexpected error decoding logs: %s", err.Error())
+		return
+	}
+
+	if len(items) != len(logs) {
+		t.Errorf("Wrong number of decoded logs. Expected %v, but got %v", len(logs), len(items))
+		return
+	}
+
+	for i, item := range items {
+		decoded, ok := item.(*domain.TaskLogPingTest)
+		if !ok {
+			t.Errorf("Decoded log has the wrong type: %T", item)
+			return
+		}
+
+		if decoded.ID != logs[i].ID || decoded.Timestamp != logs[i].Timestamp || decoded.Latency != logs[i].Latency ||
+			decoded.EntryID != logs[i].EntryID {
+			t.Errorf("Decoded log doesn't match. Expected %+v, but got %+v", logs[i], decoded)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const WebhookTimeoutSeconds = 10
+
+// allowPrivateAddresses lets the tests post to local servers. Otherwise webhooks are only posted to public
+// addresses, even if a target's host name resolves to an internal one.
+var allowPrivateAddresses = false
+
+// Message is what gets sent to a notification channel, made up of an intro followed by one line per item
+type Message struct {
+	Subject string
+	Intro   string
+	Lines   []string
+}
+
+// Text returns the intro and the lines as plain text, with each on its own line
+func (m Message) Text() string {
+	return strings.Join(append([]string{m.Intro}, m.Lines...), "\n")
+}
+
+// Notifier delivers a message to a single destination
+type Notifier interface {
+	Notify(msg Message) error
+}
+
+type SESConfig struct {
+	AWSRegion    string
+	CharSet      string
+	ReturnToAddr string
+}
+
+// NewNotifier returns the Notifier for the type of channel
+func NewNotifier(channel domain.NotificationChannel, sesConfig SESConfig) (Notifier, error) {
+	switch channel.Type {
+	case domain.NotificationChannelTypeEmail:
+		return &EmailNotifier{SESConfig: sesConfig, Address: channel.Target}, nil
+	case domain.NotificationChannelTypeWebhook:
+		return &WebhookNotifier{URL: channel.Target}, nil
+	case domain.NotificationChannelTypeSlack:
+		return &SlackNotifier{URL: channel.Target}, nil
+	case domain.NotificationChannelTypeTeams:
+		return &TeamsNotifier{URL: channel.Target}, nil
+	}
+
+	return nil, fmt.Errorf("Invalid notification channel type: %s", channel.Type)
+}
+
+// EmailNotifier sends the message as a plain text email through SES
+type EmailNotifier struct {
+	SESConfig
+	Address string
+}
+
+func (e *EmailNotifier) Notify(msg Message) error {
+	charSet := e.CharSet
+	subject := msg.Subject
+	text := msg.Text()
+
+	emailMsg := ses.Message{}
+	emailMsg.SetSubject(&ses.Content{Charset: &charSet, Data: &subject})
+	emailMsg.SetBody(&ses.Body{Text: &ses.Content{Charset: &charSet, Data: &text}})
+
+	input := &ses.SendEmailInput{
+		Destination: &ses.Destination{
+			ToAddresses: []*string{aws.String(e.Address)},
+		},
+		Message: &emailMsg,
+		Source:  aws.String(e.ReturnToAddr),
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(e.AWSRegion)},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Create an SES session.
+	svc := ses.New(sess)
+	result, err := svc.SendEmail(input)
+	log.Println(result)
+	return err
+}
+
+// WebhookNotifier posts the message as generic JSON
+type WebhookNotifier struct {
+	URL string
+}
+
+type webhookPayload struct {
+	Subject string   `json:"subject"`
+	Intro   string   `json:"intro"`
+	Items   []string `json:"items"`
+}
+
+func (w *WebhookNotifier) Notify(msg Message) error {
+	lines := msg.Lines
+	if lines == nil {
+		lines = []string{}
+	}
+	return postJSON(w.URL, webhookPayload{Subject: msg.Subject, Intro: msg.Intro, Items: lines})
+}
+
+// SlackNotifier posts the message to a Slack incoming webhook
+type SlackNotifier struct {
+	URL string
+}
+
+func (s *SlackNotifier) Notify(msg Message) error {
+	text := fmt.Sprintf("*%s*\n%s", msg.Subject, msg.Text())
+	return postJSON(s.URL, map[string]string{"text": text})
+}
+
+// TeamsNotifier posts the message to a Microsoft Teams incoming webhook
+type TeamsNotifier struct {
+	URL string
+}
+
+func (t *TeamsNotifier) Notify(msg Message) error {
+	// Teams only keeps line breaks in markdown when there is an empty line between them
+	text := strings.Join(append([]string{msg.Intro}, msg.Lines...), "\n\n")
+	return postJSON(t.URL, map[string]string{"title": msg.Subject, "text": text})
+}
+
+func postJSON(url string, payload interface{}) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	dialer := &net.Dialer{
+		Timeout: WebhookTimeoutSeconds * time.Second,
+		Control: checkWebhookAddress,
+	}
+	client := &http.Client{
+		Timeout:   WebhookTimeoutSeconds * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook returned an error. \n\tURL: %s, \n\tCode: %v, \n\tStatus: %s", url, resp.StatusCode, resp.Status)
+	}
+
+	return nil
+}
+
+// checkWebhookAddress refuses connections to addresses that aren't public, including after redirects
+func checkWebhookAddress(network, address string, c syscall.RawConn) error {
+	if allowPrivateAddresses {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !domain.IsPublicIP(ip) {
+		return fmt.Errorf("Webhook address is not public: %s", host)
+	}
+
+	return nil
+}
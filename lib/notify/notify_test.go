@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"encoding/json"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewNotifier(t *testing.T) {
+	testCases := []struct {
+		channelType string
+		expectError bool
+	}{
+		{domain.NotificationChannelTypeEmail, false},
+		{domain.NotificationChannelTypeWebhook, false},
+		{domain.NotificationChannelTypeSlack, false},
+		{domain.NotificationChannelTypeTeams, false},
+		{"pager", true},
+	}
+
+	for _, tc := range testCases {
+		channel := domain.NotificationChannel{Type: tc.channelType, Target: "https://example.com/hook"}
+		notifier, err := NewNotifier(channel, SESConfig{})
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("Expected an error for channel type %s, but got %T", tc.channelType, notifier)
+			}
+			continue
+		}
+
+		if err != nil || notifier == nil {
+			t.Errorf("Expected a notifier for channel type %s, but got error %v", tc.channelType, err)
+		}
+	}
+}
+
+func TestWebhookNotifiers(t *testing.T) {
+	allowPrivateAddresses = true
+	defer func() { allowPrivateAddresses = false }()
+
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = map[string]interface{}{}
+		json.Unmarshal(body, &received)
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	msg := Message{Subject: "Alerts", Intro: "Some alerts", Lines: []string{"one", "two"}}
+
+	testCases := []struct {
+		notifier Notifier
+		field    string
+		expected string
+	}{
+		{&WebhookNotifier{URL: server.URL}, "subject", "Alerts"},
+		{&SlackNotifier{URL: server.URL}, "text", "*Alerts*\nSome alerts\none\ntwo"},
+		{&TeamsNotifier{URL: server.URL}, "text", "Some alerts\n\none\n\ntwo"},
+	}
+
+	for _, tc := range testCases {
+		err := tc.notifier.Notify(msg)
+		if err != nil {
+			t.Errorf("Error notifying with %T: %s", tc.notifier, err.Error())
+			continue
+		}
+
+		if received[tc.field] != tc.expected {
+			t.Errorf("Wrong %s posted by %T. Expected %q, but got %q", tc.field, tc.notifier, tc.expected, received[tc.field])
+		}
+	}
+}
+
+func TestWebhookNotifierError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	// The test server is on a loopback address, which isn't allowed
+	notifier := WebhookNotifier{URL: server.URL}
+	err := notifier.Notify(Message{Subject: "Alerts"})
+	if err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Errorf("Expected an error posting to a loopback address, got: %v", err)
+	}
+
+	allowPrivateAddresses = true
+	defer func() { allowPrivateAddresses = false }()
+
+	err = notifier.Notify(Message{Subject: "Alerts"})
+	if err == nil {
+		t.Error("Expected an error when the webhook responds with a 500")
+	}
+}
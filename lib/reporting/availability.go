@@ -0,0 +1,139 @@
+package reporting
+
+import (
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"sort"
+	"time"
+)
+
+// timeRange runs from Start up to, but not including, End
+type timeRange struct {
+	Start int64
+	End   int64
+}
+
+// Availability is how much of a period a node was available, based on its network downtime and heartbeat gaps
+type Availability struct {
+	MonitoredSeconds    int64
+	HeartbeatGapSeconds int64
+	UnavailableSeconds  int64
+	Percent             float64
+}
+
+func hydrateSnapshotWithAvailability(
+	snapshot *domain.ReportingSnapshot,
+	node domain.Node,
+	outages []domain.TaskLogNetworkDowntime,
+	startTime, endTime int64,
+) error {
+	heartbeats, err := db.GetHeartbeatTimestampsForRange(node.ID, startTime, endTime)
+	if err != nil {
+		return err
+	}
+
+	availability := CalculateAvailability(startTime, endTime, time.Now().UTC().Unix(), outages, heartbeats)
+
+	snapshot.MonitoredSeconds = availability.MonitoredSeconds
+	snapshot.HeartbeatGapSeconds = availability.HeartbeatGapSeconds
+	snapshot.UnavailableSeconds = availability.UnavailableSeconds
+	snapshot.AvailabilityPercent = availability.Percent
+	return nil
+}
+
+// CalculateAvailability works out how much of the period from startTime to endTime (inclusive) that had passed
+// by now the node was unavailable. It was unavailable during its reported network outages and whenever it went
+// more than HeartbeatMaxGapSeconds without a heartbeat. The heartbeats should be in order and include the last
+// one before the period, if there is one. Time before the node's first heartbeat doesn't count as a gap, but
+// without any heartbeats at all the whole period does.
+func CalculateAvailability(
+	startTime, endTime, now int64,
+	outages []domain.TaskLogNetworkDowntime,
+	heartbeats []int64,
+) Availability {
+	limit := endTime + 1
+	if now < limit {
+		limit = now
+	}
+
+	if limit <= startTime {
+		return Availability{}
+	}
+
+	downtimes := []timeRange{}
+	for _, o := range outages {
+		start := o.Timestamp - o.DowntimeSeconds
+		downtimeStart, err := time.Parse(time.RFC3339, o.DowntimeStart)
+		if err == nil {
+			start = downtimeStart.Unix()
+		}
+		downtimes = append(downtimes, timeRange{Start: start, End: start + o.DowntimeSeconds})
+	}
+
+	gaps := []timeRange{}
+	if len(heartbeats) == 0 {
+		gaps = append(gaps, timeRange{Start: startTime, End: limit})
+	}
+
+	for i, heartbeat := range heartbeats {
+		next := limit
+		if i+1 < len(heartbeats) {
+			next = heartbeats[i+1]
+		}
+
+		if next-heartbeat > domain.HeartbeatMaxGapSeconds {
+			gaps = append(gaps, timeRange{Start: heartbeat, End: next})
+		}
+	}
+
+	availability := Availability{
+		MonitoredSeconds:    limit - startTime,
+		HeartbeatGapSeconds: getTotalSeconds(gaps, startTime, limit),
+		UnavailableSeconds:  getTotalSeconds(append(downtimes, gaps...), startTime, limit),
+	}
+	availability.Percent = GetAvailabilityPercent(availability.MonitoredSeconds, availability.UnavailableSeconds)
+
+	return availability
+}
+
+// GetAvailabilityPercent returns the percentage of the monitored time that wasn't unavailable
+func GetAvailabilityPercent(monitoredSeconds, unavailableSeconds int64) float64 {
+	if monitoredSeconds <= 0 {
+		return 0
+	}
+
+	return float64(monitoredSeconds-unavailableSeconds) / float64(monitoredSeconds) * 100
+}
+
+// getTotalSeconds returns how many seconds from start up to limit are covered by the ranges, without
+// counting the overlapping parts more than once
+func getTotalSeconds(ranges []timeRange, start, limit int64) int64 {
+	clipped := []timeRange{}
+	for _, r := range ranges {
+		if r.Start < start {
+			r.Start = start
+		}
+		if r.End > limit {
+			r.End = limit
+		}
+		if r.End > r.Start {
+			clipped = append(clipped, r)
+		}
+	}
+
+	sort.Slice(clipped, func(i, j int) bool { return clipped[i].Start < clipped[j].Start })
+
+	var total int64
+	var coveredUntil int64 = start
+	for _, r := range clipped {
+		if r.Start < coveredUntil {
+			r.Start = coveredUntil
+		}
+		if r.End > r.Start {
+			total += r.End - r.Start
+			coveredUntil = r.End
+		}
+	}
+
+	return total
+}
@@ -0,0 +1,114 @@
+package reporting
+
+import (
+	"github.com/silinternational/speed-snitch-admin-api"
+	"testing"
+	"time"
+)
+
+func TestCalculateAvailability(t *testing.T) {
+	startTime := int64(1000)
+	endTime := startTime + domain.SecondsPerDay - 1
+	afterPeriod := endTime + 3600
+
+	fixtures := []struct {
+		name       string
+		now        int64
+		outages    []domain.TaskLogNetworkDowntime
+		heartbeats []int64
+		want       Availability
+	}{
+		{
+			name: "no heartbeats at all",
+			now:  afterPeriod,
+			want: Availability{
+				MonitoredSeconds:    domain.SecondsPerDay,
+				HeartbeatGapSeconds: domain.SecondsPerDay,
+				UnavailableSeconds:  domain.SecondsPerDay,
+				Percent:             0,
+			},
+		},
+		{
+			name: "an outage and no heartbeats while the period is still going",
+			now:  startTime + 600,
+			outages: []domain.TaskLogNetworkDowntime{
+				{Timestamp: startTime + 300, DowntimeSeconds: 300},
+			},
+			want: Availability{
+				MonitoredSeconds:    600,
+				HeartbeatGapSeconds: 600,
+				UnavailableSeconds:  600,
+				Percent:             0,
+			},
+		},
+		{
+			name:       "a node whose first heartbeat was during the period",
+			now:        afterPeriod,
+			heartbeats: []int64{startTime + 3600, endTime - 60},
+			// Only the time between the two heartbeats is a gap
+			want: Availability{
+				MonitoredSeconds:    domain.SecondsPerDay,
+				HeartbeatGapSeconds: endTime - 60 - (startTime + 3600),
+				UnavailableSeconds:  endTime - 60 - (startTime + 3600),
+				Percent:             float64(domain.SecondsPerDay-(endTime-60-(startTime+3600))) / domain.SecondsPerDay * 100,
+			},
+		},
+		{
+			name: "a heartbeat gap that covers an outage",
+			now:  afterPeriod,
+			outages: []domain.TaskLogNetworkDowntime{
+				{
+					Timestamp:       4700,
+					DowntimeStart:   time.Unix(4000, 0).UTC().Format(time.RFC3339),
+					DowntimeSeconds: 600,
+				},
+			},
+			// Gaps from 1400 to 5000 and from 5300 to the end of the period
+			heartbeats: []int64{500, 1100, 1400, 5000, 5300},
+			want: Availability{
+				MonitoredSeconds:    domain.SecondsPerDay,
+				HeartbeatGapSeconds: 3600 + (endTime + 1 - 5300),
+				UnavailableSeconds:  3600 + (endTime + 1 - 5300),
+				Percent:             float64(domain.SecondsPerDay-3600-(endTime+1-5300)) / domain.SecondsPerDay * 100,
+			},
+		},
+		{
+			name: "overlapping outages while the period is still going",
+			now:  2000,
+			outages: []domain.TaskLogNetworkDowntime{
+				{Timestamp: 1900, DowntimeSeconds: 200}, // No start time, so it ended at the timestamp
+				{Timestamp: 1850, DowntimeSeconds: 100},
+				{Timestamp: 1100, DowntimeSeconds: 300}, // Started before the period
+			},
+			heartbeats: []int64{1000, 1500},
+			want: Availability{
+				MonitoredSeconds:   1000,
+				UnavailableSeconds: 300,
+				Percent:            70,
+			},
+		},
+		{
+			name:       "a node that hasn't said hello since before the period",
+			now:        afterPeriod,
+			heartbeats: []int64{startTime - domain.SecondsPerDay},
+			want: Availability{
+				MonitoredSeconds:    domain.SecondsPerDay,
+				HeartbeatGapSeconds: domain.SecondsPerDay,
+				UnavailableSeconds:  domain.SecondsPerDay,
+				Percent:             0,
+			},
+		},
+		{
+			name: "a period that hasn't started",
+			now:  startTime - 1,
+			want: Availability{},
+		},
+	}
+
+	for _, fix := range fixtures {
+		got := CalculateAvailability(startTime, endTime, fix.now, fix.outages, fix.heartbeats)
+		if got != fix.want {
+			t.Errorf("%s: expected %+v, got %+v", fix.name, fix.want, got)
+		}
+	}
+}
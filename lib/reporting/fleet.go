@@ -0,0 +1,73 @@
+package reporting
+
+import (
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"time"
+)
+
+// GetFleetReport combines the daily snapshots of the nodes between the start and end timestamps into
+// one row per node along with the totals for all of the nodes together
+func GetFleetReport(nodes []domain.Node, startTimestamp, endTimestamp int64) (domain.FleetReport, error) {
+	nodeIDs := []uint{}
+	for _, node := range nodes {
+		nodeIDs = append(nodeIDs, node.ID)
+	}
+
+	snapshots, err := db.GetSnapshotsForNodesForRange(domain.ReportingIntervalDaily, nodeIDs, startTimestamp, endTimestamp)
+	if err != nil {
+		return domain.FleetReport{}, err
+	}
+
+	report := BuildFleetReport(nodes, snapshots)
+	report.Start = time.Unix(startTimestamp, 0).UTC().Format(domain.DateLayout)
+	report.End = time.Unix(endTimestamp, 0).UTC().Format(domain.DateLayout)
+
+	return report, nil
+}
+
+// BuildFleetReport creates a row for each node from its snapshots. The totals are weighted by the number
+// of data points behind each snapshot, so that nodes with more tests count for more.
+func BuildFleetReport(nodes []domain.Node, snapshots []domain.ReportingSnapshot) domain.FleetReport {
+	snapshotsByNode := map[uint][]domain.ReportingSnapshot{}
+	for _, s := range snapshots {
+		snapshotsByNode[s.NodeID] = append(snapshotsByNode[s.NodeID], s)
+	}
+
+	report := domain.FleetReport{Nodes: []domain.FleetReportRow{}}
+
+	for _, node := range nodes {
+		row := GetFleetReportRow(RollupSnapshots(snapshotsByNode[node.ID]))
+		row.NodeID = node.ID
+		row.Nickname = node.Nickname
+		row.MacAddr = node.MacAddr
+		report.Nodes = append(report.Nodes, row)
+	}
+
+	report.Totals = GetFleetReportRow(RollupSnapshots(snapshots))
+
+	return report
+}
+
+func GetFleetReportRow(snapshot domain.ReportingSnapshot) domain.FleetReportRow {
+	return domain.FleetReportRow{
+		DownloadAvg:            snapshot.DownloadAvg,
+		DownloadMax:            snapshot.DownloadMax,
+		DownloadMin:            snapshot.DownloadMin,
+		UploadAvg:              snapshot.UploadAvg,
+		UploadMax:              snapshot.UploadMax,
+		UploadMin:              snapshot.UploadMin,
+		LatencyAvg:             snapshot.LatencyAvg,
+		LatencyMax:             snapshot.LatencyMax,
+		LatencyMin:             snapshot.LatencyMin,
+		PacketLossAvg:          snapshot.PacketLossAvg,
+		PacketLossMax:          snapshot.PacketLossMax,
+		SpeedTestDataPoints:    snapshot.SpeedTestDataPoints,
+		LatencyDataPoints:      snapshot.LatencyDataPoints,
+		NetworkDowntimeSeconds: snapshot.NetworkDowntimeSeconds,
+		NetworkOutagesCount:    snapshot.NetworkOutagesCount,
+		RestartsCount:          snapshot.RestartsCount,
+		UnavailableSeconds:     snapshot.UnavailableSeconds,
+		AvailabilityPercent:    snapshot.AvailabilityPercent,
+	}
+}
@@ -0,0 +1,77 @@
+package reporting
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"testing"
+)
+
+func TestBuildFleetReport(t *testing.T) {
+	node1 := domain.Node{Model: gorm.Model{ID: 1}, Nickname: "node1"}
+	node2 := domain.Node{Model: gorm.Model{ID: 2}, Nickname: "node2"}
+	node3 := domain.Node{Model: gorm.Model{ID: 3}, Nickname: "no data"}
+
+	snapshots := []domain.ReportingSnapshot{
+		{
+			NodeID:                 node1.ID,
+			DownloadTotal:          100,
+			DownloadMax:            60,
+			DownloadMin:            40,
+			UploadTotal:            20,
+			UploadMax:              12,
+			UploadMin:              8,
+			SpeedTestDataPoints:    2,
+			LatencyTotal:           40,
+			LatencyMax:             30,
+			LatencyMin:             10,
+			LatencyDataPoints:      2,
+			NetworkDowntimeSeconds: 60,
+			NetworkOutagesCount:    1,
+		},
+		{
+			NodeID:                 node2.ID,
+			DownloadTotal:          80,
+			DownloadMax:            40,
+			DownloadMin:            5,
+			UploadTotal:            16,
+			UploadMax:              8,
+			UploadMin:              1,
+			SpeedTestDataPoints:    8,
+			LatencyTotal:           80,
+			LatencyMax:             12,
+			LatencyMin:             8,
+			LatencyDataPoints:      8,
+			NetworkDowntimeSeconds: 120,
+			NetworkOutagesCount:    2,
+		},
+	}
+
+	report := BuildFleetReport([]domain.Node{node1, node2, node3}, snapshots)
+
+	if len(report.Nodes) != 3 {
+		t.Fatalf("Expected 3 node rows, but got %v", len(report.Nodes))
+	}
+
+	row1 := report.Nodes[0]
+	if row1.NodeID != node1.ID || row1.Nickname != "node1" || row1.DownloadAvg != 50 || row1.LatencyAvg != 20 {
+		t.Errorf("Bad row for node1. Got %+v", row1)
+	}
+
+	row3 := report.Nodes[2]
+	if row3.NodeID != node3.ID || row3.SpeedTestDataPoints != 0 || row3.DownloadAvg != 0 {
+		t.Errorf("Bad row for node without data. Got %+v", row3)
+	}
+
+	totals := report.Totals
+	if totals.DownloadAvg != 18 || totals.UploadAvg != 3.6 || totals.LatencyAvg != 12 {
+		t.Errorf("Totals not weighted by data points. Got %+v", totals)
+	}
+
+	if totals.DownloadMax != 60 || totals.DownloadMin != 5 || totals.LatencyMin != 8 {
+		t.Errorf("Bad fleet-wide min/max values. Got %+v", totals)
+	}
+
+	if totals.NetworkDowntimeSeconds != 180 || totals.NetworkOutagesCount != 3 || totals.SpeedTestDataPoints != 10 {
+		t.Errorf("Bad fleet totals. Got %+v", totals)
+	}
+}
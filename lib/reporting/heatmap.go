@@ -0,0 +1,79 @@
+package reporting
+
+import (
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"time"
+)
+
+const HoursPerDay = 24
+const DaysPerWeek = 7
+
+// GetHeatmap builds the heatmap for the node from its unflagged raw speed test and ping logs in the range
+func GetHeatmap(node domain.Node, startTimestamp, endTimestamp int64) ([]domain.HeatmapCell, error) {
+	var speedLogs []domain.TaskLogSpeedTest
+	err := db.GetUnflaggedTaskLogForRange(&speedLogs, node.ID, startTimestamp, endTimestamp)
+	if err != nil {
+		return []domain.HeatmapCell{}, err
+	}
+
+	var pingLogs []domain.TaskLogPingTest
+	err = db.GetUnflaggedTaskLogForRange(&pingLogs, node.ID, startTimestamp, endTimestamp)
+	if err != nil {
+		return []domain.HeatmapCell{}, err
+	}
+
+	return BuildHeatmap(speedLogs, pingLogs, node.GetLocation()), nil
+}
+
+// BuildHeatmap buckets the logs by the day of the week and the hour of the day of their timestamps in the
+// given location. Every cell is included, even those without any logs, so the result is always a full 7 x 24 grid.
+func BuildHeatmap(
+	speedLogs []domain.TaskLogSpeedTest,
+	pingLogs []domain.TaskLogPingTest,
+	location *time.Location,
+) []domain.HeatmapCell {
+	cells := make([]domain.HeatmapCell, DaysPerWeek*HoursPerDay)
+	for day := 0; day < DaysPerWeek; day++ {
+		for hour := 0; hour < HoursPerDay; hour++ {
+			cells[day*HoursPerDay+hour] = domain.HeatmapCell{
+				DayOfWeek: day,
+				Day:       time.Weekday(day).String(),
+				Hour:      hour,
+			}
+		}
+	}
+
+	for _, l := range speedLogs {
+		cell := &cells[getHeatmapCellIndex(l.Timestamp, location)]
+		cell.DownloadAvg += l.Download
+		cell.UploadAvg += l.Upload
+		cell.SpeedTestCount++
+	}
+
+	for _, l := range pingLogs {
+		cell := &cells[getHeatmapCellIndex(l.Timestamp, location)]
+		cell.LatencyAvg += l.Latency
+		cell.PacketLossAvg += l.PacketLossPercent
+		cell.PingCount++
+	}
+
+	// The averages hold the totals until now
+	for i := range cells {
+		if cells[i].SpeedTestCount > 0 {
+			cells[i].DownloadAvg /= float64(cells[i].SpeedTestCount)
+			cells[i].UploadAvg /= float64(cells[i].SpeedTestCount)
+		}
+		if cells[i].PingCount > 0 {
+			cells[i].LatencyAvg /= float64(cells[i].PingCount)
+			cells[i].PacketLossAvg /= float64(cells[i].PingCount)
+		}
+	}
+
+	return cells
+}
+
+func getHeatmapCellIndex(timestamp int64, location *time.Location) int {
+	t := time.Unix(timestamp, 0).In(location)
+	return int(t.Weekday())*HoursPerDay + t.Hour()
+}
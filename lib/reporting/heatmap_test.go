@@ -0,0 +1,73 @@
+package reporting
+
+import (
+	"github.com/silinternational/speed-snitch-admin-api"
+	"testing"
+	"time"
+)
+
+func TestBuildHeatmap(t *testing.T) {
+	// 1528145185 is Monday 2018-06-04 20:46:25 UTC
+	speedLogs := []domain.TaskLogSpeedTest{
+		{Timestamp: 1528145185, Download: 10, Upload: 5},
+		{Timestamp: 1528145185 + 600, Download: 20, Upload: 15},
+		{Timestamp: 1528145185 + 3600, Download: 30, Upload: 25},
+	}
+
+	pingLogs := []domain.TaskLogPingTest{
+		{Timestamp: 1528145185, Latency: 10, PacketLossPercent: 1},
+		{Timestamp: 1528145185 + 86400, Latency: 20, PacketLossPercent: 3},
+	}
+
+	cells := BuildHeatmap(speedLogs, pingLogs, time.UTC)
+	if len(cells) != DaysPerWeek*HoursPerDay {
+		t.Errorf("Expected %v cells, got %v", DaysPerWeek*HoursPerDay, len(cells))
+		return
+	}
+
+	monday8pm := cells[1*HoursPerDay+20]
+	if monday8pm.Day != "Monday" || monday8pm.Hour != 20 {
+		t.Errorf("Cells are not in the expected order. Got %+v", monday8pm)
+	}
+
+	if monday8pm.SpeedTestCount != 2 || monday8pm.DownloadAvg != 15 || monday8pm.UploadAvg != 10 {
+		t.Errorf("Speed test values for Monday 20:00 not as expected. Got %+v", monday8pm)
+	}
+
+	if monday8pm.PingCount != 1 || monday8pm.LatencyAvg != 10 || monday8pm.PacketLossAvg != 1 {
+		t.Errorf("Ping values for Monday 20:00 not as expected. Got %+v", monday8pm)
+	}
+
+	monday9pm := cells[1*HoursPerDay+21]
+	if monday9pm.SpeedTestCount != 1 || monday9pm.DownloadAvg != 30 || monday9pm.PingCount != 0 {
+		t.Errorf("Values for Monday 21:00 not as expected. Got %+v", monday9pm)
+	}
+
+	tuesday8pm := cells[2*HoursPerDay+20]
+	if tuesday8pm.PingCount != 1 || tuesday8pm.LatencyAvg != 20 || tuesday8pm.SpeedTestCount != 0 {
+		t.Errorf("Values for Tuesday 20:00 not as expected. Got %+v", tuesday8pm)
+	}
+
+	sunday := cells[0]
+	if sunday.Day != "Sunday" || sunday.Hour != 0 || sunday.SpeedTestCount != 0 || sunday.PingCount != 0 {
+		t.Errorf("Expected an empty first cell for Sunday 00:00. Got %+v", sunday)
+	}
+}
+
+func TestBuildHeatmapInLocation(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Errorf("Unable to load location. %s", err.Error())
+		return
+	}
+
+	// 1528145185 is Monday 2018-06-04 20:46:25 UTC, which is Tuesday 05:46:25 in Tokyo
+	speedLogs := []domain.TaskLogSpeedTest{{Timestamp: 1528145185, Download: 10, Upload: 5}}
+
+	cells := BuildHeatmap(speedLogs, []domain.TaskLogPingTest{}, tokyo)
+
+	tuesday5am := cells[2*HoursPerDay+5]
+	if tuesday5am.SpeedTestCount != 1 || tuesday5am.DownloadAvg != 10 {
+		t.Errorf("Expected the speed test in the Tuesday 05:00 cell. Got %+v", tuesday5am)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/silinternational/speed-snitch-admin-api"
 	"log"
+	"sort"
 	"time"
 )
 
@@ -16,11 +17,22 @@ func GetYesterday() time.Time {
 }
 
 func GetStartEndTimestampsForDate(date time.Time, startTimeOfDay, endTimeOfDay string) (int64, int64, error) {
+	return GetStartEndTimestampsForDateInLocation(date, startTimeOfDay, endTimeOfDay, time.UTC)
+}
+
+// GetStartEndTimestampsForDateInLocation returns the timestamps of the given times of day (HH:MM:SS) on the
+// date's calendar day in the given location. If the end time of day comes before the start time of day,
+// the window wraps past midnight and ends on the following day.
+func GetStartEndTimestampsForDateInLocation(
+	date time.Time,
+	startTimeOfDay, endTimeOfDay string,
+	location *time.Location,
+) (int64, int64, error) {
 	if startTimeOfDay == "" {
 		startTimeOfDay = "00:00:00"
 	}
 	startTimeString := fmt.Sprintf("%v-%v-%v %s", date.Year(), date.Month(), date.Day(), startTimeOfDay)
-	startTime, err := time.Parse(DateTimeLayout, startTimeString)
+	startTime, err := time.ParseInLocation(DateTimeLayout, startTimeString, location)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -30,10 +42,19 @@ func GetStartEndTimestampsForDate(date time.Time, startTimeOfDay, endTimeOfDay s
 		endTimeOfDay = "23:59:59"
 	}
 	endTimeString := fmt.Sprintf("%v-%v-%v %s", date.Year(), date.Month(), date.Day(), endTimeOfDay)
-	endTime, err := time.Parse(DateTimeLayout, endTimeString)
+	endTime, err := time.ParseInLocation(DateTimeLayout, endTimeString, location)
 	if err != nil {
 		return 0, 0, err
 	}
+
+	if endTime.Before(startTime) {
+		nextDay := date.AddDate(0, 0, 1)
+		endTimeString = fmt.Sprintf("%v-%v-%v %s", nextDay.Year(), nextDay.Month(), nextDay.Day(), endTimeOfDay)
+		endTime, err = time.ParseInLocation(DateTimeLayout, endTimeString, location)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
 	endTimestamp := endTime.Unix()
 
 	return startTimestamp, endTimestamp, nil
@@ -65,6 +86,27 @@ func GetHigherFloat(first, second float64) float64 {
 	return second
 }
 
+// GetPercentile returns the value below which the given percentage of the values fall, interpolating
+// between the two closest values. Returns 0 if there are no values.
+func GetPercentile(values []float64, percentile float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	rank := percentile / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+
+	fraction := rank - float64(lower)
+	return sorted[lower] + fraction*(sorted[lower+1]-sorted[lower])
+}
+
 func IsValidReportingInterval(needle string) bool {
 	haystack := []string{domain.ReportingIntervalDaily, domain.ReportingIntervalWeekly, domain.ReportingIntervalMonthly}
 	isValid, _ := domain.InArray(needle, haystack)
@@ -80,3 +122,30 @@ func StringDateToTime(date string) (time.Time, error) {
 
 	return timeObj, nil
 }
+
+// GetStartEndTimestampsForInterval returns the first and last second of the weekly or monthly period that
+// contains the given date. Weeks start on Monday.
+func GetStartEndTimestampsForInterval(date time.Time, interval string) (int64, int64, error) {
+	date = date.UTC()
+	dayStart := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+
+	var periodStart, periodEnd time.Time
+
+	switch interval {
+	case domain.ReportingIntervalDaily:
+		periodStart = dayStart
+		periodEnd = periodStart.AddDate(0, 0, 1)
+	case domain.ReportingIntervalWeekly:
+		// time.Weekday has Sunday as 0, so shift it to make Monday the first day of the week
+		daysSinceMonday := (int(dayStart.Weekday()) + 6) % 7
+		periodStart = dayStart.AddDate(0, 0, -daysSinceMonday)
+		periodEnd = periodStart.AddDate(0, 0, 7)
+	case domain.ReportingIntervalMonthly:
+		periodStart = time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		periodEnd = periodStart.AddDate(0, 1, 0)
+	default:
+		return 0, 0, fmt.Errorf("Invalid reporting interval: %s", interval)
+	}
+
+	return periodStart.Unix(), periodEnd.Unix() - 1, nil
+}
@@ -2,6 +2,7 @@ package reporting
 
 import (
 	"github.com/silinternational/speed-snitch-admin-api"
+	"math"
 	"testing"
 	"time"
 )
@@ -24,6 +25,75 @@ func TestGetStartEndTimestampsForDate(t *testing.T) {
 	}
 }
 
+func TestGetStartEndTimestampsForDateInLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Errorf("Unable to load location. %s", err.Error())
+		return
+	}
+
+	fixtures := []struct {
+		name      string
+		date      string
+		start     string
+		end       string
+		location  *time.Location
+		wantStart int64
+		wantEnd   int64
+	}{
+		{
+			name:      "whole day in New York",
+			date:      "2018-05-06",
+			location:  newYork,
+			wantStart: 1525579200, // 2018-05-06 04:00:00 UTC
+			wantEnd:   1525665599, // 2018-05-07 03:59:59 UTC
+		},
+		{
+			name:      "whole day in New York when clocks go forward",
+			date:      "2018-03-11",
+			location:  newYork,
+			wantStart: 1520744400, // 2018-03-11 05:00:00 UTC
+			wantEnd:   1520827199, // 2018-03-12 03:59:59 UTC
+		},
+		{
+			name:      "business hours in New York",
+			date:      "2018-05-06",
+			start:     "08:00:00",
+			end:       "17:00:00",
+			location:  newYork,
+			wantStart: 1525608000, // 2018-05-06 12:00:00 UTC
+			wantEnd:   1525640400, // 2018-05-06 21:00:00 UTC
+		},
+		{
+			name:      "business hours that wrap past midnight",
+			date:      "2018-05-06",
+			start:     "22:00:00",
+			end:       "06:00:00",
+			location:  time.UTC,
+			wantStart: 1525644000, // 2018-05-06 22:00:00 UTC
+			wantEnd:   1525672800, // 2018-05-07 06:00:00 UTC
+		},
+	}
+
+	for _, fix := range fixtures {
+		date, err := time.Parse(DateLayout, fix.date)
+		if err != nil {
+			t.Errorf("%s: unable to parse date %s", fix.name, fix.date)
+			continue
+		}
+
+		startTime, endTime, err := GetStartEndTimestampsForDateInLocation(date, fix.start, fix.end, fix.location)
+		if err != nil {
+			t.Errorf("%s: unexpected error. %s", fix.name, err.Error())
+			continue
+		}
+
+		if startTime != fix.wantStart || endTime != fix.wantEnd {
+			t.Errorf("%s: expected %v to %v, got %v to %v", fix.name, fix.wantStart, fix.wantEnd, startTime, endTime)
+		}
+	}
+}
+
 func TestGetHigherFloat(t *testing.T) {
 	fixtures := []struct {
 		first  float64
@@ -56,6 +126,34 @@ func TestGetHigherFloat(t *testing.T) {
 	}
 }
 
+func TestGetPercentile(t *testing.T) {
+	values := []float64{10, 1, 9, 2, 8, 3, 7, 4, 6, 5}
+
+	fixtures := []struct {
+		values     []float64
+		percentile float64
+		expected   float64
+	}{
+		{values: []float64{}, percentile: 50, expected: 0},
+		{values: []float64{3}, percentile: 95, expected: 3},
+		{values: values, percentile: 0, expected: 1},
+		{values: values, percentile: 50, expected: 5.5},
+		{values: values, percentile: 90, expected: 9.1},
+		{values: values, percentile: 100, expected: 10},
+	}
+
+	for _, fix := range fixtures {
+		result := GetPercentile(fix.values, fix.percentile)
+		if math.Abs(result-fix.expected) > 0.000001 {
+			t.Errorf("GetPercentile(%v, %v) returned %v, expected %v", fix.values, fix.percentile, result, fix.expected)
+		}
+	}
+
+	if values[0] != 10 {
+		t.Error("GetPercentile should not have changed the order of the values")
+	}
+}
+
 func TestGetLowerFloat(t *testing.T) {
 	fixtures := []struct {
 		first  float64
@@ -192,3 +290,55 @@ func TestStringDateToTime(t *testing.T) {
 		}
 	}
 }
+
+func TestGetStartEndTimestampsForInterval(t *testing.T) {
+	tests := []struct {
+		date          string
+		interval      string
+		expectedStart int64
+		expectedEnd   int64
+	}{
+		{
+			date:          "2018-08-01", // a Wednesday
+			interval:      domain.ReportingIntervalDaily,
+			expectedStart: 1533081600, // 2018-08-01 00:00:00
+			expectedEnd:   1533167999, // 2018-08-01 23:59:59
+		},
+		{
+			date:          "2018-08-01",
+			interval:      domain.ReportingIntervalWeekly,
+			expectedStart: 1532908800, // 2018-07-30 00:00:00
+			expectedEnd:   1533513599, // 2018-08-05 23:59:59
+		},
+		{
+			date:          "2018-08-05", // a Sunday
+			interval:      domain.ReportingIntervalWeekly,
+			expectedStart: 1532908800, // 2018-07-30 00:00:00
+			expectedEnd:   1533513599, // 2018-08-05 23:59:59
+		},
+		{
+			date:          "2018-08-15",
+			interval:      domain.ReportingIntervalMonthly,
+			expectedStart: 1533081600, // 2018-08-01 00:00:00
+			expectedEnd:   1535759999, // 2018-08-31 23:59:59
+		},
+	}
+
+	for _, test := range tests {
+		date, _ := time.Parse(DateLayout, test.date)
+		start, end, err := GetStartEndTimestampsForInterval(date, test.interval)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if start != test.expectedStart || end != test.expectedEnd {
+			t.Errorf("Wrong %s range for %s. Expected %v to %v, got %v to %v",
+				test.interval, test.date, test.expectedStart, test.expectedEnd, start, end)
+		}
+	}
+
+	_, _, err := GetStartEndTimestampsForInterval(time.Now(), "yearly")
+	if err == nil {
+		t.Error("Expected an error for an invalid interval but did not get one")
+	}
+}
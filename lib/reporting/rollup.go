@@ -0,0 +1,433 @@
+package reporting
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"os"
+	"time"
+)
+
+// GenerateRollupSnapshots creates or refreshes the weekly and monthly snapshots for every period that
+// includes one of the days being processed.
+// Returns number of snapshots generated and error/nil
+func GenerateRollupSnapshots(date time.Time, numDaysToProcess int64, forceOverwrite bool) (int64, error) {
+	var snapshotsGenerated int64 = 0
+
+	for _, interval := range []string{domain.ReportingIntervalWeekly, domain.ReportingIntervalMonthly} {
+		periodDates, err := getPeriodDatesForDays(date, numDaysToProcess, interval)
+		if err != nil {
+			return snapshotsGenerated, err
+		}
+
+		for _, periodDate := range periodDates {
+			periodSnapshots, err := GenerateRollupSnapshotsForDate(periodDate, interval, forceOverwrite)
+			snapshotsGenerated += periodSnapshots
+			if err != nil {
+				return snapshotsGenerated, err
+			}
+		}
+	}
+
+	return snapshotsGenerated, nil
+}
+
+// Iterate through all nodes and generate rollup snapshots for the period containing the given date
+// Returns number of snapshots created and error/nil
+func GenerateRollupSnapshotsForDate(date time.Time, interval string, forceOverwrite bool) (int64, error) {
+	var snapshotsCreated int64 = 0
+
+	var nodes []domain.Node
+	err := db.ListItems(&nodes, "id asc")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, n := range nodes {
+		created, err := GenerateRollupSnapshotForNodeForDate(n, date, interval, forceOverwrite)
+		if created {
+			snapshotsCreated++
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "%v - error generating %s snapshot for node %v - %s. err: %s", date, interval, n.ID, n.Nickname, err.Error())
+			return snapshotsCreated, err
+		}
+	}
+
+	return snapshotsCreated, nil
+}
+
+// Generates a weekly or monthly snapshot for the given node by rolling up its daily snapshots for the period
+// that contains the given date. An existing rollup is only regenerated if forceOverwrite is true or if the
+// period had not yet ended when it was last generated.
+// Returns true/false for if a snapshot was created along with an error if one is present
+func GenerateRollupSnapshotForNodeForDate(node domain.Node, date time.Time, interval string, forceOverwrite bool) (bool, error) {
+	if interval != domain.ReportingIntervalWeekly && interval != domain.ReportingIntervalMonthly {
+		return false, fmt.Errorf("Invalid rollup interval: %s", interval)
+	}
+
+	startTime, endTime, err := GetStartEndTimestampsForInterval(date, interval)
+	if err != nil {
+		return false, err
+	}
+
+	// check for existing snapshot to update, or create new one
+	snapshot := domain.ReportingSnapshot{
+		Timestamp: startTime,
+		NodeID:    node.ID,
+		Interval:  interval,
+	}
+	err = db.FindOne(&snapshot)
+	if !gorm.IsRecordNotFoundError(err) && err != nil {
+		return false, err
+	} else if snapshot.ID != 0 && !forceOverwrite && snapshot.UpdatedAt.Unix() > endTime {
+		return false, nil
+	}
+
+	dailySnapshots, err := db.GetSnapshotsForRange(domain.ReportingIntervalDaily, node.ID, startTime, endTime)
+	if err != nil {
+		return false, err
+	}
+
+	if len(dailySnapshots) == 0 && snapshot.ID == 0 {
+		return false, nil
+	}
+
+	rollup := RollupSnapshots(dailySnapshots)
+
+	err = hydrateRollupWithPercentiles(&rollup, node, dailySnapshots)
+	if err != nil {
+		return false, err
+	}
+
+	rollup.Model = snapshot.Model
+	rollup.NodeID = node.ID
+	rollup.Timestamp = startTime
+	rollup.Interval = interval
+
+	err = db.PutItem(&rollup)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RollupSnapshots combines the given snapshots into a single snapshot. Averages are weighted by
+// the number of data points behind each snapshot, and min/max values only consider snapshots that have data.
+// Percentiles can't be combined without the raw logs, so the percentile fields are only averages of the
+// snapshots' percentiles, weighted the same way as the averages. The stored weekly and monthly snapshots
+// replace them with real percentiles in hydrateRollupWithPercentiles.
+func RollupSnapshots(snapshots []domain.ReportingSnapshot) domain.ReportingSnapshot {
+	var rollup domain.ReportingSnapshot
+
+	for _, s := range snapshots {
+		rollupSpeedTestValues(&rollup, s)
+		rollupLatencyValues(&rollup, s)
+		rollupBizSpeedTestValues(&rollup, s)
+		rollupBizLatencyValues(&rollup, s)
+
+		rollup.NetworkDowntimeSeconds += s.NetworkDowntimeSeconds
+		rollup.NetworkOutagesCount += s.NetworkOutagesCount
+		rollup.RestartsCount += s.RestartsCount
+		rollup.MonitoredSeconds += s.MonitoredSeconds
+		rollup.HeartbeatGapSeconds += s.HeartbeatGapSeconds
+		rollup.UnavailableSeconds += s.UnavailableSeconds
+
+		rollup.BizNetworkDowntimeSeconds += s.BizNetworkDowntimeSeconds
+		rollup.BizNetworkOutagesCount += s.BizNetworkOutagesCount
+		rollup.BizRestartsCount += s.BizRestartsCount
+		rollup.BizExcludedDays += s.BizExcludedDays
+	}
+
+	rollup.AvailabilityPercent = GetAvailabilityPercent(rollup.MonitoredSeconds, rollup.UnavailableSeconds)
+
+	if rollup.SpeedTestDataPoints > 0 {
+		floatCount := float64(rollup.SpeedTestDataPoints)
+		rollup.DownloadAvg = rollup.DownloadTotal / floatCount
+		rollup.UploadAvg = rollup.UploadTotal / floatCount
+		rollup.DownloadP50 /= floatCount
+		rollup.DownloadP90 /= floatCount
+		rollup.DownloadP95 /= floatCount
+		rollup.UploadP50 /= floatCount
+		rollup.UploadP90 /= floatCount
+		rollup.UploadP95 /= floatCount
+	}
+
+	if rollup.LatencyDataPoints > 0 {
+		floatCount := float64(rollup.LatencyDataPoints)
+		rollup.LatencyAvg = rollup.LatencyTotal / floatCount
+		rollup.PacketLossAvg = rollup.PacketLossTotal / floatCount
+		rollup.LatencyP50 /= floatCount
+		rollup.LatencyP90 /= floatCount
+		rollup.LatencyP95 /= floatCount
+		rollup.PacketLossP50 /= floatCount
+		rollup.PacketLossP90 /= floatCount
+		rollup.PacketLossP95 /= floatCount
+	}
+
+	if rollup.BizSpeedTestDataPoints > 0 {
+		floatCount := float64(rollup.BizSpeedTestDataPoints)
+		rollup.BizDownloadAvg = rollup.BizDownloadTotal / floatCount
+		rollup.BizUploadAvg = rollup.BizUploadTotal / floatCount
+		rollup.BizDownloadP50 /= floatCount
+		rollup.BizDownloadP90 /= floatCount
+		rollup.BizDownloadP95 /= floatCount
+		rollup.BizUploadP50 /= floatCount
+		rollup.BizUploadP90 /= floatCount
+		rollup.BizUploadP95 /= floatCount
+	}
+
+	if rollup.BizLatencyDataPoints > 0 {
+		floatCount := float64(rollup.BizLatencyDataPoints)
+		rollup.BizLatencyAvg = rollup.BizLatencyTotal / floatCount
+		rollup.BizPacketLossAvg = rollup.BizPacketLossTotal / floatCount
+		rollup.BizLatencyP50 /= floatCount
+		rollup.BizLatencyP90 /= floatCount
+		rollup.BizLatencyP95 /= floatCount
+		rollup.BizPacketLossP50 /= floatCount
+		rollup.BizPacketLossP90 /= floatCount
+		rollup.BizPacketLossP95 /= floatCount
+	}
+
+	return rollup
+}
+
+// hydrateRollupWithPercentiles sets the rollup's percentiles from the node's unflagged logs for the days
+// behind the given daily snapshots. The business hours percentiles only use the logs within the business
+// hours of the days that weren't excluded.
+func hydrateRollupWithPercentiles(
+	rollup *domain.ReportingSnapshot,
+	node domain.Node,
+	dailySnapshots []domain.ReportingSnapshot,
+) error {
+	var download, upload, latency, packetLoss []float64
+	var bizDownload, bizUpload, bizLatency, bizPacketLoss []float64
+
+	start := node.BusinessStartTime
+	close := node.BusinessCloseTime
+	hasBusinessHours := !((start == "" || start == "00:00") && (close == "" || close == "00:00"))
+
+	for _, s := range dailySnapshots {
+		date := time.Unix(s.Timestamp, 0).UTC()
+
+		startTime, endTime, err := GetStartEndTimestampsForDateInLocation(date, "", "", node.GetLocation())
+		if err != nil {
+			return err
+		}
+
+		bizStartTime, bizCloseTime := int64(0), int64(-1)
+		if hasBusinessHours && s.BizExcludedDays == 0 {
+			bizStartTime, bizCloseTime, err = GetStartEndTimestampsForDateInLocation(
+				date,
+				fmt.Sprintf("%s:00", node.BusinessStartTime),
+				fmt.Sprintf("%s:00", node.BusinessCloseTime),
+				node.GetLocation(),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		var speedLogs []domain.TaskLogSpeedTest
+		err = db.GetUnflaggedTaskLogForRange(&speedLogs, node.ID, startTime, endTime)
+		if err != nil {
+			return err
+		}
+
+		for _, l := range speedLogs {
+			download = append(download, l.Download)
+			upload = append(upload, l.Upload)
+			if l.Timestamp >= bizStartTime && l.Timestamp <= bizCloseTime {
+				bizDownload = append(bizDownload, l.Download)
+				bizUpload = append(bizUpload, l.Upload)
+			}
+		}
+
+		var pingLogs []domain.TaskLogPingTest
+		err = db.GetUnflaggedTaskLogForRange(&pingLogs, node.ID, startTime, endTime)
+		if err != nil {
+			return err
+		}
+
+		for _, l := range pingLogs {
+			latency = append(latency, l.Latency)
+			packetLoss = append(packetLoss, l.PacketLossPercent)
+			if l.Timestamp >= bizStartTime && l.Timestamp <= bizCloseTime {
+				bizLatency = append(bizLatency, l.Latency)
+				bizPacketLoss = append(bizPacketLoss, l.PacketLossPercent)
+			}
+		}
+	}
+
+	rollup.DownloadP50 = GetPercentile(download, 50)
+	rollup.DownloadP90 = GetPercentile(download, 90)
+	rollup.DownloadP95 = GetPercentile(download, 95)
+	rollup.UploadP50 = GetPercentile(upload, 50)
+	rollup.UploadP90 = GetPercentile(upload, 90)
+	rollup.UploadP95 = GetPercentile(upload, 95)
+
+	rollup.LatencyP50 = GetPercentile(latency, 50)
+	rollup.LatencyP90 = GetPercentile(latency, 90)
+	rollup.LatencyP95 = GetPercentile(latency, 95)
+	rollup.PacketLossP50 = GetPercentile(packetLoss, 50)
+	rollup.PacketLossP90 = GetPercentile(packetLoss, 90)
+	rollup.PacketLossP95 = GetPercentile(packetLoss, 95)
+
+	rollup.BizDownloadP50 = GetPercentile(bizDownload, 50)
+	rollup.BizDownloadP90 = GetPercentile(bizDownload, 90)
+	rollup.BizDownloadP95 = GetPercentile(bizDownload, 95)
+	rollup.BizUploadP50 = GetPercentile(bizUpload, 50)
+	rollup.BizUploadP90 = GetPercentile(bizUpload, 90)
+	rollup.BizUploadP95 = GetPercentile(bizUpload, 95)
+
+	rollup.BizLatencyP50 = GetPercentile(bizLatency, 50)
+	rollup.BizLatencyP90 = GetPercentile(bizLatency, 90)
+	rollup.BizLatencyP95 = GetPercentile(bizLatency, 95)
+	rollup.BizPacketLossP50 = GetPercentile(bizPacketLoss, 50)
+	rollup.BizPacketLossP90 = GetPercentile(bizPacketLoss, 90)
+	rollup.BizPacketLossP95 = GetPercentile(bizPacketLoss, 95)
+
+	return nil
+}
+
+func rollupSpeedTestValues(rollup *domain.ReportingSnapshot, s domain.ReportingSnapshot) {
+	if s.SpeedTestDataPoints == 0 {
+		return
+	}
+
+	if rollup.SpeedTestDataPoints == 0 {
+		rollup.DownloadMax = s.DownloadMax
+		rollup.DownloadMin = s.DownloadMin
+		rollup.UploadMax = s.UploadMax
+		rollup.UploadMin = s.UploadMin
+	} else {
+		rollup.DownloadMax = GetHigherFloat(s.DownloadMax, rollup.DownloadMax)
+		rollup.DownloadMin = GetLowerFloat(s.DownloadMin, rollup.DownloadMin)
+		rollup.UploadMax = GetHigherFloat(s.UploadMax, rollup.UploadMax)
+		rollup.UploadMin = GetLowerFloat(s.UploadMin, rollup.UploadMin)
+	}
+
+	rollup.DownloadTotal += s.DownloadTotal
+	rollup.UploadTotal += s.UploadTotal
+	rollup.SpeedTestDataPoints += s.SpeedTestDataPoints
+
+	rollup.DownloadP50 += s.DownloadP50 * float64(s.SpeedTestDataPoints)
+	rollup.DownloadP90 += s.DownloadP90 * float64(s.SpeedTestDataPoints)
+	rollup.DownloadP95 += s.DownloadP95 * float64(s.SpeedTestDataPoints)
+	rollup.UploadP50 += s.UploadP50 * float64(s.SpeedTestDataPoints)
+	rollup.UploadP90 += s.UploadP90 * float64(s.SpeedTestDataPoints)
+	rollup.UploadP95 += s.UploadP95 * float64(s.SpeedTestDataPoints)
+}
+
+func rollupLatencyValues(rollup *domain.ReportingSnapshot, s domain.ReportingSnapshot) {
+	if s.LatencyDataPoints == 0 {
+		return
+	}
+
+	if rollup.LatencyDataPoints == 0 {
+		rollup.LatencyMax = s.LatencyMax
+		rollup.LatencyMin = s.LatencyMin
+		rollup.PacketLossMax = s.PacketLossMax
+		rollup.PacketLossMin = s.PacketLossMin
+	} else {
+		rollup.LatencyMax = GetHigherFloat(s.LatencyMax, rollup.LatencyMax)
+		rollup.LatencyMin = GetLowerLatency(s.LatencyMin, rollup.LatencyMin)
+		rollup.PacketLossMax = GetHigherFloat(s.PacketLossMax, rollup.PacketLossMax)
+		rollup.PacketLossMin = GetLowerFloat(s.PacketLossMin, rollup.PacketLossMin)
+	}
+
+	rollup.LatencyTotal += s.LatencyTotal
+	rollup.PacketLossTotal += s.PacketLossTotal
+	rollup.LatencyDataPoints += s.LatencyDataPoints
+
+	rollup.LatencyP50 += s.LatencyP50 * float64(s.LatencyDataPoints)
+	rollup.LatencyP90 += s.LatencyP90 * float64(s.LatencyDataPoints)
+	rollup.LatencyP95 += s.LatencyP95 * float64(s.LatencyDataPoints)
+	rollup.PacketLossP50 += s.PacketLossP50 * float64(s.LatencyDataPoints)
+	rollup.PacketLossP90 += s.PacketLossP90 * float64(s.LatencyDataPoints)
+	rollup.PacketLossP95 += s.PacketLossP95 * float64(s.LatencyDataPoints)
+}
+
+func rollupBizSpeedTestValues(rollup *domain.ReportingSnapshot, s domain.ReportingSnapshot) {
+	if s.BizSpeedTestDataPoints == 0 {
+		return
+	}
+
+	if rollup.BizSpeedTestDataPoints == 0 {
+		rollup.BizDownloadMax = s.BizDownloadMax
+		rollup.BizDownloadMin = s.BizDownloadMin
+		rollup.BizUploadMax = s.BizUploadMax
+		rollup.BizUploadMin = s.BizUploadMin
+	} else {
+		rollup.BizDownloadMax = GetHigherFloat(s.BizDownloadMax, rollup.BizDownloadMax)
+		rollup.BizDownloadMin = GetLowerFloat(s.BizDownloadMin, rollup.BizDownloadMin)
+		rollup.BizUploadMax = GetHigherFloat(s.BizUploadMax, rollup.BizUploadMax)
+		rollup.BizUploadMin = GetLowerFloat(s.BizUploadMin, rollup.BizUploadMin)
+	}
+
+	rollup.BizDownloadTotal += s.BizDownloadTotal
+	rollup.BizUploadTotal += s.BizUploadTotal
+	rollup.BizSpeedTestDataPoints += s.BizSpeedTestDataPoints
+
+	rollup.BizDownloadP50 += s.BizDownloadP50 * float64(s.BizSpeedTestDataPoints)
+	rollup.BizDownloadP90 += s.BizDownloadP90 * float64(s.BizSpeedTestDataPoints)
+	rollup.BizDownloadP95 += s.BizDownloadP95 * float64(s.BizSpeedTestDataPoints)
+	rollup.BizUploadP50 += s.BizUploadP50 * float64(s.BizSpeedTestDataPoints)
+	rollup.BizUploadP90 += s.BizUploadP90 * float64(s.BizSpeedTestDataPoints)
+	rollup.BizUploadP95 += s.BizUploadP95 * float64(s.BizSpeedTestDataPoints)
+}
+
+func rollupBizLatencyValues(rollup *domain.ReportingSnapshot, s domain.ReportingSnapshot) {
+	if s.BizLatencyDataPoints == 0 {
+		return
+	}
+
+	if rollup.BizLatencyDataPoints == 0 {
+		rollup.BizLatencyMax = s.BizLatencyMax
+		rollup.BizLatencyMin = s.BizLatencyMin
+		rollup.BizPacketLossMax = s.BizPacketLossMax
+		rollup.BizPacketLossMin = s.BizPacketLossMin
+	} else {
+		rollup.BizLatencyMax = GetHigherFloat(s.BizLatencyMax, rollup.BizLatencyMax)
+		rollup.BizLatencyMin = GetLowerLatency(s.BizLatencyMin, rollup.BizLatencyMin)
+		rollup.BizPacketLossMax = GetHigherFloat(s.BizPacketLossMax, rollup.BizPacketLossMax)
+		rollup.BizPacketLossMin = GetLowerFloat(s.BizPacketLossMin, rollup.BizPacketLossMin)
+	}
+
+	rollup.BizLatencyTotal += s.BizLatencyTotal
+	rollup.BizPacketLossTotal += s.BizPacketLossTotal
+	rollup.BizLatencyDataPoints += s.BizLatencyDataPoints
+
+	rollup.BizLatencyP50 += s.BizLatencyP50 * float64(s.BizLatencyDataPoints)
+	rollup.BizLatencyP90 += s.BizLatencyP90 * float64(s.BizLatencyDataPoints)
+	rollup.BizLatencyP95 += s.BizLatencyP95 * float64(s.BizLatencyDataPoints)
+	rollup.BizPacketLossP50 += s.BizPacketLossP50 * float64(s.BizLatencyDataPoints)
+	rollup.BizPacketLossP90 += s.BizPacketLossP90 * float64(s.BizLatencyDataPoints)
+	rollup.BizPacketLossP95 += s.BizPacketLossP95 * float64(s.BizLatencyDataPoints)
+}
+
+// getPeriodDatesForDays returns one date for each distinct weekly or monthly period touched by
+// the numDays days ending on the given date
+func getPeriodDatesForDays(date time.Time, numDays int64, interval string) ([]time.Time, error) {
+	periodDates := []time.Time{}
+	seenPeriods := map[int64]bool{}
+
+	var days int64
+	for days = 0; days < numDays; days++ {
+		day := date.AddDate(0, 0, -int(days))
+		periodStart, _, err := GetStartEndTimestampsForInterval(day, interval)
+		if err != nil {
+			return []time.Time{}, err
+		}
+
+		if seenPeriods[periodStart] {
+			continue
+		}
+		seenPeriods[periodStart] = true
+		periodDates = append(periodDates, time.Unix(periodStart, 0).UTC())
+	}
+
+	return periodDates, nil
+}
@@ -0,0 +1,225 @@
+package reporting
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"github.com/silinternational/speed-snitch-admin-api/lib/testutils"
+	"testing"
+)
+
+func TestRollupSnapshots(t *testing.T) {
+	dailies := []domain.ReportingSnapshot{
+		{
+			DownloadTotal:       30,
+			DownloadMax:         20,
+			DownloadMin:         10,
+			UploadTotal:         6,
+			UploadMax:           4,
+			UploadMin:           2,
+			SpeedTestDataPoints: 2,
+			LatencyTotal:        10,
+			LatencyMax:          10,
+			LatencyMin:          10,
+			PacketLossTotal:     1,
+			PacketLossMax:       1,
+			PacketLossMin:       1,
+			LatencyDataPoints:   1,
+			RestartsCount:       1,
+
+			NetworkDowntimeSeconds: 60,
+			NetworkOutagesCount:    1,
+		},
+		{
+			// A day without any test data should not affect min values
+			RestartsCount: 2,
+
+			BizExcludedDays:   1,
+			BizExcludedReason: "Non-working day: Saturday",
+		},
+		{
+			DownloadTotal:       80,
+			DownloadMax:         50,
+			DownloadMin:         30,
+			UploadTotal:         2,
+			UploadMax:           2,
+			UploadMin:           0,
+			SpeedTestDataPoints: 2,
+			LatencyTotal:        60,
+			LatencyMax:          40,
+			LatencyMin:          20,
+			PacketLossTotal:     0,
+			LatencyDataPoints:   2,
+
+			BizDownloadTotal:       50,
+			BizDownloadMax:         50,
+			BizDownloadMin:         50,
+			BizSpeedTestDataPoints: 1,
+
+			NetworkDowntimeSeconds:    120,
+			NetworkOutagesCount:       2,
+			BizNetworkDowntimeSeconds: 120,
+			BizNetworkOutagesCount:    2,
+		},
+	}
+
+	rollup := RollupSnapshots(dailies)
+
+	if rollup.DownloadAvg != 27.5 {
+		t.Errorf("Rollup download avg not as expected (27.5), got: %v", rollup.DownloadAvg)
+	}
+	if rollup.DownloadMin != 10 || rollup.DownloadMax != 50 {
+		t.Errorf("Rollup download min/max not as expected (10/50), got: %v/%v", rollup.DownloadMin, rollup.DownloadMax)
+	}
+	if rollup.UploadMin != 0 || rollup.UploadMax != 4 {
+		t.Errorf("Rollup upload min/max not as expected (0/4), got: %v/%v", rollup.UploadMin, rollup.UploadMax)
+	}
+	if rollup.SpeedTestDataPoints != 4 {
+		t.Errorf("Rollup speed test data points not as expected (4), got: %v", rollup.SpeedTestDataPoints)
+	}
+	if rollup.LatencyAvg != 70.0/3.0 {
+		t.Errorf("Rollup latency avg not as expected (%v), got: %v", 70.0/3.0, rollup.LatencyAvg)
+	}
+	if rollup.LatencyMin != 10 || rollup.LatencyMax != 40 {
+		t.Errorf("Rollup latency min/max not as expected (10/40), got: %v/%v", rollup.LatencyMin, rollup.LatencyMax)
+	}
+	if rollup.PacketLossMin != 0 {
+		t.Errorf("Rollup packet loss min not as expected (0), got: %v", rollup.PacketLossMin)
+	}
+	if rollup.BizDownloadAvg != 50 || rollup.BizDownloadMin != 50 {
+		t.Errorf("Rollup biz download avg/min not as expected (50/50), got: %v/%v", rollup.BizDownloadAvg, rollup.BizDownloadMin)
+	}
+	if rollup.BizLatencyDataPoints != 0 || rollup.BizLatencyAvg != 0 {
+		t.Errorf("Rollup biz latency should be empty, got %v data points and avg %v", rollup.BizLatencyDataPoints, rollup.BizLatencyAvg)
+	}
+	if rollup.RestartsCount != 3 {
+		t.Errorf("Rollup restarts count not as expected (3), got: %v", rollup.RestartsCount)
+	}
+	if rollup.NetworkDowntimeSeconds != 180 || rollup.NetworkOutagesCount != 3 {
+		t.Errorf("Rollup downtime not as expected (180s in 3 outages), got: %vs in %v outages",
+			rollup.NetworkDowntimeSeconds, rollup.NetworkOutagesCount)
+	}
+	if rollup.BizNetworkDowntimeSeconds != 120 || rollup.BizNetworkOutagesCount != 2 {
+		t.Errorf("Rollup biz downtime not as expected (120s in 2 outages), got: %vs in %v outages",
+			rollup.BizNetworkDowntimeSeconds, rollup.BizNetworkOutagesCount)
+	}
+	if rollup.BizExcludedDays != 1 {
+		t.Errorf("Rollup biz excluded days not as expected (1), got: %v", rollup.BizExcludedDays)
+	}
+}
+
+func TestGenerateRollupSnapshots(t *testing.T) {
+	testutils.ResetDb(t)
+
+	node1 := domain.Node{
+		Model: gorm.Model{
+			ID: 1,
+		},
+		MacAddr: "aa:aa:aa:aa:aa:aa",
+	}
+	db.PutItem(&node1)
+
+	logs := []domain.TaskLogSpeedTest{
+		{
+			NodeID:    node1.ID,
+			Timestamp: 1533081600, // 2018-08-01 00:00:00
+			Download:  10,
+			Upload:    1,
+		},
+		{
+			NodeID:    node1.ID,
+			Timestamp: 1533081700, // 2018-08-01 00:01:40
+			Download:  20,
+			Upload:    2,
+		},
+		{
+			NodeID:    node1.ID,
+			Timestamp: 1533168000, // 2018-08-02 00:00:00
+			Download:  60,
+			Upload:    6,
+		},
+	}
+
+	for _, i := range logs {
+		db.PutItem(&i)
+	}
+
+	reportDate, _ := StringDateToTime("2018-08-02")
+
+	_, err := GenerateDailySnapshots(reportDate, 2, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	snapsCreated, err := GenerateRollupSnapshots(reportDate, 2, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if snapsCreated != 2 {
+		t.Errorf("Wrong number of rollup snapshots created, expected 2, got %v", snapsCreated)
+	}
+
+	weekStart, weekEnd, _ := GetStartEndTimestampsForInterval(reportDate, domain.ReportingIntervalWeekly)
+	weeklies, err := db.GetSnapshotsForRange(domain.ReportingIntervalWeekly, node1.ID, weekStart, weekEnd)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(weeklies) != 1 {
+		t.Errorf("Wrong number of weekly snapshots found, expected 1, got %v", len(weeklies))
+		return
+	}
+
+	if weeklies[0].DownloadAvg != 30 {
+		t.Errorf("Weekly download avg not as expected (30), got: %v", weeklies[0].DownloadAvg)
+	}
+	if weeklies[0].SpeedTestDataPoints != 3 {
+		t.Errorf("Weekly speed test data points not as expected (3), got: %v", weeklies[0].SpeedTestDataPoints)
+	}
+
+	// The daily medians are 15 and 60, but the median of the week's logs is 20
+	if weeklies[0].DownloadP50 != 20 {
+		t.Errorf("Weekly download p50 not as expected (20), got: %v", weeklies[0].DownloadP50)
+	}
+
+	monthStart, monthEnd, _ := GetStartEndTimestampsForInterval(reportDate, domain.ReportingIntervalMonthly)
+	monthlies, err := db.GetSnapshotsForRange(domain.ReportingIntervalMonthly, node1.ID, monthStart, monthEnd)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(monthlies) != 1 {
+		t.Errorf("Wrong number of monthly snapshots found, expected 1, got %v", len(monthlies))
+		return
+	}
+
+	if monthlies[0].UploadMax != 6 || monthlies[0].UploadMin != 1 {
+		t.Errorf("Monthly upload min/max not as expected (1/6), got: %v/%v", monthlies[0].UploadMin, monthlies[0].UploadMax)
+	}
+
+	// The periods are long over, so without ForceOverwrite the rollups should not be regenerated
+	snapsCreated, err = GenerateRollupSnapshots(reportDate, 2, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if snapsCreated != 0 {
+		t.Errorf("Rollup snapshots for completed periods should not be regenerated, but %v were", snapsCreated)
+	}
+
+	snapsCreated, err = GenerateRollupSnapshots(reportDate, 2, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if snapsCreated != 2 {
+		t.Errorf("Rollup snapshots should be regenerated with ForceOverwrite, expected 2, got %v", snapsCreated)
+	}
+}
@@ -0,0 +1,231 @@
+package reporting
+
+import (
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"sort"
+	"time"
+)
+
+// slaMeasurement is one value that gets checked against an SLA target, covering the time from Start to End
+type slaMeasurement struct {
+	Start int64
+	End   int64
+	Value float64
+}
+
+// GetSLAComplianceReport checks each of the nodes against the SLA's targets for the month that contains the date.
+// Speed test and ping targets are checked against the unflagged raw logs for the month in each node's timezone,
+// and availability against the daily snapshots.
+func GetSLAComplianceReport(sla domain.SLA, nodes []domain.Node, month time.Time) (domain.SLAComplianceReport, error) {
+	startTime, endTime, err := GetStartEndTimestampsForInterval(month, domain.ReportingIntervalMonthly)
+	if err != nil {
+		return domain.SLAComplianceReport{}, err
+	}
+
+	report := domain.SLAComplianceReport{
+		SLAID:    sla.ID,
+		Name:     sla.Name,
+		Provider: sla.Provider,
+		Month:    time.Unix(startTime, 0).UTC().Format(domain.SLAMonthLayout),
+		Start:    time.Unix(startTime, 0).UTC().Format(domain.DateLayout),
+		End:      time.Unix(endTime, 0).UTC().Format(domain.DateLayout),
+		Rows:     []domain.SLAComplianceRow{},
+		Breaches: []domain.SLABreachPeriod{},
+	}
+
+	firstDay := time.Unix(startTime, 0).UTC()
+	lastDay := time.Unix(endTime, 0).UTC()
+
+	for _, node := range nodes {
+		// The month runs from midnight to midnight in the node's timezone
+		nodeStartTime, _, err := GetStartEndTimestampsForDateInLocation(firstDay, "", "", node.GetLocation())
+		if err != nil {
+			return report, err
+		}
+
+		_, nodeEndTime, err := GetStartEndTimestampsForDateInLocation(lastDay, "", "", node.GetLocation())
+		if err != nil {
+			return report, err
+		}
+
+		var speedLogs []domain.TaskLogSpeedTest
+		err = db.GetUnflaggedTaskLogForRange(&speedLogs, node.ID, nodeStartTime, nodeEndTime)
+		if err != nil {
+			return report, err
+		}
+
+		var pingLogs []domain.TaskLogPingTest
+		err = db.GetUnflaggedTaskLogForRange(&pingLogs, node.ID, nodeStartTime, nodeEndTime)
+		if err != nil {
+			return report, err
+		}
+
+		// Daily snapshots are already for the node's local days and are keyed by the date at midnight UTC
+		snapshots, err := db.GetSnapshotsForRange(domain.ReportingIntervalDaily, node.ID, startTime, endTime)
+		if err != nil {
+			return report, err
+		}
+
+		rows, breaches := BuildSLANodeCompliance(sla, node, speedLogs, pingLogs, snapshots)
+		report.Rows = append(report.Rows, rows...)
+		report.Breaches = append(report.Breaches, breaches...)
+	}
+
+	return report, nil
+}
+
+// BuildSLANodeCompliance checks the node's logs and daily snapshots against each of the SLA's targets that are set.
+// Returns a row for each target, in a fixed order, along with the periods in which the target was missed.
+func BuildSLANodeCompliance(
+	sla domain.SLA,
+	node domain.Node,
+	speedLogs []domain.TaskLogSpeedTest,
+	pingLogs []domain.TaskLogPingTest,
+	snapshots []domain.ReportingSnapshot,
+) ([]domain.SLAComplianceRow, []domain.SLABreachPeriod) {
+	measurements := map[string][]slaMeasurement{}
+
+	for _, l := range speedLogs {
+		measurements[domain.SLAMetricDownload] = append(measurements[domain.SLAMetricDownload],
+			slaMeasurement{Start: l.Timestamp, End: l.Timestamp, Value: l.Download})
+		measurements[domain.SLAMetricUpload] = append(measurements[domain.SLAMetricUpload],
+			slaMeasurement{Start: l.Timestamp, End: l.Timestamp, Value: l.Upload})
+	}
+
+	for _, l := range pingLogs {
+		// There is no latency to check when every packet was lost
+		if l.PacketLossPercent < 100 {
+			measurements[domain.SLAMetricLatency] = append(measurements[domain.SLAMetricLatency],
+				slaMeasurement{Start: l.Timestamp, End: l.Timestamp, Value: l.Latency})
+		}
+		measurements[domain.SLAMetricPacketLoss] = append(measurements[domain.SLAMetricPacketLoss],
+			slaMeasurement{Start: l.Timestamp, End: l.Timestamp, Value: l.PacketLossPercent})
+	}
+
+	for _, s := range snapshots {
+		measurements[domain.SLAMetricAvailability] = append(measurements[domain.SLAMetricAvailability],
+			slaMeasurement{Start: s.Timestamp, End: s.Timestamp + domain.SecondsPerDay - 1, Value: getDailyAvailability(s)})
+	}
+
+	rows := []domain.SLAComplianceRow{}
+	breaches := []domain.SLABreachPeriod{}
+	targets := sla.GetTargets()
+
+	metrics := []string{
+		domain.SLAMetricDownload,
+		domain.SLAMetricUpload,
+		domain.SLAMetricLatency,
+		domain.SLAMetricPacketLoss,
+		domain.SLAMetricAvailability,
+	}
+
+	for _, metric := range metrics {
+		target, ok := targets[metric]
+		if !ok {
+			continue
+		}
+
+		row := domain.SLAComplianceRow{
+			NodeID:   node.ID,
+			Nickname: node.Nickname,
+			MacAddr:  node.MacAddr,
+			Metric:   metric,
+			Target:   target,
+		}
+
+		metricBreaches := checkSLAMeasurements(sla, metric, measurements[metric], &row)
+		for i := range metricBreaches {
+			metricBreaches[i].NodeID = node.ID
+			metricBreaches[i].Nickname = node.Nickname
+			metricBreaches[i].MacAddr = node.MacAddr
+		}
+
+		rows = append(rows, row)
+		breaches = append(breaches, metricBreaches...)
+	}
+
+	return rows, breaches
+}
+
+// checkSLAMeasurements fills in the row's values for the measurements and returns the periods of consecutive
+// measurements that missed the target
+func checkSLAMeasurements(
+	sla domain.SLA,
+	metric string,
+	measurements []slaMeasurement,
+	row *domain.SLAComplianceRow,
+) []domain.SLABreachPeriod {
+	breaches := []domain.SLABreachPeriod{}
+	if len(measurements) == 0 {
+		return breaches
+	}
+
+	sort.Slice(measurements, func(i, j int) bool { return measurements[i].Start < measurements[j].Start })
+
+	var total float64
+	var metCount int64
+	var breach *domain.SLABreachPeriod
+
+	for _, m := range measurements {
+		total += m.Value
+
+		if sla.IsMet(metric, m.Value) {
+			metCount++
+			if breach != nil {
+				breaches = append(breaches, *breach)
+				breach = nil
+			}
+			continue
+		}
+
+		if breach == nil {
+			breach = &domain.SLABreachPeriod{
+				Metric:     metric,
+				Target:     row.Target,
+				Start:      m.Start,
+				WorstValue: m.Value,
+			}
+		} else if isWorseSLAValue(metric, m.Value, breach.WorstValue) {
+			breach.WorstValue = m.Value
+		}
+
+		breach.End = m.End
+		breach.Measurements++
+	}
+
+	if breach != nil {
+		breaches = append(breaches, *breach)
+	}
+
+	row.Measurements = int64(len(measurements))
+	row.Average = total / float64(len(measurements))
+	row.CompliancePercent = float64(metCount) / float64(len(measurements)) * 100
+	row.BreachPeriods = int64(len(breaches))
+
+	return breaches
+}
+
+// isWorseSLAValue returns true if the value is further from meeting the metric's target than the other value
+func isWorseSLAValue(metric string, value, other float64) bool {
+	if metric == domain.SLAMetricLatency || metric == domain.SLAMetricPacketLoss {
+		return value > other
+	}
+
+	return value < other
+}
+
+// getDailyAvailability returns the percentage of the snapshot's day that the node was available.
+// Snapshots from before availability was tracked only have the network downtime to go on.
+func getDailyAvailability(snapshot domain.ReportingSnapshot) float64 {
+	if snapshot.MonitoredSeconds > 0 {
+		return snapshot.AvailabilityPercent
+	}
+
+	downtime := snapshot.NetworkDowntimeSeconds
+	if downtime > domain.SecondsPerDay {
+		downtime = domain.SecondsPerDay
+	}
+
+	return float64(domain.SecondsPerDay-downtime) / domain.SecondsPerDay * 100
+}
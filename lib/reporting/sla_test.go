@@ -0,0 +1,87 @@
+package reporting
+
+import (
+	"github.com/silinternational/speed-snitch-admin-api"
+	"testing"
+)
+
+func TestBuildSLANodeCompliance(t *testing.T) {
+	sla := domain.SLA{
+		MinDownload:     50,
+		MaxPacketLoss:   1,
+		MinAvailability: 99,
+	}
+
+	node := domain.Node{MacAddr: "aa:aa:aa:aa:aa:aa", Nickname: "test node"}
+	node.ID = 1
+
+	speedLogs := []domain.TaskLogSpeedTest{
+		{Timestamp: 1000, Download: 60},
+		{Timestamp: 3000, Download: 20}, // Out of order to make sure they get sorted
+		{Timestamp: 2000, Download: 40},
+		{Timestamp: 4000, Download: 55},
+		{Timestamp: 5000, Download: 10},
+	}
+
+	pingLogs := []domain.TaskLogPingTest{
+		{Timestamp: 1000, Latency: 10, PacketLossPercent: 0},
+		{Timestamp: 2000, Latency: 0, PacketLossPercent: 100},
+	}
+
+	snapshots := []domain.ReportingSnapshot{
+		{Timestamp: 1527811200, NetworkDowntimeSeconds: 0},
+		{Timestamp: 1527897600, NetworkDowntimeSeconds: 8640}, // 90% available
+	}
+
+	rows, breaches := BuildSLANodeCompliance(sla, node, speedLogs, pingLogs, snapshots)
+
+	// Only the targets that are set get checked
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %v: %+v", len(rows), rows)
+	}
+
+	download := rows[0]
+	if download.Metric != domain.SLAMetricDownload || download.Measurements != 5 || download.Average != 37 {
+		t.Errorf("Download row not as expected. Got %+v", download)
+	}
+	if download.CompliancePercent != 40 || download.BreachPeriods != 2 || download.NodeID != node.ID {
+		t.Errorf("Download compliance not as expected. Got %+v", download)
+	}
+
+	packetLoss := rows[1]
+	if packetLoss.Metric != domain.SLAMetricPacketLoss || packetLoss.CompliancePercent != 50 {
+		t.Errorf("Packet loss row not as expected. Got %+v", packetLoss)
+	}
+
+	availability := rows[2]
+	if availability.Metric != domain.SLAMetricAvailability || availability.Average != 95 ||
+		availability.CompliancePercent != 50 {
+		t.Errorf("Availability row not as expected. Got %+v", availability)
+	}
+
+	if len(breaches) != 4 {
+		t.Fatalf("Expected 4 breach periods, got %v: %+v", len(breaches), breaches)
+	}
+
+	first := breaches[0]
+	if first.Start != 2000 || first.End != 3000 || first.Measurements != 2 || first.WorstValue != 20 {
+		t.Errorf("First download breach not as expected. Got %+v", first)
+	}
+	if first.MacAddr != node.MacAddr || first.Target != 50 {
+		t.Errorf("First download breach is missing the node or target. Got %+v", first)
+	}
+
+	second := breaches[1]
+	if second.Start != 5000 || second.End != 5000 || second.Measurements != 1 || second.WorstValue != 10 {
+		t.Errorf("Second download breach not as expected. Got %+v", second)
+	}
+
+	if breaches[2].Metric != domain.SLAMetricPacketLoss || breaches[2].WorstValue != 100 {
+		t.Errorf("Packet loss breach not as expected. Got %+v", breaches[2])
+	}
+
+	dayBreach := breaches[3]
+	if dayBreach.Start != 1527897600 || dayBreach.End != 1527983999 || dayBreach.WorstValue != 90 {
+		t.Errorf("Availability breach not as expected. Got %+v", dayBreach)
+	}
+}
@@ -52,28 +52,44 @@ func GenerateDailySnapshotsForDate(date time.Time, forceOverwrite bool) (int64,
 	return snapshotsCreated, nil
 }
 
-// Generates daily snapshot for the given node/date. If snapshot already exists for node/date it will not be
-// regenerated or overwritten unless forceOverwrite is true
+// Generates daily snapshot for the given node/date. The logs for the day are those from midnight to midnight
+// in the node's timezone, but the snapshot's timestamp is always midnight UTC of the date, so snapshots
+// for the same date line up across nodes. If snapshot already exists for node/date it will not be
+// regenerated or overwritten unless forceOverwrite is true or if the node's day had not yet ended when it
+// was last generated.
 // Returns true/false for if a snapshot was created along with an error if one is present
 func GenerateDailySnapshotForNodeForDate(node domain.Node, date time.Time, forceOverwrite bool) (bool, error) {
-	startTime, endTime, err := GetStartEndTimestampsForDate(date, "", "")
+	snapshotTime, _, err := GetStartEndTimestampsForDate(date, "", "")
+	if err != nil {
+		return false, err
+	}
+
+	startTime, endTime, err := GetStartEndTimestampsForDateInLocation(date, "", "", node.GetLocation())
 	if err != nil {
 		return false, err
 	}
 
 	// check for existing snapshot to update, or create new one
 	snapshot := domain.ReportingSnapshot{
-		Timestamp: startTime,
+		Timestamp: snapshotTime,
 		NodeID:    node.ID,
 		Interval:  domain.ReportingIntervalDaily,
 	}
 	err = db.FindOne(&snapshot)
 	if !gorm.IsRecordNotFoundError(err) && err != nil {
 		return false, err
-	} else if snapshot.ID != 0 && !forceOverwrite {
+	} else if snapshot.ID != 0 && !forceOverwrite && snapshot.UpdatedAt.Unix() > endTime {
 		return false, nil
 	}
 
+	// Start from scratch, so nothing is left over from when it was last generated
+	snapshot = domain.ReportingSnapshot{
+		Model:     snapshot.Model,
+		Timestamp: snapshotTime,
+		NodeID:    node.ID,
+		Interval:  domain.ReportingIntervalDaily,
+	}
+
 	err = hydrateSnapshotWithPingLogs(&snapshot, node, startTime, endTime)
 	if err != nil {
 		return false, err
@@ -101,7 +117,7 @@ func GenerateDailySnapshotForNodeForDate(node domain.Node, date time.Time, force
 
 	// Process network outages
 	var outages []domain.TaskLogNetworkDowntime
-	err = db.GetTaskLogForRange(&outages, node.ID, startTime, endTime)
+	err = db.GetUnflaggedTaskLogForRange(&outages, node.ID, startTime, endTime)
 	if err != nil {
 		return false, err
 	}
@@ -112,6 +128,11 @@ func GenerateDailySnapshotForNodeForDate(node domain.Node, date time.Time, force
 
 	snapshot.NetworkOutagesCount = int64(len(outages))
 
+	err = hydrateSnapshotWithAvailability(&snapshot, node, outages, startTime, endTime)
+	if err != nil {
+		return false, err
+	}
+
 	err = db.PutItem(&snapshot)
 	if err != nil {
 		return false, err
@@ -127,7 +148,7 @@ func hydrateSnapshotWithPingLogs(
 ) error {
 
 	var pingLogs []domain.TaskLogPingTest
-	err := db.GetTaskLogForRange(&pingLogs, node.ID, startTime, endTime)
+	err := db.GetUnflaggedTaskLogForRange(&pingLogs, node.ID, startTime, endTime)
 	if err != nil {
 		return err
 	}
@@ -158,6 +179,20 @@ func hydrateSnapshotWithPingLogs(
 		}
 	}
 
+	latencyValues := []float64{}
+	packetLossValues := []float64{}
+	for _, l := range pingLogs {
+		latencyValues = append(latencyValues, l.Latency)
+		packetLossValues = append(packetLossValues, l.PacketLossPercent)
+	}
+
+	snapshot.LatencyP50 = GetPercentile(latencyValues, 50)
+	snapshot.LatencyP90 = GetPercentile(latencyValues, 90)
+	snapshot.LatencyP95 = GetPercentile(latencyValues, 95)
+	snapshot.PacketLossP50 = GetPercentile(packetLossValues, 50)
+	snapshot.PacketLossP90 = GetPercentile(packetLossValues, 90)
+	snapshot.PacketLossP95 = GetPercentile(packetLossValues, 95)
+
 	snapshot.LatencyAvg = snapshot.LatencyTotal / floatLogCount
 	snapshot.PacketLossAvg = snapshot.PacketLossTotal / floatLogCount
 	snapshot.LatencyDataPoints = int64(len(pingLogs))
@@ -171,7 +206,7 @@ func hydrateSnapshotWithBusinessHourPingLogs(
 	businessStartTimestamp, businessCloseTimestamp int64,
 ) error {
 	var pingLogs []domain.TaskLogPingTest
-	err := db.GetTaskLogForRange(&pingLogs, node.ID, businessStartTimestamp, businessCloseTimestamp)
+	err := db.GetUnflaggedTaskLogForRange(&pingLogs, node.ID, businessStartTimestamp, businessCloseTimestamp)
 	if err != nil {
 		return err
 	}
@@ -202,6 +237,20 @@ func hydrateSnapshotWithBusinessHourPingLogs(
 		}
 	}
 
+	latencyValues := []float64{}
+	packetLossValues := []float64{}
+	for _, l := range pingLogs {
+		latencyValues = append(latencyValues, l.Latency)
+		packetLossValues = append(packetLossValues, l.PacketLossPercent)
+	}
+
+	snapshot.BizLatencyP50 = GetPercentile(latencyValues, 50)
+	snapshot.BizLatencyP90 = GetPercentile(latencyValues, 90)
+	snapshot.BizLatencyP95 = GetPercentile(latencyValues, 95)
+	snapshot.BizPacketLossP50 = GetPercentile(packetLossValues, 50)
+	snapshot.BizPacketLossP90 = GetPercentile(packetLossValues, 90)
+	snapshot.BizPacketLossP95 = GetPercentile(packetLossValues, 95)
+
 	snapshot.BizLatencyAvg = snapshot.BizLatencyTotal / floatLogCount
 	snapshot.BizPacketLossAvg = snapshot.BizPacketLossTotal / floatLogCount
 	snapshot.BizLatencyDataPoints = int64(len(pingLogs))
@@ -215,7 +264,7 @@ func hydrateSnapshotWithSpeedTestLogs(
 	startTime, endTime int64,
 ) error {
 	var speedLogs []domain.TaskLogSpeedTest
-	err := db.GetTaskLogForRange(&speedLogs, node.ID, startTime, endTime)
+	err := db.GetUnflaggedTaskLogForRange(&speedLogs, node.ID, startTime, endTime)
 	if err != nil {
 		return err
 	}
@@ -246,6 +295,20 @@ func hydrateSnapshotWithSpeedTestLogs(
 		}
 	}
 
+	downloadValues := []float64{}
+	uploadValues := []float64{}
+	for _, l := range speedLogs {
+		downloadValues = append(downloadValues, l.Download)
+		uploadValues = append(uploadValues, l.Upload)
+	}
+
+	snapshot.DownloadP50 = GetPercentile(downloadValues, 50)
+	snapshot.DownloadP90 = GetPercentile(downloadValues, 90)
+	snapshot.DownloadP95 = GetPercentile(downloadValues, 95)
+	snapshot.UploadP50 = GetPercentile(uploadValues, 50)
+	snapshot.UploadP90 = GetPercentile(uploadValues, 90)
+	snapshot.UploadP95 = GetPercentile(uploadValues, 95)
+
 	snapshot.DownloadAvg = snapshot.DownloadTotal / floatLogCount
 	snapshot.UploadAvg = snapshot.UploadTotal / floatLogCount
 	snapshot.SpeedTestDataPoints = int64(len(speedLogs))
@@ -259,7 +322,7 @@ func hydrateSnapshotWithBusinessHourSpeedTestLogs(
 ) error {
 
 	var speedLogs []domain.TaskLogSpeedTest
-	err := db.GetTaskLogForRange(&speedLogs, node.ID, businessStartTimestamp, businessCloseTimestamp)
+	err := db.GetUnflaggedTaskLogForRange(&speedLogs, node.ID, businessStartTimestamp, businessCloseTimestamp)
 	if err != nil {
 		return err
 	}
@@ -290,6 +353,20 @@ func hydrateSnapshotWithBusinessHourSpeedTestLogs(
 		}
 	}
 
+	downloadValues := []float64{}
+	uploadValues := []float64{}
+	for _, l := range speedLogs {
+		downloadValues = append(downloadValues, l.Download)
+		uploadValues = append(uploadValues, l.Upload)
+	}
+
+	snapshot.BizDownloadP50 = GetPercentile(downloadValues, 50)
+	snapshot.BizDownloadP90 = GetPercentile(downloadValues, 90)
+	snapshot.BizDownloadP95 = GetPercentile(downloadValues, 95)
+	snapshot.BizUploadP50 = GetPercentile(uploadValues, 50)
+	snapshot.BizUploadP90 = GetPercentile(uploadValues, 90)
+	snapshot.BizUploadP95 = GetPercentile(uploadValues, 95)
+
 	snapshot.BizDownloadAvg = snapshot.BizDownloadTotal / floatLogCount
 	snapshot.BizUploadAvg = snapshot.BizUploadTotal / floatLogCount
 	snapshot.BizSpeedTestDataPoints = int64(len(speedLogs))
@@ -308,10 +385,22 @@ func hydrateSnapshotWithBusinessHourLogs(
 		return nil
 	}
 
-	businessStartTimestamp, businessCloseTimestamp, err := GetStartEndTimestampsForDate(
+	excludedReason, err := getBizExcludedReason(node, date)
+	if err != nil {
+		return err
+	}
+
+	if excludedReason != "" {
+		snapshot.BizExcludedDays = 1
+		snapshot.BizExcludedReason = excludedReason
+		return nil
+	}
+
+	businessStartTimestamp, businessCloseTimestamp, err := GetStartEndTimestampsForDateInLocation(
 		date,
 		fmt.Sprintf("%s:00", node.BusinessStartTime),
 		fmt.Sprintf("%s:00", node.BusinessCloseTime),
+		node.GetLocation(),
 	)
 
 	if err != nil {
@@ -339,7 +428,7 @@ func hydrateSnapshotWithBusinessHourLogs(
 
 	// Process network outages
 	var outages []domain.TaskLogNetworkDowntime
-	err = db.GetTaskLogForRange(&outages, node.ID, businessStartTimestamp, businessCloseTimestamp)
+	err = db.GetUnflaggedTaskLogForRange(&outages, node.ID, businessStartTimestamp, businessCloseTimestamp)
 	if err != nil {
 		return err
 	}
@@ -351,3 +440,24 @@ func hydrateSnapshotWithBusinessHourLogs(
 	snapshot.BizNetworkOutagesCount = int64(len(outages))
 	return nil
 }
+
+// getBizExcludedReason returns why the date is not a business day for the node, either because it's not one
+// of the node's working days or because it's a holiday. Returns an empty string if it is a business day.
+func getBizExcludedReason(node domain.Node, date time.Time) (string, error) {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+
+	if !domain.IsWorkingDay(node.GetWorkingDays(), day.Weekday()) {
+		return fmt.Sprintf("Non-working day: %s", day.Weekday()), nil
+	}
+
+	holidays, err := db.GetHolidaysForDate(node.ID, day.Format(domain.DateLayout))
+	if err != nil {
+		return "", err
+	}
+
+	if len(holidays) > 0 {
+		return fmt.Sprintf("Holiday: %s", holidays[0].Name), nil
+	}
+
+	return "", nil
+}
@@ -160,6 +160,12 @@ func TestGenerateDailySnapshotsForDateNoBusinessHours(t *testing.T) {
 	if snap.UploadMax != 40.0 {
 		t.Errorf("Daily upload max not as expected (40.0), got: %v", snap.UploadMax)
 	}
+	if snap.UploadP50 != 25.0 || snap.DownloadP50 != 25.0 {
+		t.Errorf("Daily upload and download medians not as expected (25.0), got: %v and %v", snap.UploadP50, snap.DownloadP50)
+	}
+	if snap.LatencyP50 != 10.0 {
+		t.Errorf("Daily latency median not as expected (10.0), got: %v", snap.LatencyP50)
+	}
 	if snap.PacketLossAvg != 2.0 {
 		t.Errorf("Daily packet loss avg not as expected (2.0), got: %v", snap.PacketLossAvg)
 	}
@@ -416,6 +422,192 @@ func TestGenerateDailySnapshotsForDateWithBusinessHours(t *testing.T) {
 
 }
 
+func TestGenerateDailySnapshotsForDateInTimezone(t *testing.T) {
+	testutils.ResetDb(t)
+
+	// In June, New York is at UTC-4, so the node's 2018-06-04 runs from 1528084800 to 1528171199
+	// and its business hours that day run from 1528164000 to 1528192800
+	node1 := domain.Node{
+		Model: gorm.Model{
+			ID: 1,
+		},
+		MacAddr:           "aa:aa:aa:aa:aa:aa",
+		BusinessStartTime: "22:00",
+		BusinessCloseTime: "06:00",
+		Timezone:          "America/New_York",
+	}
+	db.PutItem(&node1)
+
+	speedFixtures := []domain.TaskLogSpeedTest{
+		{ // The previous day in New York
+			NodeID:    node1.ID,
+			Timestamp: 1528083000, // 2018-06-03 23:30 in New York
+			Upload:    10.0,
+			Download:  10.0,
+		},
+		{ // Not in business hours
+			NodeID:    node1.ID,
+			Timestamp: 1528100000, // 2018-06-04 08:53 in New York
+			Upload:    10.0,
+			Download:  10.0,
+		},
+		{ // In business hours
+			NodeID:    node1.ID,
+			Timestamp: 1528165000, // 2018-06-04 22:16 in New York
+			Upload:    20.0,
+			Download:  20.0,
+		},
+		{ // In business hours that started the day before
+			NodeID:    node1.ID,
+			Timestamp: 1528180000, // 2018-06-05 02:26 in New York
+			Upload:    30.0,
+			Download:  30.0,
+		},
+	}
+
+	for _, i := range speedFixtures {
+		db.PutItem(&i)
+	}
+
+	date, _ := StringDateToTime("2018-06-04")
+	count, err := GenerateDailySnapshotsForDate(date, false)
+	if err != nil {
+		t.Error("Unable to generate daily snapshots:", err)
+		return
+	}
+
+	if count != 1 {
+		t.Error("Wrong number of snapshots created, should have created 1, got:", count)
+	}
+
+	// The snapshot is still keyed by midnight UTC of the date
+	results, err := db.GetSnapshotsForRange(domain.ReportingIntervalDaily, node1.ID, 1528070400, 1528070400)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(results) != 1 {
+		t.Error("Wrong number of results returned, got ", len(results), "expected 1")
+		return
+	}
+
+	snap := results[0]
+
+	if snap.SpeedTestDataPoints != 2 || snap.DownloadAvg != 15.0 {
+		t.Errorf("Expected 2 speed tests averaging 15.0 in the day, got %v averaging %v",
+			snap.SpeedTestDataPoints, snap.DownloadAvg)
+	}
+
+	if snap.BizSpeedTestDataPoints != 2 || snap.BizDownloadAvg != 25.0 {
+		t.Errorf("Expected 2 speed tests averaging 25.0 in business hours, got %v averaging %v",
+			snap.BizSpeedTestDataPoints, snap.BizDownloadAvg)
+	}
+}
+
+func TestGenerateDailySnapshotsExcludesNonBusinessDays(t *testing.T) {
+	testutils.ResetDb(t)
+
+	// 2018-06-04 was a Monday
+	nodeOffMondays := domain.Node{
+		Model: gorm.Model{
+			ID: 1,
+		},
+		MacAddr:           "aa:aa:aa:aa:aa:aa",
+		BusinessStartTime: "08:00",
+		BusinessCloseTime: "17:00",
+		WorkingDays:       "Tue,Wed,Thu,Fri",
+	}
+	db.PutItem(&nodeOffMondays)
+
+	nodeWithHoliday := domain.Node{
+		Model: gorm.Model{
+			ID: 2,
+		},
+		MacAddr:           "bb:bb:bb:bb:bb:bb",
+		BusinessStartTime: "08:00",
+		BusinessCloseTime: "17:00",
+	}
+	db.PutItem(&nodeWithHoliday)
+
+	nodeWorking := domain.Node{
+		Model: gorm.Model{
+			ID: 3,
+		},
+		MacAddr:           "cc:cc:cc:cc:cc:cc",
+		BusinessStartTime: "08:00",
+		BusinessCloseTime: "17:00",
+		WorkingDays:       "Mon,Tue,Wed,Thu,Fri",
+	}
+	db.PutItem(&nodeWorking)
+
+	holiday := domain.ReportingEvent{
+		NodeID:    nodeWithHoliday.ID,
+		Date:      "2018-06-04",
+		Timestamp: 1528070400,
+		Name:      "Founders Day",
+		IsHoliday: true,
+	}
+	db.PutItem(&holiday)
+
+	for _, node := range []domain.Node{nodeOffMondays, nodeWithHoliday, nodeWorking} {
+		speedTest := domain.TaskLogSpeedTest{
+			NodeID:    node.ID,
+			Timestamp: 1528110000, // 11:00
+			Upload:    10.0,
+			Download:  40.0,
+		}
+		db.PutItem(&speedTest)
+	}
+
+	date, _ := StringDateToTime("2018-06-04")
+	_, err := GenerateDailySnapshotsForDate(date, false)
+	if err != nil {
+		t.Error("Unable to generate daily snapshots:", err)
+		return
+	}
+
+	fixtures := []struct {
+		node           domain.Node
+		excludedReason string
+	}{
+		{node: nodeOffMondays, excludedReason: "Non-working day: Monday"},
+		{node: nodeWithHoliday, excludedReason: "Holiday: Founders Day"},
+		{node: nodeWorking, excludedReason: ""},
+	}
+
+	for _, fix := range fixtures {
+		results, err := db.GetSnapshotsForRange(domain.ReportingIntervalDaily, fix.node.ID, 1528070400, 1528070400)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(results) != 1 {
+			t.Errorf("Expected 1 snapshot for node %v, got %v", fix.node.ID, len(results))
+			continue
+		}
+
+		snap := results[0]
+		if snap.BizExcludedReason != fix.excludedReason {
+			t.Errorf("Node %v: expected excluded reason %q, got %q", fix.node.ID, fix.excludedReason, snap.BizExcludedReason)
+		}
+
+		// The whole day's metrics are always there
+		if snap.SpeedTestDataPoints != 1 {
+			t.Errorf("Node %v: expected 1 speed test data point, got %v", fix.node.ID, snap.SpeedTestDataPoints)
+		}
+
+		if fix.excludedReason != "" {
+			if snap.BizExcludedDays != 1 || snap.BizSpeedTestDataPoints != 0 {
+				t.Errorf("Node %v: expected the day to be excluded from business metrics, got %v excluded days and %v data points",
+					fix.node.ID, snap.BizExcludedDays, snap.BizSpeedTestDataPoints)
+			}
+		} else if snap.BizExcludedDays != 0 || snap.BizSpeedTestDataPoints != 1 {
+			t.Errorf("Node %v: expected the day to be included in business metrics, got %v excluded days and %v data points",
+				fix.node.ID, snap.BizExcludedDays, snap.BizSpeedTestDataPoints)
+		}
+	}
+}
+
 func TestGenerateDailySnapshotsForThreeDaysForTwoNodes(t *testing.T) {
 	testutils.ResetDb(t)
 
@@ -0,0 +1,79 @@
+package reporting
+
+import (
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"sort"
+	"time"
+)
+
+// GetTagReport combines the snapshots of the tag's approved nodes into one entry per period
+func GetTagReport(tag domain.Tag, interval string, startTimestamp, endTimestamp int64) ([]domain.TagReportPeriod, error) {
+	nodeIDs := []uint{}
+	for _, node := range tag.Nodes {
+		if node.IsApproved() {
+			nodeIDs = append(nodeIDs, node.ID)
+		}
+	}
+
+	snapshots, err := db.GetSnapshotsForNodesForRange(interval, nodeIDs, startTimestamp, endTimestamp)
+	if err != nil {
+		return []domain.TagReportPeriod{}, err
+	}
+
+	return BuildTagReport(interval, snapshots), nil
+}
+
+// BuildTagReport groups the snapshots by their timestamp and rolls up each group, in chronological order
+func BuildTagReport(interval string, snapshots []domain.ReportingSnapshot) []domain.TagReportPeriod {
+	snapshotsByTimestamp := map[int64][]domain.ReportingSnapshot{}
+	timestamps := []int64{}
+
+	for _, s := range snapshots {
+		if _, ok := snapshotsByTimestamp[s.Timestamp]; !ok {
+			timestamps = append(timestamps, s.Timestamp)
+		}
+		snapshotsByTimestamp[s.Timestamp] = append(snapshotsByTimestamp[s.Timestamp], s)
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	periods := []domain.TagReportPeriod{}
+	for _, timestamp := range timestamps {
+		periodSnapshots := snapshotsByTimestamp[timestamp]
+		rollup := RollupSnapshots(periodSnapshots)
+
+		periods = append(periods, domain.TagReportPeriod{
+			Timestamp:                 timestamp,
+			Date:                      time.Unix(timestamp, 0).UTC().Format(domain.DateLayout),
+			Interval:                  interval,
+			NodeCount:                 len(periodSnapshots),
+			DownloadAvg:               rollup.DownloadAvg,
+			DownloadMax:               rollup.DownloadMax,
+			DownloadMin:               rollup.DownloadMin,
+			UploadAvg:                 rollup.UploadAvg,
+			UploadMax:                 rollup.UploadMax,
+			UploadMin:                 rollup.UploadMin,
+			LatencyAvg:                rollup.LatencyAvg,
+			LatencyMax:                rollup.LatencyMax,
+			LatencyMin:                rollup.LatencyMin,
+			PacketLossAvg:             rollup.PacketLossAvg,
+			PacketLossMax:             rollup.PacketLossMax,
+			SpeedTestDataPoints:       rollup.SpeedTestDataPoints,
+			LatencyDataPoints:         rollup.LatencyDataPoints,
+			NetworkDowntimeSeconds:    rollup.NetworkDowntimeSeconds,
+			NetworkOutagesCount:       rollup.NetworkOutagesCount,
+			RestartsCount:             rollup.RestartsCount,
+			UnavailableSeconds:        rollup.UnavailableSeconds,
+			AvailabilityPercent:       rollup.AvailabilityPercent,
+			BizDownloadAvg:            rollup.BizDownloadAvg,
+			BizUploadAvg:              rollup.BizUploadAvg,
+			BizLatencyAvg:             rollup.BizLatencyAvg,
+			BizPacketLossAvg:          rollup.BizPacketLossAvg,
+			BizNetworkDowntimeSeconds: rollup.BizNetworkDowntimeSeconds,
+			BizExcludedDays:           rollup.BizExcludedDays,
+		})
+	}
+
+	return periods
+}
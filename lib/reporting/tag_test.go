@@ -0,0 +1,34 @@
+package reporting
+
+import (
+	"github.com/silinternational/speed-snitch-admin-api"
+	"testing"
+)
+
+func TestBuildTagReport(t *testing.T) {
+	day1 := int64(1527811200) // 2018-06-01
+	day2 := int64(1527897600) // 2018-06-02
+
+	snapshots := []domain.ReportingSnapshot{
+		{NodeID: 1, Timestamp: day2, DownloadTotal: 10, DownloadMax: 10, DownloadMin: 10, SpeedTestDataPoints: 1},
+		{NodeID: 1, Timestamp: day1, DownloadTotal: 30, DownloadMax: 20, DownloadMin: 10, SpeedTestDataPoints: 2},
+		{NodeID: 2, Timestamp: day1, DownloadTotal: 60, DownloadMax: 40, DownloadMin: 20, SpeedTestDataPoints: 2},
+		{NodeID: 2, Timestamp: day2, NetworkDowntimeSeconds: 30},
+	}
+
+	results := BuildTagReport(domain.ReportingIntervalDaily, snapshots)
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 periods, but got %+v", results)
+	}
+
+	first := results[0]
+	if first.Date != "2018-06-01" || first.NodeCount != 2 || first.DownloadAvg != 22.5 || first.DownloadMax != 40 || first.DownloadMin != 10 {
+		t.Errorf("Bad first period. Got %+v", first)
+	}
+
+	second := results[1]
+	if second.Date != "2018-06-02" || second.NodeCount != 2 || second.DownloadAvg != 10 || second.NetworkDowntimeSeconds != 30 {
+		t.Errorf("Bad second period. Got %+v", second)
+	}
+}
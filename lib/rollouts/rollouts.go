@@ -0,0 +1,221 @@
+package rollouts
+
+import (
+	"fmt"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"github.com/silinternational/speed-snitch-admin-api/db"
+	"os"
+	"time"
+)
+
+const SecondsPerHour = 3600
+
+// CheckRollouts pauses each active rollout whose upgraded nodes have had too many errors or restarts, and moves
+// the others on to their next wave once the current one has run long enough.
+// Returns the rollouts that changed
+func CheckRollouts(now time.Time) ([]domain.Rollout, error) {
+	var rollouts []domain.Rollout
+	err := db.ListItems(&rollouts, "id asc")
+	if err != nil {
+		return []domain.Rollout{}, err
+	}
+
+	nodes, err := GetRolloutNodes()
+	if err != nil {
+		return []domain.Rollout{}, err
+	}
+
+	changedRollouts := []domain.Rollout{}
+
+	for _, rollout := range rollouts {
+		if rollout.Status != domain.RolloutStatusActive {
+			continue
+		}
+
+		progress, err := GetRolloutProgress(rollout, nodes, now.Unix())
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "error getting progress of rollout %v. err: %s", rollout.ID, err.Error())
+			return changedRollouts, err
+		}
+
+		if !UpdateRolloutState(&rollout, progress, now.Unix()) {
+			continue
+		}
+
+		// Don't save the associations that were loaded with the rollout
+		rollout.Version = domain.Version{}
+		rollout.CanaryTags = nil
+
+		err = db.PutItem(&rollout)
+		if err != nil {
+			return changedRollouts, err
+		}
+
+		changedRollouts = append(changedRollouts, rollout)
+	}
+
+	return changedRollouts, nil
+}
+
+// GetRolloutNodes returns the approved nodes that are set to the latest version, which are the ones
+// that rollouts upgrade
+func GetRolloutNodes() ([]domain.Node, error) {
+	var nodes []domain.Node
+	err := db.ListNodesByStatus(&nodes, domain.NodeStatusApproved, "id asc")
+	if err != nil {
+		return []domain.Node{}, err
+	}
+
+	rolloutNodes := []domain.Node{}
+	for _, node := range nodes {
+		if node.IsOnLatestVersion() {
+			rolloutNodes = append(rolloutNodes, node)
+		}
+	}
+
+	return rolloutNodes, nil
+}
+
+// GetRolloutProgress compares the version that each of the nodes is running with the rollout's version, and
+// works out the error and restart rates since the current wave started
+func GetRolloutProgress(rollout domain.Rollout, nodes []domain.Node, now int64) (domain.RolloutProgress, error) {
+	progress := BuildRolloutProgress(rollout, nodes)
+
+	upgradedNodeIDs := []uint{}
+	baselineNodeIDs := []uint{}
+	for _, n := range progress.Nodes {
+		if n.Upgraded {
+			upgradedNodeIDs = append(upgradedNodeIDs, n.NodeID)
+		} else {
+			baselineNodeIDs = append(baselineNodeIDs, n.NodeID)
+		}
+	}
+
+	var err error
+	progress.UpgradedErrorRate, err = getRatePerNodeDay(&domain.TaskLogError{}, upgradedNodeIDs, rollout.WaveStartedAt, now)
+	if err != nil {
+		return progress, err
+	}
+
+	progress.UpgradedRestartRate, err = getRatePerNodeDay(&domain.TaskLogRestart{}, upgradedNodeIDs, rollout.WaveStartedAt, now)
+	if err != nil {
+		return progress, err
+	}
+
+	progress.BaselineErrorRate, err = getRatePerNodeDay(&domain.TaskLogError{}, baselineNodeIDs, rollout.WaveStartedAt, now)
+	if err != nil {
+		return progress, err
+	}
+
+	progress.BaselineRestartRate, err = getRatePerNodeDay(&domain.TaskLogRestart{}, baselineNodeIDs, rollout.WaveStartedAt, now)
+	return progress, err
+}
+
+// BuildRolloutProgress counts the nodes that the rollout has reached and the ones that are running its version
+func BuildRolloutProgress(rollout domain.Rollout, nodes []domain.Node) domain.RolloutProgress {
+	progress := domain.RolloutProgress{
+		RolloutID:      rollout.ID,
+		Version:        rollout.Version.Number,
+		Status:         rollout.Status,
+		PausedReason:   rollout.PausedReason,
+		CurrentWave:    rollout.CurrentWave,
+		CurrentPercent: rollout.GetCurrentPercent(),
+		TotalNodes:     len(nodes),
+		Nodes:          []domain.RolloutNodeProgress{},
+	}
+
+	for _, node := range nodes {
+		nodeProgress := domain.RolloutNodeProgress{
+			NodeID:         node.ID,
+			Nickname:       node.Nickname,
+			MacAddr:        node.MacAddr,
+			RunningVersion: node.RunningVersion.Number,
+			Targeted:       rollout.IsNodeIncluded(node),
+			Upgraded:       node.RunningVersionID == rollout.VersionID,
+		}
+
+		if nodeProgress.Targeted {
+			progress.TargetedNodes++
+		}
+		if nodeProgress.Upgraded {
+			progress.UpgradedNodes++
+		} else if nodeProgress.Targeted {
+			progress.PendingNodes++
+		}
+
+		progress.Nodes = append(progress.Nodes, nodeProgress)
+	}
+
+	return progress
+}
+
+// UpdateRolloutState pauses an active rollout if the error or restart rate of the upgraded nodes has risen more
+// than allowed above that of the other nodes. Otherwise, once the current wave has run for the rollout's WaveHours,
+// it moves on to the next wave or, after the last one, is completed.
+// Returns true if the rollout changed
+func UpdateRolloutState(rollout *domain.Rollout, progress domain.RolloutProgress, now int64) bool {
+	if rollout.Status != domain.RolloutStatusActive {
+		return false
+	}
+
+	if progress.UpgradedNodes > 0 {
+		if rollout.MaxErrorRateIncrease > 0 &&
+			progress.UpgradedErrorRate > progress.BaselineErrorRate+rollout.MaxErrorRateIncrease {
+			rollout.Status = domain.RolloutStatusPaused
+			rollout.PausedReason = fmt.Sprintf(
+				"Upgraded nodes had %.2f errors per node per day, compared to %.2f for the other nodes",
+				progress.UpgradedErrorRate, progress.BaselineErrorRate)
+			return true
+		}
+
+		if rollout.MaxRestartRateIncrease > 0 &&
+			progress.UpgradedRestartRate > progress.BaselineRestartRate+rollout.MaxRestartRateIncrease {
+			rollout.Status = domain.RolloutStatusPaused
+			rollout.PausedReason = fmt.Sprintf(
+				"Upgraded nodes had %.2f restarts per node per day, compared to %.2f for the other nodes",
+				progress.UpgradedRestartRate, progress.BaselineRestartRate)
+			return true
+		}
+	}
+
+	waveHours := rollout.WaveHours
+	if waveHours < 1 {
+		waveHours = domain.DefaultRolloutWaveHours
+	}
+
+	if now-rollout.WaveStartedAt < waveHours*SecondsPerHour {
+		return false
+	}
+
+	if int(rollout.CurrentWave) >= len(rollout.GetWavePercentages()) {
+		rollout.Status = domain.RolloutStatusCompleted
+	} else {
+		rollout.CurrentWave++
+	}
+	rollout.WaveStartedAt = now
+
+	return true
+}
+
+// GetRatePerNodeDay returns how many times something happened per node per day. Periods of less than an hour
+// are counted as an hour, so that a single error right after a wave starts doesn't look like a huge rate.
+func GetRatePerNodeDay(count, nodeCount int, seconds int64) float64 {
+	if nodeCount == 0 {
+		return 0
+	}
+
+	if seconds < SecondsPerHour {
+		seconds = SecondsPerHour
+	}
+
+	return float64(count) / float64(nodeCount) / (float64(seconds) / domain.SecondsPerDay)
+}
+
+func getRatePerNodeDay(itemObj interface{}, nodeIDs []uint, startTime, endTime int64) (float64, error) {
+	count, err := db.CountTaskLogsForNodes(itemObj, nodeIDs, startTime, endTime)
+	if err != nil {
+		return 0, err
+	}
+
+	return GetRatePerNodeDay(count, len(nodeIDs), endTime-startTime), nil
+}
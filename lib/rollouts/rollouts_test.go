@@ -0,0 +1,159 @@
+package rollouts
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/silinternational/speed-snitch-admin-api"
+	"testing"
+)
+
+func TestBuildRolloutProgress(t *testing.T) {
+	canaryTag := domain.Tag{Model: gorm.Model{ID: 1}, Name: "canary"}
+	oldVersion := domain.Version{Model: gorm.Model{ID: 1}, Number: "1.0.0"}
+	newVersion := domain.Version{Model: gorm.Model{ID: 2}, Number: "1.1.0"}
+
+	rollout := domain.Rollout{
+		Version:     newVersion,
+		VersionID:   newVersion.ID,
+		CanaryTags:  []domain.Tag{canaryTag},
+		Waves:       "10,50,100",
+		CurrentWave: 0,
+		Status:      domain.RolloutStatusActive,
+	}
+
+	nodes := []domain.Node{
+		{
+			Model:            gorm.Model{ID: 1},
+			MacAddr:          "aa:aa:aa:aa:aa:01",
+			Tags:             []domain.Tag{canaryTag},
+			RunningVersion:   newVersion,
+			RunningVersionID: newVersion.ID,
+		},
+		{
+			Model:            gorm.Model{ID: 2},
+			MacAddr:          "aa:aa:aa:aa:aa:02",
+			Tags:             []domain.Tag{canaryTag},
+			RunningVersion:   oldVersion,
+			RunningVersionID: oldVersion.ID,
+		},
+		{
+			Model:            gorm.Model{ID: 3},
+			MacAddr:          "aa:aa:aa:aa:aa:03",
+			RunningVersion:   oldVersion,
+			RunningVersionID: oldVersion.ID,
+		},
+	}
+
+	progress := BuildRolloutProgress(rollout, nodes)
+
+	if progress.TotalNodes != 3 || progress.TargetedNodes != 2 || progress.UpgradedNodes != 1 || progress.PendingNodes != 1 {
+		t.Errorf("Rollout progress counts not as expected. Got %+v", progress)
+	}
+
+	if progress.Version != "1.1.0" || len(progress.Nodes) != 3 || progress.Nodes[2].Targeted || progress.Nodes[2].RunningVersion != "1.0.0" {
+		t.Errorf("Rollout node progress not as expected. Got %+v", progress.Nodes)
+	}
+
+	rollout.Status = domain.RolloutStatusCompleted
+	progress = BuildRolloutProgress(rollout, nodes)
+	if progress.TargetedNodes != 3 || progress.PendingNodes != 2 || progress.CurrentPercent != 100 {
+		t.Errorf("Expected a completed rollout to target every node. Got %+v", progress)
+	}
+}
+
+func TestUpdateRolloutState(t *testing.T) {
+	now := int64(1528145185)
+
+	fixtures := []struct {
+		name        string
+		rollout     domain.Rollout
+		progress    domain.RolloutProgress
+		wantChanged bool
+		wantStatus  string
+		wantWave    int64
+	}{
+		{
+			name: "the wave hasn't run long enough",
+			rollout: domain.Rollout{
+				Waves: "50,100", WaveHours: 24, WaveStartedAt: now - 3600, Status: domain.RolloutStatusActive,
+			},
+			wantChanged: false,
+			wantStatus:  domain.RolloutStatusActive,
+			wantWave:    0,
+		},
+		{
+			name: "on to the next wave",
+			rollout: domain.Rollout{
+				Waves: "50,100", WaveHours: 24, WaveStartedAt: now - 25*3600, Status: domain.RolloutStatusActive,
+			},
+			wantChanged: true,
+			wantStatus:  domain.RolloutStatusActive,
+			wantWave:    1,
+		},
+		{
+			name: "after the last wave",
+			rollout: domain.Rollout{
+				Waves: "50,100", WaveHours: 24, CurrentWave: 2, WaveStartedAt: now - 25*3600, Status: domain.RolloutStatusActive,
+			},
+			wantChanged: true,
+			wantStatus:  domain.RolloutStatusCompleted,
+			wantWave:    2,
+		},
+		{
+			name: "too many errors",
+			rollout: domain.Rollout{
+				Waves: "50,100", WaveHours: 24, WaveStartedAt: now - 25*3600, Status: domain.RolloutStatusActive,
+				MaxErrorRateIncrease: 2,
+			},
+			progress:    domain.RolloutProgress{UpgradedNodes: 1, UpgradedErrorRate: 5, BaselineErrorRate: 2},
+			wantChanged: true,
+			wantStatus:  domain.RolloutStatusPaused,
+			wantWave:    0,
+		},
+		{
+			name: "restarts within the allowed increase",
+			rollout: domain.Rollout{
+				Waves: "50,100", WaveHours: 24, WaveStartedAt: now - 3600, Status: domain.RolloutStatusActive,
+				MaxRestartRateIncrease: 2,
+			},
+			progress:    domain.RolloutProgress{UpgradedNodes: 1, UpgradedRestartRate: 3, BaselineRestartRate: 2},
+			wantChanged: false,
+			wantStatus:  domain.RolloutStatusActive,
+			wantWave:    0,
+		},
+		{
+			name: "a paused rollout",
+			rollout: domain.Rollout{
+				Waves: "50,100", WaveHours: 24, WaveStartedAt: now - 25*3600, Status: domain.RolloutStatusPaused,
+			},
+			wantChanged: false,
+			wantStatus:  domain.RolloutStatusPaused,
+			wantWave:    0,
+		},
+	}
+
+	for _, fix := range fixtures {
+		changed := UpdateRolloutState(&fix.rollout, fix.progress, now)
+		if changed != fix.wantChanged || fix.rollout.Status != fix.wantStatus || fix.rollout.CurrentWave != fix.wantWave {
+			t.Errorf("%s: expected changed %v, status %s and wave %v. Got %v, %s and %v",
+				fix.name, fix.wantChanged, fix.wantStatus, fix.wantWave, changed, fix.rollout.Status, fix.rollout.CurrentWave)
+		}
+
+		if fix.wantStatus == domain.RolloutStatusPaused && fix.wantChanged && fix.rollout.PausedReason == "" {
+			t.Errorf("%s: expected a reason for pausing the rollout", fix.name)
+		}
+	}
+}
+
+func TestGetRatePerNodeDay(t *testing.T) {
+	if rate := GetRatePerNodeDay(4, 2, domain.SecondsPerDay); rate != 2 {
+		t.Errorf("Expected a rate of 2, got %v", rate)
+	}
+
+	if rate := GetRatePerNodeDay(1, 1, 60); rate != 24 {
+		t.Errorf("Expected a short period to count as an hour, got %v", rate)
+	}
+
+	if rate := GetRatePerNodeDay(4, 0, domain.SecondsPerDay); rate != 0 {
+		t.Errorf("Expected a rate of 0 without any nodes, got %v", rate)
+	}
+}
@@ -0,0 +1,272 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchYears is how far ahead Next looks for a run before deciding that a schedule never runs
+const searchYears = 5
+
+// ParseError is returned for a schedule that isn't a valid cron expression
+type ParseError struct {
+	Schedule string
+	Reason   string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid schedule %q: %s", e.Schedule, e.Reason)
+}
+
+// Schedule holds the minutes, hours, days of the month, months and days of the week of a cron expression,
+// each as a set of bits
+type Schedule struct {
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	daysOfWeek  uint64
+	// When both day fields are restricted (don't start with "*"), a day matches if either of them does
+	daysOfMonthRestricted bool
+	daysOfWeekRestricted  bool
+}
+
+type field struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{
+		name: "month",
+		min:  1,
+		max:  12,
+		names: map[string]int{
+			"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+			"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+		},
+	},
+	{
+		// Both 0 and 7 are Sunday
+		name:  "day of week",
+		min:   0,
+		max:   7,
+		names: map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6},
+	},
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse reads a standard five field cron expression (minute, hour, day of month, month and day of week),
+// or one of the descriptors such as "@daily". Schedules that would never run are rejected.
+func Parse(schedule string) (Schedule, error) {
+	expression := strings.TrimSpace(schedule)
+	if strings.HasPrefix(expression, "@") {
+		descriptor, ok := descriptors[strings.ToLower(expression)]
+		if !ok {
+			return Schedule{}, &ParseError{Schedule: schedule, Reason: "unknown descriptor"}
+		}
+		expression = descriptor
+	}
+
+	parts := strings.Fields(expression)
+	if len(parts) != len(fields) {
+		return Schedule{}, &ParseError{
+			Schedule: schedule,
+			Reason:   fmt.Sprintf("expected %d fields, got %d", len(fields), len(parts)),
+		}
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, part := range parts {
+		var err error
+		bits[i], err = parseField(part, fields[i])
+		if err != nil {
+			return Schedule{}, &ParseError{Schedule: schedule, Reason: err.Error()}
+		}
+	}
+
+	// Sunday can be given as 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+
+	s := Schedule{
+		minutes:               bits[0],
+		hours:                 bits[1],
+		daysOfMonth:           bits[2],
+		months:                bits[3],
+		daysOfWeek:            bits[4],
+		daysOfMonthRestricted: !strings.HasPrefix(parts[2], "*"),
+		daysOfWeekRestricted:  !strings.HasPrefix(parts[4], "*"),
+	}
+
+	// e.g. "0 0 30 2 *"
+	if s.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return Schedule{}, &ParseError{Schedule: schedule, Reason: "it never runs"}
+	}
+
+	return s, nil
+}
+
+// parseField reads a comma separated list of values, ranges ("1-5") and steps ("*/15" or "10-40/10")
+func parseField(part string, f field) (uint64, error) {
+	var bits uint64
+
+	for _, item := range strings.Split(part, ",") {
+		rangePart := item
+		step := 1
+
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in %s field: %s", f.name, item)
+			}
+			rangePart = item[:i]
+		}
+
+		start, end := f.min, f.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			start, err = parseValue(bounds[0], f)
+			if err != nil {
+				return 0, err
+			}
+			end, err = parseValue(bounds[1], f)
+			if err != nil {
+				return 0, err
+			}
+			if end < start {
+				return 0, fmt.Errorf("bad range in %s field: %s", f.name, item)
+			}
+		default:
+			var err error
+			start, err = parseValue(rangePart, f)
+			if err != nil {
+				return 0, err
+			}
+			// A single value with a step, like "10/15", runs from the value to the end of the field
+			if step == 1 {
+				end = start
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseValue(value string, f field) (int, error) {
+	if number, ok := f.names[strings.ToLower(value)]; ok {
+		return number, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < f.min || number > f.max {
+		return 0, fmt.Errorf("%s must be from %d to %d, got: %s", f.name, f.min, f.max, value)
+	}
+
+	return number, nil
+}
+
+// Next returns the first time after the given one that the schedule runs, in the same location.
+// Returns a zero time if it doesn't run within the next few years.
+func (s Schedule) Next(after time.Time) time.Time {
+	location := after.Location()
+	day := time.Date(after.Year(), after.Month(), after.Day(), 0, 0, 0, 0, location)
+	lastDay := day.AddDate(searchYears, 0, 0)
+
+	for ; !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+		if !s.isRunDay(day) {
+			continue
+		}
+
+		for hour := 0; hour < 24; hour++ {
+			if s.hours&(1<<uint(hour)) == 0 {
+				continue
+			}
+
+			for minute := 0; minute < 60; minute++ {
+				if s.minutes&(1<<uint(minute)) == 0 {
+					continue
+				}
+
+				run := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, location)
+
+				// Skip the times that don't exist because the clocks went forward
+				if run.Hour() != hour || run.Minute() != minute {
+					continue
+				}
+
+				if run.After(after) {
+					return run
+				}
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// NextRuns returns the next count times after the given one that the schedule runs
+func (s Schedule) NextRuns(after time.Time, count int) []time.Time {
+	runs := []time.Time{}
+	for len(runs) < count {
+		after = s.Next(after)
+		if after.IsZero() {
+			break
+		}
+		runs = append(runs, after)
+	}
+
+	return runs
+}
+
+// RunsBetween returns the times that the schedule runs from start up to, but not including, end
+func (s Schedule) RunsBetween(start, end time.Time) []time.Time {
+	runs := []time.Time{}
+	run := s.Next(start.Add(-time.Nanosecond))
+	for !run.IsZero() && run.Before(end) {
+		runs = append(runs, run)
+		run = s.Next(run)
+	}
+
+	return runs
+}
+
+func (s Schedule) isRunDay(day time.Time) bool {
+	if s.months&(1<<uint(day.Month())) == 0 {
+		return false
+	}
+
+	dayOfMonthMatches := s.daysOfMonth&(1<<uint(day.Day())) != 0
+	dayOfWeekMatches := s.daysOfWeek&(1<<uint(day.Weekday())) != 0
+
+	if s.daysOfMonthRestricted && s.daysOfWeekRestricted {
+		return dayOfMonthMatches || dayOfWeekMatches
+	}
+
+	return dayOfMonthMatches && dayOfWeekMatches
+}
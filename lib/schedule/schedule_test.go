@@ -0,0 +1,101 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	good := []string{
+		"*/5 * * * *",
+		"0 9-17 * * Mon-Fri",
+		"15,45 */2 1 jan,jul *",
+		"30 2 * * 7",
+		"@daily",
+	}
+
+	for _, schedule := range good {
+		_, err := Parse(schedule)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", schedule, err.Error())
+		}
+	}
+
+	bad := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"*/0 * * * *",
+		"0 17-9 * * *",
+		"0 0 * * Funday",
+		"0 0 30 2 *",
+		"@fortnightly",
+	}
+
+	for _, schedule := range bad {
+		_, err := Parse(schedule)
+		if err == nil {
+			t.Errorf("Expected an error for %q", schedule)
+		} else if _, ok := err.(*ParseError); !ok {
+			t.Errorf("Expected a ParseError for %q, got %T", schedule, err)
+		}
+	}
+}
+
+func TestSchedule_NextRuns(t *testing.T) {
+	// A Wednesday
+	after := time.Date(2018, 6, 13, 16, 50, 0, 0, time.UTC)
+
+	fixtures := []struct {
+		schedule string
+		want     []string
+	}{
+		{
+			schedule: "*/20 * * * *",
+			want:     []string{"2018-06-13T17:00:00Z", "2018-06-13T17:20:00Z", "2018-06-13T17:40:00Z"},
+		},
+		{
+			schedule: "0 9-17/4 * * mon-fri",
+			want:     []string{"2018-06-13T17:00:00Z", "2018-06-14T09:00:00Z", "2018-06-14T13:00:00Z"},
+		},
+		{
+			// Either the 1st or a Sunday
+			schedule: "0 0 1 * 0",
+			want:     []string{"2018-06-17T00:00:00Z", "2018-06-24T00:00:00Z", "2018-07-01T00:00:00Z"},
+		},
+	}
+
+	for _, fix := range fixtures {
+		s, err := Parse(fix.schedule)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		runs := s.NextRuns(after, len(fix.want))
+		if len(runs) != len(fix.want) {
+			t.Errorf("%s: expected %d runs, got %v", fix.schedule, len(fix.want), runs)
+			continue
+		}
+
+		for i, run := range runs {
+			if run.Format(time.RFC3339) != fix.want[i] {
+				t.Errorf("%s: expected run %d at %s, got %s", fix.schedule, i, fix.want[i], run.Format(time.RFC3339))
+			}
+		}
+	}
+}
+
+func TestSchedule_RunsBetween(t *testing.T) {
+	s, err := Parse("0 * * * *")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	start := time.Date(2018, 6, 13, 0, 0, 0, 0, time.UTC)
+	runs := s.RunsBetween(start, start.Add(24*time.Hour))
+	if len(runs) != 24 || !runs[0].Equal(start) {
+		t.Errorf("Expected 24 runs starting at %s, got %v", start, runs)
+	}
+}
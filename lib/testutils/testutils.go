@@ -27,6 +27,11 @@ var AdminUser = domain.User{
 	},
 }
 
+// NodeAuthToken is the agent token to use for node fixtures that have AuthTokenHash set to NodeAuthTokenHash
+const NodeAuthToken = "test-node-auth-token"
+
+var NodeAuthTokenHash = domain.HashNodeAuthToken(NodeAuthToken)
+
 func MigrateTables(t *testing.T) {
 	err := db.AutoMigrateTables()
 	if err != nil {
@@ -74,3 +79,9 @@ func GetAdminUserReqHeader() map[string]string {
 		"x-user-mail": AdminUser.Email,
 	}
 }
+
+func GetNodeReqHeader() map[string]string {
+	return map[string]string{
+		domain.NodeReqHeaderToken: NodeAuthToken,
+	}
+}